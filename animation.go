@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"math"
+	"time"
+)
+
+// frames that wait to be rendered before the simulation has to wait for the renderer
+const animation_queue = 8
+
+// note:
+// -animation growth.gif saves the growth as an animated gif next to the result. Every -animation-every
+// iterations, from the first one on, the flake is rendered like a result without flags and kept as a
+// frame, and the finished flake comes last. The frames are gray until they are
+// saved, then they get the colors of -palette or -theme (without the texture, a gif has one palette of
+// 256 colors and the grays fit it exactly).
+//
+// Frames far apart make the growth jump. -tween puts that many frames between every two captured ones,
+// cross-faded from the one before to the one after, so a sparse animation plays smoothly without
+// rendering more of the simulation. The vapor changes slowly and the ice only grows, so the cross-fade
+// looks like the ice filling in between the two stages, though a branch that grows quickly fades in
+// instead of reaching out.
+//
+// A flake grows fast at first and slower later, or the other way around, so frames at a fixed interval
+// spend most of the animation on the slow part. -animation-cells takes a frame every time that many more
+// hexagons have frozen instead, then every frame adds about as much ice and the growth plays at an even
+// pace. Counting the ice goes through the whole matrix every iteration, like -fill does.
+//
+// A -grow grid gets larger while the frames are taken, but all the frames of a gif have to fit its first
+// one. The frames are rendered at the size of the grid they were taken from and the smaller ones are put
+// in the middle of the largest one when it's saved, a hexagon is a pixel at every size so the flake
+// stays where it is. Around them they get the color of their corner, the border of the smaller grid.
+//
+// A gif starts over when it ends, and the finished flake jumping back to the seed shows. With -loop the
+// frames play forward and then backward, so the flake shrinks back to where it started and the gif goes
+// round without a jump, for backgrounds of web pages. The first and the last frame aren't shown twice,
+// they would hold the turns.
+//
+// A gif keeps the time of every frame in hundredths of a second. The frames get 1 / -fps each, or
+// -animation-duration spread over all of them when the length matters more than the pace (-duration is
+// the time the simulation gets). Like the frames, the hundredths are handed out on a running total,
+// so 30 frames a second comes out as 3, 3, 4, 3, 3, 4 and a second of frames takes a second. Browsers
+// play a frame of less than 2 hundredths at 10, so no frame gets less than 2, at most 50 frames a
+// second. -hold-last keeps the finished flake up that much longer before the gif starts over (or turns
+// back with -loop), on top of the duration.
+//
+// Rendering a frame takes about as long as a few iterations, so the frames are rendered next to the
+// simulation instead of in between its iterations. A captured frame is copied into a queue of
+// animation_queue frames and a renderer takes them from there in order, the simulation goes on right
+// away. Only when the renderer falls that far behind the simulation waits for a place in the queue,
+// how often and how long is printed with the saved animation as the backpressure, and kept in the
+// timings, the -stats json and the stats of -notify-url. More than a handful of waits means frames much
+// closer together than the renderer keeps up with. The gif itself is
+// encoded at the end, it needs all the frames for the tweens and -loop.
+
+type animation struct {
+	every  int64 // iterations between captured frames
+	cells  int   // hexagons that freeze between captured frames instead, 0 to use every
+	tween  int   // frames faded in between two captured ones
+	loop   bool  // the frames play forward and then backward
+	fps    float64
+	length time.Duration // how long all the frames play, 0 to use fps
+	hold   time.Duration // extra time on the finished flake
+	frames []*image.Gray
+	last   int64 // iteration of the newest frame
+	frozen int   // frozen hexagons in the newest frame
+	queue  chan frame_job
+	done   chan struct{}
+	stalls int           // captures that waited for the renderer
+	waited time.Duration // how long they waited together
+}
+
+type frame_job struct {
+	settings Settings
+	values   Matrix
+}
+
+func new_animation(every int64, cells, tween int, loop bool) *animation {
+	a := &animation{every: every, cells: cells, tween: tween, loop: loop, fps: 25, last: -1}
+	a.queue, a.done = make(chan frame_job, animation_queue), make(chan struct{})
+	go func() {
+		for job := range a.queue {
+			a.frames = append(a.frames, gray_image(render_settings(job.settings, &job.values)))
+		}
+		close(a.done)
+	}()
+	return a
+}
+
+// sets how fast the frames play
+func (a *animation) timing(fps float64, length, hold time.Duration) error {
+	if fps <= 0 || fps > 50 {
+		return fmt.Errorf("-fps must be more than 0 and at most 50, a gif doesn't play faster")
+	}
+	if length < 0 || hold < 0 {
+		return fmt.Errorf("-animation-duration and -hold-last can't be less than 0")
+	}
+	a.fps, a.length, a.hold = fps, length, hold
+	return nil
+}
+
+// tells if the iteration that was just run gets a frame
+func (a *animation) due(state *State) bool {
+	if a.cells > 0 {
+		return a.last < 0 || state.frozen()-a.frozen >= a.cells
+	}
+	return state.Iteration%a.every == 0
+}
+
+// keeps the state rendered as the next frame
+func (a *animation) capture(state *State) {
+	a.push(state.Settings, state.values())
+	a.last = state.Iteration
+	if a.cells > 0 {
+		a.frozen = state.frozen()
+	}
+}
+
+// queues a copy of the values to be rendered as the next frame, waits when the queue is full
+func (a *animation) push(settings Settings, values Matrix) {
+	job := frame_job{settings, new_matrix_like(values)}
+	copy_grid(job.values, values)
+	select {
+	case a.queue <- job:
+	default:
+		start := time.Now()
+		a.queue <- job
+		a.stalls++
+		a.waited += time.Since(start)
+	}
+}
+
+// waits for the renderer to finish the queued frames
+func (a *animation) finish() {
+	if a.queue != nil {
+		close(a.queue)
+		<-a.done
+		a.queue = nil
+	}
+}
+
+// how often and how long the simulation waited for the renderer
+type Backpressure struct {
+	Frames int           // captured frames
+	Stalls int           // captures that waited for the renderer
+	Waited time.Duration // how long they waited together
+}
+
+// the backpressure of the animation once the renderer is done, nil without one
+func (a *animation) backpressure() *Backpressure {
+	if a == nil {
+		return nil
+	}
+	a.finish()
+	return &Backpressure{len(a.frames), a.stalls, a.waited}
+}
+
+func (b *Backpressure) String() string {
+	return fmt.Sprintf("%d captured frames, waited %d times for the renderer (%s)", b.Frames, b.Stalls, b.Waited.Round(time.Millisecond))
+}
+
+// the wait in seconds
+func (b *Backpressure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"frames": b.Frames, "stalls": b.Stalls, "waited": b.Waited.Seconds()})
+}
+
+// the brightness of the image, the renders are gray already
+func gray_image(img image.Image) *image.Gray {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray
+	}
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.SetGray(x, y, color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray))
+		}
+	}
+	return gray
+}
+
+// the frames with the tweens between them, and back with -loop
+func (a *animation) sequence() []*image.Gray {
+	width, height := 0, 0
+	for _, frame := range a.frames {
+		if frame.Rect.Dx() > width {
+			width = frame.Rect.Dx()
+		}
+		if frame.Rect.Dy() > height {
+			height = frame.Rect.Dy()
+		}
+	}
+	var frames []*image.Gray
+	for k := range a.frames {
+		frame := pad_frame(a.frames[k], width, height)
+		if k > 0 {
+			previous := pad_frame(a.frames[k-1], width, height)
+			for n := 1; n <= a.tween; n++ {
+				frames = append(frames, cross_fade(previous, frame, float64(n)/float64(a.tween+1)))
+			}
+		}
+		frames = append(frames, frame)
+	}
+	if a.loop {
+		for k := len(frames) - 2; k > 0; k-- {
+			frames = append(frames, frames[k])
+		}
+	}
+	return frames
+}
+
+// the frame in the middle of a width x height one filled with the color of its corner, or the frame
+// itself when it has that size
+func pad_frame(frame *image.Gray, width, height int) *image.Gray {
+	if frame.Rect.Dx() == width && frame.Rect.Dy() == height {
+		return frame
+	}
+	out := image.NewGray(image.Rect(0, 0, width, height))
+	corner := frame.Pix[0]
+	for k := range out.Pix {
+		out.Pix[k] = corner
+	}
+	x, y := (width-frame.Rect.Dx())/2, (height-frame.Rect.Dy())/2
+	for row := 0; row < frame.Rect.Dy(); row++ {
+		copy(out.Pix[(y+row)*out.Stride+x:], frame.Pix[row*frame.Stride:row*frame.Stride+frame.Rect.Dx()])
+	}
+	return out
+}
+
+// t of the way from a to b
+func cross_fade(a, b *image.Gray, t float64) *image.Gray {
+	out := image.NewGray(a.Rect)
+	for k := range out.Pix {
+		out.Pix[k] = uint8(float64(a.Pix[k])*(1-t) + float64(b.Pix[k])*t + 0.5)
+	}
+	return out
+}
+
+// the hundredths of a second of every one of n frames, finished is the frame of the finished flake
+func (a *animation) delays(n, finished int) []int {
+	period := 100 / a.fps
+	if a.length > 0 {
+		period = a.length.Seconds() * 100 / float64(n)
+	}
+	delays := make([]int, n)
+	for k := range delays {
+		delays[k] = int(math.Round(float64(k+1)*period) - math.Round(float64(k)*period))
+		if delays[k] < 2 {
+			delays[k] = 2
+		}
+	}
+	if finished >= 0 && finished < n {
+		delays[finished] += int(math.Round(a.hold.Seconds() * 100))
+	}
+	return delays
+}
+
+// saves the frames as a gif in the colors of the theme, the gray of a pixel is its index in the palette
+func (a *animation) save(filename string, colors theme) error {
+	a.finish()
+	var palette color.Palette
+	for g := 0; g < 256; g++ {
+		palette = append(palette, colors.palette.at(float64(g)/255))
+	}
+	frames := a.sequence()
+	out := &gif.GIF{Delay: a.delays(len(frames), len(a.frames)-1+(len(a.frames)-1)*a.tween)}
+	for _, frame := range frames {
+		paletted := &image.Paletted{Pix: frame.Pix, Stride: frame.Stride, Rect: frame.Rect, Palette: palette}
+		out.Image = append(out.Image, paletted)
+	}
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(file, out); err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// note:
+// In step() a hexagon hands the same A/12 of its vapor to each of its six neighbours, so no direction of
+// the grid is special and the flake can only grow the same in all six. Real ice takes up vapor faster
+// along some crystal axes than others. -weights gives every direction its own weight, the part of the
+// vapor going that way is A/12 times the weight, in the order the directions are in the image:
+//
+//   1 right      i+1 j      2 up right     i+1 j-1    3 up left      i   j-1
+//   4 left       i-1 j      5 down left    i-1 j+1    6 down right   i   j+1
+//
+// More vapor going one way doesn't make the ice grow that way, it carries the vapor away from the tips
+// of the arms along it faster, so those arms fall behind. -anisotropy a is the short way for the usual
+// case, the horizontal axis (1 and 4) gets 1-a and the other four 1+a/2, so the vapor that moves stays
+// the same. Above 0 the horizontal arms outgrow the others into a needle, below 0 they fall behind and
+// the other four grow out into an X, from -2 to 1 no weight gets below 0.
+// Weights that don't add up to 6 take vapor away or add it like A does, a weight that isn't the same
+// as the one of the opposite direction pushes all of the vapor across the grid like wind. All weights 1 is step() itself,
+// the same flakes bit for bit. Only the reiter solver without tiles has them.
+
+// the weights of -anisotropy a, rounded so 1-0.8 is 0.2 and fits in a code
+func anisotropy_weights(a float64) [6]float64 {
+	axis := math.Round((1-a)*1e9) / 1e9
+	other := math.Round((1+a/2)*1e9) / 1e9
+	return [6]float64{axis, other, other, axis, other, other}
+}
+
+// the weights, created with all 1 when there are none yet
+func (settings *Settings) weights() *[6]float64 {
+	if settings.Weights == nil {
+		settings.Weights = &[6]float64{1, 1, 1, 1, 1, 1}
+	}
+	return settings.Weights
+}
+
+// six comma separated weights, one for every direction
+func parse_weights(s string) ([6]float64, error) {
+	var weights [6]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 6 {
+		return weights, fmt.Errorf("expected 6 weights but got %q", s)
+	}
+	for k, part := range parts {
+		var err error
+		if weights[k], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+			return weights, err
+		}
+	}
+	return weights, nil
+}
+
+// a step of the reiter model like step() with a weight for every direction the vapor goes
+func step_anisotropic[T Real](A, Y float64, weights [6]float64, coldness_matrix, temp_coldness_matrix *Grid[T], mask_matrix *Mask) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+
+	// look for frozen hexagons and set receptive values on the mask
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if (*coldness_matrix)[i][j] >= 1.0 {
+				(*mask_matrix)[i-1][j] = receptive
+				(*mask_matrix)[i-1][j+1] = receptive
+				(*mask_matrix)[i][j-1] = receptive
+				(*mask_matrix)[i][j] = receptive
+				(*mask_matrix)[i][j+1] = receptive
+				(*mask_matrix)[i+1][j-1] = receptive
+				(*mask_matrix)[i+1][j] = receptive
+			}
+		}
+	}
+
+	for i := range *temp_coldness_matrix {
+		for j := range (*temp_coldness_matrix)[i] {
+			(*temp_coldness_matrix)[i][j] = 0
+		}
+	}
+
+	var w [6]T
+	for k, weight := range weights {
+		w[k] = T(weight)
+	}
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			switch (*mask_matrix)[i][j] {
+			case non_receptive:
+				// the same order as step(), so weights of 1 add up the same
+				v0 := (*coldness_matrix)[i][j]
+				v1 := T(A) * v0 / 12.0
+
+				(*temp_coldness_matrix)[i-1][j] += v1 * w[3]
+				(*temp_coldness_matrix)[i-1][j+1] += v1 * w[4]
+				(*temp_coldness_matrix)[i][j-1] += v1 * w[2]
+				(*temp_coldness_matrix)[i][j] += v0 / 2.0
+				(*temp_coldness_matrix)[i][j+1] += v1 * w[5]
+				(*temp_coldness_matrix)[i+1][j-1] += v1 * w[1]
+				(*temp_coldness_matrix)[i+1][j] += v1 * w[0]
+
+			case receptive:
+				(*temp_coldness_matrix)[i][j] += (*coldness_matrix)[i][j] + T(Y)
+			}
+		}
+	}
+
+	// swap so the new coldness matrix becomes the current one
+	*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// note:
+// The atlas generates n different flakes with random settings and packs their sprites into one texture,
+// for engines that want lots of unique particles in a single draw call. They are packed on shelves:
+// sorted by height, left to right until the row is full, then the next row starts below the highest
+// one. The manifest is the json hash format of TexturePacker, which most engines can read.
+
+type atlas_rect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type atlas_size struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type atlas_frame struct {
+	Frame            atlas_rect   `json:"frame"`
+	Rotated          bool         `json:"rotated"`
+	Trimmed          bool         `json:"trimmed"`
+	SpriteSourceSize atlas_rect   `json:"spriteSourceSize"`
+	SourceSize       atlas_size   `json:"sourceSize"`
+	Pivot            sprite_pivot `json:"pivot"`
+	Settings         Settings     `json:"settings"`
+}
+
+type atlas_manifest struct {
+	Frames map[string]atlas_frame `json:"frames"`
+	Meta   struct {
+		App                string     `json:"app"`
+		Image              string     `json:"image"`
+		Format             string     `json:"format"`
+		Size               atlas_size `json:"size"`
+		Scale              string     `json:"scale"`
+		PremultipliedAlpha bool       `json:"premultipliedAlpha"`
+	} `json:"meta"`
+}
+
+// snow atlas [-n 16] [-size 256] [-L 1000] [-width 1024] [-out atlas.png]
+func atlas(args []string) {
+	flags := flag.NewFlagSet("atlas", flag.ExitOnError)
+	n := flags.Int("n", 16, "amount of flakes")
+	grid := flags.Int("size", 256, "matrix size of every flake")
+	L := flags.Int64("L", 1000, "iterations of every flake")
+	width := flags.Int("width", 1024, "width of the atlas")
+	padding := flags.Int("padding", 2, "transparent pixels between the sprites")
+	power_of_two := flags.Bool("pot", true, "round the atlas height up to a power of two")
+	seed := flags.Int64("seed", 1, "seed of the random settings, the same seed gives the same atlas")
+	palette_name := flags.String("palette", "", "colors of the flakes, they get the color of the frozen hexagons")
+	out := flags.String("out", "snowflakes/atlas.png", "atlas image, the manifest is saved next to it as json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow atlas [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *n < 1 || *grid < 16 || *L < 1 || *width < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	colors, err := pick_theme("", *palette_name, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// different settings for every flake
+	rng := rand.New(rand.NewSource(*seed))
+	all := make([]Settings, 0, *n)
+	seen := map[Settings]bool{}
+	for len(all) < *n {
+		settings := random_settings(rng, random_ranges, *grid, 0, *L)
+		if !seen[settings] {
+			seen[settings] = true
+			all = append(all, settings)
+		}
+	}
+
+	type flake struct {
+		name       string
+		settings   Settings
+		sprite     *image.NRGBA
+		descriptor sprite_descriptor
+	}
+	flakes := make([]flake, *n)
+	next := make(chan int)
+	var wait, printing sync.WaitGroup
+	done := make(chan string)
+	printing.Add(1)
+	go func() {
+		defer printing.Done()
+		for k := 1; k <= *n; k++ {
+			fmt.Fprintf(os.Stderr, "\rflakes:\t %d / %d %s", k, *n, <-done)
+		}
+		fmt.Fprintln(os.Stderr)
+	}()
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for k := range next {
+				state := new_state(all[k])
+				run(state, func(int64) {})
+				sprite, descriptor := make_sprite(frozen_layer(state.values(), colors.ink()), rendered_seed(all[k]), false)
+				warning := ""
+				if state.Truncated >= 0 {
+					warning = fmt.Sprintf("(flake %d truncated)", k+1)
+				}
+				flakes[k] = flake{fmt.Sprintf("flake-%02d", k+1), all[k], sprite, descriptor}
+				done <- warning
+			}
+		}()
+	}
+	for k := range all {
+		next <- k
+	}
+	close(next)
+	wait.Wait()
+	printing.Wait()
+
+	// shelves from the highest sprite to the lowest
+	order := make([]int, len(flakes))
+	for k := range order {
+		order[k] = k
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return flakes[order[a]].sprite.Bounds().Dy() > flakes[order[b]].sprite.Bounds().Dy()
+	})
+	positions := make([]image.Point, len(flakes))
+	x, y, shelf := 0, 0, 0
+	for _, k := range order {
+		w, h := flakes[k].sprite.Bounds().Dx(), flakes[k].sprite.Bounds().Dy()
+		if w > *width {
+			fmt.Fprintf(os.Stderr, "%s is %d pixels wide, that doesn't fit in the atlas\n", flakes[k].name, w)
+			os.Exit(1)
+		}
+		if x+w > *width {
+			x, y, shelf = 0, y+shelf+*padding, 0
+		}
+		positions[k] = image.Pt(x, y)
+		x += w + *padding
+		shelf = int(math.Max(float64(shelf), float64(h)))
+	}
+	height := y + shelf
+	if *power_of_two {
+		height = next_power_of_two(height)
+	}
+
+	sheet := image.NewNRGBA(image.Rect(0, 0, *width, height))
+	manifest := atlas_manifest{Frames: map[string]atlas_frame{}}
+	for k, f := range flakes {
+		bounds := f.sprite.Bounds()
+		// the sprites are premultiplied already
+		for py := 0; py < bounds.Dy(); py++ {
+			copy(sheet.Pix[sheet.PixOffset(positions[k].X, positions[k].Y+py):], f.sprite.Pix[f.sprite.PixOffset(0, py):f.sprite.PixOffset(bounds.Dx(), py)])
+		}
+		manifest.Frames[f.name+".png"] = atlas_frame{
+			Frame:            atlas_rect{positions[k].X, positions[k].Y, bounds.Dx(), bounds.Dy()},
+			SpriteSourceSize: atlas_rect{0, 0, bounds.Dx(), bounds.Dy()},
+			SourceSize:       atlas_size{bounds.Dx(), bounds.Dy()},
+			Pivot:            f.descriptor.Pivot,
+			Settings:         f.settings,
+		}
+	}
+	manifest.Meta.App = "procedural-snowflakes"
+	manifest.Meta.Image = filepath.Base(*out)
+	manifest.Meta.Format = "RGBA8888"
+	manifest.Meta.Size = atlas_size{*width, height}
+	manifest.Meta.Scale = "1"
+	manifest.Meta.PremultipliedAlpha = true
+
+	if err := save_image(*out, sheet, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save atlas:", err)
+		os.Exit(1)
+	}
+	manifest_file := strings.TrimSuffix(*out, filepath.Ext(*out)) + ".json"
+	if err := save_json(manifest_file, manifest); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save manifest:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("saved atlas:\t %s (%dx%d, %d flakes)\n", *out, *width, height, *n)
+	fmt.Println("saved manifest:\t", manifest_file)
+}
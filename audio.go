@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// note:
+// The soundtrack turns the growth into a midi file. The iterations are cut into steps of a sixteenth of
+// a second (-audio-speed iterations per second) and every step that froze hexagons plays:
+//
+//   melody    music box, higher the further out the flake has grown, louder the more hexagons froze
+//   hi-hat    when the flake reached further out than ever before
+//   bass      when a lot more froze than in the steps before, this is where new side branches start
+//
+// The notes are taken from a pentatonic scale so it never sounds too wrong.
+
+const (
+	steps_per_second = 16
+	ticks_per_second = 480 // one quarter note per second
+	ticks_per_step   = ticks_per_second / steps_per_second
+)
+
+var pentatonic = []byte{60, 62, 64, 67, 69, 72, 74, 76, 79, 81, 84, 86, 88, 91, 93, 96}
+
+type soundtrack struct {
+	speed  float64 // iterations per second
+	steps  []sound_step
+	frozen int // frozen hexagons at the last record
+}
+
+type sound_step struct {
+	cells  int     // hexagons that froze in the step
+	radius float64 // furthest frozen hexagon from the seed
+}
+
+func new_soundtrack(speed float64) *soundtrack {
+	return &soundtrack{speed: speed}
+}
+
+// records what froze since the last call
+func (s *soundtrack) record(state *State) {
+	var frozen int
+	var radius float64
+	switch {
+	case state.Settings.Fixed:
+		frozen, radius = frozen_extent(state.Settings, &state.ColdnessFixed)
+	case state.Settings.single_precision():
+		frozen, radius = frozen_extent(state.Settings, &state.Coldness32)
+	default:
+		frozen, radius = frozen_extent(state.Settings, &state.Coldness)
+	}
+
+	k := int(float64(state.Iteration) * steps_per_second / s.speed)
+	for len(s.steps) <= k {
+		s.steps = append(s.steps, sound_step{})
+	}
+	s.steps[k].cells += frozen - s.frozen
+	s.steps[k].radius = math.Max(s.steps[k].radius, radius)
+	s.frozen = frozen
+}
+
+// amount of frozen hexagons and how far from the seed the furthest one is
+func frozen_extent[T Value](settings Settings, matrix *Grid[T]) (int, float64) {
+	one := to_value[T](1.0)
+	x, y := settings.seed_pos()
+	frozen, radius := 0, 0.0
+	for i := range *matrix {
+		for j, v := range (*matrix)[i] {
+			if v >= one {
+				frozen++
+				// hex distance from the seed
+				dx, dz := float64(i-x), float64(j-y)
+				radius = math.Max(radius, math.Max(math.Max(math.Abs(dx), math.Abs(dz)), math.Abs(dx+dz)))
+			}
+		}
+	}
+	return frozen, radius
+}
+
+type midi_event struct {
+	tick int
+	data []byte
+}
+
+// saves the soundtrack as a type 0 midi file
+func (s *soundtrack) save(filename string) error {
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != ".mid" && ext != ".midi" {
+		return errors.New("only midi (.mid) soundtracks are supported")
+	}
+
+	max_radius := 1.0
+	for _, step := range s.steps {
+		max_radius = math.Max(max_radius, step.radius)
+	}
+
+	events := []midi_event{
+		{0, []byte{0xff, 0x51, 0x03, 0x0f, 0x42, 0x40}}, // tempo, a million microseconds per quarter
+		{0, []byte{0xc0, 10}},                           // music box
+		{0, []byte{0xc1, 32}},                           // acoustic bass
+	}
+	note := func(tick, ticks int, channel, pitch, velocity byte) {
+		events = append(events,
+			midi_event{tick, []byte{0x90 | channel, pitch, velocity}},
+			midi_event{tick + ticks, []byte{0x80 | channel, pitch, 0}})
+	}
+
+	furthest, average := 0.0, 0.0
+	for k, step := range s.steps {
+		tick := k * ticks_per_step
+		if step.cells > 0 {
+			pitch := pentatonic[int(step.radius/max_radius*float64(len(pentatonic)-1))]
+			velocity := math.Min(127, 40+12*math.Log2(float64(step.cells)))
+			note(tick, 2*ticks_per_step, 0, pitch, byte(velocity))
+
+			if step.radius > furthest {
+				note(tick, ticks_per_step, 9, 42, 80)
+			}
+			if k > 8 && float64(step.cells) > 2*average && step.cells > 4 {
+				note(tick, 4*ticks_per_step, 1, pitch-24, 100)
+			}
+		}
+		furthest = math.Max(furthest, step.radius)
+		// average over about the last half second
+		average += (float64(step.cells) - average) / 8
+	}
+
+	// note offs go before note ons at the same tick so repeated notes don't cut themselves off
+	sort.SliceStable(events, func(a, b int) bool {
+		if events[a].tick != events[b].tick {
+			return events[a].tick < events[b].tick
+		}
+		return events[a].data[0]&0xf0 == 0x80 && events[b].data[0]&0xf0 != 0x80
+	})
+
+	var track []byte
+	last := 0
+	for _, event := range events {
+		track = append_varint(track, event.tick-last)
+		track = append(track, event.data...)
+		last = event.tick
+	}
+	track = append(track, 0x00, 0xff, 0x2f, 0x00) // end of track
+
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	w.WriteString("MThd")
+	binary.Write(w, binary.BigEndian, []uint16{0, 6, 0, 1, ticks_per_second})
+	w.WriteString("MTrk")
+	binary.Write(w, binary.BigEndian, uint32(len(track)))
+	w.Write(track)
+	if err := w.Flush(); err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
+
+// midi variable length number, 7 bits per byte with the high bit set on all but the last
+func append_varint(b []byte, v int) []byte {
+	var buffer [5]byte
+	n := len(buffer) - 1
+	buffer[n] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		n--
+		buffer[n] = byte(v&0x7f) | 0x80
+	}
+	return append(b, buffer[n:]...)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// one line of batch input, the settings plus where to save the result
+type Job struct {
+	Settings
+	Out    string  `json:"out"`
+	Rotate float64 `json:"rotate,omitempty"` // degrees clockwise
+}
+
+// one line of batch output
+type Result struct {
+	Job      int       `json:"job"`
+	Out      string    `json:"out,omitempty"`
+	Rotate   float64   `json:"rotate,omitempty"`
+	Settings *Settings `json:"settings,omitempty"`
+	Stats    *Stats    `json:"stats,omitempty"`
+	// measured with -stats
+	Structure *Structure `json:"structure,omitempty"`
+	Habit     string     `json:"habit,omitempty"` // with -habit
+	Error     string     `json:"error,omitempty"`
+}
+
+type Stats struct {
+	Iterations   int64         `json:"iterations"`
+	Frozen       int           `json:"frozen"`
+	Truncated    *int64        `json:"truncated,omitempty"` // iteration the flake touched the border at
+	Seconds      float64       `json:"seconds"`
+	Timings      *Timings      `json:"timings,omitempty"`      // with -timings, in seconds
+	Backpressure *Backpressure `json:"backpressure,omitempty"` // of the animation of a run, see animation.go
+}
+
+// reads one json job per line from stdin and writes one json result per line to stdout,
+// missing parameters get the README defaults and progress goes to stderr
+func batch(args []string) {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow batch < jobs.ndjson\n\n")
+		fmt.Fprintf(flags.Output(), "every line is a json object like {\"A\":1,\"B\":0.33,\"Y\":0.0002,\"PP\":0.05,\"PM\":0.2,\"L\":10000,\"size\":800,\"out\":\"flake.png\"}\n")
+		flags.PrintDefaults()
+	}
+	rotate := flags.String("rotate", "", "degrees to turn the flakes of the jobs without rotate clockwise, or random for a new angle every job")
+	var options batch_options
+	flags.BoolVar(&options.measure, "stats", false, "add the structure of every flake to its result and print the aggregate over all of them at the end")
+	flags.BoolVar(&options.habit, "habit", false, "sort every flake into a habit like fern or sectored plate, saved in the result, the metadata and the default names")
+	flags.BoolVar(&options.timings, "timings", false, "add how long the phases of every run took to its stats, see timing.go")
+	flags.StringVar(&options.catalog, "catalog", "", "add every flake to this SQLite catalog, like "+default_catalog)
+	flags.StringVar(&options.out, "out", "", "directory, or s3:// or gs:// prefix, the jobs without out are saved in under their default names")
+	notify_url := flags.String("notify-url", "", "post a json message to this url when all jobs are done, see notify.go")
+	storage_flags(flags)
+	flags.Parse(args)
+	started := time.Now()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if *rotate != "" {
+		if _, err := parse_rotation(*rotate, rng); err != nil {
+			fmt.Fprintln(os.Stderr, "bad -rotate:", err)
+			os.Exit(2)
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	results := json.NewEncoder(os.Stdout)
+	var outputs []string
+	var failures []Result
+	var structures []Structure
+
+	n := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		n++
+
+		rotation := 0.0
+		if *rotate != "" {
+			rotation, _ = parse_rotation(*rotate, rng)
+		}
+		result := run_job(n, line, rotation, options)
+		if result.Error != "" {
+			failures = append(failures, result)
+			fmt.Fprintf(os.Stderr, "job %d failed:\t %s\n", n, result.Error)
+		} else {
+			outputs = append(outputs, result.Out)
+		}
+		if result.Structure != nil {
+			structures = append(structures, *result.Structure)
+		}
+		results.Encode(result)
+	}
+	if options.measure {
+		fmt.Fprintln(os.Stderr, "structure:\t", summarize_structures(structures))
+	}
+
+	problem := ""
+	if err := scanner.Err(); err != nil {
+		problem = "reading jobs: " + err.Error()
+		fmt.Fprintln(os.Stderr, problem)
+	} else if len(failures) > 0 {
+		problem = fmt.Sprintf("%d of %d jobs failed", len(failures), n)
+	}
+	if *notify_url != "" {
+		message := new_notification("batch", started, problem)
+		message.Jobs, message.Failed, message.Outputs, message.Failures = n, len(failures), outputs, failures
+		if err := notify(*notify_url, message); err != nil {
+			fmt.Fprintln(os.Stderr, "warning:\t", err)
+		}
+	}
+	if problem != "" {
+		os.Exit(1)
+	}
+}
+
+// the flags of a batch for every job
+type batch_options struct {
+	measure bool   // adds the structure
+	habit   bool   // adds the habit
+	timings bool   // adds the phases to the stats
+	catalog string // database the flakes are added to
+	out     string // directory or prefix of the jobs without out
+}
+
+// the rotation is for jobs that don't have one
+func run_job(n int, line []byte, rotation float64, options batch_options) Result {
+	job := Job{Settings: default_settings(), Rotate: rotation}
+	if err := json.Unmarshal(line, &job); err != nil {
+		return Result{Job: n, Error: fmt.Sprintf("bad job: %v", err)}
+	}
+	if err := job.Settings.check(); err != nil {
+		return Result{Job: n, Error: fmt.Sprintf("bad job: %v", err)}
+	}
+	// stdout is taken by the results
+	if job.Out == "-" {
+		return Result{Job: n, Error: "bad job: out can't be stdout in batch mode"}
+	}
+	if job.Rotate != 0 && job.Settings.Tile {
+		return Result{Job: n, Error: "bad job: tiles can't be rotated"}
+	}
+	named := job.Out != ""
+	if !named {
+		job.Out = default_filename(job.Settings)
+		if job.Rotate != 0 {
+			job.Out = rotated_filename(job.Out, job.Rotate)
+		}
+	}
+
+	if !named && options.out != "" {
+		job.Out = in_prefix(options.out, job.Out)
+	}
+	if err := check_output(job.Out); err != nil {
+		return Result{Job: n, Error: "bad job: " + err.Error()}
+	}
+
+	start := time.Now()
+	state := new_state(job.Settings)
+	if options.timings {
+		state.timings = &Timings{Init: time.Since(start)}
+	}
+	err := run_context(context.Background(), state, func(iteration int64) {
+		fmt.Fprintf(os.Stderr, "\rjob %d:\t %d / %d", n, iteration, job.L)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return Result{Job: n, Settings: &job.Settings, Error: err.Error()}
+	}
+	if state.Truncated >= 0 {
+		fmt.Fprintf(os.Stderr, "job %d:\t flake truncated at iteration %d\n", n, state.Truncated)
+	}
+
+	encoding := time.Now()
+	coldness_matrix := state.values()
+	var img image.Image
+	if job.Rotate != 0 {
+		img = render_resampled(&coldness_matrix, resample_kernels["bilinear"], job.Rotate)
+	} else {
+		img = render_settings(state.Settings, &coldness_matrix)
+	}
+	metadata := state.metadata()
+	var structure Structure
+	if options.measure || options.habit {
+		structure = measure_structure(state)
+	}
+	if options.habit {
+		metadata["Habit"] = structure.Habit
+		if !named {
+			job.Out = habit_filename(job.Out, structure.Habit)
+		}
+	}
+	if err := save_image(job.Out, img, metadata); err != nil {
+		return Result{Job: n, Settings: &job.Settings, Error: err.Error()}
+	}
+	if state.timings != nil {
+		state.timings.add(&state.timings.Encoding, encoding)
+	}
+
+	result := Result{
+		Job:      n,
+		Out:      job.Out,
+		Rotate:   job.Rotate,
+		Settings: &job.Settings,
+		Stats: &Stats{
+			Iterations: job.L + 1,
+			Frozen:     frozen_cells(&coldness_matrix),
+			Truncated:  state.truncated(),
+			Seconds:    time.Since(start).Seconds(),
+			Timings:    state.timings,
+		},
+	}
+	if options.measure {
+		result.Structure = &structure
+	}
+	if options.habit {
+		result.Habit = structure.Habit
+	}
+	if options.catalog != "" {
+		if err := catalog_flake(options.catalog, state, job.Out, result.Stats.Seconds); err != nil {
+			result.Error = "failed to add to the catalog: " + err.Error()
+		}
+	}
+	return result
+}
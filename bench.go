@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// note:
+// snow bench times a simulation of the README parameters, to see what a machine does and how many
+// -threads are worth it:
+//
+//   snow bench                     one run on -threads, iterations a second
+//   snow bench -scaling            a run on 1, 2, 4... threads up to all cpus, with the speedup of each
+//   snow bench -counts 1,3,6       the same for these thread counts
+//   snow bench -mask               the step on one thread with the byte mask and with the packed one
+//
+// The speedup is the time on one thread over the time on the count (one thread is run as well if it
+// isn't in the list), and the efficiency is the speedup over the count, 100% when every thread does its
+// share. Every run is checked against the first, a different flake on more threads is a bug and makes
+// the bench fail. On a shared machine the speedup stops going up where the other work takes the cpus.
+//
+// -mask times only the steps, without the checks run() does between them, so the difference is what
+// reading the mask packed does (see bitmask.go): a quarter of the memory for the mask, which matters
+// more the larger the matrix is and the less of it fits in the cache.
+
+// snow bench [-threads N] [-scaling] [-counts 1,2,4] [-mask] [-size 800] [-L 2000] [-precision 64]
+func bench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	threads := flags.Int("threads", runtime.NumCPU(), "threads of the run")
+	report := flags.Bool("scaling", false, "compare 1, 2, 4... threads up to all cpus")
+	var scaling []int
+	flags.Func("counts", "comma separated thread counts to compare, like 1,2,4", func(s string) error {
+		for _, field := range strings.Split(s, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 {
+				return fmt.Errorf("bad thread count %q", field)
+			}
+			scaling = append(scaling, n)
+		}
+		return nil
+	})
+	masks := flags.Bool("mask", false, "compare the step with the byte mask and the packed mask")
+	matrix_size := flags.Int("size", size, "matrix size")
+	L := flags.Int64("L", 2000, "iterations of a run")
+	precision := flags.Int("precision", 64, "64 or 32 bit values")
+	flags.Parse(args)
+	if *report && scaling == nil {
+		for n := 1; n < runtime.NumCPU(); n *= 2 {
+			scaling = append(scaling, n)
+		}
+		scaling = append(scaling, runtime.NumCPU())
+	}
+
+	settings := default_settings()
+	settings.Size, settings.L, settings.Precision = *matrix_size, *L, *precision
+	if err := settings.check(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *threads < 1 {
+		fmt.Fprintln(os.Stderr, "-threads must be at least 1")
+		os.Exit(2)
+	}
+	fmt.Printf("platform:\t %s/%s %s, %d cpus\n", runtime.GOOS, runtime.GOARCH, runtime.Version(), runtime.NumCPU())
+	fmt.Printf("simulation:\t %s, L=%d, %d bit\n", settings.dimensions(), settings.L, *precision)
+
+	if *masks {
+		if settings.single_precision() {
+			compare_masks(settings, func(state *State) *Matrix32 { return &state.Coldness32 })
+		} else {
+			compare_masks(settings, func(state *State) *Matrix { return &state.Coldness })
+		}
+		return
+	}
+
+	timed := func(threads int) (time.Duration, string) {
+		state := new_state(settings)
+		defer state.close()
+		state.threads = threads
+		start := time.Now()
+		run(state, nil)
+		return time.Since(start), state_hash(state)
+	}
+	rate := func(elapsed time.Duration) float64 {
+		return float64(settings.L) / elapsed.Seconds()
+	}
+
+	if scaling == nil {
+		elapsed, _ := timed(*threads)
+		fmt.Printf("%d threads:\t %s, %.1f iterations/s\n", *threads, elapsed.Round(time.Millisecond), rate(elapsed))
+		return
+	}
+	if scaling[0] != 1 {
+		scaling = append([]int{1}, scaling...)
+	}
+	var one time.Duration
+	var first string
+	failed := false
+	fmt.Printf("threads\t time\t iterations/s\t speedup\t efficiency\n")
+	for _, n := range scaling {
+		elapsed, hash := timed(n)
+		if n == 1 {
+			one, first = elapsed, hash
+		}
+		speedup := one.Seconds() / elapsed.Seconds()
+		fmt.Printf("%d\t %s\t %.1f\t\t %.2fx\t\t %.0f%%", n, elapsed.Round(time.Millisecond), rate(elapsed), speedup, 100*speedup/float64(n))
+		if hash != first {
+			failed = true
+			fmt.Printf("\t MISMATCH, the flake differs from one thread")
+		}
+		fmt.Println()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// times L steps with the byte mask and with the packed mask and checks they give the same flake
+func compare_masks[T Real](settings Settings, coldness func(state *State) *Grid[T]) {
+	var bytes time.Duration
+	var first string
+	failed := false
+	fmt.Printf("mask\t memory\t time\t steps/s\t speedup\n")
+	for _, packed := range []bool{false, true} {
+		state := new_state(settings)
+		values := coldness(state)
+		temp := new_rect_grid[T](settings.Size, settings.height())
+		var mask bit_mask
+		memory := 0
+		if packed {
+			mask = pack_mask(state.Mask)
+			for _, row := range mask {
+				memory += 8 * len(row)
+			}
+		} else {
+			for _, row := range state.Mask {
+				memory += len(row)
+			}
+		}
+
+		start := time.Now()
+		for state.Iteration = 0; state.Iteration < settings.L; state.Iteration++ {
+			if packed {
+				step_packed(settings.A, settings.B, settings.Y, values, &temp, mask, &state.Mask, nil)
+			} else {
+				step(settings.A, settings.B, settings.Y, values, &temp, &state.Mask)
+			}
+		}
+		elapsed := time.Since(start)
+		hash := state_hash(state)
+		state.close()
+
+		name := "bytes"
+		if packed {
+			name = "packed"
+		} else {
+			bytes, first = elapsed, hash
+		}
+		fmt.Printf("%s\t %d kB\t %s\t %.1f\t\t %.2fx", name, memory/1024, elapsed.Round(time.Millisecond), float64(settings.L)/elapsed.Seconds(), bytes.Seconds()/elapsed.Seconds())
+		if hash != first {
+			failed = true
+			fmt.Printf("\t MISMATCH, the flake differs from the byte mask")
+		}
+		fmt.Println()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"time"
+)
+
+// note:
+// The mask has four states, so a cell needs two bits of it and not a byte. The plain step reads the mask
+// packed, 32 cells to a uint64, so the mask of an 800 matrix is 160 kB instead of 640 kB and stays in
+// the cache next to the rows of values the step goes through:
+//
+//   word   bits 0-1 cell 0, bits 2-3 cell 1 ... bits 62-63 cell 31
+//   row    the words of one row of the matrix, a row starts with a new word so threads that do
+//          different rows never write the same word
+//
+// The cells past the end of a row are out of bounds. A word of only out of bound cells and obstacles
+// has the high bit of every cell set, the scatter skips those words at once, which takes care of the
+// corners outside the hexagon.
+//
+// The byte mask stays what the state files, memory mapped states, the workers of distributed mode and
+// the other solvers use. The packed mask is made from it when a run starts (and again when a growing
+// grid grows), and a cell the step makes receptive is written to both, so the byte mask is always up to
+// date and nothing else has to know about the packed one. snow bench -mask times the step with both.
+
+type bit_mask [][]uint64
+
+const (
+	cells_per_word = 32
+	high_bits      = 0xaaaaaaaaaaaaaaaa // the high bit of every cell, set for out of bound cells and obstacles
+)
+
+// the mask packed, 2 bits a cell
+func pack_mask(mask Mask) bit_mask {
+	packed := make(bit_mask, len(mask))
+	for i := range mask {
+		words := (len(mask[i]) + cells_per_word - 1) / cells_per_word
+		packed[i] = make([]uint64, words)
+		for w := range packed[i] {
+			// cells past the end of the row are out of bounds
+			var word uint64
+			for k := 0; k < cells_per_word; k++ {
+				m := out_of_bound
+				if j := w*cells_per_word + k; j < len(mask[i]) {
+					m = mask[i][j]
+				}
+				word |= uint64(m) << (2 * k)
+			}
+			packed[i][w] = word
+		}
+	}
+	return packed
+}
+
+func (packed bit_mask) at(i, j int) uint8 {
+	return uint8(packed[i][j/cells_per_word]>>(2*(j%cells_per_word))) & 3
+}
+
+func (packed bit_mask) set(i, j int, m uint8) {
+	shift := 2 * (j % cells_per_word)
+	word := &packed[i][j/cells_per_word]
+	*word = *word&^(3<<shift) | uint64(m)<<shift
+}
+
+// makes the cell receptive in both masks, the byte mask is only written when it changes
+func (packed bit_mask) mark(i, j int, mask *Mask) {
+	if packed.at(i, j) != receptive {
+		packed.set(i, j, receptive)
+		(*mask)[i][j] = receptive
+	}
+}
+
+// same as step() but the mask is read packed, mask is kept up to date with it. The passes are timed into
+// timings if it isn't nil.
+func step_packed[T Real](A, B, Y float64, coldness_matrix, temp_coldness_matrix *Grid[T], packed bit_mask, mask_matrix *Mask, timings *Timings) {
+	size := len(*coldness_matrix)
+	var start time.Time
+	if timings != nil {
+		start = time.Now()
+	}
+	receptive_rows(0, size, coldness_matrix, packed, mask_matrix)
+	if timings != nil {
+		start = timings.add(&timings.Receptive, start)
+	}
+	scatter_rows(A, Y, 0, size, coldness_matrix, temp_coldness_matrix, packed)
+	if timings != nil {
+		timings.add(&timings.Diffusion, start)
+	}
+
+	// swap so the new coldness matrix becomes the current one
+	*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
+}
+
+// the scatter of step() from the cells of the rows from, to into the same rows of the temp matrix, the
+// vapor that goes to the rows outside is left out
+func scatter_rows[T Real](A, Y float64, from, to int, coldness_matrix, temp_coldness_matrix *Grid[T], packed bit_mask) {
+	height := len((*coldness_matrix)[0])
+	for i := from; i < to; i++ {
+		row := (*temp_coldness_matrix)[i]
+		for j := range row {
+			row[j] = 0
+		}
+	}
+
+	// a row that isn't written to, for the vapor that goes out of the rows
+	discard := make([]T, height)
+	for i := from; i < to; i++ {
+		values := (*coldness_matrix)[i]
+		above, row, below := discard, (*temp_coldness_matrix)[i], discard
+		if i > from {
+			above = (*temp_coldness_matrix)[i-1]
+		}
+		if i < to-1 {
+			below = (*temp_coldness_matrix)[i+1]
+		}
+		for w, word := range packed[i] {
+			if word&high_bits == high_bits {
+				// nothing but out of bound cells and obstacles
+				continue
+			}
+			first := w * cells_per_word
+			cells := height - first
+			if cells > cells_per_word {
+				cells = cells_per_word
+			}
+			for k := 0; k < cells; k, word = k+1, word>>2 {
+				j := first + k
+				switch uint8(word) & 3 {
+				case non_receptive:
+					// simulate water floating out to it's neighbour hexagons
+					v0 := values[j]
+					v1 := T(A) * v0 / 12.0
+
+					above[j] += v1
+					above[j+1] += v1
+					row[j-1] += v1
+					row[j] += v0 / 2.0
+					row[j+1] += v1
+					below[j-1] += v1
+					below[j] += v1
+
+				case receptive:
+					// add constant to hexagons next to already frozen hexagon
+					row[j] += values[j] + T(Y)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// note:
+// Some settings make the simulation blow up, the values turn into NaN or infinity or grow without end,
+// or the ice keeps growing past the border until it reaches the edge of the matrix and the next step
+// would write outside of it. Both used to end in a black image after all L iterations or a crash, now
+// the run stops with the iteration, the hexagon and the parameter that is most likely at fault:
+//
+//   edge        checked after every iteration, only the hexagons at the edge of the matrix are looked at
+//   values      checked every sanity_every iterations, a hexagon that is NaN, infinite or further from
+//               0 than exploding is wrong, the coldness of the ice only grows by Y every iteration so
+//               real flakes stay far below it
+//
+// Tiles wrap around and have no edge, fixed point values can't be NaN or infinite. In distributed mode
+// the workers look at their strips the same way and the coordinator checks the whole matrix when one of
+// them found something (see distributed.go).
+
+const (
+	sanity_every = 100
+	exploding    = 1e6
+)
+
+type blowup_error struct {
+	iteration int64
+	i, j      int
+	value     float64
+	edge      bool // the ice reached the edge of the matrix
+	hint      string
+}
+
+func (e *blowup_error) Error() string {
+	problem := fmt.Sprintf("hexagon %d,%d is %g", e.i, e.j, e.value)
+	if e.edge {
+		problem = fmt.Sprintf("the ice reached the edge of the matrix at hexagon %d,%d", e.i, e.j)
+	}
+	when := fmt.Sprintf("at iteration %d", e.iteration)
+	if e.iteration < 0 {
+		when = "before the first iteration"
+	}
+	return fmt.Sprintf("the simulation blew up %s, %s, %s", when, problem, e.hint)
+}
+
+// the blow up of the state if there is one
+func (state *State) sanity() error {
+	settings := state.Settings
+	// tiles have no edge and the last iteration doesn't step from it anymore
+	if !settings.Tile && state.Iteration < settings.L {
+		var i, j int
+		var edge bool
+		switch {
+		case settings.Fixed:
+			i, j, edge = edge_frozen(&state.ColdnessFixed)
+		case settings.single_precision():
+			i, j, edge = edge_frozen(&state.Coldness32)
+		default:
+			i, j, edge = edge_frozen(&state.Coldness)
+		}
+		if edge {
+			return &blowup_error{state.Iteration, i, j, 1, true, blowup_hint(settings, true)}
+		}
+	}
+	if state.Iteration%sanity_every != 0 || settings.Fixed {
+		return nil
+	}
+	var i, j int
+	var v float64
+	var wrong bool
+	if settings.single_precision() {
+		i, j, v, wrong = not_sane(&state.Coldness32)
+	} else {
+		i, j, v, wrong = not_sane(&state.Coldness)
+	}
+	if !wrong {
+		return nil
+	}
+	return &blowup_error{state.Iteration, i, j, v, false, blowup_hint(settings, false)}
+}
+
+// a frozen hexagon in the outer ring of the matrix
+func edge_frozen[T Value](matrix *Grid[T]) (int, int, bool) {
+	size, height := len(*matrix), len((*matrix)[0])
+	one := to_value[T](1.0)
+	for i := 0; i < size; i++ {
+		if (*matrix)[i][0] >= one {
+			return i, 0, true
+		}
+		if (*matrix)[i][height-1] >= one {
+			return i, height - 1, true
+		}
+	}
+	for j := 0; j < height; j++ {
+		if (*matrix)[0][j] >= one {
+			return 0, j, true
+		}
+		if (*matrix)[size-1][j] >= one {
+			return size - 1, j, true
+		}
+	}
+	return 0, 0, false
+}
+
+// the first hexagon that is NaN, infinite or exploding
+func not_sane[T Real](matrix *Grid[T]) (int, int, float64, bool) {
+	for i := range *matrix {
+		for j, v := range (*matrix)[i] {
+			if f := float64(v); math.IsNaN(f) || math.Abs(f) > exploding {
+				return i, j, f, true
+			}
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// the parameter that most likely made it blow up
+func blowup_hint(settings Settings, edge bool) string {
+	a, y := a_hint(settings.A), y_hint(settings.Y)
+	switch {
+	case a != "":
+		return a
+	case settings.Kinetics != nil && !edge:
+		return "the attachment kinetics are unstable, look at -beta, -kappa, -mu and -gamma"
+	case math.Abs(settings.B) > exploding || math.Abs(settings.PP) > exploding || math.Abs(settings.PM) > exploding:
+		return "B, PP and PM put the background far out of range, the background only makes sense from 0 to 1"
+	case settings.B >= 1:
+		return fmt.Sprintf("B is %g, a background of 1 or more is frozen from the start, try from 0 to 1", settings.B)
+	case y != "":
+		return y
+	case edge:
+		return "the flake kept growing after it touched the border, lower L, use -fill to stop in time or give it room with -grid or -grow"
+	}
+	return "look at A and Y first, A from 0 to 1 and Y from 0.0001 to 0.01 are stable"
+}
+
+// why A blows the simulation up, empty when it doesn't
+func a_hint(a float64) string {
+	switch {
+	case a > 1:
+		return fmt.Sprintf("A is %g, above 1 the vapor grows every iteration instead of spreading out and everything freezes, try 1 or less", a)
+	case a < 0:
+		return fmt.Sprintf("A is %g, below 0 the vapor flips sign every iteration, try from 0 to 1", a)
+	}
+	return ""
+}
+
+// why Y blows the simulation up, empty when it doesn't
+func y_hint(y float64) string {
+	switch {
+	case y > 0.1:
+		return fmt.Sprintf("Y is %g, a hexagon next to the ice freezes in %.0f iterations or less, try 0.01 or less", y, math.Ceil(1/y))
+	case y < 0:
+		return fmt.Sprintf("Y is %g, below 0 the ice melts away without end, try from 0.0001 to 0.01", y)
+	}
+	return ""
+}
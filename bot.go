@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// note:
+// snow bot grows flakes for a Discord or Slack server, somebody types
+//
+//   /snowflake seed=alice style=dendrite
+//
+// and the bot answers with the flake and its code, to grow it again with snow -from-code. Both take
+// slash commands over http, so the bot is a server like snow serve with its slots, rate limits and
+// limits on the size and the iterations, and one endpoint for each:
+//
+//   /discord   the interactions endpoint url of a discord application, checked with its public key
+//   /slack     the request url of a slack slash command, checked with the signing secret of the app
+//
+// The command answers at once that the flake is growing, both want an answer within 3 seconds, and the
+// flake follows when it's done: uploaded to the message on discord, and for slack an image block with
+// the url of the flake on the bot, so slack needs -public-url. The last bot_images flakes are kept for
+// slack to fetch. A flake waits for a slot up to bot_wait, discord stops taking the answer after 15
+// minutes, with more than -max-queued flakes waiting the next ones are turned down.
+//
+// What can be asked for:
+//
+//   seed=word       the same word grows the same flake, without one every flake is new
+//   style=name      fern, dendrite, sectored or plate, picks B and Y from bot_styles
+//   code=XXXX-XXXX  a flake from its code, with its own L and size
+//   size=400        the size of the matrix
+//   A= B= Y= PP= PM= L=
+//
+// The parameters are random in the ranges of -random unless they are given. Without L the flake grows
+// until it reaches bot_reach of the way to the border, so every flake fills the image whatever it is.
+// The styles come from a sweep over B and Y at size 400 and how the flakes look, the habit (habit.go)
+// is only measured on larger flakes.
+
+const (
+	bot_reach  = 0.7
+	bot_images = 100
+	bot_wait   = 10 * time.Minute
+)
+
+// the B and Y ranges of the styles, the other parameters keep the ranges of -random
+var bot_styles = map[string]struct {
+	B, Y     random_range
+	describe string
+}{
+	"fern":     {random_range{0.55, 0.65}, random_range{0.0005, 0.001}, "dense arms covered in branches"},
+	"dendrite": {random_range{0.40, 0.44}, random_range{0.0008, 0.0015}, "thin arms with separate side branches"},
+	"sectored": {random_range{0.46, 0.50}, random_range{0.003, 0.005}, "wide arms with their branches grown together"},
+	"plate":    {random_range{0.30, 0.33}, random_range{0.004, 0.008}, "a solid middle with short arms"},
+}
+
+func bot_style_names() []string {
+	var names []string
+	for name := range bot_styles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func bot_usage() string {
+	return fmt.Sprintf("/snowflake [seed=word] [style=%s] [code=XXXX-XXXX] [size=400] [A= B= Y= PP= PM= L=]", strings.Join(bot_style_names(), "|"))
+}
+
+type bot struct {
+	server     *server       // the slots, the rate limits and the limits of snow serve
+	queue      chan struct{} // one for every flake waiting or growing
+	size       int           // of flakes that don't say
+	public_url string        // where slack fetches the images
+
+	discord_key  ed25519.PublicKey
+	discord_api  string
+	slack_secret []byte
+
+	images_mutex sync.Mutex
+	images       map[string][]byte // png by code
+	order        []string          // codes oldest first
+}
+
+// what a message asks for
+type flake_request struct {
+	settings Settings
+	grow     bool // no L, grows until bot_reach
+	seed     string
+	style    string
+}
+
+// reads "seed=alice style=dendrite B=0.4", keys in any case
+func parse_flake_request(text string, size int) (flake_request, error) {
+	values := map[string]string{}
+	for _, field := range strings.Fields(text) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return flake_request{}, fmt.Errorf("%q isn't key=value, like seed=alice", field)
+		}
+		values[strings.ToLower(key)] = value
+	}
+	take := func(key string) (string, bool) {
+		value, ok := values[key]
+		delete(values, key)
+		return value, ok
+	}
+
+	request := flake_request{grow: true}
+	request.seed, _ = take("seed")
+	request.style, _ = take("style")
+	if code, ok := take("code"); ok {
+		settings, err := decode_code(code)
+		if err != nil {
+			return request, fmt.Errorf("code %s: %v", code, err)
+		}
+		request.settings, request.grow = settings, false
+	} else {
+		ranges := map[string]random_range{}
+		for name, r := range random_ranges {
+			ranges[name] = r
+		}
+		if request.style != "" {
+			style, ok := bot_styles[strings.ToLower(request.style)]
+			if !ok {
+				return request, fmt.Errorf("there is no style %q, the styles are %s", request.style, strings.Join(bot_style_names(), ", "))
+			}
+			ranges["B"], ranges["Y"] = style.B, style.Y
+		}
+		source := time.Now().UnixNano()
+		if request.seed != "" {
+			h := fnv.New64a()
+			h.Write([]byte(strings.ToLower(request.seed)))
+			source = int64(h.Sum64())
+		}
+		request.settings = random_settings(rand.New(rand.NewSource(source)), ranges, size, 0, 0)
+	}
+
+	floats := map[string]*float64{"a": &request.settings.A, "b": &request.settings.B, "y": &request.settings.Y, "pp": &request.settings.PP, "pm": &request.settings.PM}
+	for key, field := range floats {
+		if value, ok := take(key); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return request, fmt.Errorf("bad value for %s: %q", strings.ToUpper(key), value)
+			}
+			*field = v
+		}
+	}
+	if value, ok := take("l"); ok {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return request, fmt.Errorf("bad value for L: %q", value)
+		}
+		request.settings.L, request.grow = v, false
+	}
+	if value, ok := take("size"); ok {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return request, fmt.Errorf("bad value for size: %q", value)
+		}
+		request.settings.Size = v
+	}
+	for key := range values {
+		return request, fmt.Errorf("%s isn't something a flake can have, try %s", key, bot_usage())
+	}
+	return request, request.settings.check()
+}
+
+// a flake grown for a message
+type chat_flake struct {
+	request flake_request
+	code    string
+	png     []byte
+}
+
+// the text next to the image
+func (f chat_flake) summary() string {
+	s := f.request.settings
+	var asked []string
+	if f.request.seed != "" {
+		asked = append(asked, "seed "+f.request.seed)
+	}
+	if f.request.style != "" {
+		asked = append(asked, strings.ToLower(f.request.style))
+	}
+	about := ""
+	if len(asked) > 0 {
+		about = " for " + strings.Join(asked, ", ")
+	}
+	return fmt.Sprintf("snowflake%s, code `%s` (A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f L=%d size=%s)\ngrow it yourself with `snow -from-code %s`",
+		about, f.code, s.A, s.B, s.Y, s.PP, s.PM, s.L, s.dimensions(), f.code)
+}
+
+// grows the flake a message asks for, client is who sent it for the rate limit
+func (b *bot) grow(client, text string) (chat_flake, error) {
+	request, err := parse_flake_request(text, b.size)
+	if err != nil {
+		return chat_flake{}, err
+	}
+	if request.grow {
+		request.settings.L = b.server.limits.MaxIterations
+	}
+	if err := b.server.check_limits(request.settings); err != nil {
+		return chat_flake{}, err
+	}
+	if ok, wait := b.server.clients.allow(client, time.Now()); !ok {
+		return chat_flake{}, fmt.Errorf("that's a lot of flakes, the next one in %s", wait.Round(time.Second))
+	}
+
+	select {
+	case b.queue <- struct{}{}:
+		defer func() { <-b.queue }()
+	default:
+		return chat_flake{}, errors.New("too many flakes are waiting, try again in a few minutes")
+	}
+	select {
+	case b.server.running <- struct{}{}:
+		defer func() { <-b.server.running }()
+	case <-time.After(bot_wait):
+		return chat_flake{}, errors.New("the bot is too busy, try again later")
+	}
+
+	ctx, cancel := b.server.context(context.Background())
+	defer cancel()
+	state := new_state(request.settings)
+	defer state.close()
+	reach := bot_reach * float64(request.settings.Size) / 2
+	err = run_context(ctx, state, func(iteration int64) {
+		if !request.grow || iteration%100 != 0 {
+			return
+		}
+		radius := 0.0
+		if state.Settings.single_precision() {
+			_, radius = frozen_extent(state.Settings, &state.Coldness32)
+		} else {
+			_, radius = frozen_extent(state.Settings, &state.Coldness)
+		}
+		if radius >= reach {
+			state.Settings.L = iteration
+		}
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return chat_flake{}, fmt.Errorf("the flake took longer than the limit of %s", b.server.limits.Timeout)
+	} else if err != nil {
+		return chat_flake{}, err
+	}
+
+	flake := chat_flake{request: request}
+	flake.request.settings = state.Settings
+	if flake.code, err = encode_code(state.Settings); err != nil {
+		return chat_flake{}, err
+	}
+	coldness_matrix := state.values()
+	var png bytes.Buffer
+	if err := write_png(&png, render_settings(state.Settings, &coldness_matrix), state.metadata()); err != nil {
+		return chat_flake{}, err
+	}
+	flake.png = png.Bytes()
+	return flake, nil
+}
+
+// keeps the image for slack to fetch, the oldest goes after bot_images
+func (b *bot) keep(code string, png []byte) {
+	b.images_mutex.Lock()
+	defer b.images_mutex.Unlock()
+	if _, ok := b.images[code]; !ok {
+		b.order = append(b.order, code)
+	}
+	b.images[code] = png
+	if len(b.order) > bot_images {
+		delete(b.images, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// GET /flakes/<code>.png
+func (b *bot) image(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/flakes/"), ".png")
+	b.images_mutex.Lock()
+	png, ok := b.images[code]
+	b.images_mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// reads the body of a request from discord or slack, they are small
+func read_body(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// POST /discord, the interactions of the application
+func (b *bot) discord(w http.ResponseWriter, r *http.Request) {
+	body, ok := read_body(w, r)
+	if !ok {
+		return
+	}
+	// discord sends requests with bad signatures to check that they are turned down
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	if err != nil || !ed25519.Verify(b.discord_key, append([]byte(timestamp), body...), signature) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	type discord_user struct {
+		ID string `json:"id"`
+	}
+	var interaction struct {
+		Type          int    `json:"type"`
+		Token         string `json:"token"`
+		ApplicationID string `json:"application_id"`
+		Data          struct {
+			Name    string `json:"name"`
+			Options []struct {
+				Name  string      `json:"name"`
+				Value interface{} `json:"value"`
+			} `json:"options"`
+		} `json:"data"`
+		Member *struct {
+			User discord_user `json:"user"`
+		} `json:"member"` // in a server
+		User *discord_user `json:"user"` // in a direct message
+	}
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch interaction.Type {
+	case 1: // ping
+		write_json(w, http.StatusOK, map[string]int{"type": 1})
+		return
+	case 2: // a slash command
+	default:
+		http.Error(w, "unsupported interaction", http.StatusBadRequest)
+		return
+	}
+
+	var fields []string
+	for _, option := range interaction.Data.Options {
+		if option.Name == "more" {
+			fields = append(fields, fmt.Sprint(option.Value))
+		} else {
+			fields = append(fields, fmt.Sprintf("%s=%v", option.Name, option.Value))
+		}
+	}
+	user := ""
+	if interaction.Member != nil {
+		user = interaction.Member.User.ID
+	} else if interaction.User != nil {
+		user = interaction.User.ID
+	}
+	text := strings.Join(fields, " ")
+	// a message that can't be a flake gets the error at once, only the one who sent it sees it
+	if _, err := parse_flake_request(text, b.size); err != nil {
+		write_json(w, http.StatusOK, map[string]interface{}{"type": 4, "data": map[string]interface{}{"content": err.Error(), "flags": 64}})
+		return
+	}
+
+	// "thinking" until the flake replaces it
+	write_json(w, http.StatusOK, map[string]int{"type": 5})
+	go func() {
+		var message string
+		flake, err := b.grow("discord:"+user, text)
+		if err != nil {
+			message = "couldn't grow the flake: " + err.Error()
+		} else {
+			message = flake.summary()
+		}
+		if err := b.discord_edit(interaction.ApplicationID, interaction.Token, message, flake.png); err != nil {
+			log.Printf("answering discord: %v", err)
+		}
+	}()
+}
+
+// replaces the thinking message of the interaction with the flake, png is left out when it's nil
+func (b *bot) discord_edit(application, token, content string, png []byte) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	payload := map[string]interface{}{"content": content}
+	if png != nil {
+		payload["attachments"] = []map[string]interface{}{{"id": 0, "filename": "snowflake.png"}}
+	}
+	data, _ := json.Marshal(payload)
+	form.WriteField("payload_json", string(data))
+	if png != nil {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="files[0]"; filename="snowflake.png"`)
+		header.Set("Content-Type", "image/png")
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		part.Write(png)
+	}
+	form.Close()
+
+	request, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", b.discord_api, application, token), &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return send(request)
+}
+
+// POST /slack, the slash command
+func (b *bot) slack(w http.ResponseWriter, r *http.Request) {
+	body, ok := read_body(w, r)
+	if !ok {
+		return
+	}
+	// signed with the secret, and not older than 5 minutes so it can't be sent again later
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if age := time.Since(time.Unix(seconds, 0)); err != nil || age > 5*time.Minute || age < -5*time.Minute {
+		http.Error(w, "bad timestamp", http.StatusUnauthorized)
+		return
+	}
+	mac := hmac.New(sha256.New, b.slack_secret)
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	if !hmac.Equal([]byte("v0="+hex.EncodeToString(mac.Sum(nil))), []byte(r.Header.Get("X-Slack-Signature"))) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	text, user, response_url := form.Get("text"), form.Get("user_id"), form.Get("response_url")
+	if strings.TrimSpace(text) == "help" {
+		write_json(w, http.StatusOK, map[string]string{"response_type": "ephemeral", "text": bot_usage()})
+		return
+	}
+	if _, err := parse_flake_request(text, b.size); err != nil {
+		write_json(w, http.StatusOK, map[string]string{"response_type": "ephemeral", "text": err.Error()})
+		return
+	}
+
+	// only the one who asked sees that it's growing, the flake goes to the channel
+	write_json(w, http.StatusOK, map[string]string{"response_type": "ephemeral", "text": "growing your snowflake, it takes a few seconds"})
+	go func() {
+		var reply map[string]interface{}
+		flake, err := b.grow("slack:"+user, text)
+		if err != nil {
+			reply = map[string]interface{}{"response_type": "ephemeral", "text": "couldn't grow the flake: " + err.Error()}
+		} else {
+			b.keep(flake.code, flake.png)
+			summary := fmt.Sprintf("<@%s> %s", user, flake.summary())
+			reply = map[string]interface{}{
+				"response_type": "in_channel",
+				"text":          summary,
+				"blocks": []interface{}{
+					map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": summary}},
+					map[string]interface{}{"type": "image", "image_url": b.public_url + "/flakes/" + flake.code + ".png", "alt_text": "snowflake " + flake.code},
+				},
+			}
+		}
+		data, _ := json.Marshal(reply)
+		request, err := http.NewRequest(http.MethodPost, response_url, bytes.NewReader(data))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/json")
+			err = send(request)
+		}
+		if err != nil {
+			log.Printf("answering slack: %v", err)
+		}
+	}()
+}
+
+// does the request, an error for anything but a 2xx
+func send(request *http.Request) error {
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%s %s: %s %s", request.Method, request.URL.Redacted(), response.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// the /snowflake command of a discord application, its options are what parse_flake_request reads
+func discord_command(max_size int) map[string]interface{} {
+	var styles []map[string]string
+	for _, name := range bot_style_names() {
+		styles = append(styles, map[string]string{"name": name + ", " + bot_styles[name].describe, "value": name})
+	}
+	// 3 is a string option and 4 an integer one
+	return map[string]interface{}{
+		"name":        "snowflake",
+		"description": "grow a snowflake",
+		"options": []map[string]interface{}{
+			{"type": 3, "name": "seed", "description": "a word, the same word grows the same flake"},
+			{"type": 3, "name": "style", "description": "the kind of flake", "choices": styles},
+			{"type": 3, "name": "code", "description": "the code of a flake to grow it again"},
+			{"type": 4, "name": "size", "description": "size of the matrix", "min_value": 50, "max_value": max_size},
+			{"type": 3, "name": "more", "description": "other settings, like B=0.4 Y=0.001 L=3000"},
+		},
+	}
+}
+
+// snow bot [-addr :8090] [-public-url https://bot.example.com] [-register]
+func bot_command(args []string) {
+	flags := flag.NewFlagSet("bot", flag.ExitOnError)
+	addr := flags.String("addr", ":8090", "address to listen on")
+	public_url := flags.String("public-url", "", "url the bot is reached on from outside, like https://bot.example.com, slack fetches the images from it")
+	flake_size := flags.Int("size", 400, "matrix size of the flakes that don't ask for one")
+	var limits Limits
+	flags.IntVar(&limits.MaxConcurrent, "max-concurrent", runtime.NumCPU(), "flakes growing at the same time")
+	max_queued := flags.Int("max-queued", 20, "flakes waiting or growing before the next ones are turned down")
+	flags.Float64Var(&limits.Rate, "rate", 3, "flakes per minute per user, 0 turns the limit off")
+	flags.IntVar(&limits.Burst, "burst", 2, "flakes a user can ask for at once")
+	flags.IntVar(&limits.MaxSize, "max-size", 800, "largest matrix size a user can ask for")
+	flags.Int64Var(&limits.MaxIterations, "max-iterations", 50000, "largest L a user can ask for, and where a flake that didn't reach the border stops")
+	flags.DurationVar(&limits.Timeout, "timeout", 2*time.Minute, "longest a flake can grow, 0 for no limit")
+	discord_key := flags.String("discord-public-key", os.Getenv("DISCORD_PUBLIC_KEY"), "public key of the discord application, turns on /discord")
+	discord_api := flags.String("discord-api", "https://discord.com/api/v10", "the discord api")
+	register := flags.Bool("register", false, "add the /snowflake command to the discord application of DISCORD_APPLICATION_ID with DISCORD_BOT_TOKEN and quit")
+	flags.Parse(args)
+
+	if *register {
+		application, token := os.Getenv("DISCORD_APPLICATION_ID"), os.Getenv("DISCORD_BOT_TOKEN")
+		if application == "" || token == "" {
+			fmt.Fprintln(os.Stderr, "-register needs DISCORD_APPLICATION_ID and DISCORD_BOT_TOKEN")
+			os.Exit(2)
+		}
+		data, _ := json.Marshal([]interface{}{discord_command(limits.MaxSize)})
+		request, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/applications/%s/commands", *discord_api, application), bytes.NewReader(data))
+		if err == nil {
+			request.Header.Set("Authorization", "Bot "+token)
+			request.Header.Set("Content-Type", "application/json")
+			err = send(request)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to register the command:", err)
+			os.Exit(1)
+		}
+		fmt.Println("registered /snowflake")
+		return
+	}
+
+	if limits.MaxConcurrent < 1 || *max_queued < limits.MaxConcurrent {
+		fmt.Fprintln(os.Stderr, "-max-concurrent must be at least 1 and -max-queued at least -max-concurrent")
+		os.Exit(2)
+	}
+	if limits.Rate > 0 && limits.Burst < 1 {
+		fmt.Fprintln(os.Stderr, "-burst must be at least 1 when -rate limits the flakes")
+		os.Exit(2)
+	}
+	b := &bot{
+		server:       new_server(limits),
+		queue:        make(chan struct{}, *max_queued),
+		size:         *flake_size,
+		public_url:   strings.TrimSuffix(*public_url, "/"),
+		discord_api:  strings.TrimSuffix(*discord_api, "/"),
+		slack_secret: []byte(os.Getenv("SLACK_SIGNING_SECRET")),
+		images:       map[string][]byte{},
+	}
+	settings := default_settings()
+	settings.Size = b.size
+	if err := b.server.check_limits(settings); err != nil {
+		fmt.Fprintln(os.Stderr, "-size:", err)
+		os.Exit(2)
+	}
+
+	mux := http.NewServeMux()
+	var chats []string
+	if *discord_key != "" {
+		key, err := hex.DecodeString(*discord_key)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			fmt.Fprintln(os.Stderr, "-discord-public-key must be the hex public key of the application")
+			os.Exit(2)
+		}
+		b.discord_key = key
+		mux.HandleFunc("/discord", b.discord)
+		chats = append(chats, "discord on /discord")
+	}
+	if len(b.slack_secret) > 0 {
+		if b.public_url == "" {
+			fmt.Fprintln(os.Stderr, "slack fetches the images from the bot, it needs -public-url")
+			os.Exit(2)
+		}
+		mux.HandleFunc("/slack", b.slack)
+		mux.HandleFunc("/flakes/", b.image)
+		chats = append(chats, "slack on /slack")
+	}
+	if chats == nil {
+		fmt.Fprintln(os.Stderr, "set -discord-public-key (or DISCORD_PUBLIC_KEY) for discord and SLACK_SIGNING_SECRET for slack")
+		os.Exit(2)
+	}
+
+	log.Printf("bot for %s on %s (max %d growing, %d waiting, %.1f flakes/min per user, size <= %d, L <= %d, timeout %s)",
+		strings.Join(chats, " and "), *addr, limits.MaxConcurrent, *max_queued, limits.Rate, limits.MaxSize, limits.MaxIterations, limits.Timeout)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// note:
+// The catalog keeps the flakes that were made in a SQLite database, -catalog snowflakes/catalog.db adds
+// the result of a run, and of every job with snow batch -catalog. A row has the parameters and the seed,
+// how the run went, the traits, the structure with the habit, and where the result was saved. snow
+// catalog list and query filter them with the where clause of an SQL query:
+//
+//   snow catalog list -where "symmetry > 0.9 AND habit LIKE '%dendrite'"
+//   snow catalog query -where "arms = 6" -order "branches DESC" -limit 10
+//
+// list prints a line for every flake, query every column as json lines. symmetry is the score of the
+// traits from 0 to 1, fold their symmetry in words. The driver is pure Go, no C compiler needed.
+
+const default_catalog = "snowflakes/catalog.db"
+
+const catalog_schema = `CREATE TABLE IF NOT EXISTS flakes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created TEXT NOT NULL,
+	out TEXT NOT NULL,
+	A REAL, B REAL, Y REAL, PP REAL, PM REAL, L INTEGER,
+	size INTEGER, height INTEGER,
+	seed_x INTEGER, seed_y INTEGER, seed_value REAL,
+	iterations INTEGER, truncated INTEGER, seconds REAL,
+	code TEXT, settings TEXT,
+	frozen INTEGER, radius INTEGER, fill REAL, edge REAL,
+	arms INTEGER, form TEXT, density TEXT, fold TEXT, symmetry REAL, mirror INTEGER, mirror_score REAL,
+	arm_length REAL, branches REAL, spacing REAL, area INTEGER, perimeter INTEGER, perimeter_ratio REAL,
+	habit TEXT
+)`
+
+func open_catalog(filename string) (*sql.DB, error) {
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	// a batch and a run can add to the same catalog, the second one waits for the first
+	db, err := sql.Open("sqlite", filename+"?_pragma=busy_timeout(10000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(catalog_schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return db, nil
+}
+
+// adds the finished flake saved as out
+func catalog_flake(filename string, state *State, out string, seconds float64) error {
+	db, err := open_catalog(filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	settings := state.Settings
+	traits, structure := analyze(state), measure_structure(state)
+	encoded, _ := json.Marshal(settings)
+	code, _ := encode_code(settings)
+	x, y := settings.seed_pos()
+	var truncated interface{}
+	if state.Truncated >= 0 {
+		truncated = state.Truncated
+	}
+	_, err = db.Exec(`INSERT INTO flakes (created, out, A, B, Y, PP, PM, L, size, height, seed_x, seed_y, seed_value,
+		iterations, truncated, seconds, code, settings, frozen, radius, fill, edge,
+		arms, form, density, fold, symmetry, mirror, mirror_score,
+		arm_length, branches, spacing, area, perimeter, perimeter_ratio, habit)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UTC().Format(time.RFC3339), out, settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L,
+		settings.Size, settings.height(), x, y, settings.seed_value(),
+		state.Iteration, truncated, seconds, code, string(encoded), traits.Frozen, traits.Radius, traits.Fill, traits.Edge,
+		traits.Arms, traits.Form, traits.Density, traits.Symmetry, traits.SymmetryScore, traits.Mirror, traits.MirrorScore,
+		structure.ArmLength, structure.Branches, structure.Spacing, structure.Area, structure.Perimeter, structure.PerimeterRatio,
+		structure.Habit)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// snow catalog list|query [-db snowflakes/catalog.db] [-where ...] [-order ...] [-limit n]
+func catalog_command(args []string) {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "query") {
+		fmt.Fprintf(os.Stderr, "usage: snow catalog list|query [flags]\n")
+		os.Exit(2)
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("catalog "+command, flag.ExitOnError)
+	filename := flags.String("db", default_catalog, "catalog database")
+	where := flags.String("where", "", "SQL condition the flakes have to meet, like \"symmetry > 0.9 AND habit = 'fern'\"")
+	order := flags.String("order", "id", "SQL order of the flakes, like \"branches DESC\"")
+	limit := flags.Int("limit", 0, "flakes to print at most, all of them when 0")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow catalog %s [flags]\n\n", command)
+		if command == "list" {
+			fmt.Fprintf(flags.Output(), "prints a line for every flake in the catalog\n")
+		} else {
+			fmt.Fprintf(flags.Output(), "prints every column of the flakes in the catalog as json lines\n")
+		}
+		fmt.Fprintf(flags.Output(), "the columns are %s\n", strings.Join(catalog_columns(), ", "))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	db, err := open_catalog(*filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	query := "SELECT * FROM flakes"
+	if *where != "" {
+		query += " WHERE " + *where
+	}
+	query += " ORDER BY " + *order
+	if *limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", *limit)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bad query:", err)
+		os.Exit(2)
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	lines := json.NewEncoder(os.Stdout)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for k := range values {
+			pointers[k] = &values[k]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		row := make(map[string]interface{}, len(columns))
+		for k, column := range columns {
+			if b, ok := values[k].([]byte); ok {
+				values[k] = string(b)
+			}
+			row[column] = values[k]
+		}
+		if command == "query" {
+			lines.Encode(row)
+			continue
+		}
+		fmt.Printf("%v\t %v\t %v, %v arms, %v, symmetry %.2f, A=%.4f B=%.4f Y=%.4f I=%v\n", row["id"], row["out"],
+			row["habit"], row["arms"], row["fold"], row["symmetry"], row["A"], row["B"], row["Y"], row["iterations"])
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// the columns of the flakes table in the order of the schema
+func catalog_columns() []string {
+	schema := catalog_schema[strings.Index(catalog_schema, "(")+1 : strings.LastIndex(catalog_schema, ")")]
+	var columns []string
+	for _, definition := range strings.Split(schema, ",") {
+		if fields := strings.Fields(definition); len(fields) > 0 {
+			columns = append(columns, fields[0])
+		}
+	}
+	return columns
+}
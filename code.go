@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// note:
+// A code is a short base58 string holding all the settings, to share a flake without typing six floats.
+//
+//   version     1 byte
+//   fields      uvarint, one bit for every field below that isn't the default
+//   values      zigzag varint of the difference to the default, for every field that is set
+//   check       1 byte, the first byte of the sha256 of everything before it, catches most typos
+//
+// Floats are stored with 4 decimals like in the file names, settings with more decimals can't be put in
+// a code. The base58 string is split in groups of four with dashes, they are ignored when reading it.
+
+const code_version = 1
+
+const base58_alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// a float or an int of the settings with the value it has when it isn't set
+type code_field struct {
+	name     string
+	decimals bool // stored as 1/10000
+	initial  float64
+	get      func(s *Settings) (float64, bool)
+	set      func(s *Settings, v float64)
+}
+
+func float_field(name string, initial float64, field func(s *Settings) *float64) code_field {
+	return code_field{name, true, initial,
+		func(s *Settings) (float64, bool) { return *field(s), *field(s) != initial },
+		func(s *Settings, v float64) { *field(s) = v }}
+}
+
+func int_field(name string, initial int, field func(s *Settings) *int) code_field {
+	return code_field{name, false, float64(initial),
+		func(s *Settings) (float64, bool) { return float64(*field(s)), *field(s) != initial },
+		func(s *Settings, v float64) { *field(s) = int(v) }}
+}
+
+// a value of the attachment kinetics, beta always comes first and is always set so the decoder creates them
+func kinetics_field(name string, field func(k *Kinetics) *float64) code_field {
+	return code_field{name, true, 0,
+		func(s *Settings) (float64, bool) {
+			if s.Kinetics == nil {
+				return 0, false
+			}
+			return *field(s.Kinetics), name == "beta" || *field(s.Kinetics) != 0
+		},
+		func(s *Settings, v float64) { *field(s.kinetics()) = v }}
+}
+
+// a weight of a direction, the decoder creates the weights with the first one and they are all set then
+func weight_field(k int) code_field {
+	return code_field{fmt.Sprintf("weight %d", k+1), true, 1,
+		func(s *Settings) (float64, bool) {
+			if s.Weights == nil {
+				return 1, false
+			}
+			return s.Weights[k], true
+		},
+		func(s *Settings, v float64) { s.weights()[k] = v }}
+}
+
+// pointers and strings get a field per value, the order can never change
+var code_fields = []code_field{
+	float_field("A", 1.0, func(s *Settings) *float64 { return &s.A }),
+	float_field("B", 0.33, func(s *Settings) *float64 { return &s.B }),
+	float_field("Y", 0.0002, func(s *Settings) *float64 { return &s.Y }),
+	float_field("PP", 0.05, func(s *Settings) *float64 { return &s.PP }),
+	float_field("PM", 0.2, func(s *Settings) *float64 { return &s.PM }),
+	{"L", false, 10000,
+		func(s *Settings) (float64, bool) { return float64(s.L), s.L != 10000 },
+		func(s *Settings, v float64) { s.L = int64(v) }},
+	int_field("size", size, func(s *Settings) *int { return &s.Size }),
+	{"precision", false, 64,
+		func(s *Settings) (float64, bool) { return float64(s.Precision), s.single_precision() },
+		func(s *Settings, v float64) { s.Precision = int(v) }},
+	{"fixed", false, 0,
+		func(s *Settings) (float64, bool) { return 1, s.Fixed },
+		func(s *Settings, v float64) { s.Fixed = v != 0 }},
+	{"seed x", false, 0,
+		func(s *Settings) (float64, bool) { x, _ := s.seed_pos(); return float64(x), s.SeedPos != nil },
+		func(s *Settings, v float64) { s.SeedPos = &[2]int{int(v), 0} }},
+	{"seed y", false, 0,
+		func(s *Settings) (float64, bool) { _, y := s.seed_pos(); return float64(y), s.SeedPos != nil },
+		func(s *Settings, v float64) {
+			if s.SeedPos == nil {
+				s.SeedPos = &[2]int{}
+			}
+			s.SeedPos[1] = int(v)
+		}},
+	float_field("seed value", 1.0, func(s *Settings) *float64 { return &s.SeedValue }),
+	{"B_edge", true, 0,
+		func(s *Settings) (float64, bool) {
+			if s.BEdge == nil {
+				return 0, false
+			}
+			return *s.BEdge, true
+		},
+		func(s *Settings, v float64) { s.BEdge = &v }},
+	{"radial inner", true, 0,
+		func(s *Settings) (float64, bool) { inner, _, ok := s.radial_background(); return inner, ok },
+		func(s *Settings, v float64) { s.Background = fmt.Sprintf("radial:%g,", v) }},
+	{"radial outer", true, 0,
+		func(s *Settings) (float64, bool) { _, outer, ok := s.radial_background(); return outer, ok },
+		func(s *Settings, v float64) { s.Background += fmt.Sprintf("%g", v) }}, // always comes right after radial inner
+	int_field("octaves", 1, func(s *Settings) *int { return &s.Octaves }),
+	float_field("lacunarity", 2, func(s *Settings) *float64 { return &s.Lacunarity }),
+	float_field("gain", 0.5, func(s *Settings) *float64 { return &s.Gain }),
+	int_field("max_size", 0, func(s *Settings) *int { return &s.MaxSize }),
+	{"tile", false, 0,
+		func(s *Settings) (float64, bool) { return 1, s.Tile },
+		func(s *Settings, v float64) { s.Tile = v != 0 }},
+	int_field("seeds", 0, func(s *Settings) *int { return &s.Seeds }),
+	int_field("height", 0, func(s *Settings) *int { return &s.Height }),
+	{"laplacian", false, 0,
+		func(s *Settings) (float64, bool) { return 1, s.laplacian() },
+		func(s *Settings, v float64) { s.Solver = "laplacian" }},
+	float_field("diffusion", 0, func(s *Settings) *float64 { return &s.Diffusion }),
+	int_field("substeps", 0, func(s *Settings) *int { return &s.Substeps }),
+	float_field("heat", 0, func(s *Settings) *float64 { return &s.Heat }),
+	float_field("cooling", 0, func(s *Settings) *float64 { return &s.Cooling }),
+	kinetics_field("beta", func(k *Kinetics) *float64 { return &k.Beta }),
+	kinetics_field("alpha", func(k *Kinetics) *float64 { return &k.Alpha }),
+	kinetics_field("theta", func(k *Kinetics) *float64 { return &k.Theta }),
+	kinetics_field("kappa", func(k *Kinetics) *float64 { return &k.Kappa }),
+	kinetics_field("mu", func(k *Kinetics) *float64 { return &k.Mu }),
+	kinetics_field("gamma", func(k *Kinetics) *float64 { return &k.Gamma }),
+	{"attach neighbours", false, 0,
+		func(s *Settings) (float64, bool) {
+			if s.Kinetics == nil {
+				return 0, false
+			}
+			return float64(s.Kinetics.Neighbours), s.Kinetics.Neighbours != 0
+		},
+		func(s *Settings, v float64) { s.kinetics().Neighbours = int(v) }},
+	float_field("smoothing", 0, func(s *Settings) *float64 { return &s.Smoothing }),
+	float_field("curvature", 0, func(s *Settings) *float64 { return &s.Curvature }),
+	{"kahan", false, 0,
+		func(s *Settings) (float64, bool) { return 1, s.kahan() },
+		func(s *Settings, v float64) { s.Summation = "kahan" }},
+	weight_field(0),
+	weight_field(1),
+	weight_field(2),
+	weight_field(3),
+	weight_field(4),
+	weight_field(5),
+	{"pad", false, 0,
+		func(s *Settings) (float64, bool) { return 1, s.padded() },
+		func(s *Settings, v float64) { s.Crop = "pad" }},
+}
+
+// the settings the command line starts from, a code only stores what is different
+func code_defaults() Settings {
+	settings := default_settings()
+	settings.Precision = 64
+	settings.SeedValue = 1.0
+	settings.Background = "uniform"
+	settings.Octaves, settings.Lacunarity, settings.Gain = 1, 2, 0.5
+	return settings
+}
+
+func encode_code(settings Settings) (string, error) {
+	// settings left at zero mean the same as the command line defaults
+	if settings.Precision == 0 {
+		settings.Precision = 64
+	}
+	if settings.SeedValue == 0 {
+		settings.SeedValue = 1.0
+	}
+	if settings.Octaves == 0 {
+		settings.Octaves = 1
+	}
+	if settings.Lacunarity == 0 {
+		settings.Lacunarity = 2
+	}
+	if settings.Gain == 0 {
+		settings.Gain = 0.5
+	}
+
+	if settings.Sources != "" || settings.Obstacles != "" || settings.SeedText != "" || settings.Receptivity != "" {
+		return "", errors.New("sources, obstacles, seed texts and receptivity images can't be put in a code")
+	}
+
+	var fields uint64
+	var values []byte
+	for k, field := range code_fields {
+		v, set := field.get(&settings)
+		if !set {
+			continue
+		}
+		fields |= 1 << k
+		difference := v - field.initial
+		if field.decimals {
+			difference = math.Round(v*10000) - math.Round(field.initial*10000)
+			if math.Round(v*10000)/10000 != v {
+				return "", fmt.Errorf("%s=%g has more than 4 decimals and can't be put in a code", field.name, v)
+			}
+		}
+		values = append_varint_signed(values, int64(difference))
+	}
+
+	buffer := make([]byte, binary.MaxVarintLen64)
+	payload := []byte{code_version}
+	payload = append(payload, buffer[:binary.PutUvarint(buffer, fields)]...)
+	payload = append(payload, values...)
+	check := sha256.Sum256(payload)
+	payload = append(payload, check[0])
+
+	code := base58(payload)
+	var groups []string
+	for len(code) > 4 {
+		groups = append(groups, code[:4])
+		code = code[4:]
+	}
+	return strings.Join(append(groups, code), "-"), nil
+}
+
+func decode_code(code string) (Settings, error) {
+	settings := code_defaults()
+	payload, err := unbase58(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if err != nil {
+		return settings, err
+	}
+	if len(payload) < 3 {
+		return settings, errors.New("the code is too short")
+	}
+	check := sha256.Sum256(payload[:len(payload)-1])
+	if check[0] != payload[len(payload)-1] {
+		return settings, errors.New("the code doesn't check out, is there a typo?")
+	}
+	if payload[0] != code_version {
+		return settings, fmt.Errorf("unsupported code version %d", payload[0])
+	}
+
+	r := bytes.NewReader(payload[1 : len(payload)-1])
+	fields, err := binary.ReadUvarint(r)
+	if err != nil {
+		return settings, errors.New("broken code")
+	}
+	for k, field := range code_fields {
+		if fields&(1<<k) == 0 {
+			continue
+		}
+		difference, err := binary.ReadVarint(r)
+		if err != nil {
+			return settings, errors.New("broken code")
+		}
+		if field.decimals {
+			field.set(&settings, float64(int64(math.Round(field.initial*10000))+difference)/10000)
+		} else {
+			field.set(&settings, field.initial+float64(difference))
+		}
+	}
+	if r.Len() != 0 || fields>>len(code_fields) != 0 {
+		return settings, errors.New("the code has settings this version doesn't know about")
+	}
+	return settings, settings.check()
+}
+
+func append_varint_signed(b []byte, v int64) []byte {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	return append(b, buffer[:binary.PutVarint(buffer, v)]...)
+}
+
+func base58(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	base, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		out = append(out, base58_alphabet[mod.Int64()])
+	}
+	// leading zero bytes become ones
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58_alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func unbase58(s string) ([]byte, error) {
+	n, base := new(big.Int), big.NewInt(58)
+	zeros := 0
+	for k, c := range s {
+		digit := strings.IndexRune(base58_alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("%q is not a code character", c)
+		}
+		if digit == 0 && k == zeros {
+			zeros++
+		}
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
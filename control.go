@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// note:
+// snow perform takes its controls from OSC over udp and from MIDI. Both are read here into the same
+// controls, a name and a value from 0 to 1 (or the name of a palette):
+//
+//   OSC    /snow/Y 0.3, /snow/A, /snow/palette 0.5 or "ice", /snow/reseed, the last part of the address
+//          is the name so /Y works too, floats, ints and doubles are taken as they are
+//   MIDI   a control change of -cc-Y, -cc-A or -cc-palette is its value over 127, any note on reseeds
+//
+// OSC bundles are unpacked, their time tags are ignored and everything in them happens right away. MIDI
+// is read as the raw byte stream of a device like /dev/midi1 (or /dev/snd/midiC1D0), with running
+// status, system messages are skipped. Names the performance doesn't know are ignored.
+
+type control struct {
+	name  string  // Y, A, palette or reseed
+	value float64 // from 0 to 1
+	text  string  // a palette name sent as an OSC string
+}
+
+// the controls in an OSC packet, a message or a bundle of them
+func parse_osc(packet []byte) ([]control, error) {
+	if strings.HasPrefix(string(packet), "#bundle\x00") {
+		if len(packet) < 16 {
+			return nil, fmt.Errorf("bundle without a time tag")
+		}
+		var controls []control
+		rest := packet[16:] // the tag and the time tag
+		for len(rest) >= 4 {
+			size := int(binary.BigEndian.Uint32(rest))
+			if size < 0 || size > len(rest)-4 {
+				return controls, fmt.Errorf("bundle element of %d bytes in %d", size, len(rest)-4)
+			}
+			inner, err := parse_osc(rest[4 : 4+size])
+			if err != nil {
+				return controls, err
+			}
+			controls = append(controls, inner...)
+			rest = rest[4+size:]
+		}
+		return controls, nil
+	}
+
+	address, rest, err := osc_string(packet)
+	if err != nil {
+		return nil, err
+	}
+	c := control{name: address[strings.LastIndex(address, "/")+1:]}
+	if len(rest) == 0 {
+		// old senders leave out the type tags of messages without arguments
+		return []control{c}, nil
+	}
+	tags, rest, err := osc_string(rest)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return nil, fmt.Errorf("%s: bad type tags %q", address, tags)
+	}
+	if len(tags) > 1 {
+		switch tags[1] {
+		case 'f', 'i':
+			if len(rest) < 4 {
+				return nil, fmt.Errorf("%s: the argument is cut off", address)
+			}
+			bits := binary.BigEndian.Uint32(rest)
+			if tags[1] == 'f' {
+				c.value = float64(math.Float32frombits(bits))
+			} else {
+				c.value = float64(int32(bits))
+			}
+		case 'd':
+			if len(rest) < 8 {
+				return nil, fmt.Errorf("%s: the argument is cut off", address)
+			}
+			c.value = math.Float64frombits(binary.BigEndian.Uint64(rest))
+		case 's':
+			if c.text, _, err = osc_string(rest); err != nil {
+				return nil, err
+			}
+		case 'T':
+			c.value = 1
+		}
+	}
+	return []control{c}, nil
+}
+
+// a string padded with zeros to 4 bytes and what comes after it
+func osc_string(b []byte) (string, []byte, error) {
+	end := strings.IndexByte(string(b), 0)
+	if end < 0 {
+		return "", nil, fmt.Errorf("unterminated osc string")
+	}
+	padded := (end + 4) &^ 3
+	if padded > len(b) {
+		padded = len(b)
+	}
+	return string(b[:end]), b[padded:], nil
+}
+
+// the control change numbers of the performance
+type midi_map struct {
+	y, a, palette int
+}
+
+// reads the MIDI stream and sends its controls until it ends
+func read_midi(r io.Reader, m midi_map, controls chan<- control) error {
+	in := bufio.NewReader(r)
+	var status byte
+	var data []byte
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch {
+		case b >= 0xf8:
+			// real time messages come in between the bytes of other messages
+			continue
+		case b >= 0xf0:
+			// system messages cancel the running status, their data is skipped
+			status, data = 0, data[:0]
+			continue
+		case b >= 0x80:
+			status, data = b, data[:0]
+			continue
+		case status == 0:
+			continue
+		}
+		if status&0xf0 == 0xc0 || status&0xf0 == 0xd0 {
+			// program and channel pressure changes have one byte, they aren't controls
+			continue
+		}
+		data = append(data, b)
+		if len(data) < 2 {
+			continue
+		}
+		switch status & 0xf0 {
+		case 0x90:
+			if data[1] > 0 {
+				controls <- control{name: "reseed"}
+			}
+		case 0xb0:
+			value := float64(data[1]) / 127
+			switch int(data[0]) {
+			case m.y:
+				controls <- control{name: "Y", value: value}
+			case m.a:
+				controls <- control{name: "A", value: value}
+			case m.palette:
+				controls <- control{name: "palette", value: value}
+			}
+		}
+		// running status, the next data bytes are another message of the same kind
+		data = data[:0]
+	}
+}
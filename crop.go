@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// note:
+// The rows of a rectangular grid are slanted, every row is half a hexagon further right than the one
+// above, so the matrix is a parallelogram in the image. There are two ways to fit it in a rectangle:
+//
+//   inner   the rectangle all the rows cover, the default. The slanted corners of the matrix are cut
+//           off and are outside the border, the flake grows up to the edges of the image.
+//   pad     the whole parallelogram, the image is wider by the slant of the rows and the triangles
+//           in the corners the matrix doesn't reach get the darkest color. The border follows the
+//           matrix, so a flake that grows into the corners isn't cut off there.
+//
+// -crop pad picks the second, it is kept in the settings as crop: pad since it changes where the flake
+// can grow. -grid is still the size of the image, the matrix of a padded grid is narrower by the slant.
+// A square grid has a hexagonal border that fits its image, there is nothing to pad. The angle of the
+// slant isn't a choice, it's where the hexagons are (see the transform in snow.go), any other angle
+// squeezes the flake.
+//
+// The padded image is rendered bilinear like render() in the coordinates of the matrix, x = i + j/2,
+// y = j sqrt(3)/2. Stencils and seed texts are stretched over the inner rectangle and sprites, scenes,
+// -roi, -inset, -rotate and -size measure the image with it, they don't go with padding.
+
+// true for a rectangular grid rendered whole
+func (settings Settings) padded() bool {
+	return settings.Crop == "pad"
+}
+
+func (settings Settings) check_crop() error {
+	switch {
+	case settings.Crop == "":
+		return nil
+	case settings.Crop != "pad":
+		return fmt.Errorf("unknown crop %q, use pad or leave it out for the inner rectangle", settings.Crop)
+	case settings.Height == 0:
+		return fmt.Errorf("a square grid shows its whole border, only rectangular grids can be padded")
+	case settings.SeedText != "" || settings.Obstacles != "" || settings.Receptivity != "":
+		return fmt.Errorf("seed texts, obstacles and receptivity are stretched over the inner rectangle, a padded grid can't have them")
+	}
+	return nil
+}
+
+// tells if the hexagon is inside the border, with padding that is the whole matrix but its edge
+func (settings Settings) in_arena(i, j int) bool {
+	if settings.padded() {
+		return i >= 2 && i < settings.Size-2 && j >= 2 && j < settings.height()-2
+	}
+	return in_arena(i, j, settings.Size, settings.height())
+}
+
+// the size of the padded image of a width x height grid
+func padded_size(width, height int) (int, int) {
+	return width + int(math.Ceil(float64(height-1)/2)), rendered_height(height)
+}
+
+// the grid whose padded image is image_width x image_height
+func padded_grid_size(image_width, image_height int) (int, int) {
+	height := 2
+	for rendered_height(height) < image_height {
+		height++
+	}
+	width := image_width - int(math.Ceil(float64(height-1)/2))
+	if width == height {
+		// a square grid would get the hexagonal border
+		width++
+	}
+	return width, height
+}
+
+// the whole matrix of a rectangular grid, bilinear between the hexagons
+func render_padded[T Real](matrix *Grid[T]) image.Image {
+	img, _ := render_padded_context(context.Background(), matrix)
+	return img
+}
+
+// like render_padded, but stops between the rows with the error of the context when it's cancelled
+func render_padded_context[T Real](ctx context.Context, matrix *Grid[T]) (image.Image, error) {
+	width, height := padded_size(len(*matrix), len((*matrix)[0]))
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	k := resample_kernels["bilinear"]
+	for py := 0; py < height; py++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		j := float64(py) * 2 / math.Sqrt(3)
+		for px := 0; px < width; px++ {
+			i := float64(px) - j/2
+			c := sample(matrix, i, j, k)
+			img.SetGray(px, py, color.Gray{uint8(math.Max(0, math.Min(c*255, 255)))})
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "math"
+
+// note:
+// -curvature makes the freezing threshold depend on the curvature of the ice next to a hexagon, so sharp
+// tips grow faster than flat sides, the instability that makes dendrites grow out of a plate. The
+// curvature is estimated from how many of the 6 neighbours were frozen before the step:
+//
+//   1 frozen       the tip of an arm or a spike, curvature 1
+//   2 frozen       a flat side, curvature 1/2
+//   3 or more      a notch, curvature 0
+//
+// A receptive hexagon freezes at 1 - curvature*k instead of 1, and it jumps to 1 then so the rest of the
+// program sees it as ice. Notches keep the threshold of 1, the ice can't be held back above it because
+// everything that reaches 1 is frozen. When 1 - curvature gets down to the background B every tip
+// freezes right away and the whole grid fills up, small values like 0.1 are enough.
+
+// freezes the receptive hexagons that reached their threshold, called after a step with the coldness
+// before it in previous
+func curvature_freeze[T Real](curvature float64, coldness_matrix, previous_matrix *Grid[T], mask_matrix *Mask) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+
+	// the edge of the matrix has no neighbours, like in smooth_growth
+	for i := 1; i < size-1; i++ {
+		for j := 1; j < height-1; j++ {
+			v := (*coldness_matrix)[i][j]
+			if (*mask_matrix)[i][j] != receptive || v >= 1.0 {
+				continue
+			}
+			k := math.Max(0, math.Min(1, float64(3-frozen_neighbours(previous_matrix, i, j))/2))
+			if float64(v) >= 1-curvature*k {
+				(*coldness_matrix)[i][j] = 1
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+//go:build gui
+// +build gui
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// note:
+// The window of snow gui, the same sliders as the page (gui_page_data in gui.go) with the flake growing
+// next to them. There is no server in between, the simulation runs in the program:
+//
+//   A, Y        taken by the flake that grows at the next iteration
+//   B, PP, PM,  start the flake again a moment after the slider stops moving
+//   L, size
+//   palette     only colors the preview
+//   seed        the -random-seed of the parameters, enter picks the parameters of a seed again
+//   random      a new seed and its parameters
+//   save        saves the preview under the name snow would give the result, in snowflakes/
+//
+// The preview is rendered every desktop_preview_every while the flake grows and once more when it's
+// done, the simulation doesn't wait for the window.
+
+const (
+	desktop_preview_every = 100 * time.Millisecond
+	desktop_restart_after = 250 * time.Millisecond
+)
+
+// the simulation behind the window, the controls change it from the goroutine of the window
+type desktop_run struct {
+	mu       sync.Mutex
+	settings Settings // of the sliders
+	colors   *theme   // of the palette, nil for gray
+	cancel   context.CancelFunc
+	done     bool          // the flake is grown and the run waits for a restart
+	restart  chan struct{} // starts the flake again
+	timer    *time.Timer   // restarts when the sliders stopped moving
+
+	// the last preview, in gray
+	preview  image.Image
+	shown    Settings
+	metadata map[string]string
+
+	show func(img image.Image, status string)
+}
+
+// grows a flake from the settings every time it's restarted
+func (r *desktop_run) grow() {
+	for {
+		r.mu.Lock()
+		settings := r.settings
+		ctx, cancel := context.WithCancel(context.Background())
+		r.cancel, r.done = cancel, false
+		r.mu.Unlock()
+		if err := settings.check(); err != nil {
+			r.show(nil, err.Error())
+		} else {
+			state := new_state(settings)
+			var rendered time.Time
+			err := run_context(ctx, state, func(iteration int64) {
+				r.mu.Lock()
+				state.Settings.A, state.Settings.Y = r.settings.A, r.settings.Y
+				r.mu.Unlock()
+				if time.Since(rendered) >= desktop_preview_every {
+					r.render(state, nil)
+					rendered = time.Now()
+				}
+			})
+			if err != context.Canceled {
+				r.render(state, err)
+			}
+			state.close()
+		}
+		cancel()
+		r.mu.Lock()
+		r.done = true
+		r.mu.Unlock()
+		<-r.restart
+	}
+}
+
+// renders the state into the preview
+func (r *desktop_run) render(state *State, err error) {
+	values := state.values()
+	img := render_settings(state.Settings, &values)
+	status := fmt.Sprintf("iteration %d of %d\nfrozen %d", state.Iteration, state.Settings.L, state.frozen())
+	if state.Truncated >= 0 {
+		status += fmt.Sprintf("\ntouched the border at %d", state.Truncated)
+	}
+	if err != nil {
+		status += "\n" + err.Error()
+	}
+	r.mu.Lock()
+	r.preview, r.shown, r.metadata = img, state.Settings, state.metadata()
+	r.mu.Unlock()
+	r.show(r.colorized(img), status)
+}
+
+// the preview in the colors of the palette
+func (r *desktop_run) colorized(img image.Image) image.Image {
+	r.mu.Lock()
+	colors := r.colors
+	r.mu.Unlock()
+	if colors == nil {
+		return img
+	}
+	return colorize(img, *colors)
+}
+
+// changes the settings, a flake that is done or not live starts again
+func (r *desktop_run) change(f func(settings *Settings), live bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(&r.settings)
+	if live && !r.done {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(desktop_restart_after, r.start_again)
+}
+
+// stops the flake that grows and starts the next one
+func (r *desktop_run) start_again() {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.mu.Unlock()
+	select {
+	case r.restart <- struct{}{}:
+	default:
+	}
+}
+
+// saves the preview under the name of the result, in the colors of the palette
+func (r *desktop_run) save() (string, error) {
+	r.mu.Lock()
+	img, settings, metadata := r.preview, r.shown, r.metadata
+	r.mu.Unlock()
+	if img == nil {
+		return "", fmt.Errorf("there is nothing to save yet")
+	}
+	filename := default_filename(settings)
+	os.MkdirAll(filepath.Dir(filename), 0755)
+	return filename, save_image(filename, r.colorized(img), metadata)
+}
+
+// the value of the slider at t from 0 to 1, in steps of the slider like on the page
+func (s gui_slider) at(t float64) float64 {
+	v := s.Min + (s.Max-s.Min)*t
+	if s.Logarithmic {
+		v = s.Min * math.Pow(s.Max/s.Min, t)
+	}
+	v, _ = strconv.ParseFloat(strconv.FormatFloat(math.Round(v/s.Step)*s.Step, 'g', 10, 64), 64)
+	return v
+}
+
+// where the value is on the slider, from 0 to 1
+func (s gui_slider) position(v float64) float64 {
+	t := (v - s.Min) / (s.Max - s.Min)
+	if s.Logarithmic {
+		t = math.Log(v/s.Min) / math.Log(s.Max/s.Min)
+	}
+	return math.Max(0, math.Min(1, t))
+}
+
+func (s gui_slider) format(v float64) string {
+	if s.Name == "L" || s.Name == "size" {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// sets the parameter of the slider in the settings
+func (s gui_slider) set(settings *Settings, v float64) {
+	switch s.Name {
+	case "A":
+		settings.A = v
+	case "B":
+		settings.B = v
+	case "Y":
+		settings.Y = v
+	case "PP":
+		settings.PP = v
+	case "PM":
+		settings.PM = v
+	case "L":
+		settings.L = int64(v)
+	case "size":
+		settings.Size = int(v)
+	}
+}
+
+// snow gui in a window, until it's closed
+func desktop_gui(max_size int) error {
+	if err := windows_available(); err != nil {
+		return err
+	}
+	w := new_gui_window(app.New(), max_size)
+	w.ShowAndRun()
+	return nil
+}
+
+func new_gui_window(a fyne.App, max_size int) fyne.Window {
+	w := a.NewWindow("snow")
+	data := gui_page_data(max_size)
+	run := &desktop_run{settings: default_settings(), restart: make(chan struct{}, 1)}
+
+	preview := canvas.NewImageFromImage(image.NewGray(image.Rect(0, 0, 1, 1)))
+	preview.FillMode = canvas.ImageFillContain
+	preview.ScaleMode = canvas.ImageScalePixels
+	preview.SetMinSize(fyne.NewSize(400, 400))
+	status := widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true})
+	// what the buttons did, under the preview since the status changes with every preview
+	message := widget.NewLabel("")
+	run.show = func(img image.Image, text string) {
+		if img != nil {
+			preview.Image = img
+			preview.Refresh()
+		}
+		status.SetText(text)
+	}
+
+	controls := container.NewVBox()
+	// moves a slider to the value without it being moved by hand
+	moves := map[string]func(v float64){}
+	for _, s := range data.Sliders {
+		s := s
+		label := widget.NewLabel("")
+		slider := widget.NewSlider(0, 10000)
+		name := s.Name
+		if s.Live {
+			name += " (live)"
+		}
+		show := func(v float64) { label.SetText(name + "  " + s.format(v)) }
+		slider.OnChanged = func(t float64) {
+			v := s.at(t / 10000)
+			show(v)
+			run.change(func(settings *Settings) { s.set(settings, v) }, s.Live)
+		}
+		moves[s.Name] = func(v float64) {
+			slider.Value = s.position(v) * 10000
+			slider.Refresh()
+			show(v)
+			run.change(func(settings *Settings) { s.set(settings, v) }, s.Live)
+		}
+		slider.Value = s.position(s.Value) * 10000
+		show(s.Value)
+		controls.Add(label)
+		controls.Add(slider)
+	}
+
+	palette := widget.NewSelect(append([]string{"none"}, data.Palettes...), func(name string) {
+		var colors *theme
+		if t, err := pick_theme("", name, ""); err == nil && name != "none" {
+			colors = &t
+		}
+		run.mu.Lock()
+		run.colors = colors
+		img := run.preview
+		run.mu.Unlock()
+		if img != nil {
+			preview.Image = run.colorized(img)
+			preview.Refresh()
+		}
+	})
+	palette.SetSelected("none")
+	controls.Add(widget.NewLabel("palette"))
+	controls.Add(palette)
+
+	seed := widget.NewEntry()
+	seed.SetPlaceHolder("-random-seed")
+	pick := func(s int64) {
+		run.mu.Lock()
+		settings := run.settings
+		run.mu.Unlock()
+		picked := random_settings(rand.New(rand.NewSource(s)), random_ranges, settings.Size, 0, settings.L)
+		for name, v := range map[string]float64{"A": picked.A, "B": picked.B, "Y": picked.Y, "PP": picked.PP, "PM": picked.PM} {
+			// B, PP and PM start the flake again
+			moves[name](v)
+		}
+	}
+	seed.OnSubmitted = func(text string) {
+		s, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			message.SetText("the seed is a whole number")
+			return
+		}
+		pick(s)
+	}
+	random := widget.NewButton("random", func() {
+		s := time.Now().UnixNano()
+		seed.SetText(strconv.FormatInt(s, 10))
+		pick(s)
+	})
+	save := widget.NewButton("save", func() {
+		filename, err := run.save()
+		if err != nil {
+			message.SetText("failed to save: " + err.Error())
+			return
+		}
+		message.SetText("saved " + filename)
+	})
+	controls.Add(widget.NewLabel("seed"))
+	controls.Add(seed)
+	controls.Add(container.NewHBox(random, save))
+	controls.Add(status)
+
+	// the controls are as wide as on the page
+	width := canvas.NewRectangle(color.Transparent)
+	width.SetMinSize(fyne.NewSize(300, 0))
+	left := container.NewVScroll(container.NewMax(width, controls))
+	w.SetContent(container.NewBorder(nil, message, left, nil, preview))
+	w.Resize(fyne.NewSize(1100, 760))
+
+	go run.grow()
+	return w
+}
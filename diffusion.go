@@ -0,0 +1,95 @@
+package main
+
+// note:
+// -solver laplacian spreads the vapor by solving the diffusion equation du/dt = D∇²u on the hexagons
+// instead of Reiter's averaging. The laplacian of a hexagon is the sum of its 6 neighbours minus 6 times
+// itself, receptive neighbours count as 0 because the flake takes up the vapor that reaches it, and every
+// iteration is split into sub-steps of forward euler:
+//
+//   u' = u + D/substeps * (A * sum(neighbours) - 6u)
+//
+// Reiter's averaging is this with D = 1/12 and a single step, so the defaults give the same flakes up to
+// rounding. A bigger D brings the vapor from further away in every iteration, the flake grows faster and
+// the arms get long side branches, a smaller D starves it and it stays small with short stubby arms. One
+// euler step can't spread more than a hexagon has, D/substeps can be at most 1/6, so a fast diffusion
+// needs sub-steps.
+// The receptive hexagons get Y once for every iteration, whatever the sub-steps.
+
+const reiter_diffusion = 1.0 / 12
+
+var solvers = []string{"reiter", "laplacian"}
+
+func (settings Settings) laplacian() bool {
+	return settings.Solver == "laplacian"
+}
+
+func (settings Settings) diffusion() float64 {
+	if settings.Diffusion == 0 {
+		return reiter_diffusion
+	}
+	return settings.Diffusion
+}
+
+func (settings Settings) substeps() int {
+	if settings.Substeps == 0 {
+		return 1
+	}
+	return settings.Substeps
+}
+
+// same as step() but the vapor diffuses with the coefficient D in sub-steps, see the note
+func step_laplacian[T Real](A, Y, D float64, substeps int, coldness_matrix, temp_coldness_matrix *Grid[T], mask_matrix *Mask) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+
+	// look for frozen hexagons and set receptive values on the mask, they stay the same for all sub-steps
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if (*coldness_matrix)[i][j] >= 1.0 {
+				(*mask_matrix)[i-1][j] = receptive
+				(*mask_matrix)[i-1][j+1] = receptive
+				(*mask_matrix)[i][j-1] = receptive
+				(*mask_matrix)[i][j] = receptive
+				(*mask_matrix)[i][j+1] = receptive
+				(*mask_matrix)[i+1][j-1] = receptive
+				(*mask_matrix)[i+1][j] = receptive
+			}
+		}
+	}
+
+	d := D / float64(substeps)
+	flow, keep := T(A*d), T(1-6*d)
+	for s := 0; s < substeps; s++ {
+		for i := range *temp_coldness_matrix {
+			for j := range (*temp_coldness_matrix)[i] {
+				(*temp_coldness_matrix)[i][j] = 0
+			}
+		}
+
+		for i := 0; i < size; i++ {
+			for j := 0; j < height; j++ {
+				switch (*mask_matrix)[i][j] {
+				case non_receptive:
+					v0 := (*coldness_matrix)[i][j]
+					v1 := flow * v0
+
+					(*temp_coldness_matrix)[i-1][j] += v1
+					(*temp_coldness_matrix)[i-1][j+1] += v1
+					(*temp_coldness_matrix)[i][j-1] += v1
+					(*temp_coldness_matrix)[i][j] += keep * v0
+					(*temp_coldness_matrix)[i][j+1] += v1
+					(*temp_coldness_matrix)[i+1][j-1] += v1
+					(*temp_coldness_matrix)[i+1][j] += v1
+
+				case receptive:
+					v0 := (*coldness_matrix)[i][j]
+					if s == 0 {
+						v0 += T(Y)
+					}
+					(*temp_coldness_matrix)[i][j] += v0
+				}
+			}
+		}
+
+		*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
+	}
+}
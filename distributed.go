@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+// note:
+// In distributed mode the coordinator cuts the matrix into strips of rows and every worker owns one strip.
+// To do a step a worker needs the values of the two rows above and below its strip (two because the
+// mask of the row next to the strip depends on the row after that). After every step the coordinator
+// passes the edge rows of every strip on to its neighbours. The workers also say if the flake touched
+// the border in their strip, so the coordinator knows when it did like on one machine, and if the strip
+// blew up (see blowup.go). Then the coordinator collects the strips and stops with the same error as on
+// one machine.
+//
+//   rows   0 .. lo-2, lo-1 | lo .. hi-1 | hi, hi+1 .. size
+//            halo above    |   strip    | halo below
+//
+// The workers calculate every cell by gathering from its neighbours in the same order as step() scatters
+// to them, so the result is exactly the same as when running on one machine.
+
+// the part of the simulation a worker is responsible for
+type Shard struct {
+	A, Y     float64
+	Size     int
+	Lo, Hi   int    // the rows owned by the worker
+	Base     int    // global row of the first local row, the strip starts with up to two halo rows
+	Coldness Matrix // rows Base .. Hi+2 (clamped to the matrix)
+	Mask     Mask
+}
+
+// halo rows sent to a worker before a step
+type Halo struct {
+	Above     Matrix // rows lo-2, lo-1, empty for the first strip
+	Below     Matrix // rows hi, hi+1, empty for the last strip
+	Iteration int64  // the iteration of the step, the values are checked every sanity_every
+}
+
+// edge rows of a strip returned after a step
+type Edges struct {
+	Top    Matrix // rows lo, lo+1
+	Bottom Matrix // rows hi-2, hi-1
+	Border bool   // a hexagon of the strip next to the border is frozen
+	Blowup bool   // the ice is at the edge of the matrix or a value is NaN, infinite or exploding
+}
+
+// rpc service, a worker simulates one shard at a time
+type Worker struct {
+	mutex  sync.Mutex
+	shard  *Shard
+	temp   Matrix
+	packed bit_mask // the mask of the shard as the step reads it
+	border [][2]int // hexagons of the strip next to the border, in local rows
+}
+
+func worker(args []string) {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	addr := flags.String("addr", ":9000", "address to listen on")
+	flags.Parse(args)
+
+	server := rpc.NewServer()
+	if err := server.Register(&Worker{}); err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("worker listening on %s", listener.Addr())
+	server.Accept(listener)
+}
+
+func (w *Worker) Init(shard *Shard, _ *struct{}) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(shard.Coldness) != len(shard.Mask) || shard.Hi-shard.Lo < 2 {
+		return errors.New("bad shard")
+	}
+	w.shard = shard
+	w.packed = pack_mask(shard.Mask)
+	w.temp = make(Matrix, len(shard.Coldness))
+	for i := range w.temp {
+		w.temp[i] = make([]float64, shard.Size)
+	}
+	inside := func(i, j int) bool { return in_arena(i, j, shard.Size, shard.Size) }
+	w.border = border_cells(inside, shard.Size, shard.Size, shard.Lo, shard.Hi)
+	for k := range w.border {
+		w.border[k][0] -= shard.Base
+	}
+	log.Printf("simulating rows %d to %d of %d", shard.Lo, shard.Hi, shard.Size)
+	return nil
+}
+
+func (w *Worker) Step(halo *Halo, edges *Edges) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	shard := w.shard
+	if shard == nil {
+		return errors.New("worker has no shard")
+	}
+
+	// copy in the halo rows from the neighbours
+	for k, row := range halo.Above {
+		copy(shard.Coldness[shard.Lo-len(halo.Above)+k-shard.Base], row)
+	}
+	for k, row := range halo.Below {
+		copy(shard.Coldness[shard.Hi+k-shard.Base], row)
+	}
+
+	step_rows(shard.A, shard.Y, shard.Lo-shard.Base, shard.Hi-shard.Base, &shard.Coldness, &w.temp, w.packed, &shard.Mask)
+
+	local := shard.Lo - shard.Base
+	edges.Top = Matrix{shard.Coldness[local], shard.Coldness[local+1]}
+	local = shard.Hi - shard.Base
+	edges.Bottom = Matrix{shard.Coldness[local-2], shard.Coldness[local-1]}
+	edges.Border = frozen_at(&shard.Coldness, w.border)
+	edges.Blowup = w.blown_up(halo.Iteration)
+	return nil
+}
+
+// true when the strip has a blow up sanity() would find
+func (w *Worker) blown_up(iteration int64) bool {
+	shard := w.shard
+	rows := shard.Coldness[shard.Lo-shard.Base : shard.Hi-shard.Base]
+	last := shard.Size - 1
+	for _, row := range rows {
+		if row[0] >= 1.0 || row[last] >= 1.0 {
+			return true
+		}
+	}
+	for j := 0; j < shard.Size; j++ {
+		if (shard.Lo == 0 && rows[0][j] >= 1.0) || (shard.Hi == shard.Size && rows[len(rows)-1][j] >= 1.0) {
+			return true
+		}
+	}
+	if iteration%sanity_every != 0 {
+		return false
+	}
+	_, _, _, wrong := not_sane(&rows)
+	return wrong
+}
+
+// returns the rows owned by the worker as they are now, the shard keeps going
+func (w *Worker) Rows(_ struct{}, rows *Shard) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.rows(rows)
+}
+
+func (w *Worker) rows(rows *Shard) error {
+	if w.shard == nil {
+		return errors.New("worker has no shard")
+	}
+	*rows = *w.shard
+	rows.Coldness = w.shard.Coldness[w.shard.Lo-w.shard.Base : w.shard.Hi-w.shard.Base]
+	rows.Mask = w.shard.Mask[w.shard.Lo-w.shard.Base : w.shard.Hi-w.shard.Base]
+	return nil
+}
+
+// returns the rows owned by the worker and forgets the shard
+func (w *Worker) Collect(_ struct{}, rows *Shard) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.rows(rows); err != nil {
+		return err
+	}
+	w.shard = nil
+	w.temp = nil
+	w.packed = nil
+	w.border = nil
+	return nil
+}
+
+// does one step for the rows from, to of a strip, the strip must contain the two rows above and below them
+// if they exist. The mask is updated for the rows next to the strip as well so it stays correct without
+// sending it around.
+func step_rows(A, Y float64, from, to int, coldness_matrix, temp_coldness_matrix *Matrix, packed bit_mask, mask_matrix *Mask) {
+	first, last := from-1, to+1
+	if first < 0 {
+		first = 0
+	}
+	if last > len(*coldness_matrix) {
+		last = len(*coldness_matrix)
+	}
+	receptive_rows(first, last, coldness_matrix, packed, mask_matrix)
+	gather_rows(A, Y, from, to, coldness_matrix, temp_coldness_matrix, packed)
+
+	// only the rows of the strip are new, keep the halo rows
+	for i := from; i < to; i++ {
+		(*coldness_matrix)[i], (*temp_coldness_matrix)[i] = (*temp_coldness_matrix)[i], (*coldness_matrix)[i]
+	}
+}
+
+// a feature of a run outside of the settings, by the name check_distributed gives it
+type run_feature struct {
+	name string
+	used bool
+}
+
+// an error naming everything the settings and the features of the run use that distributed mode can't
+// do. The workers only have the plain step of Reiter's rule on a square grid in 64 bits, and the matrix
+// of the coordinator stays as it started until the strips are collected at the end.
+func (settings Settings) check_distributed(run ...run_feature) error {
+	features := append([]run_feature{
+		{"32 bit precision", settings.single_precision()},
+		{"fixed point values", settings.Fixed},
+		{"growing grids", settings.MaxSize != 0},
+		{"rectangular grids", settings.Height != 0},
+		{"tiles", settings.Tile},
+		{"the laplacian solver", settings.laplacian()},
+		{"latent heat", settings.Heat != 0},
+		{"attachment kinetics", settings.Kinetics != nil},
+		{"smoothing", settings.Smoothing != 0},
+		{"curvature", settings.Curvature != 0},
+		{"kahan summation", settings.kahan()},
+		{"anisotropy", settings.anisotropic()},
+		{"sources", settings.Sources != ""},
+		{"obstacles", settings.Obstacles != ""},
+		{"receptivity", settings.Receptivity != ""},
+	}, run...)
+	var used []string
+	for _, feature := range features {
+		if feature.used {
+			used = append(used, feature.name)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s can't be used in distributed mode", used[0])
+	}
+	return fmt.Errorf("%s and %s can't be used in distributed mode", strings.Join(used[:len(used)-1], ", "), used[len(used)-1])
+}
+
+// continues the simulation on the workers until all L loops are done, the coordinator only hands out
+// the strips and passes halo rows around. Like run, progress may lower L to stop early. While it runs
+// state.collect brings the strips over into the matrices of the state, for a snapshot when it's paused.
+func simulate_distributed(state *State, addrs []string, progress func(iteration int64)) error {
+	settings := state.Settings
+	size := settings.Size
+	if size/len(addrs) < 2 {
+		return fmt.Errorf("%d workers is too many for size %d", len(addrs), size)
+	}
+
+	coldness_matrix := state.Coldness
+	mask_matrix := state.Mask
+	if err := state.sanity(); err != nil {
+		return err
+	}
+
+	// connect and hand out the strips
+	clients := make([]*rpc.Client, len(addrs))
+	shards := make([]*Shard, len(addrs))
+	defer func() {
+		for _, client := range clients {
+			if client != nil {
+				client.Close()
+			}
+		}
+	}()
+
+	for k, addr := range addrs {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("connecting to worker %s: %v", addr, err)
+		}
+		clients[k] = client
+
+		lo, hi := k*size/len(addrs), (k+1)*size/len(addrs)
+		// neighbouring strips have at least two rows so only the first and last strip get clamped
+		base, top := lo-2, hi+2
+		if k == 0 {
+			base = 0
+		}
+		if k == len(addrs)-1 {
+			top = size
+		}
+		shards[k] = &Shard{
+			A: settings.A, Y: settings.Y, Size: size, Lo: lo, Hi: hi, Base: base,
+			Coldness: coldness_matrix[base:top], Mask: mask_matrix[base:top],
+		}
+		if err := client.Call("Worker.Init", shards[k], &struct{}{}); err != nil {
+			return fmt.Errorf("worker %s: %v", addr, err)
+		}
+	}
+
+	// copies the rows of the workers into the matrices, method is Worker.Rows or Worker.Collect
+	collect := func(method string) error {
+		for k, shard := range shards {
+			var rows Shard
+			if err := clients[k].Call(method, struct{}{}, &rows); err != nil {
+				return fmt.Errorf("worker %s: %v", addrs[k], err)
+			}
+			for i := range rows.Coldness {
+				copy(coldness_matrix[shard.Lo+i], rows.Coldness[i])
+				copy(mask_matrix[shard.Lo+i], rows.Mask[i])
+			}
+		}
+		return nil
+	}
+	state.collect = func() error { return collect("Worker.Rows") }
+	defer func() { state.collect = nil }()
+
+	// edges of every strip, the first halos come from the initialized matrix
+	edges := make([]Edges, len(addrs))
+	for k, shard := range shards {
+		edges[k].Top = coldness_matrix[shard.Lo : shard.Lo+2]
+		edges[k].Bottom = coldness_matrix[shard.Hi-2 : shard.Hi]
+	}
+
+	for state.Iteration < state.Settings.L {
+		calls := make([]*rpc.Call, len(addrs))
+		next := make([]Edges, len(addrs))
+		for k := range shards {
+			var halo Halo
+			if k > 0 {
+				halo.Above = edges[k-1].Bottom
+			}
+			if k < len(shards)-1 {
+				halo.Below = edges[k+1].Top
+			}
+			halo.Iteration = state.Iteration + 1
+			calls[k] = clients[k].Go("Worker.Step", &halo, &next[k], nil)
+		}
+		for k, call := range calls {
+			<-call.Done
+			if call.Error != nil {
+				return fmt.Errorf("worker %s: %v", addrs[k], call.Error)
+			}
+		}
+		edges = next
+
+		state.Iteration++
+		blown_up := false
+		for _, strip := range edges {
+			if state.Truncated < 0 && strip.Border {
+				state.Truncated = state.Iteration
+			}
+			blown_up = blown_up || strip.Blowup
+		}
+		if blown_up {
+			// the whole matrix says which hexagon it was and what is likely at fault
+			if err := collect("Worker.Rows"); err != nil {
+				return err
+			}
+			if err := state.sanity(); err != nil {
+				return err
+			}
+		}
+		if progress != nil {
+			progress(state.Iteration)
+		}
+	}
+
+	// put the strips back together
+	if err := collect("Worker.Collect"); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// note:
+// -draft grows a small copy of the flake in a few seconds, for trying settings before the full run. The
+// matrix shrinks to draft_size hexagons and everything measured in hexagons with it:
+//
+//   L    divided by the scale, a flake grows about as many hexagons an iteration on every matrix size
+//   PP   times the scale, the noise is sampled per hexagon so the hills stay as wide next to the flake
+//   seed position and rows of a rectangular grid scaled down
+//
+// A, B, Y, PM and the rest are rates and levels of single hexagons, they stay the same. The draft is
+// close to the full flake, the arms reach as far and branch about as much, but the details of the
+// noise come out different. The rendered draft is stretched back up to the size of the full result,
+// blurry on purpose, with DRAFT stamped in a corner, and gets -draft in its name and a Draft line in
+// the metadata so it isn't mistaken for the real thing. Tiles and insets stay at the size of the draft.
+// Sources, obstacles, receptivity and seed text are placed in hexagons of the full matrix, they and
+// growing grids don't have a draft.
+
+// hexagons across the matrix of a draft
+const draft_size = 200
+
+// the settings scaled down to a draft, and the scale
+func draft_settings(settings Settings) (Settings, float64, error) {
+	if settings.MaxSize != 0 || settings.Sources != "" || settings.Obstacles != "" || settings.Receptivity != "" || settings.SeedText != "" {
+		return settings, 1, fmt.Errorf("growing grids, sources, obstacles, receptivity and seed text can't be drafted")
+	}
+	scale := float64(settings.Size) / draft_size
+	if scale <= 1 {
+		// small enough already
+		return settings, 1, nil
+	}
+	settings.Size = draft_size
+	if settings.Height != 0 {
+		settings.Height = int(math.Round(float64(settings.Height) / scale))
+	}
+	if settings.L != math.MaxInt64 {
+		settings.L = int64(math.Max(1, math.Round(float64(settings.L)/scale)))
+	}
+	settings.PP *= scale
+	if settings.SeedPos != nil {
+		settings.SeedPos = &[2]int{int(math.Round(float64(settings.SeedPos[0]) / scale)), int(math.Round(float64(settings.SeedPos[1]) / scale))}
+	}
+	return settings, scale, nil
+}
+
+// the rendered draft at the size of the full result
+func draft_upscale(img image.Image, scale float64) image.Image {
+	bounds := img.Bounds()
+	width, height := int(math.Round(float64(bounds.Dx())*scale)), int(math.Round(float64(bounds.Dy())*scale))
+	return transform.Resize(img, width, height, transform.Linear)
+}
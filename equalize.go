@@ -0,0 +1,66 @@
+package main
+
+import "sort"
+
+// note:
+// The result shows the values from 0 to 1 and everything frozen is white, so the inside of the ice
+// is a flat white even though the values there go on well above 1, and a background of B = 0.4 with
+// little noise is a flat gray. -equalize spreads the values over the grays by their rank instead: a
+// hexagon gets the part of the hexagons with a lower or equal value, so every gray is used by about
+// as many hexagons. The ridges and the thicker middle of the ice become visible, and so do the
+// ripples of the vapor around it.
+//
+// The vapor and the ice are equalized on their own, the vapor to the darker half of the grays and the
+// ice to the lighter one. Together the vapor has far more hexagons, it would take nearly all grays and
+// leave the ice as white as before, and this way the edge of the flake stays where the grays jump
+// past one half. Only the hexagons inside the border count, the outside stays black. The equalized
+// values are only what the heatmap shows, everything else (-raw, -pfm, the pbr maps and scenes) keeps
+// the values.
+
+// the values of the matrix replaced with their rank among the hexagons inside the border, the vapor
+// from 0 to one half and the ice from one half to 1
+func equalize(values Matrix, tile bool) Matrix {
+	width, height := len(values), len(values[0])
+	inside := func(i, j int) bool { return tile || in_arena(i, j, width, height) }
+	var vapor, ice []float64
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			switch {
+			case !inside(i, j):
+			case values[i][j] >= 1:
+				ice = append(ice, values[i][j])
+			default:
+				vapor = append(vapor, values[i][j])
+			}
+		}
+	}
+	sort.Float64s(vapor)
+	sort.Float64s(ice)
+
+	equalized := new_matrix_like(values)
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			switch {
+			case !inside(i, j):
+			case values[i][j] >= 1:
+				equalized[i][j] = 0.5 + rank(ice, values[i][j])/2
+			default:
+				equalized[i][j] = rank(vapor, values[i][j]) / 2
+			}
+		}
+	}
+	return equalized
+}
+
+// where v is among the sorted values from 0 to 1, the lowest of them is 0
+func rank(sorted []float64, v float64) float64 {
+	// the number of values at or below a value
+	upper := func(v float64) int {
+		return sort.Search(len(sorted), func(k int) bool { return sorted[k] > v })
+	}
+	lowest := upper(sorted[0])
+	if lowest == len(sorted) {
+		return 1
+	}
+	return float64(upper(v)-lowest) / float64(len(sorted)-lowest)
+}
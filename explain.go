@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// note:
+// Explain prints what the parameters mean in the model of the paper, where every hexagon holds some
+// water and the frozen ones are the crystal. With -strip it also renders a row of small flakes where only
+// that parameter changes, so it's easy to see what it does. The thumbnails start from thumbnail_base,
+// which grows a flake quickly on a small matrix, rather than from the defaults.
+
+type explanation struct {
+	name    string
+	paper   string // the name in the paper
+	meaning string
+	more    string // what happens when it goes up
+	typical string
+	values  []float64 // for the thumbnails
+	set     func(s *Settings, v float64)
+}
+
+var explanations = []explanation{
+	{"A", "alpha", "Diffusion. Every iteration a hexagon that isn't next to the crystal keeps half of its water and hands A/12 to each of its six neighbours, so 1.0 keeps the water constant, above it water is added and below it evaporates. Think of it as the humidity of the air.",
+		"more water reaches the crystal, it grows faster and gets fuller",
+		"0.995 to 1.005, default 1.0",
+		[]float64{0.998, 0.999, 1.0, 1.001, 1.002}, func(s *Settings, v float64) { s.A = v }},
+	{"B", "beta", "Background level. The water in every hexagon before the first iteration, the vapour the flake grows from.",
+		"thicker arms and plates, with less it stays thin and branchy",
+		"0.3 to 0.5, default 0.33",
+		[]float64{0.3, 0.35, 0.4, 0.45, 0.5}, func(s *Settings, v float64) { s.B = v }},
+	{"Y", "gamma", "Growth constant. Water added every iteration to the hexagons next to the crystal, from vapour freezing onto its edge. It's how cold it is.",
+		"the edge freezes faster and the flake gets solid",
+		"0.0001 to 0.01, default 0.0002",
+		[]float64{0.0002, 0.0005, 0.001, 0.002, 0.004}, func(s *Settings, v float64) { s.Y = v }},
+	{"PP", "", "Perlin noise period. How quickly the initial water level changes from hexagon to hexagon, not in the paper, it makes every flake a little different.",
+		"smaller patches of noise, with less there are large smooth areas",
+		"0.01 to 0.2, default 0.05",
+		[]float64{0.01, 0.03, 0.05, 0.1, 0.2}, func(s *Settings, v float64) { s.PP = v }},
+	{"PM", "", "Perlin noise magnitude. How much the initial water level differs from B, 0 gives a perfectly symmetric flake.",
+		"the arms grow more differently from each other",
+		"0 to 0.5, default 0.2",
+		[]float64{0, 0.1, 0.2, 0.4, 0.8}, func(s *Settings, v float64) { s.PM = v }},
+	{"L", "", "Loops. The amount of iterations, the time the flake has to grow.",
+		"a bigger flake, until it reaches the border",
+		"1000 to 20000, default 10000",
+		[]float64{250, 500, 1000, 1500, 2500}, func(s *Settings, v float64) { s.L = int64(v) }},
+}
+
+// grows a flake of a fair size in a couple of thousand iterations
+func thumbnail_base(size int) Settings {
+	return Settings{A: 1.0, B: 0.4, Y: 0.001, PP: 0.05, PM: 0.2, L: 1500, Size: size}
+}
+
+func print_explanation(w io.Writer, e explanation) {
+	name := e.name
+	if e.paper != "" {
+		name += " (" + e.paper + " in the paper)"
+	}
+	fmt.Fprintf(w, "%s\n", name)
+	for _, line := range wrap(e.meaning, 96) {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+	fmt.Fprintf(w, "    more: %s\n", e.more)
+	fmt.Fprintf(w, "    typical: %s\n\n", e.typical)
+}
+
+// splits the text in lines of at most width characters, between words
+func wrap(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
+
+// thumbnails of the flake with every value of the explanation next to each other, with the values below
+func explain_strip(e explanation, size int) image.Image {
+	thumbnails := make([]image.Image, len(e.values))
+	next := make(chan int)
+	var wait sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for k := range next {
+				settings := thumbnail_base(size)
+				e.set(&settings, e.values[k])
+				state := new_state(settings)
+				run(state, func(int64) {})
+				coldness_matrix := state.values()
+				thumbnails[k] = render(&coldness_matrix)
+				fmt.Fprint(os.Stderr, ".")
+			}
+		}()
+	}
+	for k := range e.values {
+		next <- k
+	}
+	close(next)
+	wait.Wait()
+	fmt.Fprintln(os.Stderr)
+
+	bounds := thumbnails[0].Bounds()
+	_, line := text_size("0", 1)
+	gap := line / 2
+	strip := image.NewRGBA(image.Rect(0, 0, len(thumbnails)*(bounds.Dx()+gap)-gap, bounds.Dy()+2*line))
+	draw.Draw(strip, strip.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for k, thumbnail := range thumbnails {
+		x := k * (bounds.Dx() + gap)
+		draw.Draw(strip, image.Rect(x, 0, x+bounds.Dx(), bounds.Dy()), thumbnail, thumbnail.Bounds().Min, draw.Src)
+		label := fmt.Sprintf("%s=%g", e.name, e.values[k])
+		width, _ := text_size(label, 1)
+		draw_text(strip, x+(bounds.Dx()-width)/2, bounds.Dy()+line/2, label, 1, color.White)
+	}
+	return strip
+}
+
+// snow explain [-strip] [-size 160] [parameter ...]
+func explain(args []string) {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	strip := flags.Bool("strip", false, "also render a strip of thumbnails where only the parameter changes")
+	thumbnail := flags.Int("size", 160, "matrix size of the thumbnails")
+	out := flags.String("out", "snowflakes", "directory for the strips, they are called explain-<parameter>.png")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow explain [flags] [A|B|Y|PP|PM|L ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *thumbnail < 16 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	chosen := explanations
+	if flags.NArg() > 0 {
+		chosen = nil
+		for _, name := range flags.Args() {
+			found := false
+			for _, e := range explanations {
+				if strings.EqualFold(e.name, name) {
+					chosen, found = append(chosen, e), true
+				}
+			}
+			if !found {
+				fmt.Fprintf(os.Stderr, "unknown parameter %q, it's one of A, B, Y, PP, PM and L\n", name)
+				os.Exit(2)
+			}
+		}
+	}
+
+	for _, e := range chosen {
+		print_explanation(os.Stdout, e)
+		if !*strip {
+			continue
+		}
+		filename := filepath.Join(*out, "explain-"+e.name+".png")
+		if err := save_image(filename, explain_strip(e, *thumbnail), nil); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save strip:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("    strip: %s\n\n", filename)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// note:
+// -palette-from photo.jpg takes the palette from a picture, so the flakes match the colors of an artwork
+// or a website. The pixels are split into extracted_colors boxes with median cut: the box with the
+// widest channel is cut in two at the median of that channel until there are enough of them, so every
+// box holds about as many pixels and the colors that cover most of the picture get the most boxes. The
+// mean of every box is a color, they're sorted by their lightness in CIE Lab and spread evenly from the
+// background to the ice, so the darkest color of the picture is the background and the lightest one the
+// ice. Pixels that are more than half transparent are left out.
+//
+// A big picture is sampled at about extract_samples pixels on a grid, that is plenty for a few colors.
+
+const (
+	extracted_colors = 5
+	extract_samples  = 128 * 128
+)
+
+// the pictures -palette takes the colors from, the rest of the files are luts and gradients
+func is_picture(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+func extract_palette(filename string) (palette, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return palette{}, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return palette{}, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	bounds := img.Bounds()
+	every := 1
+	for bounds.Dx()/every*(bounds.Dy()/every) > extract_samples {
+		every++
+	}
+	var pixels [][3]uint8
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += every {
+		for x := bounds.Min.X; x < bounds.Max.X; x += every {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A >= 128 {
+				pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+			}
+		}
+	}
+	if len(pixels) == 0 {
+		return palette{}, fmt.Errorf("%s has no pixels to take colors from", filename)
+	}
+
+	colors := median_cut(pixels, extracted_colors)
+	sort.SliceStable(colors, func(a, b int) bool { return lab(colors[a])[0] < lab(colors[b])[0] })
+	if len(colors) == 1 {
+		// a picture of one color, from black to it
+		colors = append([]color.RGBA{{0, 0, 0, 255}}, colors...)
+	}
+	return palette{colors, false}, nil
+}
+
+// the mean colors of up to n boxes of the pixels
+func median_cut(pixels [][3]uint8, n int) []color.RGBA {
+	boxes := [][][3]uint8{pixels}
+	for len(boxes) < n {
+		// the box with the widest channel, boxes of one color can't be cut
+		widest, channel, width := -1, 0, 0
+		for b, box := range boxes {
+			for k := 0; k < 3; k++ {
+				low, high := box[0][k], box[0][k]
+				for _, p := range box {
+					if p[k] < low {
+						low = p[k]
+					}
+					if p[k] > high {
+						high = p[k]
+					}
+				}
+				if int(high-low) > width {
+					widest, channel, width = b, k, int(high-low)
+				}
+			}
+		}
+		if widest < 0 {
+			break
+		}
+		box := boxes[widest]
+		sort.Slice(box, func(a, b int) bool { return box[a][channel] < box[b][channel] })
+		half := len(box) / 2
+		boxes[widest] = box[:half]
+		boxes = append(boxes, box[half:])
+	}
+	colors := make([]color.RGBA, 0, len(boxes))
+	for _, box := range boxes {
+		var sum [3]int
+		for _, p := range box {
+			for k := range sum {
+				sum[k] += int(p[k])
+			}
+		}
+		mean := func(k int) uint8 { return uint8((sum[k] + len(box)/2) / len(box)) }
+		colors = append(colors, color.RGBA{mean(0), mean(1), mean(2), 255})
+	}
+	return colors
+}
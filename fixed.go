@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// note:
+// Floating point results depend on the order values are summed in and on the platform, some
+// architectures fuse a multiplication and an addition into one instruction that rounds differently.
+// With -fixed the coldness values are Q32.32 fixed point numbers in an int64 instead: 32 bits for the
+// integer part and 32 bits for the fraction. Integer addition doesn't care about the order and rounds
+// the same everywhere, so the results are bit identical on every platform and for every thread count.
+//
+// The background is still calculated with floats, it is rounded to 20 fractional bits before it is
+// converted so tiny platform differences in the noise disappear in the rounding.
+
+type Fixed int64
+type FixedMatrix = Grid[Fixed]
+
+const fixed_one Fixed = 1 << 32
+
+func to_fixed(v float64) Fixed {
+	return Fixed(math.Round(v * float64(fixed_one)))
+}
+
+func (v Fixed) float() float64 {
+	return float64(v) / float64(fixed_one)
+}
+
+// multiplies two fixed point numbers, the product is rounded towards zero
+func (a Fixed) mul(b Fixed) Fixed {
+	negative := (a < 0) != (b < 0)
+	hi, lo := bits.Mul64(uint64(abs_fixed(a)), uint64(abs_fixed(b)))
+	product := Fixed(hi<<32 | lo>>32)
+	if negative {
+		return -product
+	}
+	return product
+}
+
+func abs_fixed(v Fixed) Fixed {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// the initial background in fixed point, see the note above
+func background_fixed(v float64) Fixed {
+	return Fixed(math.Round(v*(1<<20))) << 12
+}
+
+// same as step() but with fixed point numbers
+func step_fixed(A, B, Y float64, coldness_matrix, temp_coldness_matrix *FixedMatrix, mask_matrix *Mask) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+	spread := to_fixed(A / 12.0)
+	growth := to_fixed(Y)
+
+	// look for frozen hexagons and set receptive values on the mask
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if (*coldness_matrix)[i][j] >= fixed_one {
+				(*mask_matrix)[i-1][j] = receptive
+				(*mask_matrix)[i-1][j+1] = receptive
+				(*mask_matrix)[i][j-1] = receptive
+				(*mask_matrix)[i][j] = receptive
+				(*mask_matrix)[i][j+1] = receptive
+				(*mask_matrix)[i+1][j-1] = receptive
+				(*mask_matrix)[i+1][j] = receptive
+			}
+		}
+	}
+
+	for i := range *temp_coldness_matrix {
+		for j := range (*temp_coldness_matrix)[i] {
+			(*temp_coldness_matrix)[i][j] = 0
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			switch {
+			case (*mask_matrix)[i][j] == non_receptive:
+				// simulate water floating out to it's neighbour hexagons
+				v0 := (*coldness_matrix)[i][j]
+				v1 := spread.mul(v0)
+
+				(*temp_coldness_matrix)[i-1][j] += v1
+				(*temp_coldness_matrix)[i-1][j+1] += v1
+				(*temp_coldness_matrix)[i][j-1] += v1
+				(*temp_coldness_matrix)[i][j] += v0 / 2
+				(*temp_coldness_matrix)[i][j+1] += v1
+				(*temp_coldness_matrix)[i+1][j-1] += v1
+				(*temp_coldness_matrix)[i+1][j] += v1
+
+			case (*mask_matrix)[i][j] == receptive:
+				// add constant to hexagons next to already frozen hexagon
+				(*temp_coldness_matrix)[i][j] += (*coldness_matrix)[i][j] + growth
+
+			default:
+				// ignore out of bound
+			}
+		}
+	}
+
+	*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// note:
+// grow_frames grows a flake and hands out how it looks while it grows, for a command that shows it
+// live, like a window or a web page, without its own snapshots and rendering. Every every iterations
+// the flake is rendered like the result without flags and sent on the channel, and the finished flake
+// after the last iteration if it didn't fall on one. The simulation waits until a frame is taken, so a
+// slow reader slows it down instead of piling up frames.
+//
+// The channel is closed when the simulation ends, however it ends. The function that comes with it
+// tells how once the channel is closed: nil when the flake is done, the error of the settings when they
+// are wrong (the channel is closed right away then), the error of ctx when it was cancelled and the
+// blow up error when the simulation broke. Like the hooks it is for the commands of this program.
+
+func grow_frames(ctx context.Context, settings Settings, every int) (<-chan image.Image, func() error) {
+	frames := make(chan image.Image)
+	var err error
+	done := make(chan struct{})
+	result := func() error {
+		<-done
+		return err
+	}
+	if every < 1 {
+		err = fmt.Errorf("a frame every %d iterations, it needs to be at least 1", every)
+	} else {
+		err = settings.check()
+	}
+	if err != nil {
+		close(frames)
+		close(done)
+		return frames, result
+	}
+	go func() {
+		defer close(done)
+		defer close(frames)
+		state := new_state(settings)
+		defer state.close()
+		send := func() bool {
+			// rendered between two iterations, nothing changes the matrix while it's drawn
+			values := state.values()
+			select {
+			case frames <- render_settings(state.Settings, &values):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		err = run_context(ctx, state, func(iteration int64) {
+			if iteration%int64(every) == 0 {
+				send()
+			}
+		})
+		if err == nil && state.Iteration%int64(every) != 0 && !send() {
+			err = ctx.Err()
+		}
+	}()
+	return frames, result
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// note:
+// A gallery is a static html page of results to share a whole session. snow gallery outdir/ looks
+// through snowflakes/ (or the files and directories after outdir) for pngs and jpegs with the metadata
+// of this program, images without it are skipped. For every flake it copies the image to outdir/images,
+// saves a thumbnail in outdir/thumbs and lists the settings from the metadata, the iterations, the habit
+// and the code that makes it again. The page is one file with the styles and the script of the lightbox
+// in it, clicking a thumbnail shows the image big with its settings, the arrow keys go to the next and
+// previous one and escape closes it. The flakes are in the order they were saved.
+
+type gallery_flake struct {
+	Name    string // of the image in images/ and the thumbnail in thumbs/
+	Caption string
+	Details [][2]string
+	source  string
+	saved   int64
+}
+
+// the settings first in the order of the command line, then the rest of the metadata
+func gallery_details(metadata map[string]string) [][2]string {
+	var details [][2]string
+	var settings map[string]json.RawMessage
+	if json.Unmarshal([]byte(metadata["Settings"]), &settings) == nil {
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		first := map[string]int{"A": 1, "B": 2, "Y": 3, "PP": 4, "PM": 5, "L": 6, "size": 7, "height": 8}
+		sort.Slice(keys, func(a, b int) bool {
+			fa, fb := first[keys[a]], first[keys[b]]
+			switch {
+			case fa != 0 && fb != 0:
+				return fa < fb
+			case fa != 0 || fb != 0:
+				return fa != 0
+			}
+			return keys[a] < keys[b]
+		})
+		for _, key := range keys {
+			value := string(settings[key])
+			var s string
+			if json.Unmarshal(settings[key], &s) == nil {
+				value = s
+			}
+			details = append(details, [2]string{key, value})
+		}
+	}
+	for _, key := range []string{"Iteration", "Habit", "Truncated", "Author", "Copyright"} {
+		if v, ok := metadata[key]; ok {
+			details = append(details, [2]string{strings.ToLower(key), v})
+		}
+	}
+	if code, ok := metadata["Code"]; ok {
+		details = append(details, [2]string{"code", code})
+		command := "snow -from-code " + code
+		if iteration, ok := metadata["Iteration"]; ok {
+			command += " " + iteration
+		}
+		details = append(details, [2]string{"command", command})
+	}
+	return details
+}
+
+// snow gallery [-title ...] [-thumbnail 240] outdir [files and directories]
+func gallery(args []string) {
+	flags := flag.NewFlagSet("gallery", flag.ExitOnError)
+	title := flags.String("title", "Snowflakes", "title of the page")
+	thumbnail := flags.Int("thumbnail", 240, "width and height the thumbnails fit in")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow gallery [flags] outdir [images and directories, snowflakes by default]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 || *thumbnail < 16 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	outdir := flags.Arg(0)
+	inputs := flags.Args()[1:]
+	if len(inputs) == 0 {
+		inputs = []string{"snowflakes"}
+	}
+
+	// the results, without what an earlier gallery in the same place copied
+	absolute_out, _ := filepath.Abs(outdir)
+	var flakes []gallery_flake
+	for _, input := range inputs {
+		err := filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if absolute, _ := filepath.Abs(path); info.IsDir() && absolute == absolute_out {
+				return filepath.SkipDir
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".png", ".jpg", ".jpeg":
+			default:
+				return nil
+			}
+			metadata, err := read_metadata(path)
+			if err != nil || metadata["Software"] != "procedural-snowflakes" {
+				return nil
+			}
+			flakes = append(flakes, gallery_flake{
+				Caption: metadata["Description"],
+				Details: gallery_details(metadata),
+				source:  path,
+				saved:   info.ModTime().UnixNano(),
+			})
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if len(flakes) == 0 {
+		fmt.Fprintln(os.Stderr, "no results with metadata found in", strings.Join(inputs, ", "))
+		os.Exit(1)
+	}
+	sort.SliceStable(flakes, func(a, b int) bool { return flakes[a].saved < flakes[b].saved })
+
+	// results from different directories can have the same name
+	taken := map[string]bool{}
+	for k := range flakes {
+		base := filepath.Base(flakes[k].source)
+		name := base
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, filepath.Ext(base)), n, filepath.Ext(base))
+		}
+		taken[name] = true
+		flakes[k].Name = name
+	}
+
+	for _, dir := range []string{"images", "thumbs"} {
+		if err := os.MkdirAll(filepath.Join(outdir, dir), 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	next := make(chan int)
+	var wait sync.WaitGroup
+	var mutex sync.Mutex // for the progress
+	done, failed := 0, false
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for k := range next {
+				err := copy_file(flakes[k].source, filepath.Join(outdir, "images", flakes[k].Name))
+				if err == nil {
+					err = save_thumbnail(flakes[k].source, filepath.Join(outdir, "thumbs", flakes[k].Name), *thumbnail)
+				}
+				mutex.Lock()
+				done++
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "\nfailed to add %s: %v\n", flakes[k].source, err)
+					failed = true
+				}
+				fmt.Fprintf(os.Stderr, "\rgallery:\t %d / %d", done, len(flakes))
+				mutex.Unlock()
+			}
+		}()
+	}
+	for k := range flakes {
+		next <- k
+	}
+	close(next)
+	wait.Wait()
+	fmt.Fprintln(os.Stderr)
+	if failed {
+		os.Exit(1)
+	}
+
+	page := filepath.Join(outdir, "index.html")
+	file, err := os.Create(page)
+	if err == nil {
+		err = gallery_page.Execute(file, struct {
+			Title     string
+			Thumbnail int
+			Flakes    []gallery_flake
+		}{*title, *thumbnail, flakes})
+		if close_err := file.Close(); err == nil {
+			err = close_err
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save gallery:", err)
+		os.Exit(1)
+	}
+	fmt.Println("saved gallery:\t", page)
+}
+
+func copy_file(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// the image scaled down to fit in size x size, smaller ones stay as they are
+func save_thumbnail(from, to string, size int) error {
+	img, err := load_layer(from)
+	if err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	scale := math.Min(1, float64(size)/math.Max(float64(bounds.Dx()), float64(bounds.Dy())))
+	width := int(math.Max(1, math.Round(float64(bounds.Dx())*scale)))
+	height := int(math.Max(1, math.Round(float64(bounds.Dy())*scale)))
+	if scale < 1 {
+		img = transform.Resize(img, width, height, transform.Linear)
+	}
+	return save_image(to, img, nil)
+}
+
+var gallery_page = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; padding: 24px; background: #0b0d12; color: #d8dee9; font: 14px/1.4 system-ui, sans-serif; }
+h1 { font-weight: 300; margin: 0 0 24px; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax({{.Thumbnail}}px, 1fr)); gap: 16px; }
+figure { margin: 0; cursor: pointer; text-align: center; }
+figure img { max-width: 100%; border-radius: 4px; }
+figcaption { font-size: 12px; color: #8891a0; margin-top: 4px; overflow-wrap: anywhere; }
+#lightbox { display: none; position: fixed; inset: 0; background: rgba(0, 0, 0, 0.92); padding: 24px; box-sizing: border-box; gap: 24px; }
+#lightbox.open { display: flex; }
+#lightbox img { flex: 1; min-width: 0; object-fit: contain; }
+#lightbox table { align-self: center; border-collapse: collapse; max-width: 360px; }
+#lightbox td { padding: 2px 8px; vertical-align: top; overflow-wrap: anywhere; }
+#lightbox td:first-child { color: #8891a0; }
+#lightbox a { color: #88c0d0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="grid">
+{{- range $k, $flake := .Flakes}}
+<figure data-index="{{$k}}"><img src="thumbs/{{$flake.Name}}" alt="{{$flake.Caption}}" loading="lazy"><figcaption>{{$flake.Name}}</figcaption></figure>
+{{- end}}
+</div>
+<div id="lightbox"><img alt=""><table></table></div>
+{{- range $k, $flake := .Flakes}}
+<template id="details-{{$k}}"><tr><td>image</td><td><a href="images/{{$flake.Name}}">{{$flake.Name}}</a></td></tr>{{range $flake.Details}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>{{end}}</template>
+{{- end}}
+<script>
+const figures = document.querySelectorAll("figure");
+const lightbox = document.getElementById("lightbox");
+let current = -1;
+function show(k) {
+  current = (k + figures.length) % figures.length;
+  const img = figures[current].querySelector("img");
+  lightbox.querySelector("img").src = img.src.replace("/thumbs/", "/images/");
+  lightbox.querySelector("img").alt = img.alt;
+  lightbox.querySelector("table").innerHTML = document.getElementById("details-" + current).innerHTML;
+  lightbox.classList.add("open");
+}
+figures.forEach((figure, k) => figure.addEventListener("click", () => show(k)));
+lightbox.addEventListener("click", (e) => { if (e.target.tagName !== "A") lightbox.classList.remove("open"); });
+document.addEventListener("keydown", (e) => {
+  if (!lightbox.classList.contains("open")) return;
+  if (e.key === "Escape") lightbox.classList.remove("open");
+  if (e.key === "ArrowRight") show(current + 1);
+  if (e.key === "ArrowLeft") show(current - 1);
+});
+</script>
+</body>
+</html>
+`))
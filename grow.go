@@ -0,0 +1,108 @@
+package main
+
+import "math"
+
+// note:
+// A growing grid starts small around the seed and doubles its size whenever the flake gets close to
+// the border, up to max_size. The old grid ends up in the middle of the new one:
+//
+//   X X X X X X X X
+//   X . . . . . . X
+//   X . o o o o . X     o  the old grid, copied as it is
+//   X . o o o o . X     .  new hexagons, they get the initial background
+//   X . o o o o . X
+//   X . o o o o . X
+//   X . . . . . . X
+//   X X X X X X X X
+//
+// The noise is taken relative to the middle of the grid so the background under the old grid stays the
+// same. The new hexagons start from the initial background while in a grid of the final size they would
+// have had the whole run to even out, so the result is close to but not the same as a run with that size.
+
+// size a growing grid starts with
+const grow_start = 64
+
+// the noise position of the first hexagon, growing grids are placed around the middle
+func (settings Settings) origin() int {
+	if settings.MaxSize == 0 {
+		return 0
+	}
+	return settings.Size / 2
+}
+
+// true when the flake or the water the border soaks up gets close enough to the middle that the grid
+// should grow
+func (state *State) near_edge() bool {
+	size := len(state.Mask)
+
+	// water spreads about sqrt(iteration / 2) hexagons, so the border has dried out three times that
+	if 3*math.Sqrt(float64(state.Iteration)/2) > float64(size/4) {
+		return true
+	}
+
+	if state.edge == nil {
+		// the flake is connected so it has to cross this ring before it gets further out. It lies half way
+		// to the border, closer than that the flake runs out of water the border has soaked up
+		distance := size / 4
+		for i := 0; i < size; i++ {
+			for j := 0; j < size; j++ {
+				if hex_distance(i, j, size) == float64(distance) {
+					state.edge = append(state.edge, [2]int{i, j})
+				}
+			}
+		}
+	}
+
+	switch {
+	case state.Settings.Fixed:
+		return frozen_at(&state.ColdnessFixed, state.edge)
+	case state.Settings.single_precision():
+		return frozen_at(&state.Coldness32, state.edge)
+	}
+	return frozen_at(&state.Coldness, state.edge)
+}
+
+// doubles the size of the grid, capped at max_size
+func (state *State) grow() {
+	old := state.Settings.Size
+	size := old * 2
+	if size > state.Settings.MaxSize {
+		size = state.Settings.MaxSize
+	}
+	state.Settings.Size = size
+
+	switch {
+	case state.Settings.Fixed:
+		state.ColdnessFixed, state.Mask = grow_grid(state.Settings, state.ColdnessFixed, state.Mask)
+		state.tempFixed = new_grid[Fixed](size)
+	case state.Settings.single_precision():
+		state.Coldness32, state.Mask = grow_grid(state.Settings, state.Coldness32, state.Mask)
+		state.temp32 = new_grid[float32](size)
+	default:
+		state.Coldness, state.Mask = grow_grid(state.Settings, state.Coldness, state.Mask)
+		state.temp = new_matrix(size)
+	}
+	if state.Temperature != nil {
+		state.Temperature, state.tempHeat = grow_temperature(state.Temperature, size), new_matrix(size)
+	}
+	state.border, state.edge = nil, nil
+}
+
+// initializes a grid of the settings size and copies the hexagons inside the border of the old one into
+// its middle
+func grow_grid[T Value](settings Settings, old_matrix Grid[T], old_mask Mask) (Grid[T], Mask) {
+	coldness_matrix, mask_matrix := new_grid[T](settings.Size), new_mask(settings.Size)
+	init_matrices(settings, &coldness_matrix, &mask_matrix)
+
+	old := len(old_matrix)
+	offset := settings.Size/2 - old/2
+	for i := 0; i < old; i++ {
+		for j := 0; j < old; j++ {
+			if in_bounds(i, j, old) {
+				coldness_matrix[i+offset][j+offset] = old_matrix[i][j]
+				mask_matrix[i+offset][j+offset] = old_mask[i][j]
+			}
+		}
+	}
+	return coldness_matrix, mask_matrix
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+)
+
+// note:
+// snow gui opens a window with a slider for every parameter and the flake growing next to them, to find
+// settings by feel instead of by running the command over and over (see desktop_gui.go). In a build
+// without windows, or with -browser, it's a page in the browser instead. The page is the run api of
+// snow serve (see runs.go) on 127.0.0.1 with the limits of one person at their own computer, and a page
+// on / that drives it:
+//
+//   A, Y        change the run that is growing from the next iteration on, like PATCH /runs/<id>
+//   B, PP, PM,  start the flake again with the new value, the old run is stopped
+//   L, size
+//   palette     only colors the preview, the run goes on
+//   random      new parameters in the ranges of -random
+//   save        downloads the preview under the name snow would give the result
+//
+// The preview is the image of the run, fetched a few times a second while it grows. The page opens in
+// the browser by itself unless -open=false.
+
+// snow gui [-browser] [-addr 127.0.0.1:8080]
+func gui(args []string) {
+	flags := flag.NewFlagSet("gui", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "address to serve the page on, keep it local")
+	open := flags.Bool("open", true, "open the page in the browser")
+	max_size := flags.Int("max-size", 1600, "largest matrix size of the size slider")
+	browser := flags.Bool("browser", false, "serve the page in the browser instead of opening a window")
+	flags.Parse(args)
+	if *max_size < 50 {
+		fmt.Fprintln(os.Stderr, "-max-size must be at least 50")
+		os.Exit(2)
+	}
+	if !*browser {
+		err := desktop_gui(*max_size)
+		if err == nil {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "gui:\t\t in the browser instead")
+	}
+
+	// one person at their own computer, no rate limit, and room for a run next to the one being stopped
+	s := new_server(Limits{MaxConcurrent: 2, MaxSize: *max_size, MaxIterations: 1000000})
+	mux := http.NewServeMux()
+	s.routes(mux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := gui_template.Execute(w, gui_page_data(*max_size)); err != nil {
+			fmt.Fprintln(os.Stderr, "rendering the page:", err)
+		}
+	})
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to listen:", err)
+		os.Exit(1)
+	}
+	url := "http://" + listener.Addr().String() + "/"
+	fmt.Fprintln(os.Stderr, "gui:\t\t", url)
+	if *open {
+		if err := open_browser(url); err != nil {
+			fmt.Fprintln(os.Stderr, "couldn't open a browser, open the address yourself:", err)
+		}
+	}
+	fmt.Fprintln(os.Stderr, http.Serve(listener, mux))
+	os.Exit(1)
+}
+
+// opens the url in the browser of the desktop
+func open_browser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
+type gui_slider struct {
+	Name              string
+	Min, Max, Step    float64
+	Value             float64
+	Live, Logarithmic bool // changed while the flake grows, moved in powers
+}
+
+type gui_data struct {
+	Sliders  []gui_slider
+	Palettes []string
+	Ranges   map[string][2]float64
+}
+
+func gui_page_data(max_size int) gui_data {
+	d := default_settings()
+	data := gui_data{
+		Sliders: []gui_slider{
+			{Name: "A", Min: 0.99, Max: 1.01, Step: 0.0001, Value: d.A, Live: true},
+			{Name: "B", Min: 0.05, Max: 0.95, Step: 0.0001, Value: d.B},
+			{Name: "Y", Min: 0.00001, Max: 0.01, Step: 0.00001, Value: d.Y, Live: true, Logarithmic: true},
+			{Name: "PP", Min: 0, Max: 0.3, Step: 0.0001, Value: d.PP},
+			{Name: "PM", Min: 0, Max: 0.8, Step: 0.0001, Value: d.PM},
+			{Name: "L", Min: 100, Max: 100000, Step: 100, Value: float64(d.L), Logarithmic: true},
+			{Name: "size", Min: 50, Max: float64(max_size), Step: 10, Value: float64(d.Size)},
+		},
+		Ranges: map[string][2]float64{},
+	}
+	for name := range palettes {
+		data.Palettes = append(data.Palettes, name)
+	}
+	sort.Strings(data.Palettes)
+	for name, r := range random_ranges {
+		data.Ranges[name] = [2]float64{r.low, r.high}
+	}
+	return data
+}
+
+var gui_template = template.Must(template.New("gui").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>snow</title>
+<style>
+body { margin: 0; display: flex; height: 100vh; font: 14px sans-serif; background: #111; color: #ddd; }
+#controls { width: 320px; padding: 16px; box-sizing: border-box; overflow-y: auto; }
+#controls label { display: block; margin-top: 12px; }
+#controls input[type=range], #controls select { width: 100%; }
+#controls output { float: right; font-family: monospace; }
+#preview { flex: 1; display: flex; align-items: center; justify-content: center; }
+#preview img { max-width: 100%; max-height: 100%; image-rendering: pixelated; }
+button, a.button { margin-top: 16px; margin-right: 8px; padding: 6px 12px; background: #333; color: #ddd; border: 1px solid #555; text-decoration: none; font: inherit; cursor: pointer; }
+#status { margin-top: 16px; font-family: monospace; white-space: pre-wrap; }
+</style></head>
+<body>
+<div id="controls">
+{{range .Sliders}}<label>{{.Name}}{{if .Live}} (live){{end}} <output id="{{.Name}}-value"></output>
+<input type="range" id="{{.Name}}" min="0" max="10000" data-min="{{.Min}}" data-max="{{.Max}}" data-step="{{.Step}}" data-value="{{.Value}}" data-live="{{.Live}}" data-log="{{.Logarithmic}}"></label>
+{{end}}<label>palette <select id="palette"><option value="">none</option>{{range .Palettes}}<option>{{.}}</option>{{end}}</select></label>
+<button id="random">random</button><a class="button" id="save" href="#">save</a>
+<div id="status"></div>
+</div>
+<div id="preview"><img id="flake" alt=""></div>
+<script>
+const ranges = {{.Ranges}};
+const sliders = Array.from(document.querySelectorAll("input[type=range]"));
+const status = document.getElementById("status");
+const flake = document.getElementById("flake");
+let run = null, done = false, fetching = false, restart = null;
+
+// the position of a slider from 0 to 10000 and back
+function value(s) {
+	const min = +s.dataset.min, max = +s.dataset.max, t = s.value / 10000;
+	const v = s.dataset.log === "true" ? min * Math.pow(max / min, t) : min + (max - min) * t;
+	const step = +s.dataset.step;
+	return +(Math.round(v / step) * step).toPrecision(10);
+}
+function set(s, v) {
+	const min = +s.dataset.min, max = +s.dataset.max;
+	const t = s.dataset.log === "true" ? Math.log(v / min) / Math.log(max / min) : (v - min) / (max - min);
+	s.value = Math.round(Math.max(0, Math.min(1, t)) * 10000);
+	show(s);
+}
+function show(s) {
+	const v = value(s);
+	document.getElementById(s.id + "-value").textContent = s.id === "L" || s.id === "size" ? v.toFixed(0) : +v.toPrecision(4);
+}
+function query() {
+	return sliders.map(s => s.id + "=" + (s.id === "L" || s.id === "size" ? value(s).toFixed(0) : value(s))).join("&");
+}
+
+async function start() {
+	if (run) {
+		await fetch("/runs/" + run, {method: "DELETE"});
+	}
+	const response = await fetch("/runs?" + query(), {method: "POST"});
+	if (!response.ok) {
+		status.textContent = await response.text();
+		run = null;
+		return;
+	}
+	run = (await response.json()).id;
+	done = false;
+}
+async function patch(s) {
+	if (!run || done) {
+		return start();
+	}
+	await fetch("/runs/" + run + "?" + s.id + "=" + value(s), {method: "PATCH"});
+}
+
+function image_url(download) {
+	const palette = document.getElementById("palette").value;
+	let url = "/runs/" + run + "/image?t=" + Date.now();
+	if (palette) {
+		url += "&palette=" + encodeURIComponent(palette);
+	}
+	return download ? url + "&download=1" : url;
+}
+// fetches the image of the run while it grows, and once more when it's done
+async function poll() {
+	if (!run || fetching) {
+		return;
+	}
+	fetching = true;
+	try {
+		const response = await fetch("/runs/" + run);
+		if (response.ok) {
+			const s = await response.json();
+			status.textContent = "iteration " + s.iteration + " of " + s.settings.L + "\nfrozen " + s.frozen +
+				(s.truncated != null ? "\ntouched the border at " + s.truncated : "") + (s.error ? "\n" + s.error : "");
+			if (!done) {
+				const img = new Image();
+				img.onload = () => { flake.src = img.src; };
+				img.src = image_url(false);
+			}
+			done = s.done;
+		}
+	} finally {
+		fetching = false;
+	}
+}
+
+for (const s of sliders) {
+	set(s, +s.dataset.value);
+	s.addEventListener("input", () => {
+		show(s);
+		if (s.dataset.live === "true") {
+			patch(s);
+			return;
+		}
+		// a restart after the slider stops moving, not for every step of it
+		clearTimeout(restart);
+		restart = setTimeout(start, 250);
+	});
+}
+document.getElementById("palette").addEventListener("change", () => {
+	if (run) {
+		flake.src = image_url(false);
+	}
+});
+document.getElementById("random").addEventListener("click", () => {
+	for (const s of sliders) {
+		const r = ranges[s.id];
+		if (r) {
+			set(s, +(r[0] + Math.random() * (r[1] - r[0])).toFixed(4));
+		}
+	}
+	start();
+});
+document.getElementById("save").addEventListener("click", e => {
+	if (!run) {
+		e.preventDefault();
+		return;
+	}
+	e.target.href = image_url(true);
+});
+start();
+setInterval(poll, 400);
+</script>
+</body></html>
+`))
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// note:
+// The habit sorts a flake into one of the standard forms of snow crystals, from its structure. The checks
+// go in order and the first one that fits is the habit:
+//
+//   irregular          not 6 main arms, or the longest arm is irregular_spread of the mean longer than
+//                      the shortest, the arms grew unevenly
+//   simple plate       no side branches and the flake fills plate_fill of the hexagon its arms reach
+//   fern               side branches fern_spacing hexagons apart or closer, they grow into each other
+//   sectored plate     the flake fills sectored_fill of the hexagon, the gaps between the branches close
+//   stellar dendrite   everything else, arms with side branches far enough apart to stay separate
+//
+// The thresholds come from a sweep over A = 1 with B from 0.2 to 0.8 and Y from 0.0001 to 0.01, they
+// match how the images look. The form of the traits only looks at the fill, the habit also looks at the
+// side branches and the arms. -habit puts it in the metadata and the name of the result.
+
+const (
+	irregular_spread = 0.3
+	plate_fill       = 0.6
+	fern_spacing     = 4.0
+	sectored_fill    = 0.45
+)
+
+func classify_habit(s Structure) string {
+	if s.Arms != 6 {
+		return "irregular"
+	}
+	shortest, longest := math.Inf(1), 0.0
+	for _, arm := range s.PerArm {
+		shortest, longest = math.Min(shortest, float64(arm.Length)), math.Max(longest, float64(arm.Length))
+	}
+	if longest-shortest > irregular_spread*s.ArmLength {
+		return "irregular"
+	}
+	// hexagons in a hexagon with the arms as radius
+	R := s.ArmLength
+	fill := float64(s.Area) / (3*R*R + 3*R + 1)
+	switch {
+	case s.Branches < 1 && fill >= plate_fill:
+		return "simple plate"
+	case s.Spacing > 0 && s.Spacing <= fern_spacing:
+		return "fern"
+	case fill >= sectored_fill:
+		return "sectored plate"
+	}
+	return "stellar dendrite"
+}
+
+// the habit at the end of the name, like flake-stellar-dendrite.png
+func habit_filename(filename, habit string) string {
+	return fmt.Sprintf("%s-%s.png", strings.TrimSuffix(filename, ".png"), strings.ReplaceAll(habit, " ", "-"))
+}
@@ -0,0 +1,80 @@
+package main
+
+import "math"
+
+// note:
+// -heat adds a second field, the temperature of the air, to model latent heat. Ice gives off heat when it
+// freezes, and warm ice grows slower, so a hexagon that freezes holds back the hexagons around it for a
+// while. The arms warm the ice between them and the side branches next to each other hold each other
+// back, they stay short and fine and sit closer together, and the flake grows slower. Every iteration
+// after the vapor has moved:
+//
+//   slowing      a receptive hexagon at temperature t keeps only 1 - t of what it took up, none at 1 or
+//                more, what it didn't take up is lost to the air
+//   release      a hexagon that freezes warms up by -heat
+//   conduction   the heat spreads like the vapor in Reiter's averaging, half stays and a twelfth goes to
+//                each neighbour, through the ice and the air alike, the border is cold and takes it
+//   cooling      the air takes -cooling of the heat away
+//
+// The temperature is always 64 bit, also with -precision 32, and it is stored in the state files after
+// the mask.
+
+const default_cooling = 0.02
+
+func (settings Settings) cooling() float64 {
+	if settings.Cooling == 0 {
+		return default_cooling
+	}
+	return settings.Cooling
+}
+
+// the heat of a step, called after it with the coldness before the step in previous
+func release_heat[T Real](heat, cooling float64, coldness_matrix, previous_matrix *Grid[T], mask_matrix *Mask, temperature, temp_temperature *Matrix) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+
+	for i := 1; i < size-1; i++ {
+		for j := 1; j < height-1; j++ {
+			before := (*previous_matrix)[i][j]
+			if (*mask_matrix)[i][j] != receptive || before >= 1.0 {
+				continue
+			}
+			if t := (*temperature)[i][j]; t > 0 {
+				keep := T(math.Max(0, 1-t))
+				(*coldness_matrix)[i][j] = before + ((*coldness_matrix)[i][j]-before)*keep
+			}
+			if (*coldness_matrix)[i][j] >= 1.0 {
+				(*temperature)[i][j] += heat
+			}
+		}
+	}
+
+	// the out of bound hexagons stay at 0 and take what reaches them, the edge of the matrix is never
+	// touched and stays 0 too
+	for i := 1; i < size-1; i++ {
+		for j := 1; j < height-1; j++ {
+			if (*mask_matrix)[i][j] == out_of_bound {
+				(*temp_temperature)[i][j] = 0
+				continue
+			}
+			neighbours := (*temperature)[i-1][j] + (*temperature)[i-1][j+1] + (*temperature)[i][j-1] +
+				(*temperature)[i][j+1] + (*temperature)[i+1][j-1] + (*temperature)[i+1][j]
+			(*temp_temperature)[i][j] = (1 - cooling) * ((*temperature)[i][j]/2 + neighbours/12)
+		}
+	}
+	*temperature, *temp_temperature = *temp_temperature, *temperature
+}
+
+// the temperature of a grid that grew, in the middle of a cold grid of the new size
+func grow_temperature(temperature Matrix, size int) Matrix {
+	grown := new_matrix(size)
+	old := len(temperature)
+	offset := size/2 - old/2
+	for i := 0; i < old; i++ {
+		for j := 0; j < old; j++ {
+			if in_bounds(i, j, old) {
+				grown[i+offset][j+offset] = temperature[i][j]
+			}
+		}
+	}
+	return grown
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// note:
+// The history keeps the last few states of a simulation in memory, compressed the same way as state
+// files. After the run it is saved as a history file, which is just the state files one after another
+// (oldest first), so an earlier and maybe prettier stage of the flake can be rendered or resumed later.
+
+type history struct {
+	every   int64    // iterations between snapshots
+	records [][]byte // ring buffer of encoded states
+	next    int      // where the next snapshot goes
+	last    int64    // iteration of the newest snapshot
+}
+
+func new_history(length int, every int64) *history {
+	return &history{every: every, records: make([][]byte, length), last: -1}
+}
+
+// keeps a snapshot of the state, the oldest one is dropped when the buffer is full
+func (h *history) add(state *State) error {
+	var record bytes.Buffer
+	if err := write_state(&record, state); err != nil {
+		return err
+	}
+	h.records[h.next] = record.Bytes()
+	h.next = (h.next + 1) % len(h.records)
+	h.last = state.Iteration
+	return nil
+}
+
+// writes the snapshots oldest first
+func (h *history) save(filename string) error {
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	for k := range h.records {
+		record := h.records[(h.next+k)%len(h.records)]
+		if _, err := file.Write(record); err != nil {
+			file.abort()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// reads all states of a history file
+func load_history(filename string) ([]*State, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var states []*State
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			break
+		}
+		state, err := read_state(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: snapshot %d: %v", filename, len(states)+1, err)
+		}
+		states = append(states, state)
+	}
+	if len(states) == 0 {
+		return nil, fmt.Errorf("%s: no snapshots", filename)
+	}
+	return states, nil
+}
+
+// snow rewind [-n iterations] [-out file] [-state file] flake.history
+func rewind(args []string) {
+	flags := flag.NewFlagSet("rewind", flag.ExitOnError)
+	n := flags.Int64("n", -1, "iterations to go back from the last snapshot, lists the snapshots when not set")
+	out := flags.String("out", "", "output file, - streams the png to stdout (default is the history file name with the iteration)")
+	state_out := flags.String("state", "", "also save the snapshot as a state file, to resume from it")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow rewind [flags] flake.history\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	states, err := load_history(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	last := states[len(states)-1].Iteration
+	if *n < 0 {
+		for _, state := range states {
+			fmt.Printf("iteration %d:\t %d back\n", state.Iteration, last-state.Iteration)
+		}
+		return
+	}
+
+	// the newest snapshot that is at least n iterations back
+	var state *State
+	for _, s := range states {
+		if s.Iteration <= last-*n {
+			state = s
+		}
+	}
+	if state == nil {
+		fmt.Fprintf(os.Stderr, "the history doesn't go back that far, the oldest snapshot is %d back\n", last-states[0].Iteration)
+		os.Exit(1)
+	}
+
+	// keep stdout clean for the image when streaming
+	console := io.Writer(os.Stdout)
+	if *out == "-" {
+		console = os.Stderr
+	}
+	if *state_out != "" {
+		if err := save_state(*state_out, state); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save state:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(console, "saved state:\t", *state_out)
+	}
+
+	filename := *out
+	if filename == "" {
+		filename = fmt.Sprintf("%s-%d.png", strings.TrimSuffix(flags.Arg(0), ".history"), state.Iteration)
+	}
+	coldness_matrix := state.values()
+	if err := save_image(filename, render_settings(state.Settings, &coldness_matrix), state.metadata()); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save result:", err)
+		os.Exit(1)
+	}
+	if filename != "-" {
+		fmt.Printf("rendered iteration %d:\t %s\n", state.Iteration, filename)
+	}
+}
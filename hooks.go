@@ -0,0 +1,82 @@
+package main
+
+// note:
+// Hooks let a command that runs a simulation watch it without changing the engine, for progress bars,
+// live views or stopping on something of its own. They are called by run() on the goroutine that steps
+// the simulation, after every iteration:
+//
+//   on_iteration   the iteration with the frozen hexagons so far and the ones that froze in it
+//   on_freeze      every hexagon that froze, i and j of the matrix like the timeline
+//   on_complete    once when run() is done, with the finished state, not when its context was cancelled
+//
+// A hook can end the simulation with state.stop(), it stops after the iteration it was called for. Any
+// number of hooks can be added, they are called in the order they were added. Finding the hexagons that
+// froze looks at the whole matrix, so a state with hooks runs a little slower. Hooks aren't saved with
+// the state and aren't called in distributed mode. Everything is in package main, so they are for the
+// commands of this program, there is no package to import them from.
+
+type iteration_stats struct {
+	Iteration int64
+	Frozen    int  // hexagons frozen so far
+	Froze     int  // hexagons that froze in this iteration
+	Truncated bool // the flake has touched the border
+}
+
+type hooks struct {
+	iteration []func(stats iteration_stats)
+	freeze    []func(i, j int, iteration int64)
+	complete  []func(state *State)
+	frozen    *timeline // the hexagons that have been seen frozen
+	count     int
+}
+
+func (state *State) add_hooks() *hooks {
+	if state.hooks == nil {
+		state.hooks = &hooks{frozen: new_timeline(state), count: state.frozen()}
+	}
+	return state.hooks
+}
+
+// calls hook after every iteration
+func (state *State) on_iteration(hook func(stats iteration_stats)) {
+	h := state.add_hooks()
+	h.iteration = append(h.iteration, hook)
+}
+
+// calls hook for every hexagon that freezes
+func (state *State) on_freeze(hook func(i, j int, iteration int64)) {
+	h := state.add_hooks()
+	h.freeze = append(h.freeze, hook)
+}
+
+// calls hook when the simulation is done
+func (state *State) on_complete(hook func(state *State)) {
+	h := state.add_hooks()
+	h.complete = append(h.complete, hook)
+}
+
+// ends the simulation after the iteration that is running
+func (state *State) stop() {
+	state.Settings.L = state.Iteration
+}
+
+func (h *hooks) iterated(state *State) {
+	froze := 0
+	h.frozen.record(state, func(i, j int) {
+		froze++
+		for _, hook := range h.freeze {
+			hook(i, j, state.Iteration)
+		}
+	})
+	h.count += froze
+	stats := iteration_stats{state.Iteration, h.count, froze, state.Truncated >= 0}
+	for _, hook := range h.iteration {
+		hook(stats)
+	}
+}
+
+func (h *hooks) completed(state *State) {
+	for _, hook := range h.complete {
+		hook(state)
+	}
+}
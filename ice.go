@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// note:
+// -render picks how the matrix becomes colors. heatmap is the brightness of the coldness in the colors of
+// the palette, ice lights the flake like a crystal in a photograph. The thickness of the ice is the
+// coldness of the frozen hexagons like the height of the pbr maps, and it is rendered the same way as the
+// result, with -roi, -resample and -rotate, before it is lit:
+//
+//   shape          normals from the thickness like the pbr normal map
+//   transmission   the light through the ice loses red first, thick parts turn blue (beer-lambert)
+//   lighting       a soft light from -light, the top left by default, with a sharp specular highlight
+//                  (blinn-phong)
+//   edges          tilted parts and the thin border get darker, the way the edges of real ice do
+//
+// The ice is see through, the background of the theme shows through it tinted.
+
+var render_modes = []string{"heatmap", "ice", "volume", "mask", "stroke"}
+
+func render_mode_names() string {
+	return strings.Join(render_modes, ", ")
+}
+
+func valid_render_mode(mode string) bool {
+	for _, m := range render_modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ice_relief  = 6.0  // how steep the normals get compared to the thickness
+	ice_density = 2.2  // how much light the thickest ice takes
+	ice_shine   = 60.0 // the higher the smaller the highlights
+)
+
+// what the ice takes out of every channel for every unit of thickness, red the most
+var ice_absorption = [3]float64{0.5, 0.2, 0.06}
+
+func normalized(v [3]float64) [3]float64 {
+	length := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	return [3]float64{v[0] / length, v[1] / length, v[2] / length}
+}
+
+// the slope of the values around x, y along x and y
+func sobel(at func(x, y int) float64, x, y int) (float64, float64) {
+	dx := (at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x-1, y) + at(x-1, y+1))
+	dy := (at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x, y-1) + at(x+1, y-1))
+	return dx, dy
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+// the frozen hexagons scaled so the thickest is 1, the rest is 0
+func thickness(values Matrix) Matrix {
+	highest := 1.0
+	for i := range values {
+		for _, v := range values[i] {
+			highest = math.Max(highest, v)
+		}
+	}
+	out := new_matrix_like(values)
+	for i := range values {
+		for j, v := range values[i] {
+			if v >= 1.0 {
+				out[i][j] = v / highest
+			}
+		}
+	}
+	return out
+}
+
+// the flake lit as ice on the background, draw renders a matrix like the result is rendered
+func render_ice(values Matrix, draw func(*Matrix) image.Image, background color.RGBA, light [3]float64) image.Image {
+	thick := thickness(values)
+	mask := new_matrix_like(values)
+	for i := range values {
+		for j, v := range values[i] {
+			if v >= 1.0 {
+				mask[i][j] = 1
+			}
+		}
+	}
+	heights, coverage := draw(&thick), draw(&mask)
+	bounds := heights.Bounds()
+	gray := func(img image.Image, x, y int) float64 {
+		x = int(math.Max(0, math.Min(float64(bounds.Dx()-1), float64(x))))
+		y = int(math.Max(0, math.Min(float64(bounds.Dy()-1), float64(y))))
+		return float64(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y) / 255
+	}
+	half := normalized([3]float64{light[0], light[1], light[2] + 1})
+	back := [3]float64{float64(background.R) / 255, float64(background.G) / 255, float64(background.B) / 255}
+
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			h, cover := gray(heights, x, y), gray(coverage, x, y)
+			// the step down to nothing at the border isn't a slope, outside counts as level with the middle
+			at := func(x, y int) float64 {
+				if gray(coverage, x, y) < 0.5 {
+					return h
+				}
+				return gray(heights, x, y)
+			}
+			dx, dy := sobel(at, x, y)
+			n := normalized([3]float64{-dx * ice_relief, -dy * ice_relief, 1})
+			// the border is where the coverage changes
+			rx, ry := sobel(func(x, y int) float64 { return gray(coverage, x, y) }, x, y)
+			rim := math.Min(1, math.Hypot(rx, ry)/2)
+
+			diffuse := math.Max(0, dot(n, light))
+			specular := math.Pow(math.Max(0, dot(n, half)), ice_shine)
+			// facing the camera is 1, tilted parts and the border go darker
+			edge := n[2] * (1 - 0.6*rim)
+
+			var c [3]float64
+			for k := range c {
+				transmitted := math.Exp(-ice_absorption[k] * ice_density * h)
+				lit := transmitted * (0.45 + 0.75*diffuse) * edge
+				ice := back[k]*transmitted*0.35 + lit + 0.9*specular
+				c[k] = back[k]*(1-cover) + ice*cover
+			}
+			channel := func(v float64) uint8 { return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255)) }
+			out.SetRGBA(x, y, color.RGBA{channel(c[0]), channel(c[1]), channel(c[2]), 255})
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// note:
+// Insets put magnified regions of interest next to the full flake, the way figures in papers show the
+// detail of a crystal. The insets are stacked in a column on the right, as high together as the flake,
+// and every one gets a box of its own color around it and around the place it shows in the flake, with
+// its number next to both.
+
+// the colors of the boxes, they stand out on all the themes
+var inset_colors = hex_stops("#ff6b35", "#00b4d8", "#f72585", "#80ed99", "#ffd60a", "#9d4edd")
+
+// -inset can be given more than once
+func inset_flag(flags *flag.FlagSet, insets *[]image.Rectangle) {
+	flags.Func("inset", "x,y,w,h of the flake in cells (pixels at 1:1) to show magnified next to it, can be given more than once", func(s string) error {
+		roi, err := parse_roi(s)
+		if err == nil {
+			*insets = append(*insets, roi)
+		}
+		return err
+	})
+}
+
+// the full image with the insets on the right, in the colors of the theme
+func render_insets[T Real](full image.Image, matrix *Grid[T], insets []image.Rectangle, t theme) image.Image {
+	bounds := full.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	gap := int(math.Max(4, float64(height/40)))
+	side := (height - gap*(len(insets)-1)) / len(insets)
+
+	// the gray image first so the theme colors it like the result, the boxes go on top
+	figure := image.NewGray(image.Rect(0, 0, width+gap+side, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			figure.Set(x, y, full.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	panels := make([]image.Rectangle, len(insets))
+	for k, roi := range insets {
+		scale := float64(side) / math.Max(float64(roi.Dx()), float64(roi.Dy()))
+		panel := render_scaled(matrix, roi, scale)
+		size := panel.Bounds().Size()
+		// centered in its square
+		at := image.Pt(width+gap+(side-size.X)/2, k*(side+gap)+(side-size.Y)/2)
+		panels[k] = image.Rectangle{at, at.Add(size)}
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				figure.Set(at.X+x, at.Y+y, panel.At(x, y))
+			}
+		}
+	}
+
+	out := image.NewRGBA(figure.Bounds())
+	for y := 0; y < figure.Bounds().Dy(); y++ {
+		for x := 0; x < figure.Bounds().Dx(); x++ {
+			out.SetRGBA(x, y, t.at(x, y, float64(figure.GrayAt(x, y).Y)/255))
+		}
+	}
+
+	line := int(math.Max(1, float64(height/400)))
+	scale := int(math.Max(1, math.Round(float64(height)/400)))
+	for k, roi := range insets {
+		c := inset_colors[k%len(inset_colors)]
+		label := fmt.Sprint(k + 1)
+		locator := roi
+		draw_frame(out, locator, line, c)
+		// above the box, or below it when the box is at the top
+		_, label_height := text_size(label, scale)
+		y := locator.Min.Y - label_height - line
+		if y < 0 {
+			y = locator.Max.Y + line
+		}
+		draw_text(out, locator.Min.X, y, label, scale, c)
+		draw_frame(out, panels[k], line, c)
+		draw_text(out, panels[k].Min.X+2*line, panels[k].Min.Y+2*line, label, scale, c)
+	}
+	return out
+}
+
+// a box of lines as thick as line, on the inside of the rectangle
+func draw_frame(img *image.RGBA, r image.Rectangle, line int, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if x < r.Min.X+line || x >= r.Max.X-line || y < r.Min.Y+line || y >= r.Max.Y-line {
+				if (image.Point{x, y}).In(img.Bounds()) {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+}
+
+// the file name of the result with insets
+func insets_filename(filename string) string {
+	return strings.TrimSuffix(filename, ".png") + "-insets.png"
+}
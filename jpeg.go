@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+)
+
+// encodes the image as jpeg with the metadata as exif and xmp, both go in app1 segments right after
+// the start of the image
+func write_jpeg(w io.Writer, img image.Image, metadata map[string]string) error {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		return err
+	}
+	data := encoded.Bytes()
+
+	exif := append([]byte("Exif\x00\x00"), encode_ifd(metadata_entries(metadata))...)
+	xmp := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp_packet(metadata)...)
+
+	var out bytes.Buffer
+	out.Write(data[:2]) // start of image
+	for _, segment := range [][]byte{exif, xmp} {
+		if len(segment)+2 > 0xffff {
+			return errors.New("the metadata is too long for a jpeg")
+		}
+		out.Write([]byte{0xff, 0xe1})
+		binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+		out.Write(segment)
+	}
+	out.Write(data[2:])
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// the metadata of a jpeg from its xmp packet, the segments are read up to the start of the scan
+func read_jpeg_metadata(r io.Reader) (map[string]string, error) {
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xff, 0xd8} {
+		return nil, errors.New("not a jpeg")
+	}
+	const xmp_header = "http://ns.adobe.com/xap/1.0/\x00"
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil || header[0] != 0xff || header[1] == 0xda {
+			return map[string]string{}, nil
+		}
+		segment := make([]byte, int(binary.BigEndian.Uint16(header[2:]))-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+		if header[1] == 0xe1 && bytes.HasPrefix(segment, []byte(xmp_header)) {
+			return parse_xmp(segment[len(xmp_header):])
+		}
+	}
+}
+
+// the metadata back from an xmp packet of xmp_packet
+func parse_xmp(packet []byte) (map[string]string, error) {
+	metadata := map[string]string{}
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var path []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return metadata, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if t.Name.Space == "https://github.com/antonpalsson/procedural-snowflakes/" {
+				name = "snow:" + name
+			}
+			path = append(path, name)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			// xmpmeta, RDF, Description and the field, the dublin core fields are in a list
+			var key string
+			switch {
+			case len(path) == 4 && path[3] == "CreatorTool":
+				key = "Software"
+			case len(path) == 4 && strings.HasPrefix(path[3], "snow:"):
+				key = strings.TrimPrefix(path[3], "snow:")
+			case len(path) == 6 && path[3] == "creator":
+				key = "Author"
+			case len(path) == 6 && path[3] == "description":
+				key = "Description"
+			case len(path) == 6 && path[3] == "rights":
+				key = "Copyright"
+			default:
+				continue
+			}
+			metadata[key] += string(t)
+		}
+	}
+}
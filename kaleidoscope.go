@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// note:
+// The kaleidoscope and the mirror force a symmetry on the finished flake, to make perfect flakes or
+// mandalas from simulations with noise. They work on the matrix around the seed, turning by 60 degrees
+// and mirroring are exact in hex coordinates, so every hexagon gets the value of exactly one other:
+//
+//   3     the wedge from 0 to 120 degrees is turned around twice
+//   6     the wedge from 0 to 60 degrees, five times
+//   12    the wedge from 0 to 30 degrees, mirrored into 60 and then turned like 6
+//   mirror  the left half is mirrored onto the right
+//
+// 0 degrees points right from the seed. The mirror can't be put on a kaleidoscope, 12 is mirrored already
+// and the others would lose their turns. Only the result changes, the state, checkpoints and traits are
+// the simulation as it was. Hexagons that would come from outside of the matrix are 0.
+
+var kaleidoscope_folds = []int{3, 6, 12}
+
+// the hexagon turned around the seed by 60 degrees k times
+func turn(q, r, k int) (int, int) {
+	for ; k > 0; k-- {
+		q, r = -r, q+r
+	}
+	return q, r
+}
+
+// the angle of the hexagon around the seed in the image, from 0 to 360
+func hex_angle(q, r int) float64 {
+	x, y := float64(q)+float64(r)/2, float64(r)*math.Sqrt(3)/2
+	a := math.Atan2(y, x) * 180 / math.Pi
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// the hexagon in the first wedge the hexagon gets its value from
+func kaleidoscope_source(q, r, fold int) (int, int) {
+	wedge, turns := 120.0, 2
+	if fold != 3 {
+		wedge, turns = 60, 1
+	}
+	for k := 0; k < 6; k += turns {
+		tq, tr := turn(q, r, k)
+		// a hexagon on the edge between two wedges belongs to the one it starts
+		if hex_angle(tq, tr) < wedge-1e-9 {
+			q, r = tq, tr
+			break
+		}
+	}
+	// mirrored on the line at 30 degrees
+	if fold == 12 && hex_angle(q, r) > 30+1e-9 {
+		q, r = r, q
+	}
+	return q, r
+}
+
+// a copy of the values with the kaleidoscope or the mirror around the seed, fold is 0 for the mirror
+func symmetric(values Matrix, seed_i, seed_j, fold int) Matrix {
+	size, height := len(values), len(values[0])
+	out := new_matrix_like(values)
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			q, r := i-seed_i, j-seed_j
+			switch {
+			case fold != 0:
+				q, r = kaleidoscope_source(q, r, fold)
+			case 2*q+r > 0:
+				// right of the seed
+				q = -q - r
+			}
+			if si, sj := seed_i+q, seed_j+r; si >= 0 && si < size && sj >= 0 && sj < height {
+				out[i][j] = values[si][sj]
+			}
+		}
+	}
+	return out
+}
+
+func valid_fold(fold int) bool {
+	for _, f := range kaleidoscope_folds {
+		if f == fold {
+			return true
+		}
+	}
+	return false
+}
+
+// the file name of a result with a symmetry
+func symmetric_filename(filename string, fold int) string {
+	if fold == 0 {
+		return strings.TrimSuffix(filename, ".png") + "-mirror.png"
+	}
+	return fmt.Sprintf("%s-kaleidoscope%d.png", strings.TrimSuffix(filename, ".png"), fold)
+}
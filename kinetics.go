@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// note:
+// -kinetics replaces Reiter's rule, where a receptive hexagon freezes by Y every iteration, with the
+// attachment kinetics of Gravner and Griffeath (Modeling snow crystal growth II, 2008). Every hexagon holds
+// three masses: vapor d, the boundary mass b of the quasi-liquid layer on the ice, and crystal mass c. The
+// vapor starts at B with the perlin noise, A and Y aren't used. Every iteration:
+//
+//   diffusion    the vapor of every hexagon that isn't ice becomes the mean of it and its 6 neighbours,
+//                ice and the border reflect it back
+//   freezing     the hexagons next to the ice turn their vapor into boundary mass, kappa of it straight
+//                into crystal mass
+//   attachment   a hexagon next to the ice joins it when
+//                  it has -attach-neighbours frozen neighbours or more
+//                  it has one less and b >= 1, or b >= alpha and the vapor around it is below theta
+//                  it has fewer and b >= beta
+//                its boundary mass becomes crystal mass
+//   melting      mu of the boundary mass and gamma of the crystal mass of the hexagons next to the ice go
+//                back to vapor
+//
+// All the mass stays in the grid, the flake stops growing when the vapor runs out. The tips need beta,
+// the sides between the branches fill in when the vapor is low enough, that is what grows sectored plates
+// and the ribs on dendrites. The coldness of the ice is 1 + c, so the ribs show where the coldness is the
+// thickness, in -render ice, -pbr and -scene, the rest of the grid shows its vapor. The masses are 64
+// bit and stored in the state files after the mask.
+
+type Kinetics struct {
+	Beta       float64 `json:"beta"`                 // boundary mass a tip needs to attach
+	Alpha      float64 `json:"alpha"`                // boundary mass enough with one frozen neighbour less when the vapor is low
+	Theta      float64 `json:"theta"`                // vapor of a hexagon and its neighbours below which alpha is enough
+	Kappa      float64 `json:"kappa"`                // part of the freezing vapor that becomes crystal mass right away
+	Mu         float64 `json:"mu"`                   // part of the boundary mass that melts every iteration
+	Gamma      float64 `json:"gamma"`                // part of the crystal mass next to the ice that melts every iteration
+	Neighbours int     `json:"neighbours,omitempty"` // frozen neighbours a hexagon always attaches with, 4 when not set
+}
+
+func (k Kinetics) neighbours() int {
+	if k.Neighbours == 0 {
+		return 4
+	}
+	return k.Neighbours
+}
+
+// the kinetics of the settings, created when they aren't set
+func (settings *Settings) kinetics() *Kinetics {
+	if settings.Kinetics == nil {
+		settings.Kinetics = &Kinetics{}
+	}
+	return settings.Kinetics
+}
+
+// the stellar dendrite from the paper, with B = 0.635
+func default_kinetics() Kinetics {
+	return Kinetics{Beta: 1.6, Alpha: 0.4, Theta: 0.025, Kappa: 0.0025, Mu: 0.015, Gamma: 0.0005}
+}
+
+func (k Kinetics) check() error {
+	switch {
+	case k.Beta < 0 || k.Alpha < 0 || k.Theta < 0:
+		return fmt.Errorf("beta, alpha and theta can't be negative")
+	case k.Kappa < 0 || k.Kappa > 1 || k.Mu < 0 || k.Mu > 1 || k.Gamma < 0 || k.Gamma > 1:
+		return fmt.Errorf("kappa, mu and gamma must be from 0 to 1")
+	case k.Neighbours < 0 || k.Neighbours > 6:
+		return fmt.Errorf("attach neighbours must be from 1 to 6")
+	}
+	return nil
+}
+
+var hex_neighbours = [6][2]int{{-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}}
+
+// the largest coldness of a hexagon that isn't ice
+var below_ice = math.Nextafter(1, 0)
+
+// the masses of a new simulation from its coldness, the seed becomes crystal mass
+func init_kinetics(coldness_matrix Matrix) (vapor, boundary, crystal Matrix) {
+	vapor, boundary, crystal = new_matrix_like(coldness_matrix), new_matrix_like(coldness_matrix), new_matrix_like(coldness_matrix)
+	for i := range coldness_matrix {
+		for j, v := range coldness_matrix[i] {
+			if v >= 1.0 {
+				crystal[i][j] = v - 1
+			} else {
+				vapor[i][j] = v
+			}
+		}
+	}
+	return vapor, boundary, crystal
+}
+
+func step_kinetics(k Kinetics, coldness_matrix *Matrix, mask_matrix *Mask, vapor, boundary, crystal, temp_vapor *Matrix) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+	frozen := func(i, j int) bool { return (*coldness_matrix)[i][j] >= 1.0 }
+
+	// look for frozen hexagons and set receptive values on the mask, the receptive hexagons that aren't
+	// frozen are the boundary
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if frozen(i, j) {
+				for _, n := range hex_neighbours {
+					// the border stays out of bound so the ice never gets to the edge of the matrix
+					if x, y := i+n[0], j+n[1]; (*mask_matrix)[x][y] != out_of_bound {
+						(*mask_matrix)[x][y] = receptive
+					}
+				}
+			}
+		}
+	}
+	boundary_at := func(i, j int) bool { return (*mask_matrix)[i][j] == receptive && !frozen(i, j) }
+
+	// diffusion
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			d := (*vapor)[i][j]
+			if (*mask_matrix)[i][j] == out_of_bound || frozen(i, j) {
+				(*temp_vapor)[i][j] = d
+				continue
+			}
+			sum := d
+			for _, n := range hex_neighbours {
+				if x, y := i+n[0], j+n[1]; (*mask_matrix)[x][y] == out_of_bound || frozen(x, y) {
+					sum += d
+				} else {
+					sum += (*vapor)[x][y]
+				}
+			}
+			(*temp_vapor)[i][j] = sum / 7
+		}
+	}
+	*vapor, *temp_vapor = *temp_vapor, *vapor
+
+	// freezing
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if boundary_at(i, j) {
+				d := (*vapor)[i][j]
+				(*boundary)[i][j] += (1 - k.Kappa) * d
+				(*crystal)[i][j] += k.Kappa * d
+				(*vapor)[i][j] = 0
+			}
+		}
+	}
+
+	// attachment, all hexagons decide before the first one attaches
+	var attaching [][2]int
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if !boundary_at(i, j) {
+				continue
+			}
+			count, around := 0, (*vapor)[i][j]
+			for _, n := range hex_neighbours {
+				if frozen(i+n[0], j+n[1]) {
+					count++
+				}
+				around += (*vapor)[i+n[0]][j+n[1]]
+			}
+			b := (*boundary)[i][j]
+			var attach bool
+			switch {
+			case count >= k.neighbours():
+				attach = true
+			case count == k.neighbours()-1:
+				attach = b >= 1 || (around < k.Theta && b >= k.Alpha)
+			default:
+				attach = b >= k.Beta
+			}
+			if attach {
+				attaching = append(attaching, [2]int{i, j})
+			}
+		}
+	}
+	for _, a := range attaching {
+		i, j := a[0], a[1]
+		(*crystal)[i][j] += (*boundary)[i][j]
+		(*boundary)[i][j] = 0
+		(*coldness_matrix)[i][j] = 1 + (*crystal)[i][j]
+	}
+
+	// melting, and the coldness of everything that isn't ice shows its vapor
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if (*mask_matrix)[i][j] == out_of_bound || frozen(i, j) {
+				continue
+			}
+			if boundary_at(i, j) {
+				(*vapor)[i][j] += k.Mu*(*boundary)[i][j] + k.Gamma*(*crystal)[i][j]
+				(*boundary)[i][j] *= 1 - k.Mu
+				(*crystal)[i][j] *= 1 - k.Gamma
+			}
+			(*coldness_matrix)[i][j] = math.Min((*vapor)[i][j], below_ice)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// note:
+// Layers put several results into one image, so a composite crystal can be built from simple runs, like
+// a plate with a dendrite on top of it. Every layer is a state, rendered like snow render does, or a
+// result that was saved before. They are blended in gray from the first to the last, each one centered
+// on the biggest, and the theme colors the composite at the end, so colored results count by their
+// brightness:
+//
+//   screen    1 - (1-a)(1-b), brighter where they overlap but never above white, the default
+//   add       a + b, overlapping parts burn out to white
+//   lighten   the brighter of the two
+//
+// The opacity mixes the blended value with what was under the layer, the first layer is blended onto
+// black.
+//
+// A depth puts the layer further away, for scenes that look photographed: it gets smaller by 1/(1+depth)
+// and blurred with a disc like the bokeh of a lens, more the further it is from -focus. The depth is
+// made up, nothing is sorted by it, far layers go first. An offset moves the middle of the layer away from
+// the middle of the composite by x,y pixels, so the flakes of a scene don't all sit on top of each other,
+// what leaves the composite is cut off.
+
+var blend_modes = map[string]func(under, over float64) float64{
+	"screen":  func(a, b float64) float64 { return 1 - (1-a)*(1-b) },
+	"add":     func(a, b float64) float64 { return math.Min(1, a+b) },
+	"lighten": math.Max,
+}
+
+func blend_names() string {
+	var names []string
+	for name := range blend_modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+type layer struct {
+	filename string
+	opacity  float64
+	mode     string
+	depth    float64
+	offset   image.Point
+}
+
+// file[:opacity[:mode[:depth[:x,y]]]], the opacity is 1, the mode screen, the depth 0 and the offset 0,0
+// when they are left out
+func parse_layer(s string) (layer, error) {
+	l := layer{s, 1, "screen", 0, image.Point{}}
+	parts := strings.Split(s, ":")
+	if len(parts) > 5 {
+		return l, fmt.Errorf("expected file[:opacity[:mode[:depth[:x,y]]]] but got %q", s)
+	}
+	l.filename = parts[0]
+	if len(parts) > 1 && parts[1] != "" {
+		opacity, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || opacity < 0 || opacity > 1 {
+			return l, fmt.Errorf("the opacity of %q must be from 0 to 1", s)
+		}
+		l.opacity = opacity
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		if _, ok := blend_modes[parts[2]]; !ok {
+			return l, fmt.Errorf("unknown blend mode %q, use %s", parts[2], blend_names())
+		}
+		l.mode = parts[2]
+	}
+	if len(parts) > 3 && parts[3] != "" {
+		depth, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil || depth < 0 {
+			return l, fmt.Errorf("the depth of %q must be 0 or more", s)
+		}
+		l.depth = depth
+	}
+	if len(parts) > 4 {
+		x, y, err := parse_pair(parts[4])
+		if err != nil {
+			return l, fmt.Errorf("bad offset of %q: %v", s, err)
+		}
+		l.offset = image.Pt(x, y)
+	}
+	return l, nil
+}
+
+// the gray image of a state, a pfm or a saved result
+func load_layer(filename string) (image.Image, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".snow") {
+		state, err := load_state(filename)
+		if err != nil {
+			return nil, err
+		}
+		values := state.values()
+		return render_settings(state.Settings, &values), nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if strings.EqualFold(filepath.Ext(filename), ".pfm") {
+		values, err := read_pfm(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return render(&values), nil
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return img, nil
+}
+
+// the brightness of the pixels from 0 to 1, row by row
+type gray_values struct {
+	width, height int
+	values        []float64
+}
+
+// the layer at its depth, smaller and blurred more the further it is from the focus
+func layer_values(img image.Image, depth, focus, aperture float64) gray_values {
+	if depth > 0 {
+		scale := 1 / (1 + depth)
+		width := int(math.Max(1, math.Round(float64(img.Bounds().Dx())*scale)))
+		height := int(math.Max(1, math.Round(float64(img.Bounds().Dy())*scale)))
+		img = transform.Resize(img, width, height, transform.Linear)
+	}
+	bounds := img.Bounds()
+	g := gray_values{bounds.Dx(), bounds.Dy(), make([]float64, bounds.Dx()*bounds.Dy())}
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			g.values[y*g.width+x] = float64(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y) / 255
+		}
+	}
+	return disc_blur(g, aperture*math.Abs(depth-focus))
+}
+
+// the mean of the pixels in a disc with the radius around every pixel, it keeps the edges of bright
+// spots round like a lens does, where the disc leaves the image only the pixels inside count
+func disc_blur(g gray_values, radius float64) gray_values {
+	r := int(radius)
+	if r < 1 {
+		return g
+	}
+	// sums of every row up to x, so a span of a row is one subtraction
+	sums := make([]float64, (g.width+1)*g.height)
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			sums[y*(g.width+1)+x+1] = sums[y*(g.width+1)+x] + g.values[y*g.width+x]
+		}
+	}
+	spans := make([]int, r+1)
+	for dy := range spans {
+		spans[dy] = int(math.Sqrt(radius*radius - float64(dy*dy)))
+	}
+	out := gray_values{g.width, g.height, make([]float64, len(g.values))}
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			sum, count := 0.0, 0
+			for dy := -r; dy <= r; dy++ {
+				row := y + dy
+				if row < 0 || row >= g.height {
+					continue
+				}
+				span := spans[int(math.Abs(float64(dy)))]
+				left, right := x-span, x+span+1
+				if left < 0 {
+					left = 0
+				}
+				if right > g.width {
+					right = g.width
+				}
+				sum += sums[row*(g.width+1)+right] - sums[row*(g.width+1)+left]
+				count += right - left
+			}
+			out.values[y*g.width+x] = sum / float64(count)
+		}
+	}
+	return out
+}
+
+// the layers blended from the first to the last, as big as the biggest
+func blend_layers(layers []layer, images []image.Image, focus, aperture float64) *image.Gray {
+	all := make([]gray_values, len(images))
+	width, height := 0, 0
+	for k, img := range images {
+		all[k] = layer_values(img, layers[k].depth, focus, aperture)
+		if all[k].width > width {
+			width = all[k].width
+		}
+		if all[k].height > height {
+			height = all[k].height
+		}
+	}
+	values := make([]float64, width*height)
+	for k, g := range all {
+		blend := blend_modes[layers[k].mode]
+		// centered, odd differences put the extra pixel on the right and at the bottom
+		left, top := (width-g.width)/2+layers[k].offset.X, (height-g.height)/2+layers[k].offset.Y
+		for y := 0; y < g.height; y++ {
+			for x := 0; x < g.width; x++ {
+				if left+x < 0 || left+x >= width || top+y < 0 || top+y >= height {
+					continue
+				}
+				over := g.values[y*g.width+x]
+				under := &values[(top+y)*width+left+x]
+				*under += layers[k].opacity * (blend(*under, over) - *under)
+			}
+		}
+	}
+	out := image.NewGray(image.Rect(0, 0, width, height))
+	for p, v := range values {
+		out.Pix[p] = uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+	return out
+}
+
+// snow layer [-out composite.png] a.snow b.snow:0.6:add far.snow:1:screen:3 ...
+func layer_command(args []string) {
+	flags := flag.NewFlagSet("layer", flag.ExitOnError)
+	out := flags.String("out", "snowflakes/layers.png", "output file, - streams the png to stdout")
+	theme_name := flags.String("theme", "", "background and colors of the composite: "+theme_names())
+	palette_name := flags.String("palette", "", "colors of the composite, on top of the theme")
+	focus := flags.Float64("focus", 0, "depth of the layers that are sharp")
+	aperture := flags.Float64("aperture", 4, "pixels of blur for every step of depth away from -focus")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow layer [flags] file[:opacity[:mode[:depth[:x,y]]]]...\n\n")
+		fmt.Fprintf(flags.Output(), "the files are states, pfm files or results, the modes are %s (default screen)\n", blend_names())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 || *focus < 0 || *aperture < 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	t, err := pick_theme(*theme_name, *palette_name, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	layers := make([]layer, flags.NArg())
+	for k, arg := range flags.Args() {
+		if layers[k], err = parse_layer(arg); err != nil {
+			fmt.Fprintln(os.Stderr, "bad layer:", err)
+			os.Exit(2)
+		}
+	}
+	images := make([]image.Image, len(layers))
+	for k, l := range layers {
+		if images[k], err = load_layer(l.filename); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	composite := colorize(blend_layers(layers, images, *focus, *aperture), t)
+	metadata := map[string]string{
+		"Software":    "procedural-snowflakes",
+		"Description": "snowflake layers " + strings.Join(flags.Args(), " "),
+	}
+	if err := save_image(*out, composite, metadata); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save result:", err)
+		os.Exit(1)
+	}
+	if *out != "-" {
+		fmt.Printf("blended %d layers:\t %s\n", len(layers), *out)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// note:
+// -live growing.png renders the flake to the same file every -live-every iterations while it grows, and
+// once more when it's done, so an image viewer or a web page pointed at the file shows the growth as
+// it runs. The frames are rendered like a result without flags, in the colors of -palette or -theme.
+// Every frame is written next to the file first and then renamed over it, so whatever reads the file
+// never gets half an image, and a viewer that watches the file sees one change per frame. Rendering
+// takes about as long as a few iterations, so frames far apart don't slow the simulation down.
+
+// the state rendered like the frames of -live
+func live_image(state *State, colors theme, colorized bool) image.Image {
+	values := state.values()
+	img := render_settings(state.Settings, &values)
+	if colorized {
+		img = colorize(img, colors)
+	}
+	return img
+}
+
+// renders the state and replaces the file with it
+func save_live(filename string, state *State, colors theme, colorized bool) error {
+	return replace_image(filename, live_image(state, colors, colorized), state.metadata())
+}
+
+// writes the image next to the file and renames it over the file
+func replace_image(filename string, img image.Image, metadata map[string]string) error {
+	ext := filepath.Ext(filename)
+	temporary := strings.TrimSuffix(filename, ext) + ".tmp" + ext
+	defer os.Remove(temporary)
+	if err := save_image(temporary, img, metadata); err != nil {
+		return err
+	}
+	return os.Rename(temporary, filename)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// note:
+// -palette also takes a file, so colors graded somewhere else come out the same here:
+//
+//   .cube      a 1D or 3D LUT like Resolve and Photoshop export them, the gray of the flake goes in as
+//              r = g = b and comes out graded, the 3D ones are interpolated trilinearly like they do
+//   .png .jpg  a picture, its main colors from dark to light like -palette-from
+//   anything   a gradient, a color like #4ea8de on every line, spread evenly or at the position in front
+//   else       of it from 0 to 1 like "0.25 #4ea8de", lines starting with "# " are comments
+//
+// The rendered flake is 8 bit gray, so the file is sampled at the 256 grays once into the stops of a
+// palette and the palette gives every gray exactly the color of the file.
+
+const lut_stops = 256
+
+// a palette of the built in ones or from a file
+func find_palette(name string) (palette, error) {
+	if p, ok := palettes[name]; ok {
+		return p, nil
+	}
+	if strings.EqualFold(filepath.Ext(name), ".cube") {
+		return load_cube(name)
+	}
+	if is_picture(name) {
+		return extract_palette(name)
+	}
+	if _, err := os.Stat(name); err == nil {
+		return load_gradient(name)
+	}
+	return palette{}, fmt.Errorf("unknown -palette %q, use %s, a .cube or gradient file or a picture", name, palette_names())
+}
+
+// the palette of a function from every gray from 0 to 1 to a color
+func sampled_palette(at func(t float64) [3]float64) palette {
+	stops := make([]color.RGBA, lut_stops)
+	for k := range stops {
+		c := at(float64(k) / (lut_stops - 1))
+		channel := func(v float64) uint8 { return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255)) }
+		stops[k] = color.RGBA{channel(c[0]), channel(c[1]), channel(c[2]), 255}
+	}
+	return palette{stops, false}
+}
+
+func load_cube(filename string) (palette, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return palette{}, err
+	}
+	defer file.Close()
+
+	size, dimensions := 0, 0
+	low, high := [3]float64{0, 0, 0}, [3]float64{1, 1, 1}
+	var table [][3]float64
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		bad := func(err error) (palette, error) {
+			return palette{}, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		switch fields[0] {
+		case "TITLE":
+			continue
+		case "LUT_1D_SIZE", "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return bad(fmt.Errorf("%s needs a size", fields[0]))
+			}
+			if size, err = strconv.Atoi(fields[1]); err != nil || size < 2 {
+				return bad(fmt.Errorf("bad size %q", fields[1]))
+			}
+			dimensions = 1
+			if fields[0] == "LUT_3D_SIZE" {
+				dimensions = 3
+			}
+			continue
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, err := cube_triple(fields[1:])
+			if err != nil {
+				return bad(err)
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				low = v
+			} else {
+				high = v
+			}
+			continue
+		}
+		v, err := cube_triple(fields)
+		if err != nil {
+			return bad(err)
+		}
+		table = append(table, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return palette{}, err
+	}
+	switch {
+	case dimensions == 0:
+		return palette{}, fmt.Errorf("%s has no LUT_1D_SIZE or LUT_3D_SIZE", filename)
+	case dimensions == 1 && len(table) != size, dimensions == 3 && len(table) != size*size*size:
+		return palette{}, fmt.Errorf("%s has %d entries, its size needs %d", filename, len(table), int(math.Pow(float64(size), float64(dimensions))))
+	}
+
+	// where the gray is in the table along every channel, with the domain
+	position := func(t float64, k int) (int, float64) {
+		x := (t - low[k]) / (high[k] - low[k]) * float64(size-1)
+		x = math.Max(0, math.Min(float64(size-1), x))
+		n := int(math.Min(math.Floor(x), float64(size-2)))
+		return n, x - float64(n)
+	}
+	if dimensions == 1 {
+		return sampled_palette(func(t float64) [3]float64 {
+			var c [3]float64
+			for k := range c {
+				n, f := position(t, k)
+				c[k] = table[n][k]*(1-f) + table[n+1][k]*f
+			}
+			return c
+		}), nil
+	}
+	return sampled_palette(func(t float64) [3]float64 {
+		r, fr := position(t, 0)
+		g, fg := position(t, 1)
+		b, fb := position(t, 2)
+		// red changes fastest in the table
+		var c [3]float64
+		for corner := 0; corner < 8; corner++ {
+			dr, dg, db := corner&1, corner>>1&1, corner>>2&1
+			weight := lerp_weight(fr, dr) * lerp_weight(fg, dg) * lerp_weight(fb, db)
+			entry := table[(r+dr)+(g+dg)*size+(b+db)*size*size]
+			for k := range c {
+				c[k] += weight * entry[k]
+			}
+		}
+		return c
+	}), nil
+}
+
+// the weight of the lower (0) or upper (1) end of a linear interpolation at f
+func lerp_weight(f float64, end int) float64 {
+	if end == 0 {
+		return 1 - f
+	}
+	return f
+}
+
+func cube_triple(fields []string) ([3]float64, error) {
+	var v [3]float64
+	if len(fields) != 3 {
+		return v, fmt.Errorf("expected 3 numbers but got %q", strings.Join(fields, " "))
+	}
+	for k, field := range fields {
+		var err error
+		if v[k], err = strconv.ParseFloat(field, 64); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+func load_gradient(filename string) (palette, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return palette{}, err
+	}
+	var positions []float64
+	var colors []color.RGBA
+	placed := 0
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return palette{}, fmt.Errorf("%s:%d: expected a color with a position in front or not but got %q", filename, n+1, line)
+		}
+		position := math.NaN()
+		if len(fields) == 2 {
+			if position, err = strconv.ParseFloat(fields[0], 64); err != nil || position < 0 || position > 1 {
+				return palette{}, fmt.Errorf("%s:%d: the position %q isn't from 0 to 1", filename, n+1, fields[0])
+			}
+			placed++
+		}
+		c, err := parse_color(fields[len(fields)-1])
+		if err != nil {
+			return palette{}, fmt.Errorf("%s:%d: %v", filename, n+1, err)
+		}
+		positions, colors = append(positions, position), append(colors, c)
+	}
+	switch {
+	case len(colors) < 2:
+		return palette{}, fmt.Errorf("%s needs at least 2 colors", filename)
+	case placed != 0 && placed != len(colors):
+		return palette{}, fmt.Errorf("%s has positions for some colors and not for others", filename)
+	}
+	for k := range positions {
+		if placed == 0 {
+			positions[k] = float64(k) / float64(len(colors)-1)
+		}
+		if k > 0 && positions[k] < positions[k-1] {
+			return palette{}, fmt.Errorf("%s: the positions have to go up", filename)
+		}
+	}
+	return sampled_palette(func(t float64) [3]float64 {
+		k := 0
+		for k < len(positions)-2 && t > positions[k+1] {
+			k++
+		}
+		// before the first and after the last position the color stays
+		f := 0.0
+		if span := positions[k+1] - positions[k]; span > 0 {
+			f = math.Max(0, math.Min(1, (t-positions[k])/span))
+		} else if t >= positions[k+1] {
+			f = 1
+		}
+		a, b := colors[k], colors[k+1]
+		mixed := func(x, y uint8) float64 { return (float64(x)*(1-f) + float64(y)*f) / 255 }
+		return [3]float64{mixed(a.R, b.R), mixed(a.G, b.G), mixed(a.B, b.B)}
+	}), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// note:
+// -render mask is the flake in pure black and white for stencils, plotters and embroidery, white where
+// the value is at least -mask-threshold (1 by default, everything frozen) and black everywhere else.
+// A lower threshold takes in the vapor next to the ice, so the shape gets fatter and its gaps close, a
+// higher one keeps only the thicker ice in the middle of the arms and thins it out.
+//
+// The hexagons above the threshold are rendered like the result first, so -size, -resample, -rotate and
+// -roi give the mask smooth edges, and then every pixel past one half is white. That way the edge is
+// where the rendered gray would cross one half, between the hexagons instead of a staircase of them.
+
+// the pixels of the rendered matrix where the value is at least threshold in white, the rest in black
+func render_mask(values Matrix, draw func(*Matrix) image.Image, threshold float64) image.Image {
+	above := new_matrix_like(values)
+	for i := range values {
+		for j, v := range values[i] {
+			if v >= threshold {
+				above[i][j] = 1
+			}
+		}
+	}
+	rendered := draw(&above)
+	bounds := rendered.Bounds()
+	out := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if color.GrayModel.Convert(rendered.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y >= 128 {
+				out.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return out
+}
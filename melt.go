@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// note:
+// snow melt flake.snow runs the growth backwards, the ice of a grown flake sublimates into the air
+// around it. Every iteration a frozen hexagon loses rate times the square of the part of its neighbours
+// that is vapor, so the tips of the arms, which stick out into the vapor on 4 or 5 sides, go first and
+// the flat sides of a plate with 1 or 2 last:
+//
+//   exposed sides   1      2      3      4      5
+//   of rate         0.03   0.11   0.25   0.44   0.69
+//
+// A hexagon is ice until its value drops below 1, then it turns into vapor as humid as the vapor next to
+// it and its neighbours are exposed. The flake shrinks from the outside in, the arms thin into needles
+// before they break off and the thick middle is left last. The vapor that comes off is carried away,
+// the air around the flake stays as it was: kept, it would gather next to the ice in a haze brighter
+// than the flake. Nothing freezes while it melts.
+//
+// The half melted flake is saved as an image, and -animation saves the melting as a gif like the
+// animations of the growth, that plays from the grown flake to what is left of it.
+
+var melt_directions = [6][2]int{{1, 0}, {1, -1}, {0, -1}, {-1, 0}, {-1, 1}, {0, 1}}
+
+type melt struct {
+	values Matrix
+	ice    [][]bool
+	inside [][]bool
+	rate   float64
+}
+
+func new_melt(state *State, rate float64) *melt {
+	values := state.values()
+	width, height := len(values), len(values[0])
+	m := &melt{values: values, rate: rate}
+	m.ice, m.inside = make([][]bool, width), make([][]bool, width)
+	for i := range values {
+		m.ice[i], m.inside[i] = make([]bool, height), make([]bool, height)
+		for j, v := range values[i] {
+			mask := state.Mask[i][j]
+			m.inside[i][j] = mask != out_of_bound && mask != obstacle
+			m.ice[i][j] = m.inside[i][j] && v >= 1
+		}
+	}
+	return m
+}
+
+// tells if i, j is vapor inside the border
+func (m *melt) vapor(i, j int) bool {
+	return i >= 0 && i < len(m.values) && j >= 0 && j < len(m.values[0]) && m.inside[i][j] && !m.ice[i][j]
+}
+
+// one iteration of melting, every hexagon melts by the ice of the iteration before
+func (m *melt) step() {
+	type change struct {
+		i, j  int
+		value float64
+	}
+	var changes []change
+	for i := range m.values {
+		for j := range m.values[i] {
+			if !m.ice[i][j] {
+				continue
+			}
+			exposed, humidity := 0, 0.0
+			for _, d := range melt_directions {
+				if m.vapor(i+d[0], j+d[1]) {
+					exposed++
+					humidity += m.values[i+d[0]][j+d[1]]
+				}
+			}
+			if exposed == 0 {
+				continue
+			}
+			v := m.values[i][j] - m.rate*float64(exposed*exposed)/36
+			if v < 1 {
+				v = humidity / float64(exposed)
+			}
+			changes = append(changes, change{i, j, v})
+		}
+	}
+	for _, c := range changes {
+		m.values[c.i][c.j] = c.value
+		m.ice[c.i][c.j] = c.value >= 1
+	}
+}
+
+// the frozen hexagons that are left
+func (m *melt) frozen() int {
+	frozen := 0
+	for i := range m.ice {
+		for _, ice := range m.ice[i] {
+			if ice {
+				frozen++
+			}
+		}
+	}
+	return frozen
+}
+
+func melt_command(args []string) {
+	flags := flag.NewFlagSet("melt", flag.ExitOnError)
+	n := flags.Int("n", 500, "iterations of melting")
+	rate := flags.Float64("rate", 0.02, "how fast the ice sublimates, what a hexagon with vapor all around it loses every iteration")
+	out := flags.String("out", "", "output file, - streams the png to stdout (default is the state file name with -melt-<n>.png)")
+	palette_name := flags.String("palette", "", "colors of the result and the animation: "+palette_names()+", or a file")
+	animation_file := flags.String("animation", "", "also save the melting as an animated gif to this file")
+	animation_every := flags.Int64("animation-every", 10, "iterations between the frames of -animation")
+	fps := flags.Float64("fps", 25, "frames a second of -animation, at most 50")
+	animation_duration := flags.Duration("animation-duration", 0, "how long -animation plays, instead of -fps")
+	hold_last := flags.Duration("hold-last", 0, "keep what is left of the flake up this much longer at the end of -animation")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow melt [flags] flake.snow\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *n < 0 || *rate <= 0 || *animation_every < 1 {
+		fmt.Fprintln(os.Stderr, "-n can't be less than 0, -rate must be more than 0 and -animation-every at least 1")
+		os.Exit(2)
+	}
+	if *animation_file != "" && strings.ToLower(filepath.Ext(*animation_file)) != ".gif" {
+		fmt.Fprintln(os.Stderr, "animations are saved as gif (.gif)")
+		os.Exit(2)
+	}
+	colors, err := pick_theme("", *palette_name, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	state, err := load_state(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if state.Settings.Tile {
+		fmt.Fprintln(os.Stderr, "tiles can't melt")
+		os.Exit(2)
+	}
+
+	// keep stdout clean for the image when streaming
+	console := io.Writer(os.Stdout)
+	if *out == "-" {
+		console = os.Stderr
+	}
+	m := new_melt(state, *rate)
+	var anim *animation
+	if *animation_file != "" {
+		anim = new_animation(*animation_every, 0, 0, false)
+		defer anim.finish()
+		if err := anim.timing(*fps, *animation_duration, *hold_last); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	frame := func() {
+		anim.push(Settings{}, m.values)
+	}
+	before := m.frozen()
+	for k := 0; k <= *n; k++ {
+		if k > 0 {
+			m.step()
+		}
+		if anim != nil && (int64(k)%anim.every == 0 || k == *n) {
+			frame()
+		}
+		fmt.Fprintf(console, "\rmelting:\t %d / %d", k, *n)
+	}
+	fmt.Fprintf(console, "\nice left:\t %d of %d hexagons\n", m.frozen(), before)
+
+	filename := *out
+	if filename == "" {
+		filename = fmt.Sprintf("%s-melt-%d.png", strings.TrimSuffix(flags.Arg(0), filepath.Ext(flags.Arg(0))), *n)
+	}
+	img := render(&m.values)
+	if *palette_name != "" {
+		img = colorize(img, colors)
+	}
+	metadata := state.metadata()
+	metadata["Melted"] = fmt.Sprintf("%d iterations at a rate of %g", *n, *rate)
+	if err := save_image(filename, img, metadata); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save result:", err)
+		os.Exit(1)
+	}
+	if filename != "-" {
+		fmt.Fprintln(console, "saved result:\t", filename)
+	}
+	if anim != nil {
+		if err := anim.save(*animation_file, colors); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save animation:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(console, "saved animation:\t", *animation_file)
+		fmt.Fprintln(console, "backpressure:\t", anim.backpressure())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// note:
+// Huge matrices don't have to fit in memory. With -mmap the coldness matrices and the mask are backed by
+// memory mapped files and the kernel pages them in and out as the simulation sweeps over the rows.
+// The rows are visited in order so the access pattern is friendly to the page cache.
+
+// moves the matrices of the state into memory mapped files in dir
+func map_state(state *State, dir string) error {
+	size, height := state.Settings.Size, state.Settings.height()
+
+	switch {
+	case state.Settings.Fixed:
+		coldness, temp, err := mapped_matrices[Fixed](state, dir, size, height)
+		if err != nil {
+			return err
+		}
+		copy_grid(coldness, state.ColdnessFixed)
+		state.ColdnessFixed, state.tempFixed = coldness, temp
+	case state.Settings.single_precision():
+		coldness, temp, err := mapped_matrices[float32](state, dir, size, height)
+		if err != nil {
+			return err
+		}
+		copy_grid(coldness, state.Coldness32)
+		state.Coldness32, state.temp32 = coldness, temp
+	default:
+		coldness, temp, err := mapped_matrices[float64](state, dir, size, height)
+		if err != nil {
+			return err
+		}
+		copy_grid(coldness, state.Coldness)
+		state.Coldness, state.temp = coldness, temp
+	}
+
+	data, unmap, err := map_bytes(dir, size*height)
+	if err != nil {
+		return fmt.Errorf("mapping mask: %v", err)
+	}
+	state.unmap = append(state.unmap, unmap)
+
+	mask := make(Mask, size)
+	for i := range mask {
+		mask[i] = data[i*height : (i+1)*height]
+	}
+
+	// resumed states already have values
+	for i := range state.Mask {
+		copy(mask[i], state.Mask[i])
+	}
+	state.Mask = mask
+	return nil
+}
+
+// maps the coldness matrix and the temp matrix
+func mapped_matrices[T Value](state *State, dir string, size, height int) (Grid[T], Grid[T], error) {
+	coldness, err := mapped_grid[T](state, dir, size, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	temp, err := mapped_grid[T](state, dir, size, height)
+	return coldness, temp, err
+}
+
+func mapped_grid[T Value](state *State, dir string, size, height int) (Grid[T], error) {
+	var zero T
+	data, unmap, err := map_bytes(dir, size*height*int(unsafe.Sizeof(zero)))
+	if err != nil {
+		return nil, fmt.Errorf("mapping matrix: %v", err)
+	}
+	state.unmap = append(state.unmap, unmap)
+
+	backing := unsafe.Slice((*T)(unsafe.Pointer(&data[0])), size*height)
+	grid := make(Grid[T], size)
+	for i := range grid {
+		grid[i] = backing[i*height : (i+1)*height]
+	}
+	return grid, nil
+}
+
+func copy_grid[T Value](to, from Grid[T]) {
+	for i := range from {
+		copy(to[i], from[i])
+	}
+}
+
+// unmaps the files of a mapped state, the matrices can't be used after this
+func (state *State) close() error {
+	var first error
+	for _, unmap := range state.unmap {
+		if err := unmap(); err != nil && first == nil {
+			first = err
+		}
+	}
+	state.unmap = nil
+	state.Coldness, state.Coldness32, state.ColdnessFixed, state.Mask = nil, nil, nil, nil
+	state.temp, state.temp32, state.tempFixed = nil, nil, nil
+	return first
+}
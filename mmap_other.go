@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "errors"
+
+func map_bytes(dir string, n int) ([]byte, func() error, error) {
+	return nil, nil, errors.New("memory mapped matrices are not supported on this platform")
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+// maps n bytes of a new file in dir into memory, the file is removed right away so the kernel
+// drops it when the mapping is gone
+func map_bytes(dir string, n int) ([]byte, func() error, error) {
+	file, err := ioutil.TempFile(dir, "snow-matrix-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	defer os.Remove(file.Name())
+
+	if err := file.Truncate(int64(n)); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// note:
+// How bright the background is depends on B and PM, from nearly black at B = 0.15 to a lighter gray at
+// B = 0.6, and everything frozen is the same white even though the ice goes on well above 1, so results
+// of different settings don't go together. -normalize puts the vapor and the ice in windows of the gray
+// that stay the same for every setting, with a straight line through each one:
+//
+//   vapor   from normalize_low to normalize_high of its hexagons, to black up to normalize_vapor
+//   ice     from 1 to normalize_high of its hexagons, to normalize_ice up to white
+//
+// The few darkest and brightest hexagons are left out of the range, so one stray hexagon doesn't decide
+// the contrast like it would with the minimum and the maximum. The gap between the windows keeps the
+// edge of the flake sharp. Only the hexagons inside the border count, the outside stays black.
+
+const (
+	normalize_low   = 0.01
+	normalize_high  = 0.99
+	normalize_vapor = 0.5
+	normalize_ice   = 0.6
+)
+
+// the values of the matrix stretched into the windows of the vapor and the ice
+func normalize(values Matrix, tile bool) Matrix {
+	width, height := len(values), len(values[0])
+	inside := func(i, j int) bool { return tile || in_arena(i, j, width, height) }
+	var vapor, ice []float64
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			switch {
+			case !inside(i, j):
+			case values[i][j] >= 1:
+				ice = append(ice, values[i][j])
+			default:
+				vapor = append(vapor, values[i][j])
+			}
+		}
+	}
+	// the straight line from the low percentile of the values (or low) to the high one
+	stretch := func(values []float64, low float64) func(v float64) float64 {
+		if len(values) == 0 {
+			return nil
+		}
+		sort.Float64s(values)
+		percentile := func(p float64) float64 { return values[int(math.Round(p*float64(len(values)-1)))] }
+		if math.IsNaN(low) {
+			low = percentile(normalize_low)
+		}
+		high := percentile(normalize_high)
+		return func(v float64) float64 {
+			if high <= low {
+				return 1
+			}
+			return math.Max(0, math.Min(1, (v-low)/(high-low)))
+		}
+	}
+	vapor_line, ice_line := stretch(vapor, math.NaN()), stretch(ice, 1)
+
+	normalized := new_matrix_like(values)
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			switch {
+			case !inside(i, j):
+			case values[i][j] >= 1:
+				normalized[i][j] = normalize_ice + (1-normalize_ice)*ice_line(values[i][j])
+			default:
+				normalized[i][j] = normalize_vapor * vapor_line(values[i][j])
+			}
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// note:
+// -notify-url posts a json message when a run or a whole batch is done, so a long batch over night can
+// ping a chat, a phone or some automation instead of somebody looking at the output folder:
+//
+//   snow -notify-url https://hooks.example.com/snow 1 0.33 0.0002 0.05 0.2 100000
+//   snow batch -notify-url https://hooks.example.com/snow < sweep.ndjson
+//
+// A run sends its settings, where the result went, the stats of batch results and the error if it
+// failed. A batch sends one message at the end with how many jobs there were, the jobs that failed with
+// their errors and where the others went, the results on stdout have the rest. Both have:
+//
+//   event      run or batch
+//   status     done or failed
+//   started    when it started and finished, in rfc 3339
+//   seconds    how long it took
+//   host       the machine it ran on
+//
+// A message that doesn't get through is tried twice more, after 1 and 5 seconds, unless the server says
+// the message is wrong (a 4xx other than 429). A notification that fails is a warning, it doesn't fail
+// the run that is done.
+
+// what -notify-url gets
+type notification struct {
+	Event    string    `json:"event"`
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Seconds  float64   `json:"seconds"`
+	Host     string    `json:"host,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// of a run
+	Settings *Settings `json:"settings,omitempty"`
+	Out      string    `json:"out,omitempty"`
+	Stats    *Stats    `json:"stats,omitempty"`
+
+	// of a batch
+	Jobs     int      `json:"jobs,omitempty"`
+	Failed   int      `json:"failed,omitempty"`
+	Outputs  []string `json:"outputs,omitempty"`
+	Failures []Result `json:"failures,omitempty"`
+}
+
+func new_notification(event string, started time.Time, err string) notification {
+	host, _ := os.Hostname()
+	status := "done"
+	if err != "" {
+		status = "failed"
+	}
+	finished := time.Now()
+	return notification{
+		Event: event, Status: status, Error: err, Host: host,
+		Started: started, Finished: finished, Seconds: finished.Sub(started).Seconds(),
+	}
+}
+
+var notify_waits = []time.Duration{time.Second, 5 * time.Second}
+
+// posts the notification to url, tried again when it doesn't get through
+func notify(url string, message notification) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	for attempt := 0; ; attempt++ {
+		retry := true
+		response, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			reply, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+			response.Body.Close()
+			if response.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("%s %s", response.Status, bytes.TrimSpace(reply))
+			retry = response.StatusCode/100 != 4 || response.StatusCode == http.StatusTooManyRequests
+		}
+		if !retry || attempt == len(notify_waits) {
+			return fmt.Errorf("notifying %s: %v", url, err)
+		}
+		time.Sleep(notify_waits[attempt])
+	}
+}
+
+// the notification of a single run, from the state it got to
+func notify_run(url string, state *State, backpressure *Backpressure, out string, started time.Time, err string) {
+	message := new_notification("run", started, err)
+	settings := state.Settings
+	message.Settings, message.Out = &settings, out
+	message.Stats = &Stats{
+		Iterations:   state.Iteration + 1,
+		Frozen:       state.frozen(),
+		Truncated:    state.truncated(),
+		Seconds:      message.Seconds,
+		Timings:      state.timings,
+		Backpressure: backpressure,
+	}
+	if err := notify(url, message); err != nil {
+		fmt.Fprintln(os.Stderr, "warning:\t", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// note:
+// -obstacles stencil.png puts shapes in the way of the flake, the white parts of the image (gray above
+// one half) become obstacles and the flake has to grow around them. The image is stretched over the
+// result, so a stencil of the same size as the result lines up pixel for pixel, and it can be a .snow
+// state like the layers, to grow a flake around another one.
+//
+// An obstacle is a hexagon of its own kind on the mask, it never turns receptive and never freezes. In
+// the step it is left out like the hexagons outside of the border, then bounce_obstacles hands the
+// vapor its neighbours gave it back to them, so an obstacle is a wall and doesn't soak up vapor like the
+// border does. Its value stays 0, it is rendered with the darkest color of the palette like the outside
+// of the border, so the shape shows in the result too. Only the reiter solver and the steps with its
+// sums have obstacles, kahan summation and the weights of the directions, on a grid that doesn't grow.
+
+// the hexagons of the stencil inside the border, the outside of the border can't grow anyway
+func stencil_cells(filename string, width, height int) ([][2]int, error) {
+	img, err := load_layer(filename)
+	if err != nil {
+		return nil, err
+	}
+	return image_cells(img, width, height), nil
+}
+
+// the hexagons inside the border where the image stretched over the result is brighter than one half
+func image_cells(img image.Image, width, height int) [][2]int {
+	values := image_values(img, width, height)
+	var cells [][2]int
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			if in_arena(i, j, width, height) && values[i][j] > 0.5 {
+				cells = append(cells, [2]int{i, j})
+			}
+		}
+	}
+	return cells
+}
+
+// marks the obstacles of the stencil on the mask, called on the initial matrices, checked by settings.check()
+func place_obstacles[T Value](filename string, coldness_matrix *Grid[T], mask_matrix *Mask) {
+	cells, _ := stencil_cells(filename, len(*coldness_matrix), len((*coldness_matrix)[0]))
+	for _, cell := range cells {
+		(*mask_matrix)[cell[0]][cell[1]] = obstacle
+		(*coldness_matrix)[cell[0]][cell[1]] = 0
+	}
+}
+
+// the obstacles on the mask
+func obstacle_cells(mask_matrix *Mask) [][2]int {
+	var cells [][2]int
+	for i := range *mask_matrix {
+		for j, m := range (*mask_matrix)[i] {
+			if m == obstacle {
+				cells = append(cells, [2]int{i, j})
+			}
+		}
+	}
+	return cells
+}
+
+// gives the vapor the obstacles got in a step back to the neighbours it came from, called after the
+// step with the coldness before it in previous. weights are the ones of step_anisotropic, in the same
+// order
+func bounce_obstacles[T Real](A float64, weights [6]float64, obstacles [][2]int, coldness_matrix, previous_matrix *Grid[T], mask_matrix *Mask) {
+	directions := [6][2]int{{1, 0}, {1, -1}, {0, -1}, {-1, 0}, {-1, 1}, {0, 1}}
+	for _, cell := range obstacles {
+		i, j := cell[0], cell[1]
+		for k, d := range directions {
+			// the neighbour that way handed vapor back the opposite way
+			x, y := i+d[0], j+d[1]
+			if (*mask_matrix)[x][y] != non_receptive {
+				continue
+			}
+			(*coldness_matrix)[x][y] += T(A) * (*previous_matrix)[x][y] / 12.0 * T(weights[(k+3)%6])
+		}
+		// the step may have marked it receptive next to the ice
+		(*mask_matrix)[i][j] = obstacle
+		(*coldness_matrix)[i][j] = 0
+	}
+}
+
+// the obstacles of the settings can be read and leave room for the seed
+func (settings Settings) check_obstacles() error {
+	cells, err := stencil_cells(settings.Obstacles, settings.Size, settings.height())
+	if err != nil {
+		return fmt.Errorf("bad obstacles: %v", err)
+	}
+	x, y := settings.seed_pos()
+	for _, cell := range cells {
+		if cell == [2]int{x, y} {
+			return fmt.Errorf("the seed %d,%d is on an obstacle", x, y)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+	"strings"
+)
+
+// note:
+// A palette maps the brightness of the rendered flake (0 is the background, 1 is frozen) to a color by
+// going through evenly spaced stops. The safe palettes keep every step of the brightness apart under
+// protanopia, deuteranopia and tritanopia, -palette-check shows how they (or any other) look then.
+//
+// Color vision deficiencies are simulated with the matrices of Machado, Oliveira and Fernandes (2009)
+// at full severity, applied to linear rgb. Two steps count as apart when their CIE Lab distance is at
+// least palette_distinct, a bit more than what most people can just tell apart, and the lightness has to
+// go up through the whole palette.
+
+type palette struct {
+	stops []color.RGBA
+	safe  bool // keeps its steps apart for all color vision deficiencies
+}
+
+const palette_distinct = 3.0
+
+var palettes = map[string]palette{
+	"gray":    {hex_stops("#000000", "#ffffff"), true},
+	"viridis": {hex_stops("#440154", "#472d7b", "#3b528b", "#2c728e", "#21918c", "#28ae80", "#5ec962", "#addc30", "#fde725"), true},
+	"cividis": {hex_stops("#00224e", "#123570", "#3b496c", "#575d6d", "#707173", "#8a8779", "#a69d75", "#c4b56c", "#e4cf5b", "#fee838"), true},
+	"inferno": {hex_stops("#000004", "#1b0c41", "#4a0c6b", "#781c6d", "#a52c60", "#cf4446", "#ed6925", "#fb9b06", "#f7d13d", "#fcffa4"), true},
+	"ice":     {hex_stops("#000814", "#003566", "#4ea8de", "#caf0f8", "#ffffff"), false},
+	"fire":    {hex_stops("#000000", "#6a040f", "#d00000", "#f48c06", "#ffba08"), false},
+	"aurora":  {hex_stops("#0b0c2a", "#1b998b", "#2ec4b6", "#c5f277", "#ff5e5b"), false},
+}
+
+func hex_stops(colors ...string) []color.RGBA {
+	stops := make([]color.RGBA, len(colors))
+	for k, c := range colors {
+		stops[k], _ = parse_color(c)
+	}
+	return stops
+}
+
+func palette_names() string {
+	names := make([]string, 0, len(palettes))
+	for name, p := range palettes {
+		if p.safe {
+			name += " (safe)"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// the color at t from 0 to 1
+func (p palette) at(t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t)) * float64(len(p.stops)-1)
+	k := int(math.Min(math.Floor(t), float64(len(p.stops)-2)))
+	return mix(p.stops[k], p.stops[k+1], t-float64(k))
+}
+
+// the same palette starting from another background color
+func (p palette) with_background(background color.RGBA) palette {
+	stops := append([]color.RGBA{background}, p.stops[1:]...)
+	return palette{stops, false}
+}
+
+var color_visions = []struct {
+	name   string
+	matrix [3][3]float64
+}{
+	{"normal", [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}},
+	{"protanopia", [3][3]float64{{0.152286, 1.052583, -0.204868}, {0.114503, 0.786281, 0.099216}, {-0.003882, -0.048116, 1.051998}}},
+	{"deuteranopia", [3][3]float64{{0.367322, 0.860646, -0.227968}, {0.280085, 0.672501, 0.047413}, {-0.011820, 0.042940, 0.968881}}},
+	{"tritanopia", [3][3]float64{{1.255528, -0.076749, -0.178779}, {-0.078411, 0.930809, 0.147602}, {0.004733, 0.691367, 0.303900}}},
+}
+
+func srgb_to_linear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linear_to_srgb(c float64) uint8 {
+	c = math.Max(0, math.Min(1, c))
+	if c <= 0.0031308 {
+		c *= 12.92
+	} else {
+		c = 1.055*math.Pow(c, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(c * 255))
+}
+
+// how the color looks with a color vision deficiency
+func simulate_vision(c color.RGBA, matrix [3][3]float64) color.RGBA {
+	rgb := [3]float64{srgb_to_linear(c.R), srgb_to_linear(c.G), srgb_to_linear(c.B)}
+	var out [3]uint8
+	for k := range out {
+		out[k] = linear_to_srgb(matrix[k][0]*rgb[0] + matrix[k][1]*rgb[1] + matrix[k][2]*rgb[2])
+	}
+	return color.RGBA{out[0], out[1], out[2], 255}
+}
+
+// CIE Lab with a D65 white point
+func lab(c color.RGBA) [3]float64 {
+	r, g, b := srgb_to_linear(c.R), srgb_to_linear(c.G), srgb_to_linear(c.B)
+	xyz := [3]float64{
+		(0.4124*r + 0.3576*g + 0.1805*b) / 0.95047,
+		0.2126*r + 0.7152*g + 0.0722*b,
+		(0.0193*r + 0.1192*g + 0.9505*b) / 1.08883,
+	}
+	for k, v := range xyz {
+		if v > 0.008856 {
+			xyz[k] = math.Cbrt(v)
+		} else {
+			xyz[k] = 7.787*v + 16.0/116
+		}
+	}
+	return [3]float64{116*xyz[1] - 16, 500 * (xyz[0] - xyz[1]), 200 * (xyz[1] - xyz[2])}
+}
+
+// the smallest Lab distance between neighbouring steps of the palette with the color vision, and if
+// the lightness only goes up so brighter still means colder
+func palette_distance(p palette, matrix [3][3]float64, steps int) (float64, bool) {
+	smallest, ordered := math.Inf(1), true
+	last := lab(simulate_vision(p.at(0), matrix))
+	for k := 1; k < steps; k++ {
+		next := lab(simulate_vision(p.at(float64(k)/float64(steps-1)), matrix))
+		smallest = math.Min(smallest, math.Sqrt(
+			math.Pow(next[0]-last[0], 2)+math.Pow(next[1]-last[1], 2)+math.Pow(next[2]-last[2], 2)))
+		ordered = ordered && next[0] > last[0]
+		last = next
+	}
+	return smallest, ordered
+}
+
+// prints how far apart the steps of the palette stay and draws it for every color vision
+func palette_check(name string, p palette, console func(format string, a ...interface{})) image.Image {
+	const width, bar, steps = 768, 48, 16
+	_, label := text_size("", 1)
+	sheet := image.NewRGBA(image.Rect(0, 0, width, len(color_visions)*(bar+label+12)))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for row, vision := range color_visions {
+		distance, ordered := palette_distance(p, vision.matrix, steps)
+		verdict := "ok"
+		switch {
+		case distance < palette_distinct:
+			verdict = "steps look the same"
+		case !ordered:
+			verdict = "the lightness goes down again"
+		}
+		console("%-13s %-8s smallest step %5.1f\t %s\n", vision.name+":", name, distance, verdict)
+
+		top := row * (bar + label + 12)
+		draw_text(sheet, 4, top+4, fmt.Sprintf("%s, smallest step %.1f", vision.name, distance), 1, color.Black)
+		for x := 0; x < width; x++ {
+			c := simulate_vision(p.at(float64(x)/float64(width-1)), vision.matrix)
+			for y := top + label + 8; y < top+label+8+bar; y++ {
+				sheet.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return sheet
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// note:
+// The pbr maps are a texture set for a material, all rendered with the same transform as the
+// result so they line up pixel for pixel:
+//
+//   albedo    the result in the colors of the palette or theme
+//   height    coldness of the frozen hexagons, it keeps growing after they froze so the middle is the
+//             thickest, scaled so the thickest is white
+//   normal    tangent space normals from the height, opengl style (green is up) unless directx is set
+//   opacity   white where the flake is
+
+// how steep the normals get compared to the height
+const normal_strength = 4.0
+
+func pbr_maps(values Matrix, t theme, directx bool) map[string]image.Image {
+	height := thickness(values)
+	heights := render(&height)
+	bounds := heights.Bounds()
+	at := func(x, y int) float64 {
+		x = int(math.Max(0, math.Min(float64(bounds.Dx()-1), float64(x))))
+		y = int(math.Max(0, math.Min(float64(bounds.Dy()-1), float64(y))))
+		return float64(color.GrayModel.Convert(heights.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y) / 255
+	}
+
+	normal := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			// sobel
+			dx := (at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x-1, y) + at(x-1, y+1))
+			dy := (at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x, y-1) + at(x+1, y-1))
+			n := [3]float64{-dx * normal_strength, dy * normal_strength, 1}
+			if directx {
+				n[1] = -n[1]
+			}
+			length := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+			channel := func(v float64) uint8 { return uint8(math.Round((v/length + 1) / 2 * 255)) }
+			normal.SetRGBA(x, y, color.RGBA{channel(n[0]), channel(n[1]), channel(n[2]), 255})
+		}
+	}
+
+	opacity := frozen_layer(values, color.RGBA{255, 255, 255, 255})
+	opaque := image.NewGray(opacity.Bounds())
+	for k := range opaque.Pix {
+		opaque.Pix[k] = opacity.Pix[4*k+3]
+	}
+
+	return map[string]image.Image{
+		"albedo":  colorize(render(&values), t),
+		"height":  heights,
+		"normal":  normal,
+		"opacity": opaque,
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// note:
+// snow perform grows flakes without an end for live visuals, with Y, A and the palette played from an OSC
+// app or a MIDI controller while the flake grows (see control.go for the messages), or left alone. The
+// flake is rendered to -out every -every iterations and replaced like -live, streamed as video (see
+// video.go) or shown in -window, and when it reaches the border or a reseed comes in a new flake
+// starts from the seed with the parameters as they are then.
+//
+// The controls go from 0 to 1 like faders and knobs:
+//
+//   Y         from the low to the high end of -range-Y, logarithmic since Y spans decades
+//   A         from the low to the high end of -range-A
+//   palette   through the built in palettes in the order of their names, or an OSC string names one
+//
+// A new Y or A is taken at the next iteration, the ice that is there stays and only grows differently
+// from then on, so turning Y up while an arm grows gives it a wider tip. The background comes from the
+// same noise every time, a reseed gives a new flake with the same field.
+
+var perform_default_ranges = map[string]random_range{
+	"Y": {0.0005, 0.004},
+	"A": {0.995, 1.005},
+}
+
+// the value of a control in the range, logarithmic for a range of positive numbers
+func control_value(r random_range, t float64, logarithmic bool) float64 {
+	t = math.Max(0, math.Min(1, t))
+	if logarithmic && r.low > 0 {
+		return r.low * math.Pow(r.high/r.low, t)
+	}
+	return r.low + (r.high-r.low)*t
+}
+
+// snow perform [-osc :9000] [-midi /dev/midi1] [A B Y PP PM]
+func perform(args []string) {
+	flags := flag.NewFlagSet("perform", flag.ExitOnError)
+	osc := flags.String("osc", "", "udp address to take OSC messages on, like :9000")
+	midi := flags.String("midi", "", "raw MIDI device to read control changes and notes from, like /dev/midi1")
+	out := flags.String("out", "snowflakes/perform.png", "image the flake is rendered to, empty for none")
+	video := flags.String("video", "", "also stream raw rgb24 frames to this file, - is stdout (for ffmpeg)")
+	mjpeg := flags.String("mjpeg", "", "also serve the frames as motion jpeg over http on this address, like :8080")
+	fps := flags.Float64("fps", 25, "frames a second of -video and -mjpeg")
+	every := flags.Int64("every", 10, "iterations between renders")
+	random := flags.Bool("random", false, "every flake gets new random parameters like -random")
+	flake_length := flags.Int64("flake-length", 0, "iterations before the next flake starts, 0 grows every flake until it reaches the border")
+	fade := flags.Duration("fade", 0, "cross-fade of -video and -mjpeg from one flake to the next")
+	screensaver := flags.Bool("screensaver", false, "random flakes that fade into each other, on the fullscreen page /screen of -mjpeg (:8080 unless given) or in -window")
+	window := flags.Bool("window", false, "show the frames fullscreen in a window of their own, in a build with -tags gui")
+	size := flags.Int("size", 400, "matrix size")
+	palette_name := flags.String("palette", "ice", "palette to start with")
+	var m midi_map
+	flags.IntVar(&m.y, "cc-Y", 1, "MIDI control change number of Y")
+	flags.IntVar(&m.a, "cc-A", 2, "MIDI control change number of A")
+	flags.IntVar(&m.palette, "cc-palette", 3, "MIDI control change number of the palette")
+	ranges := map[string]random_range{}
+	for name, r := range perform_default_ranges {
+		name := name
+		ranges[name] = r
+		flags.Func("range-"+name, fmt.Sprintf("low:high %s is played in (default %g:%g)", name, r.low, r.high), func(s string) error {
+			r, err := parse_range(s)
+			ranges[name] = r
+			return err
+		})
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow perform [flags] [A B Y PP PM]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *window {
+		if err := windows_available(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *screensaver {
+		*random = true
+		if *fade == 0 {
+			*fade = 2 * time.Second
+		}
+		if *flake_length == 0 {
+			*flake_length = 4000
+		}
+		if *mjpeg == "" && !*window {
+			*mjpeg = ":8080"
+		}
+	}
+	settings := default_settings()
+	settings.B, settings.Y = 0.4, 0.001
+	if flags.NArg() != 0 && flags.NArg() != 5 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if flags.NArg() == 5 {
+		var parameters [5]float64
+		for k := range parameters {
+			var err error
+			if parameters[k], err = strconv.ParseFloat(flags.Arg(k), 64); err != nil {
+				fmt.Fprintln(os.Stderr, "bad parameter:", err)
+				os.Exit(2)
+			}
+		}
+		settings.A, settings.B, settings.Y, settings.PP, settings.PM = parameters[0], parameters[1], parameters[2], parameters[3], parameters[4]
+	}
+	settings.L, settings.Size = math.MaxInt64, *size
+	if err := settings.check(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *out == "-" || *every < 1 || *fps <= 0 || *flake_length < 0 || *fade < 0 {
+		fmt.Fprintln(os.Stderr, "the flake is rendered to a file (-video - streams to stdout), -every must be at least 1, -fps more than 0 and -flake-length and -fade can't be less than 0")
+		os.Exit(2)
+	}
+	if *flake_length > 0 {
+		settings.L = *flake_length
+	}
+	var names []string
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	colors, err := pick_theme("", *palette_name, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	current := *palette_name
+
+	controls := make(chan control, 64)
+	if *osc != "" {
+		conn, err := net.ListenPacket("udp", *osc)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to listen for OSC:", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+		fmt.Fprintln(os.Stderr, "osc:\t\t", conn.LocalAddr())
+		go func() {
+			buffer := make([]byte, 65536)
+			for {
+				n, _, err := conn.ReadFrom(buffer)
+				if err != nil {
+					return
+				}
+				received, err := parse_osc(buffer[:n])
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "\nbad osc message:", err)
+				}
+				for _, c := range received {
+					controls <- c
+				}
+			}
+		}()
+	}
+	if *midi != "" {
+		device, err := os.Open(*midi)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to open MIDI device:", err)
+			os.Exit(1)
+		}
+		defer device.Close()
+		fmt.Fprintln(os.Stderr, "midi:\t\t", *midi)
+		go func() {
+			if err := read_midi(device, m, controls); err != nil {
+				fmt.Fprintln(os.Stderr, "\nreading MIDI failed:", err)
+			}
+		}()
+	}
+	if dir := filepath.Dir(*out); *out != "" && dir != "." {
+		os.MkdirAll(dir, 0755)
+	}
+	var feed *frame_feed
+	if *video != "" || *mjpeg != "" || *window {
+		feed = &frame_feed{}
+		first := new_state(settings)
+		bounds := live_image(first, colors, true).Bounds()
+		first.close()
+		fmt.Fprintf(os.Stderr, "frames:\t\t %dx%d at %g fps\n", bounds.Dx(), bounds.Dy(), *fps)
+	}
+	if *video != "" {
+		w := io.Writer(os.Stdout)
+		if *video != "-" {
+			file, err := os.OpenFile(*video, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "failed to open -video:", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			w = file
+		}
+		go func() {
+			err := stream_raw(w, feed, *fps)
+			fmt.Fprintln(os.Stderr, "\nvideo stream ended:", err)
+			os.Exit(1)
+		}()
+	}
+	if *mjpeg != "" {
+		listener, err := net.Listen("tcp", *mjpeg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to serve motion jpeg:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "mjpeg:\t\t http://%s/, fullscreen on http://%s/screen\n", listener.Addr(), listener.Addr())
+		go func() {
+			fmt.Fprintln(os.Stderr, "\nmotion jpeg server failed:", serve_mjpeg(listener, feed, *fps))
+			os.Exit(1)
+		}()
+	}
+
+	// the flakes, one after the other without an end
+	grow := func() {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for flakes := 1; ; flakes++ {
+			if *random && (flakes > 1 || flags.NArg() == 0) {
+				random := random_settings(rng, random_ranges, *size, 0, settings.L)
+				settings.A, settings.B, settings.Y, settings.PP, settings.PM = random.A, random.B, random.Y, random.PP, random.PM
+				fmt.Fprintf(os.Stderr, "\nflake %d:\t %g %g %g %g %g\n", flakes, settings.A, settings.B, settings.Y, settings.PP, settings.PM)
+			}
+			if feed != nil && *fade > 0 && flakes > 1 {
+				feed.fade_out(*fade)
+			}
+			state := new_state(settings)
+			ctx, cancel := context.WithCancel(context.Background())
+			err := run_context(ctx, state, func(iteration int64) {
+				for drained := false; !drained; {
+					select {
+					case c := <-controls:
+						switch strings.ToLower(c.name) {
+						case "y":
+							settings.Y = control_value(ranges["Y"], c.value, true)
+							state.Settings.Y = settings.Y
+						case "a":
+							settings.A = control_value(ranges["A"], c.value, false)
+							state.Settings.A = settings.A
+						case "palette":
+							name := c.text
+							if name == "" {
+								name = names[int(math.Min(float64(len(names)-1), math.Max(0, c.value)*float64(len(names))))]
+							}
+							if t, err := pick_theme("", name, ""); err == nil {
+								colors, current = t, name
+							}
+						case "reseed":
+							cancel()
+						}
+					default:
+						drained = true
+					}
+				}
+				if state.Truncated >= 0 {
+					cancel()
+				}
+				if iteration%*every == 0 {
+					img := live_image(state, colors, true)
+					if feed != nil {
+						feed.set(img)
+					}
+					if *out != "" {
+						if err := replace_image(*out, img, state.metadata()); err != nil {
+							fmt.Fprintln(os.Stderr, "\nfailed to save:", err)
+						}
+					}
+				}
+				fmt.Fprintf(os.Stderr, "\rflake %d:\t %d, A=%.4f Y=%.5f %s   ", flakes, iteration, settings.A, settings.Y, current)
+			})
+			cancel()
+			if err != nil && err != context.Canceled {
+				fmt.Fprintln(os.Stderr, "\n"+err.Error())
+				os.Exit(1)
+			}
+		}
+	}
+	if *window {
+		// fyne needs the main goroutine for the window, the flakes grow next to it until it's closed
+		go grow()
+		if err := show_window(feed, *fps); err != nil {
+			fmt.Fprintln(os.Stderr, "\nthe window failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	grow()
+}
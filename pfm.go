@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// note:
+// -pfm also saves the matrix next to the result as a portable float map, the simplest format with floats
+// that image tools read (ImageMagick, GIMP, OpenCV, numpy in a few lines), for steps after the simulation
+// that shouldn't lose the values the 8 bit result rounds away. Like -raw there is a pixel for every
+// hexagon at i, j, and the three channels are:
+//
+//   red     the value of the hexagon, 1 and above is frozen, as a 32 bit float
+//   green   the mask, 0 receptive, 1 vapor, 2 outside of the border, 3 obstacle
+//   blue    the temperature with -heat, 0 without
+//
+// The file starts with "PF\n<width> <height>\n-1\n" and the floats follow little endian, the rows from
+// the bottom up like the format wants. The values are the ones of the simulation, before -kaleidoscope
+// and -mirror. 32 bits keep 7 digits, the 64 bit matrix of a state file (-checkpoint) is the one to
+// resume from, the pfm is for what comes after. Layers, obstacles and receptivity images can be pfm
+// files too, the red channel is rendered like the matrix of a state.
+
+func write_pfm(w io.Writer, state *State) error {
+	values := state.values()
+	width, height := len(values), len(values[0])
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "PF\n%d %d\n-1\n", width, height)
+	pixel := make([]float32, 3)
+	for j := height - 1; j >= 0; j-- {
+		for i := 0; i < width; i++ {
+			pixel[0], pixel[1], pixel[2] = float32(values[i][j]), float32(state.Mask[i][j]), 0
+			if state.Temperature != nil {
+				pixel[2] = float32(state.Temperature[i][j])
+			}
+			if err := binary.Write(out, binary.LittleEndian, pixel); err != nil {
+				return err
+			}
+		}
+	}
+	return out.Flush()
+}
+
+func save_pfm(filename string, state *State) error {
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	if err := write_pfm(file, state); err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
+
+// the matrix of the first channel of a pfm, pixel i, j is the value at i, j
+func read_pfm(r io.Reader) (Matrix, error) {
+	in := bufio.NewReader(r)
+	var kind string
+	var width, height int
+	var scale float64
+	if _, err := fmt.Fscan(in, &kind, &width, &height, &scale); err != nil {
+		return nil, fmt.Errorf("bad pfm header: %v", err)
+	}
+	channels := map[string]int{"PF": 3, "Pf": 1}[kind]
+	if channels == 0 || width <= 0 || height <= 0 || scale == 0 {
+		return nil, fmt.Errorf("not a pfm")
+	}
+	// a single whitespace ends the header
+	if _, err := in.ReadByte(); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		order = binary.LittleEndian
+	}
+	values := new_rect_grid[float64](width, height)
+	pixel := make([]float32, channels)
+	for y := height - 1; y >= 0; y-- {
+		for x := 0; x < width; x++ {
+			if err := binary.Read(in, order, pixel); err != nil {
+				return nil, fmt.Errorf("pfm ends early: %v", err)
+			}
+			values[x][y] = float64(pixel[0])
+		}
+	}
+	return values, nil
+}
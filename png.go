@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// encodes the image as png with a tEXt chunk for every entry of the metadata, sorted by key so the
+// same image always gives the same file
+func write_png(w io.Writer, img image.Image, metadata map[string]string) error {
+	return write_png_context(context.Background(), w, img, metadata)
+}
+
+// like write_png, but the encoding and the writing stop with the error of the context when it's cancelled
+func write_png_context(ctx context.Context, w io.Writer, img image.Image, metadata map[string]string) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(context_writer{ctx, &encoded}, img); err != nil {
+		return err
+	}
+	w = context_writer{ctx, w}
+	data := encoded.Bytes()
+
+	// the signature and the IHDR chunk always come first, the text goes right after them
+	const header = 8 + 4 + 4 + 13 + 4
+	if _, err := w.Write(data[:header]); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := write_chunk(w, "tEXt", []byte(key+"\x00"+metadata[key])); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(data[header:])
+	return err
+}
+
+// a writer that fails with the error of the context once it's cancelled, the encoders stop at their
+// next write
+type context_writer struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w context_writer) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+func write_chunk(w io.Writer, kind string, data []byte) error {
+	// length, type, data and the crc of type and data
+	chunk := make([]byte, 8+len(data)+4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], kind)
+	copy(chunk[8:], data)
+	binary.BigEndian.PutUint32(chunk[8+len(data):], crc32.ChecksumIEEE(chunk[4:8+len(data)]))
+	_, err := w.Write(chunk)
+	return err
+}
+
+// the tEXt chunks of a png, stops at the image data since write_png puts them before it
+func read_png_metadata(r io.Reader) (map[string]string, error) {
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(r, signature); err != nil || string(signature) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("not a png")
+	}
+	metadata := map[string]string{}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return metadata, nil
+		}
+		length, kind := binary.BigEndian.Uint32(header[:4]), string(header[4:])
+		if kind == "IDAT" || kind == "IEND" {
+			return metadata, nil
+		}
+		data := make([]byte, length+4) // and the crc
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		if kind == "tEXt" {
+			if key, value, ok := bytes.Cut(data[:length], []byte{0}); ok {
+				metadata[string(key)] = string(value)
+			}
+		}
+	}
+}
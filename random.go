@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// note:
+// Random settings are picked uniformly between a low and a high value for every parameter. The ranges
+// below were found by trying lots of flakes, everything in them grows into something that looks like a
+// snowflake in a few thousand iterations. A stays at 1.0 since even small changes flood the matrix or
+// dry it out, see snow explain A.
+
+type random_range struct {
+	low, high float64
+}
+
+// the ranges that grow nice flakes, by parameter name
+var random_ranges = map[string]random_range{
+	"A":  {1.0, 1.0},
+	"B":  {0.30, 0.45},
+	"Y":  {0.0005, 0.004},
+	"PP": {0.01, 0.06},
+	"PM": {0.15, 0.40},
+}
+
+// random settings that grow into a flake, with 4 decimals so they can be shared as codes
+func random_settings(rng *rand.Rand, ranges map[string]random_range, size, height int, L int64) Settings {
+	between := func(name string) float64 {
+		r := ranges[name]
+		if r.low == r.high {
+			// no random number, so fixing one parameter doesn't change the others
+			return r.low
+		}
+		return math.Round((r.low+rng.Float64()*(r.high-r.low))*10000) / 10000
+	}
+	settings := default_settings()
+	settings.A = between("A")
+	settings.B = between("B")
+	settings.Y = between("Y")
+	settings.PP = between("PP")
+	settings.PM = between("PM")
+	settings.L, settings.Size, settings.Height = L, size, height
+	return settings
+}
+
+// "low:high" or a single value
+func parse_range(s string) (random_range, error) {
+	low, high, found := strings.Cut(s, ":")
+	if !found {
+		high = low
+	}
+	a, err := strconv.ParseFloat(low, 64)
+	if err != nil {
+		return random_range{}, err
+	}
+	b, err := strconv.ParseFloat(high, 64)
+	if err != nil {
+		return random_range{}, err
+	}
+	if b < a {
+		return random_range{}, fmt.Errorf("%g is below %g", b, a)
+	}
+	return random_range{a, b}, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// note:
+// -raw also saves the matrix as it is next to the result, hexagon i, j is pixel i, j with the gray of
+// its value, nothing is resampled or moved into place. The axial coordinates slant the rows, so the
+// border of a square matrix is a hexagon stretched from the top right to the bottom left and the arms of
+// the flake are 90 and 45 degrees apart, but every pixel is one hexagon, which is what changes to the
+// rules need to be looked at with. The raw image is always gray, it shows the matrix the result is
+// rendered from, after -kaleidoscope and -mirror. -no-shear saves it as the result instead, then the
+// palette and the text go on it like on any other result.
+
+// the matrix with a pixel for every hexagon at i, j
+func render_raw[T Real](matrix *Grid[T]) image.Image {
+	width, height := len(*matrix), len((*matrix)[0])
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			v := float64((*matrix)[i][j])
+			img.SetGray(i, j, color.Gray{uint8(math.Max(0, math.Min(v*255, 255)))})
+		}
+	}
+	return img
+}
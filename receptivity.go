@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// note:
+// -receptivity paint.png paints where the flake grows. The image is stretched over the result like the
+// obstacles, and the brightness of the pixel over a hexagon scales the Y it takes up every iteration
+// when it is receptive:
+//
+//   white   Y, the flake grows like without the image
+//   gray    a part of Y, one half grows about half as fast
+//   black   nothing, the hexagon only freezes from the vapor its neighbours hand it
+//
+// So the ice grows dense and branches out in the bright parts and stays thin in the dark ones, the
+// vapor goes where it is taken up. The step adds Y to every receptive hexagon, called after it
+// paint_receptivity takes away what the image doesn't give, that way it works with every step that
+// adds Y, the reiter solver, the laplacian one, kahan summation and the weights of the directions.
+
+// the brightness of the image stretched over the result at every hexagon, from 0 to 1
+func image_values(img image.Image, width, height int) Matrix {
+	stencil := new_field(img)
+	image_width, image_height := rendered_size(width, height)
+	scale_x, scale_y := float64(stencil.width)/float64(image_width), float64(stencil.height)/float64(image_height)
+	values := new_rect_grid[float64](width, height)
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			x, y := cell_position(i, j, width, height)
+			// cell_position is the corner of the pixel that shows the hexagon, at() wants its middle
+			values[i][j] = stencil.at((x+0.5)*scale_x, (y+0.5)*scale_y)
+		}
+	}
+	return values
+}
+
+// the receptivity of every hexagon, nil without an image
+func (settings Settings) receptivity() (Matrix, error) {
+	if settings.Receptivity == "" {
+		return nil, nil
+	}
+	img, err := load_layer(settings.Receptivity)
+	if err != nil {
+		return nil, fmt.Errorf("bad receptivity: %v", err)
+	}
+	return image_values(img, settings.Size, settings.height()), nil
+}
+
+// takes the part of Y the receptivity doesn't give back from the receptive hexagons, called after a step
+func paint_receptivity[T Real](Y float64, receptivity Matrix, coldness_matrix *Grid[T], mask_matrix *Mask) {
+	for i := range *coldness_matrix {
+		for j := range (*coldness_matrix)[i] {
+			if (*mask_matrix)[i][j] == receptive {
+				(*coldness_matrix)[i][j] -= T(Y * (1 - receptivity[i][j]))
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// note:
+// Every result has its settings and the iterations it ran for in the metadata, so the result is all it
+// takes to grow the same flake again. snow regen flake.png runs the simulation from the metadata of the
+// image, the same noise, seed and iterations give the same matrix, and renders it with the flags after
+// it. -size 3200 renders the longer side of the result with that many pixels, the hexagons get further
+// apart in the image and the pixels between them are taken with the -resample kernel, the simulation
+// itself stays the same. -spritesheet, -history and the rest of the flags of a normal run work too, so
+// a still can be grown again as an animation. Runs that stopped on a -duration or -fill stop at the
+// iteration they got to.
+
+// the settings in the metadata of a result, with L the iterations it ran for
+func image_settings(filename string) (Settings, error) {
+	var settings Settings
+	metadata, err := read_metadata(filename)
+	if err != nil {
+		return settings, err
+	}
+	if metadata["Software"] != "procedural-snowflakes" || metadata["Settings"] == "" {
+		return settings, fmt.Errorf("%s has no settings in its metadata", filename)
+	}
+	if err := json.Unmarshal([]byte(metadata["Settings"]), &settings); err != nil {
+		return settings, fmt.Errorf("%s: %v", filename, err)
+	}
+	if iteration, err := strconv.ParseInt(metadata["Iteration"], 10, 64); err == nil && iteration > 0 {
+		settings.L = iteration
+	}
+	return settings, nil
+}
+
+// the scale that makes the longer side of render() of a width x height grid pixels long
+func size_scale(width, height, pixels int) float64 {
+	image_width, image_height := rendered_size(width, height)
+	return float64(pixels) / math.Max(float64(image_width), float64(image_height))
+}
+
+// the size at the end of the name, like flake-3200px.png
+func size_filename(filename string, pixels int) string {
+	return fmt.Sprintf("%s-%dpx.png", strings.TrimSuffix(filename, ".png"), pixels)
+}
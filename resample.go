@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// note:
+// Every pixel of the image is somewhere between the hexagons, -resample picks how its value is taken from
+// the hexagons around it, in axial coordinates:
+//
+//   bilinear   the four nearest hexagons, smooth but a little soft, the default
+//   bicubic    the sixteen nearest with the Keys kernel (a = -0.5), sharper edges
+
+type resample_kernel struct {
+	radius int // hexagons on every side that have a weight
+	weight func(t float64) float64
+}
+
+var resample_kernels = map[string]resample_kernel{
+	"bilinear": {1, func(t float64) float64 { return math.Max(0, 1-math.Abs(t)) }},
+	"bicubic":  {2, keys_cubic},
+}
+
+func resample_names() string {
+	var names []string
+	for name := range resample_kernels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func parse_resample(name string) (resample_kernel, error) {
+	k, ok := resample_kernels[name]
+	if !ok {
+		return k, fmt.Errorf("unknown -resample %q, use %s", name, resample_names())
+	}
+	return k, nil
+}
+
+// the cubic convolution kernel of Keys with a = -0.5, catmull-rom
+func keys_cubic(t float64) float64 {
+	const a = -0.5
+	t = math.Abs(t)
+	switch {
+	case t <= 1:
+		return (a+2)*t*t*t - (a+3)*t*t + 1
+	case t < 2:
+		return a*t*t*t - 5*a*t*t + 8*a*t - 4*a
+	}
+	return 0
+}
+
+// the value at i, j of the matrix between the hexagons, whole numbers are the hexagons, outside of the
+// matrix is 0
+func sample[T Real](matrix *Grid[T], i, j float64, k resample_kernel) float64 {
+	size, height := len(*matrix), len((*matrix)[0])
+	i0, j0 := int(math.Floor(i)), int(math.Floor(j))
+	sum := 0.0
+	for b := j0 - k.radius + 1; b <= j0+k.radius; b++ {
+		wj := k.weight(j - float64(b))
+		if wj == 0 || b < 0 || b >= height {
+			continue
+		}
+		for a := i0 - k.radius + 1; a <= i0+k.radius; a++ {
+			if a >= 0 && a < size {
+				sum += wj * k.weight(i-float64(a)) * float64((*matrix)[a][b])
+			}
+		}
+	}
+	return sum
+}
+
+// the image of render() with the kernel, turned clockwise by the degrees around the middle
+func render_resampled[T Real](matrix *Grid[T], k resample_kernel, degrees float64) image.Image {
+	return render_resized(matrix, k, degrees, 1)
+}
+
+// like render_resampled with scale pixels for every pixel of render()
+func render_resized[T Real](matrix *Grid[T], k resample_kernel, degrees, scale float64) image.Image {
+	img, _ := render_resized_context(context.Background(), matrix, k, degrees, scale)
+	return img
+}
+
+// like render_resized, but stops between the rows with the error of the context when it's cancelled
+func render_resized_context[T Real](ctx context.Context, matrix *Grid[T], k resample_kernel, degrees, scale float64) (image.Image, error) {
+	size, height := len(*matrix), len((*matrix)[0])
+	width, rows := rendered_size(size, height)
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	middle_x, middle_y := float64(width)/2, float64(rows)/2
+	out_width := int(math.Max(1, math.Round(float64(width)*scale)))
+	out_rows := int(math.Max(1, math.Round(float64(rows)*scale)))
+	img := image.NewRGBA(image.Rect(0, 0, out_width, out_rows))
+	for py := 0; py < out_rows; py++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for px := 0; px < out_width; px++ {
+			// where the pixel was before turning
+			dx, dy := (float64(px)+0.5)/scale-middle_x, (float64(py)+0.5)/scale-middle_y
+			x, y := middle_x+dx*cos+dy*sin, middle_y-dx*sin+dy*cos
+			i, j := image_to_cell(x, y, size, height)
+			c := sample(matrix, i, j, k)
+			img.Set(px, py, color.Gray{uint8(math.Max(0, math.Min(c*255, 255)))})
+		}
+	}
+	return img, nil
+}
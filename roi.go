@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// note:
+// A region of interest is a rectangle of the rendered flake, in cells, where one cell is one pixel of the
+// image render() makes. Only that rectangle is rendered, straight from the matrix and scaled up to the
+// size of the full image, so a dendrite tip can be looked at closely without rendering a huge image and
+// cropping it. Every pixel takes the value of the nearest hexagon, with the hexagons where render() puts
+// them, so the cells show up as hexagons instead of blurry squares.
+
+// the hexagon closest to the point of the rendered image, axial coordinates are rounded through cube
+// coordinates
+func nearest_cell(x, y float64, width, height int) (int, int) {
+	q, r := image_to_cell(x, y, width, height)
+	s := -q - r
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	// the coordinate that was rounded the most is the one that has to give
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	switch {
+	case dq > dr && dq > ds:
+		rq = -rr - rs
+	case dr > ds:
+		rr = -rq - rs
+	}
+	return int(rq), int(rr)
+}
+
+// x,y,w,h of the rendered flake
+func parse_roi(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("expected x,y,w,h but got %q", s)
+	}
+	var v [4]int
+	for k, part := range parts {
+		var err error
+		if v[k], err = strconv.Atoi(strings.TrimSpace(part)); err != nil {
+			return image.Rectangle{}, err
+		}
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("the width and height of %q must be more than 0", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// renders the region of interest with the longer side as long as the longer side of the full image,
+// what is outside of the matrix is black
+func render_roi[T Real](matrix *Grid[T], roi image.Rectangle) image.Image {
+	size, height := len(*matrix), len((*matrix)[0])
+	full_width, full_height := rendered_size(size, height)
+	scale := math.Max(float64(full_width), float64(full_height)) / math.Max(float64(roi.Dx()), float64(roi.Dy()))
+	return render_scaled(matrix, roi, scale)
+}
+
+// renders the region of interest with scale pixels per cell
+func render_scaled[T Real](matrix *Grid[T], roi image.Rectangle, scale float64) image.Image {
+	size, height := len(*matrix), len((*matrix)[0])
+	width := int(math.Max(1, math.Round(float64(roi.Dx())*scale)))
+	rows := int(math.Max(1, math.Round(float64(roi.Dy())*scale)))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, rows))
+	for py := 0; py < rows; py++ {
+		y := float64(roi.Min.Y) + (float64(py)+0.5)/scale
+		for px := 0; px < width; px++ {
+			x := float64(roi.Min.X) + (float64(px)+0.5)/scale
+			c := 0.0
+			if i, j := nearest_cell(x, y, size, height); i >= 0 && i < size && j >= 0 && j < height {
+				c = math.Min(float64((*matrix)[i][j])*255, 255)
+			}
+			img.Set(px, py, color.Gray{uint8(c)})
+		}
+	}
+	return img
+}
+
+// the file name of a region of interest of the result
+func roi_filename(filename string, roi image.Rectangle) string {
+	return fmt.Sprintf("%s-roi-%d,%d,%d,%d.png", strings.TrimSuffix(filename, ".png"), roi.Min.X, roi.Min.Y, roi.Dx(), roi.Dy())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// the hexagon with its middle closest to the point, out of the ones around it
+func closest_cell(x, y float64, width, height int) (int, int) {
+	q, r := image_to_cell(x, y, width, height)
+	best_i, best_j, best := 0, 0, math.Inf(1)
+	for i := int(math.Floor(q)) - 1; i <= int(math.Floor(q))+2; i++ {
+		for j := int(math.Floor(r)) - 1; j <= int(math.Floor(r))+2; j++ {
+			cx, cy := cell_position(i, j, width, height)
+			if d := math.Hypot(cx-x, cy-y); d < best {
+				best_i, best_j, best = i, j, d
+			}
+		}
+	}
+	return best_i, best_j
+}
+
+func TestNearestCell(t *testing.T) {
+	tests := []struct {
+		name          string
+		x, y          float64
+		width, height int
+		i, j          int
+	}{
+		{"middle of a hexagon", 100.5, 100.5, 201, 201, 100, 100},
+		{"rounding the axial coordinates gives 100,99", 100.3671, 100, 201, 201, 100, 100},
+		{"just inside a corner", 100.5 + 0.55*math.Cos(math.Pi/6), 100.5 - 0.55*math.Sin(math.Pi/6), 201, 201, 100, 100},
+		{"just past the side to the right", 101.01, 100.5, 201, 201, 101, 100},
+		{"middle of a rectangular grid", 35.5, 43.5, 121, 101, 60, 50},
+	}
+	for _, test := range tests {
+		i, j := nearest_cell(test.x, test.y, test.width, test.height)
+		if i != test.i || j != test.j {
+			t.Errorf("%s: %g,%g is in hexagon %d,%d, not %d,%d", test.name, test.x, test.y, i, j, test.i, test.j)
+		}
+	}
+}
+
+func TestNearestCellIsClosest(t *testing.T) {
+	for _, grid := range test_grids {
+		image_width, image_height := rendered_size(grid.width, grid.height)
+		for k := 0; k < 5000; k++ {
+			// a grid of points that doesn't line up with the hexagons
+			x := float64(k%71) / 71 * float64(image_width)
+			y := float64(k/71) / 71 * float64(image_height)
+			i, j := nearest_cell(x, y, grid.width, grid.height)
+			ci, cj := closest_cell(x, y, grid.width, grid.height)
+			if i != ci || j != cj {
+				cx, cy := cell_position(i, j, grid.width, grid.height)
+				bx, by := cell_position(ci, cj, grid.width, grid.height)
+				// on the edge between two hexagons either one is right
+				if math.Abs(math.Hypot(cx-x, cy-y)-math.Hypot(bx-x, by-y)) > 1e-9 {
+					t.Fatalf("%dx%d: %g,%g is in hexagon %d,%d, but %d,%d is closer", grid.width, grid.height, x, y, i, j, ci, cj)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// note:
+// -rotate turns the flake clockwise around the middle of the image, so flakes that are put together in
+// a scene don't all point the same way. The turn is part of rendering, every pixel is taken from the
+// hexagons with the -resample kernel like without a turn, so the image isn't resampled twice. The image
+// keeps its size, the corners that come from outside of the matrix are black.
+//
+// random picks a new angle for every flake, from 0 to 360 degrees since flakes with noise aren't the
+// same after 60.
+
+// degrees or random
+func parse_rotation(s string, rng *rand.Rand) (float64, error) {
+	if strings.EqualFold(s, "random") {
+		// whole tenths of a degree read better in the file names
+		return math.Round(rng.Float64()*3600) / 10, nil
+	}
+	degrees, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected degrees or random but got %q", s)
+	}
+	return degrees, nil
+}
+
+// the file name of a turned result
+func rotated_filename(filename string, degrees float64) string {
+	return fmt.Sprintf("%s-rotate-%g.png", strings.TrimSuffix(filename, ".png"), degrees)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// note:
+// Runs are simulations that keep going in the background after the request that started them.
+// A and Y of a run can be changed while it grows, which is how real snowflakes get their layers.
+//
+//   POST   /runs?A=1&B=0.33&...   starts a run, same parameters as /snowflake
+//   GET    /runs/<id>             status as json
+//   GET    /runs/<id>/image       png of how far the run has grown, ?palette=ice colors it and
+//                                 ?download=1 saves it under the name of a result
+//   PATCH  /runs/<id>?Y=0.001     changes A and/or Y from the next iteration on
+//   DELETE /runs/<id>             stops the run, it can still be looked at until it is forgotten
+//
+// A run outlives the request that started it, so only the -timeout of the server stops it early, it's
+// kept at the iteration it got to with an error that says so. Only the simulation goroutine touches
+// the state while a run is going. Requests hand it a function that it calls between two iterations,
+// once the run is done they use the state directly.
+
+// how long finished runs stay around
+const run_lifetime = 10 * time.Minute
+
+type run_session struct {
+	id       string
+	state    *State
+	requests chan func(state *State) // handled between iterations
+	done     chan struct{}           // closed when the simulation is finished
+	mutex    sync.Mutex              // only used after done
+	err      string                  // set if the simulation broke down, before done is closed
+}
+
+type run_status struct {
+	ID        string   `json:"id"`
+	Settings  Settings `json:"settings"`
+	Iteration int64    `json:"iteration"`
+	Frozen    int      `json:"frozen"`
+	Truncated *int64   `json:"truncated,omitempty"`
+	Done      bool     `json:"done"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// calls f with the state between two iterations, or right away if the run is done
+func (session *run_session) with(f func(state *State)) {
+	handled := make(chan struct{})
+	select {
+	case session.requests <- func(state *State) { f(state); close(handled) }:
+		<-handled
+	case <-session.done:
+		session.mutex.Lock()
+		defer session.mutex.Unlock()
+		f(session.state)
+	}
+}
+
+func (session *run_session) status() run_status {
+	var status run_status
+	session.with(func(state *State) {
+		values := state.values()
+		status = run_status{
+			ID:        session.id,
+			Settings:  state.Settings,
+			Iteration: state.Iteration,
+			Frozen:    frozen_cells(&values),
+			Truncated: state.truncated(),
+			Done:      state.Iteration >= state.Settings.L || session.err != "",
+			Error:     session.err,
+		}
+	})
+	return status
+}
+
+func new_run_id() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// POST /runs
+func (s *server) start_run(w http.ResponseWriter, r *http.Request) {
+	if !s.allow(w, r) {
+		return
+	}
+	settings, ok := s.settings(w, r)
+	if !ok {
+		return
+	}
+
+	// the run keeps its slot until it is done
+	select {
+	case s.running <- struct{}{}:
+	default:
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "too many simulations running, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	session := &run_session{
+		id:       new_run_id(),
+		state:    new_state(settings),
+		requests: make(chan func(state *State)),
+		done:     make(chan struct{}),
+	}
+	s.runs_mutex.Lock()
+	s.runs[session.id] = session
+	s.runs_mutex.Unlock()
+
+	go func() {
+		defer func() {
+			// values that grow without bounds (A above 1) reach the edge of the matrix, that must not
+			// take the whole server down
+			if err := recover(); err != nil {
+				session.err = fmt.Sprintf("simulation failed: %v", err)
+				log.Printf("run %s: %s", session.id, session.err)
+			}
+			close(session.done)
+			<-s.running
+
+			time.AfterFunc(run_lifetime, func() {
+				s.runs_mutex.Lock()
+				delete(s.runs, session.id)
+				s.runs_mutex.Unlock()
+			})
+		}()
+
+		ctx, cancel := s.context(context.Background())
+		defer cancel()
+		err := run_context(ctx, session.state, func(iteration int64) {
+			for {
+				select {
+				case f := <-session.requests:
+					f(session.state)
+				default:
+					return
+				}
+			}
+		})
+		switch {
+		case err != nil && ctx.Err() != nil:
+			session.err = fmt.Sprintf("stopped at the time limit of %s", s.limits.Timeout)
+		case err != nil:
+			session.err = err.Error()
+		}
+	}()
+
+	w.Header().Set("Location", "/runs/"+session.id)
+	write_json(w, http.StatusCreated, session.status())
+}
+
+// /runs/<id> and /runs/<id>/image
+func (s *server) run(w http.ResponseWriter, r *http.Request) {
+	id, image := strings.TrimPrefix(r.URL.Path, "/runs/"), false
+	if strings.HasSuffix(id, "/image") {
+		id, image = strings.TrimSuffix(id, "/image"), true
+	}
+	s.runs_mutex.Lock()
+	session, ok := s.runs[id]
+	s.runs_mutex.Unlock()
+	if !ok {
+		http.Error(w, "no such run", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case image && r.Method == http.MethodGet:
+		var coldness_matrix Matrix
+		var metadata map[string]string
+		var settings Settings
+		session.with(func(state *State) {
+			metadata, settings = state.metadata(), state.Settings
+			// copy so the image can be encoded while the run goes on
+			values := state.values()
+			coldness_matrix = new_matrix_like(values)
+			for i := range values {
+				copy(coldness_matrix[i], values[i])
+			}
+		})
+		img, err := render_settings_context(r.Context(), settings, &coldness_matrix)
+		if err != nil {
+			log.Printf("rendering run %s stopped: %v", id, err)
+			return
+		}
+		// only the built in palettes, a palette file would be read from the disk of the server
+		if name := r.URL.Query().Get("palette"); name != "" {
+			p, ok := palettes[name]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown palette %q, use %s", name, palette_names()), http.StatusBadRequest)
+				return
+			}
+			img = colorize(img, plain_theme(p))
+		}
+		if r.URL.Query().Get("download") != "" {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(default_filename(settings))))
+		}
+		w.Header().Set("Content-Type", "image/png")
+		if err := write_png_context(r.Context(), w, img, metadata); err != nil {
+			log.Printf("encoding run %s: %v", id, err)
+		}
+
+	case !image && r.Method == http.MethodGet:
+		write_json(w, http.StatusOK, session.status())
+
+	case !image && r.Method == http.MethodPatch:
+		var err error
+		session.with(func(state *State) {
+			settings := state.Settings
+			if err = parse_floats(r, map[string]*float64{"A": &settings.A, "Y": &settings.Y}); err != nil {
+				return
+			}
+			// a run that blew up can't be changed back, new values it would blow up on are turned down
+			for name, hint := range map[string]string{"A": a_hint(settings.A), "Y": y_hint(settings.Y)} {
+				if hint != "" && r.URL.Query().Has(name) {
+					err = errors.New(hint)
+					return
+				}
+			}
+			state.Settings = settings
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		write_json(w, http.StatusOK, session.status())
+
+	case !image && r.Method == http.MethodDelete:
+		session.with(func(state *State) {
+			if state.Iteration < state.Settings.L {
+				state.Settings.L = state.Iteration
+			}
+		})
+		write_json(w, http.StatusOK, session.status())
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// reads the values that are in the query string, the others are left alone
+func parse_floats(r *http.Request, floats map[string]*float64) error {
+	query := r.URL.Query()
+	for name, value := range floats {
+		if query.Has(name) {
+			v, err := strconv.ParseFloat(query.Get(name), 64)
+			if err != nil {
+				return fmt.Errorf("bad value for %s: %v", name, err)
+			}
+			if math.IsNaN(v) {
+				return fmt.Errorf("bad value for %s: not a number", name)
+			}
+			*value = v
+		}
+	}
+	return nil
+}
+
+func write_json(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// note:
+// -scene saves the flake as a mesh with a scene around it, ready to render as glass ice in Blender or
+// POV-Ray:
+//
+//   gltf   a binary .glb with the mesh, a camera, a spot light and an ice material with transmission, an
+//          index of refraction of 1.31 and a blue volume, File > Import > glTF in Blender
+//   pov    a .pov with the mesh as a mesh2, a camera, an area light and a glass interior
+//
+// The mesh is a plate like -render volume, as thick as volume_depth pixels where the ice is thickest.
+// The middles of the frozen hexagons are a triangle grid, so every hexagon is a vertex on the top and one
+// on the bottom, with walls along the border to close it, glass needs an inside. Hexagons that aren't in
+// a triangle with two frozen neighbours, like the tip of a dendrite one hexagon wide, are left out. The
+// flake is 2 units wide in the x, y plane around the middle of the image, y is up.
+
+var scene_formats = map[string]string{"gltf": ".glb", "pov": ".pov"}
+
+type scene_mesh struct {
+	positions [][3]float64
+	normals   [][3]float64
+	triangles [][3]int
+}
+
+// the closed mesh of the frozen hexagons
+func flake_mesh(values Matrix) scene_mesh {
+	size, height := len(values), len(values[0])
+	image_width, image_height := rendered_size(size, height)
+	scale := 2 / math.Max(float64(image_width), float64(image_height))
+	thick := thickness(values)
+
+	var mesh scene_mesh
+	// the top vertex of every hexagon once it is in a triangle, the bottom one comes right after it
+	top := make([]int, size*height)
+	for k := range top {
+		top[k] = -1
+	}
+	vertex := func(i, j int) int {
+		if top[i*height+j] < 0 {
+			x, y := cell_position(i, j, size, height)
+			x, y = (x-float64(image_width)/2)*scale, -(y-float64(image_height)/2)*scale
+			z := thick[i][j] * volume_depth / 2 * scale
+			top[i*height+j] = len(mesh.positions)
+			mesh.positions = append(mesh.positions, [3]float64{x, y, z}, [3]float64{x, y, -z})
+			mesh.normals = append(mesh.normals, [3]float64{}, [3]float64{})
+		}
+		return top[i*height+j]
+	}
+	frozen := func(i, j int) bool { return i < size && j < height && thick[i][j] > 0 }
+
+	// counterclockwise seen from above, y of the image is down
+	var faces [][3]int
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if !frozen(i, j) {
+				continue
+			}
+			if frozen(i+1, j) && frozen(i, j+1) {
+				faces = append(faces, [3]int{vertex(i, j), vertex(i, j+1), vertex(i+1, j)})
+			}
+			if frozen(i+1, j) && frozen(i+1, j+1) && frozen(i, j+1) {
+				faces = append(faces, [3]int{vertex(i+1, j), vertex(i, j+1), vertex(i+1, j+1)})
+			}
+		}
+	}
+	edges := map[[2]int]bool{}
+	for _, f := range faces {
+		for k := 0; k < 3; k++ {
+			edges[[2]int{f[k], f[(k+1)%3]}] = true
+		}
+	}
+	for _, f := range faces {
+		// the bottom the other way around, facing down
+		mesh.add_smooth(f)
+		mesh.add_smooth([3]int{f[0] + 1, f[2] + 1, f[1] + 1})
+	}
+	// an edge without a triangle on the other side is on the border and gets a wall
+	for _, f := range faces {
+		for k := 0; k < 3; k++ {
+			a, b := f[k], f[(k+1)%3]
+			if !edges[[2]int{b, a}] {
+				mesh.add_wall(a, b, a+1, b+1)
+			}
+		}
+	}
+	for k, n := range mesh.normals {
+		if l := math.Sqrt(dot(n, n)); l > 0 {
+			mesh.normals[k] = [3]float64{n[0] / l, n[1] / l, n[2] / l}
+		}
+	}
+	return mesh
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+// a triangle on shared vertices, their normals add up the ones of the faces around them
+func (mesh *scene_mesh) add_smooth(f [3]int) {
+	p := mesh.positions
+	n := cross(sub(p[f[1]], p[f[0]]), sub(p[f[2]], p[f[0]]))
+	for _, v := range f {
+		for k := range n {
+			mesh.normals[v][k] += n[k]
+		}
+	}
+	mesh.triangles = append(mesh.triangles, f)
+}
+
+// a flat wall from the top edge a, b down to the bottom one, on vertices of its own so the corner stays
+// sharp
+func (mesh *scene_mesh) add_wall(top_a, top_b, bottom_a, bottom_b int) {
+	p := mesh.positions
+	corners := [4][3]float64{p[top_a], p[bottom_a], p[bottom_b], p[top_b]}
+	d := sub(p[top_b], p[top_a])
+	n := normalized([3]float64{d[1], -d[0], 0})
+	first := len(mesh.positions)
+	for _, c := range corners {
+		mesh.positions = append(mesh.positions, c)
+		mesh.normals = append(mesh.normals, n)
+	}
+	mesh.triangles = append(mesh.triangles, [3]int{first, first + 1, first + 2}, [3]int{first, first + 2, first + 3})
+}
+
+// where the camera is and where the light is, the camera looks at the middle from a bit below
+var (
+	scene_camera = [3]float64{0, -0.8, 2.6}
+	scene_light  = [3]float64{-2, 2, 3}
+)
+
+// the rotation as a quaternion x, y, z, w that turns -z, where gltf cameras and lights look, towards the
+// middle from the position
+func look_at_middle(from [3]float64) [4]float64 {
+	forward := [3]float64{0, 0, -1}
+	to := normalized([3]float64{-from[0], -from[1], -from[2]})
+	axis := cross(forward, to)
+	angle := math.Acos(math.Max(-1, math.Min(1, dot(forward, to))))
+	if l := math.Sqrt(dot(axis, axis)); l > 0 {
+		axis = [3]float64{axis[0] / l, axis[1] / l, axis[2] / l}
+	}
+	s := math.Sin(angle / 2)
+	return [4]float64{axis[0] * s, axis[1] * s, axis[2] * s, math.Cos(angle / 2)}
+}
+
+// the mesh, camera, light and material as a binary gltf
+func write_gltf(filename string, mesh scene_mesh) error {
+	var bin bytes.Buffer
+	low, high := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range mesh.positions {
+		for k := range p {
+			binary.Write(&bin, binary.LittleEndian, float32(p[k]))
+			low[k], high[k] = math.Min(low[k], p[k]), math.Max(high[k], p[k])
+		}
+	}
+	for _, n := range mesh.normals {
+		for k := range n {
+			binary.Write(&bin, binary.LittleEndian, float32(n[k]))
+		}
+	}
+	for _, t := range mesh.triangles {
+		for _, v := range t {
+			binary.Write(&bin, binary.LittleEndian, uint32(v))
+		}
+	}
+	vertices, indices := len(mesh.positions), 3*len(mesh.triangles)
+	document := map[string]any{
+		"asset":          map[string]any{"version": "2.0", "generator": "procedural-snowflakes"},
+		"extensionsUsed": []string{"KHR_materials_transmission", "KHR_materials_ior", "KHR_materials_volume", "KHR_lights_punctual"},
+		"scene":          0,
+		"scenes":         []any{map[string]any{"name": "snowflake", "nodes": []int{0, 1, 2}}},
+		"nodes": []any{
+			map[string]any{"name": "flake", "mesh": 0},
+			map[string]any{"name": "camera", "camera": 0, "translation": scene_camera, "rotation": look_at_middle(scene_camera)},
+			map[string]any{"name": "light", "translation": scene_light, "rotation": look_at_middle(scene_light),
+				"extensions": map[string]any{"KHR_lights_punctual": map[string]any{"light": 0}}},
+		},
+		"meshes": []any{map[string]any{"name": "flake", "primitives": []any{map[string]any{
+			"attributes": map[string]any{"POSITION": 0, "NORMAL": 1}, "indices": 2, "material": 0,
+		}}}},
+		"materials": []any{map[string]any{
+			"name": "ice",
+			"pbrMetallicRoughness": map[string]any{
+				"baseColorFactor": []float64{1, 1, 1, 1}, "metallicFactor": 0, "roughnessFactor": 0.05,
+			},
+			"extensions": map[string]any{
+				"KHR_materials_transmission": map[string]any{"transmissionFactor": 1},
+				"KHR_materials_ior":          map[string]any{"ior": 1.31},
+				"KHR_materials_volume": map[string]any{
+					"thicknessFactor": high[2] - low[2], "attenuationDistance": 0.05, "attenuationColor": []float64{0.75, 0.9, 1},
+				},
+			},
+		}},
+		"cameras": []any{map[string]any{"type": "perspective", "perspective": map[string]any{
+			"yfov": 0.8, "aspectRatio": 1, "znear": 0.01, "zfar": 100,
+		}}},
+		"extensions": map[string]any{"KHR_lights_punctual": map[string]any{"lights": []any{map[string]any{
+			"name": "light", "type": "spot", "intensity": 40, "spot": map[string]any{"outerConeAngle": 0.7},
+		}}}},
+		"buffers": []any{map[string]any{"byteLength": bin.Len()}},
+		"bufferViews": []any{
+			map[string]any{"buffer": 0, "byteOffset": 0, "byteLength": 12 * vertices, "target": 34962},
+			map[string]any{"buffer": 0, "byteOffset": 12 * vertices, "byteLength": 12 * vertices, "target": 34962},
+			map[string]any{"buffer": 0, "byteOffset": 24 * vertices, "byteLength": 4 * indices, "target": 34963},
+		},
+		"accessors": []any{
+			map[string]any{"bufferView": 0, "componentType": 5126, "count": vertices, "type": "VEC3", "min": low, "max": high},
+			map[string]any{"bufferView": 1, "componentType": 5126, "count": vertices, "type": "VEC3"},
+			map[string]any{"bufferView": 2, "componentType": 5125, "count": indices, "type": "SCALAR"},
+		},
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	// both chunks are padded to 4 bytes, the json with spaces
+	for len(content)%4 != 0 {
+		content = append(content, ' ')
+	}
+	for bin.Len()%4 != 0 {
+		bin.WriteByte(0)
+	}
+
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	header := []uint32{0x46546c67, 2, uint32(12 + 8 + len(content) + 8 + bin.Len())}
+	binary.Write(w, binary.LittleEndian, header)
+	binary.Write(w, binary.LittleEndian, []uint32{uint32(len(content)), 0x4e4f534a})
+	w.Write(content)
+	binary.Write(w, binary.LittleEndian, []uint32{uint32(bin.Len()), 0x004e4942})
+	w.Write(bin.Bytes())
+	if err := w.Flush(); err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
+
+// the mesh, camera, light and material as a pov-ray scene, pov-ray is left handed so z is turned around
+func write_pov(filename string, mesh scene_mesh) error {
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, `// snowflake by procedural-snowflakes, render with: povray +W1200 +H1200 +A %s
+#version 3.7;
+global_settings { assumed_gamma 1.0 max_trace_level 32 }
+
+camera { location <%g, %g, %g> look_at <0, 0, 0> angle 45 }
+light_source {
+	<%g, %g, %g> color rgb 1.4
+	area_light <0.8, 0, 0>, <0, 0.8, 0>, 6, 6 adaptive 1 jitter circular orient
+}
+background { color rgb <0.01, 0.015, 0.03> }
+// a dark backdrop for the ice to bend
+plane { z, 0.6 pigment { color rgb <0.02, 0.03, 0.06> } finish { diffuse 0.6 } }
+
+#declare ice = material {
+	texture {
+		pigment { color rgbf <0.97, 0.99, 1, 0.95> }
+		finish { specular 0.8 roughness 0.002 reflection { 0.02, 0.2 fresnel on } conserve_energy }
+	}
+	interior { ior 1.31 fade_distance 0.05 fade_power 1001 fade_color <0.75, 0.9, 1> }
+}
+
+`, filename, scene_camera[0], scene_camera[1], -scene_camera[2], scene_light[0], scene_light[1], -scene_light[2])
+	fmt.Fprintf(w, "mesh2 {\n\tvertex_vectors { %d", len(mesh.positions))
+	for _, p := range mesh.positions {
+		fmt.Fprintf(w, ",\n\t\t<%.6f, %.6f, %.6f>", p[0], p[1], -p[2])
+	}
+	fmt.Fprintf(w, "\n\t}\n\tnormal_vectors { %d", len(mesh.normals))
+	for _, n := range mesh.normals {
+		fmt.Fprintf(w, ",\n\t\t<%.5f, %.5f, %.5f>", n[0], n[1], -n[2])
+	}
+	fmt.Fprintf(w, "\n\t}\n\tface_indices { %d", len(mesh.triangles))
+	for _, t := range mesh.triangles {
+		fmt.Fprintf(w, ",\n\t\t<%d, %d, %d>", t[0], t[1], t[2])
+	}
+	fmt.Fprintf(w, "\n\t}\n\tinside_vector <0, 0, 1>\n\tmaterial { ice }\n}\n")
+	if err := w.Flush(); err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
+
+// saves the scene in the format, gltf or pov
+func save_scene(filename, format string, values Matrix) error {
+	mesh := flake_mesh(values)
+	if format == "pov" {
+		return write_pov(filename, mesh)
+	}
+	return write_gltf(filename, mesh)
+}
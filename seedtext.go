@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// note:
+// -seed-text "NOEL" grows the flake out of letters instead of a single hexagon. The text is rasterized
+// over the result, centered and as large as fits in seed_text_width of the width and seed_text_height of
+// the height, and every hexagon under a letter starts frozen. The dendrites sprout from the outlines of
+// the letters and the vapor between them runs out first, so the counters and gaps fill up slower than the
+// outside. The bold Go font is used when -font doesn't give a .ttf or .otf file. \n starts a new line
+// like in -text.
+//
+// The text replaces the seed, so -seed-pos doesn't go with it, and it is laid out for the size the grid
+// starts with, which a growing grid would leave behind.
+
+const (
+	seed_text_width  = 0.6
+	seed_text_height = 0.25 // the height of the capitals of all lines
+)
+
+func load_font(filename string) (*sfnt.Font, error) {
+	if filename == "" {
+		return sfnt.Parse(gobold.TTF)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return f, nil
+}
+
+// the width of a line of text at ppem, with kerning
+func line_width(f *sfnt.Font, buffer *sfnt.Buffer, line string, ppem fixed.Int26_6) (fixed.Int26_6, error) {
+	var width fixed.Int26_6
+	previous := sfnt.GlyphIndex(0)
+	for k, r := range line {
+		glyph, err := f.GlyphIndex(buffer, r)
+		if err != nil {
+			return 0, err
+		}
+		if k > 0 {
+			if kern, err := f.Kern(buffer, previous, glyph, ppem, font.HintingNone); err == nil {
+				width += kern
+			}
+		}
+		advance, err := f.GlyphAdvance(buffer, glyph, ppem, font.HintingNone)
+		if err != nil {
+			return 0, err
+		}
+		width += advance
+		previous = glyph
+	}
+	return width, nil
+}
+
+// the text in white on black, in an image of the size of the result
+func seed_text_image(text, font_file string, width, height int) (image.Image, error) {
+	f, err := load_font(font_file)
+	if err != nil {
+		return nil, err
+	}
+	var buffer sfnt.Buffer
+	lines := strings.Split(strings.ReplaceAll(text, `\n`, "\n"), "\n")
+	image_width, image_height := rendered_size(width, height)
+
+	// measured at 100 pixels per em and scaled to fit
+	measure := fixed.I(100)
+	metrics, err := f.Metrics(&buffer, measure, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	widest := fixed.Int26_6(0)
+	for _, line := range lines {
+		w, err := line_width(f, &buffer, line, measure)
+		if err != nil {
+			return nil, err
+		}
+		if w > widest {
+			widest = w
+		}
+	}
+	capital := metrics.CapHeight
+	if capital <= 0 {
+		capital = metrics.Ascent
+	}
+	// the capitals of the lines with the line gaps between them
+	block := float64(capital+metrics.Height*fixed.Int26_6(len(lines)-1)) / 64
+	if widest == 0 || block <= 0 {
+		return nil, fmt.Errorf("the text %q has nothing to draw", text)
+	}
+	scale := math.Min(seed_text_width*float64(image_width)/(float64(widest)/64), seed_text_height*float64(image_height)/block)
+	ppem := fixed.Int26_6(math.Round(100 * 64 * scale))
+	if metrics, err = f.Metrics(&buffer, ppem, font.HintingNone); err != nil {
+		return nil, err
+	}
+
+	z := vector.NewRasterizer(image_width, image_height)
+	top := (float64(image_height) - block*scale) / 2
+	for n, line := range lines {
+		w, _ := line_width(f, &buffer, line, ppem)
+		x := (float64(image_width) - float64(w)/64) / 2
+		baseline := top + float64(capital)/64*scale + float64(n)*float64(metrics.Height)/64
+		previous := sfnt.GlyphIndex(0)
+		for k, r := range line {
+			glyph, _ := f.GlyphIndex(&buffer, r)
+			if k > 0 {
+				if kern, err := f.Kern(&buffer, previous, glyph, ppem, font.HintingNone); err == nil {
+					x += float64(kern) / 64
+				}
+			}
+			segments, err := f.LoadGlyph(&buffer, glyph, ppem, nil)
+			if err != nil {
+				return nil, fmt.Errorf("can't draw %q: %v", r, err)
+			}
+			outline(z, segments, x, baseline)
+			advance, _ := f.GlyphAdvance(&buffer, glyph, ppem, font.HintingNone)
+			x += float64(advance) / 64
+			previous = glyph
+		}
+	}
+	img := image.NewAlpha(image.Rect(0, 0, image_width, image_height))
+	z.Draw(img, img.Bounds(), image.Opaque, image.Point{})
+	return img, nil
+}
+
+// adds the outline of a glyph with its origin at x, y to the rasterizer
+func outline(z *vector.Rasterizer, segments []sfnt.Segment, x, y float64) {
+	point := func(p fixed.Point26_6) (float32, float32) {
+		return float32(x + float64(p.X)/64), float32(y + float64(p.Y)/64)
+	}
+	open := false
+	for _, segment := range segments {
+		a := segment.Args
+		switch segment.Op {
+		case sfnt.SegmentOpMoveTo:
+			if open {
+				z.ClosePath()
+			}
+			z.MoveTo(point(a[0]))
+			open = true
+		case sfnt.SegmentOpLineTo:
+			z.LineTo(point(a[0]))
+		case sfnt.SegmentOpQuadTo:
+			bx, by := point(a[0])
+			cx, cy := point(a[1])
+			z.QuadTo(bx, by, cx, cy)
+		case sfnt.SegmentOpCubeTo:
+			bx, by := point(a[0])
+			cx, cy := point(a[1])
+			dx, dy := point(a[2])
+			z.CubeTo(bx, by, cx, cy, dx, dy)
+		}
+	}
+	if open {
+		z.ClosePath()
+	}
+}
+
+// the hexagons under the letters, checked by settings.check()
+func seed_text_cells(settings Settings, width, height int) ([][2]int, error) {
+	img, err := seed_text_image(settings.SeedText, settings.Font, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return image_cells(img, width, height), nil
+}
+
+// freezes the hexagons under the letters, called on the initial matrices instead of freezing the seed
+func freeze_seed_text[T Value](settings Settings, coldness_matrix *Grid[T]) {
+	cells, _ := seed_text_cells(settings, len(*coldness_matrix), len((*coldness_matrix)[0]))
+	for _, cell := range cells {
+		(*coldness_matrix)[cell[0]][cell[1]] = to_value[T](settings.seed_value())
+	}
+}
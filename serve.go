@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limits that keep a public server from falling over
+type Limits struct {
+	MaxConcurrent int           // simulations allowed to run at the same time
+	Rate          float64       // requests per minute per client
+	Burst         int           // requests a client can make at once before the rate kicks in
+	MaxSize       int           // largest matrix size
+	MaxIterations int64         // largest amount of loops
+	Timeout       time.Duration // longest a simulation can run, 0 for no limit
+}
+
+type server struct {
+	limits  Limits
+	running chan struct{} // one slot per simulation allowed to run
+	clients *rate_limiter
+	store   string // directory or prefix the flakes are saved in too, see storage.go
+
+	runs_mutex sync.Mutex
+	runs       map[string]*run_session
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	var limits Limits
+	flags.IntVar(&limits.MaxConcurrent, "max-concurrent", runtime.NumCPU(), "simulations allowed to run at the same time")
+	flags.Float64Var(&limits.Rate, "rate", 10, "requests per minute per client, 0 turns the limit off")
+	flags.IntVar(&limits.Burst, "burst", 3, "requests a client can make at once")
+	flags.IntVar(&limits.MaxSize, "max-size", 800, "largest matrix size a client can ask for")
+	flags.Int64Var(&limits.MaxIterations, "max-iterations", 20000, "largest amount of loops a client can ask for")
+	flags.DurationVar(&limits.Timeout, "timeout", 0, "longest a simulation can run before it is stopped, like 2m (default is no limit)")
+	store := flags.String("store", "", "directory, or s3:// or gs:// prefix, every flake is saved in under its default name as well")
+	storage_flags(flags)
+	flags.Parse(args)
+
+	if limits.MaxConcurrent < 1 {
+		fmt.Fprintln(os.Stderr, "-max-concurrent must be at least 1")
+		os.Exit(2)
+	}
+	if limits.Rate > 0 && limits.Burst < 1 {
+		// an empty bucket never fills up to a whole request
+		fmt.Fprintln(os.Stderr, "-burst must be at least 1 when -rate limits the requests")
+		os.Exit(2)
+	}
+	s := new_server(limits)
+	if err := check_output(in_prefix(*store, "flake.png")); err != nil {
+		log.Fatal(err)
+	}
+	s.store = *store
+	s.routes(http.DefaultServeMux)
+
+	log.Printf("serving snowflakes on %s (max %d concurrent, %.1f req/min per client, size <= %d, L <= %d, timeout %s)",
+		*addr, limits.MaxConcurrent, limits.Rate, limits.MaxSize, limits.MaxIterations, limits.Timeout)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func new_server(limits Limits) *server {
+	return &server{
+		limits:  limits,
+		running: make(chan struct{}, limits.MaxConcurrent),
+		clients: new_rate_limiter(limits.Rate, limits.Burst),
+		runs:    map[string]*run_session{},
+	}
+}
+
+// the snowflake and run endpoints
+func (s *server) routes(mux *http.ServeMux) {
+	mux.HandleFunc("/snowflake", s.snowflake)
+	mux.HandleFunc("/runs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.start_run(w, r)
+	})
+	mux.HandleFunc("/runs/", s.run)
+}
+
+// GET /snowflake?A=1&B=0.33&Y=0.0002&PP=0.05&PM=0.2&L=10000&size=800&precision=64
+func (s *server) snowflake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.allow(w, r) {
+		return
+	}
+	settings, ok := s.settings(w, r)
+	if !ok {
+		return
+	}
+
+	// take a simulation slot, don't queue when the server is full
+	select {
+	case s.running <- struct{}{}:
+		defer func() { <-s.running }()
+	default:
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "too many simulations running, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// a client that goes away frees its slot right away
+	ctx, cancel := s.context(r.Context())
+	defer cancel()
+	state := new_state(settings)
+	if err := run_context(ctx, state, nil); err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, fmt.Sprintf("the simulation took longer than the limit of %s", s.limits.Timeout), http.StatusServiceUnavailable)
+		case ctx.Err() != nil:
+			log.Printf("snowflake for %s stopped at iteration %d: %v", r.RemoteAddr, state.Iteration, err)
+		default:
+			// the settings blew the simulation up
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+	coldness_matrix := state.values()
+	img, err := render_settings_context(ctx, settings, &coldness_matrix)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, fmt.Sprintf("rendering took longer than the limit of %s", s.limits.Timeout), http.StatusServiceUnavailable)
+		} else {
+			log.Printf("snowflake for %s stopped while rendering: %v", r.RemoteAddr, err)
+		}
+		return
+	}
+
+	if s.store != "" {
+		name := in_prefix(s.store, default_filename(settings))
+		if err := save_image_context(ctx, name, img, state.metadata()); err != nil {
+			log.Printf("storing snowflake for %s: %v", r.RemoteAddr, err)
+		} else {
+			w.Header().Set("Content-Location", name)
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	if err := write_png_context(ctx, w, img, state.metadata()); err != nil {
+		log.Printf("encoding snowflake for %s: %v", r.RemoteAddr, err)
+	}
+}
+
+// the context of a simulation, cancelled with parent or when it runs into the -timeout
+func (s *server) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.limits.Timeout > 0 {
+		return context.WithTimeout(parent, s.limits.Timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// rate limits per client before doing any work, writes the error if the client has to wait
+func (s *server) allow(w http.ResponseWriter, r *http.Request) bool {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if ok, wait := s.clients.allow(client, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, fmt.Sprintf("rate limit exceeded, retry in %s", wait.Round(time.Second)), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// reads the settings from the query and rejects simulations that are too heavy
+func (s *server) settings(w http.ResponseWriter, r *http.Request) (Settings, bool) {
+	settings, err := settings_from_query(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return settings, false
+	}
+	if err := s.check_limits(settings); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return settings, false
+	}
+	return settings, true
+}
+
+// an error for settings larger than -max-size or -max-iterations
+func (s *server) check_limits(settings Settings) error {
+	if settings.Size > s.limits.MaxSize || settings.Height > s.limits.MaxSize {
+		return fmt.Errorf("size %s is larger than the limit %d", settings.dimensions(), s.limits.MaxSize)
+	}
+	if settings.L > s.limits.MaxIterations {
+		return fmt.Errorf("L %d is larger than the limit %d", settings.L, s.limits.MaxIterations)
+	}
+	return nil
+}
+
+// reads the simulation parameters from the url, missing ones get the README defaults
+func settings_from_query(r *http.Request) (Settings, error) {
+	settings := default_settings()
+	query := r.URL.Query()
+
+	floats := map[string]*float64{"A": &settings.A, "B": &settings.B, "Y": &settings.Y, "PP": &settings.PP, "PM": &settings.PM}
+	if err := parse_floats(r, floats); err != nil {
+		return settings, err
+	}
+
+	if query.Has("L") {
+		v, err := strconv.ParseInt(query.Get("L"), 10, 64)
+		if err != nil {
+			return settings, fmt.Errorf("bad value for L: %q", query.Get("L"))
+		}
+		settings.L = v
+	}
+
+	ints := map[string]*int{"size": &settings.Size, "height": &settings.Height, "precision": &settings.Precision}
+	for name, value := range ints {
+		if query.Has(name) {
+			v, err := strconv.Atoi(query.Get(name))
+			if err != nil {
+				return settings, fmt.Errorf("bad value for %s: %q", name, query.Get(name))
+			}
+			*value = v
+		}
+	}
+
+	return settings, settings.check()
+}
+
+// token bucket per client, keyed by remote ip
+type rate_limiter struct {
+	mutex   sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func new_rate_limiter(per_minute float64, burst int) *rate_limiter {
+	return &rate_limiter{
+		rate:    per_minute / 60.0,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+	}
+}
+
+// takes a token for the client, if there are none left it returns how long to wait for the next one
+func (limiter *rate_limiter) allow(client string, now time.Time) (bool, time.Duration) {
+	// a rate of zero turns the limit off
+	if limiter.rate <= 0 {
+		return true, 0
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	b, ok := limiter.buckets[client]
+	if !ok {
+		limiter.forget(now)
+		b = &bucket{tokens: limiter.burst, last: now}
+		limiter.buckets[client] = b
+	}
+
+	// refill for the time that passed since the last request
+	b.tokens = math.Min(limiter.burst, b.tokens+now.Sub(b.last).Seconds()*limiter.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limiter.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// drops clients whose bucket would be full again, keeps the map from growing forever
+func (limiter *rate_limiter) forget(now time.Time) {
+	if len(limiter.buckets) < 10000 {
+		return
+	}
+	for client, b := range limiter.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*limiter.rate >= limiter.burst {
+			delete(limiter.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "os"
+
+// there are no user signals on this platform, the simulation is never paused
+func pause_signals() (pause, resume <-chan os.Signal) {
+	return nil, nil
+}
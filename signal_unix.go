@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SIGUSR1 pauses the simulation and SIGUSR2 lets it continue
+func pause_signals() (pause, resume <-chan os.Signal) {
+	pause_channel := make(chan os.Signal, 1)
+	signal.Notify(pause_channel, syscall.SIGUSR1)
+	resume_channel := make(chan os.Signal, 1)
+	signal.Notify(resume_channel, syscall.SIGUSR2)
+	return pause_channel, resume_channel
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// note:
+// find-similar looks for the flakes in a directory that look most like a reference, every result and
+// state file under it is compared. The ice is the brightest part of an image, from the brightest pixel
+// down by a tenth of the range, so it works on heatmaps and colored results alike. Every flake gets a
+// fingerprint of its ice, cropped to the circle around it from its middle so the size of the image and
+// of the flake don't matter:
+//
+//   hash    a 16 x 16 thumbnail with a bit for every cell that has more ice than the mean, what the flake
+//           looks like
+//   rings   the share of ice in 16 rings from the middle out to the circle, what it's made of, and the
+//           same however the flake is rotated
+//
+// The distance is the mean of the share of hash bits that differ and the mean difference of the rings,
+// 0 for the same flake. Flakes are often rotated, so the reference is fingerprinted turned by every
+// rotation_step degrees up to 60, where the six arms look the same again, and the closest one counts. Fingerprints are kept in fingerprints.json in the directory and only computed
+// again for files that changed.
+
+const (
+	fingerprint_cells = 16
+	fingerprint_rings = 16
+	fingerprint_cache = "fingerprints.json"
+	rotation_step     = 5
+	// changes when the fingerprints do, so old caches are computed again
+	fingerprint_version = 1
+)
+
+type Fingerprint struct {
+	Hash  [fingerprint_cells * fingerprint_cells / 64]uint64 `json:"hash"`
+	Rings [fingerprint_rings]float64                         `json:"rings"`
+}
+
+type fingerprint_file struct {
+	Version int                           `json:"version"`
+	Files   map[string]cached_fingerprint `json:"files"` // by the path in the directory
+}
+
+// a fingerprint and the file it was taken from when it was
+type cached_fingerprint struct {
+	Size        int64       `json:"size"`
+	Modified    time.Time   `json:"modified"`
+	Fingerprint Fingerprint `json:"fingerprint"`
+}
+
+func fingerprint(img image.Image) Fingerprint {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	values := make([]float64, width*height)
+	low, high := math.Inf(1), math.Inf(-1)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := float64(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y)
+			values[y*width+x] = v
+			low, high = math.Min(low, v), math.Max(high, v)
+		}
+	}
+	threshold := high - (high-low)/10
+	ice := func(x, y int) bool {
+		return x >= 0 && x < width && y >= 0 && y < height && values[y*width+x] >= threshold
+	}
+
+	// the circle around the ice from its middle
+	var f Fingerprint
+	var cx, cy float64
+	count := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if ice(x, y) {
+				cx, cy = cx+float64(x), cy+float64(y)
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return f
+	}
+	cx, cy = cx/float64(count), cy/float64(count)
+	radius := 0.0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if ice(x, y) {
+				radius = math.Max(radius, math.Hypot(float64(x)-cx, float64(y)-cy))
+			}
+		}
+	}
+	radius++
+
+	// the square around the circle
+	var cells [fingerprint_cells * fingerprint_cells]float64
+	var ring_ice, ring_all [fingerprint_rings]float64
+	x0, y0, side := int(math.Floor(cx-radius)), int(math.Floor(cy-radius)), int(math.Ceil(2*radius))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			distance := math.Hypot(float64(x0+x)-cx, float64(y0+y)-cy) / radius
+			if distance >= 1 {
+				continue
+			}
+			ring := int(distance * fingerprint_rings)
+			ring_all[ring]++
+			if ice(x0+x, y0+y) {
+				ring_ice[ring]++
+				cells[min_int(y*fingerprint_cells/side, fingerprint_cells-1)*fingerprint_cells+min_int(x*fingerprint_cells/side, fingerprint_cells-1)]++
+			}
+		}
+	}
+	mean := 0.0
+	for _, c := range cells {
+		mean += c / float64(len(cells))
+	}
+	for k, c := range cells {
+		if c > mean {
+			f.Hash[k/64] |= 1 << (k % 64)
+		}
+	}
+	for k := range f.Rings {
+		if ring_all[k] > 0 {
+			f.Rings[k] = ring_ice[k] / ring_all[k]
+		}
+	}
+	return f
+}
+
+func fingerprint_distance(a, b Fingerprint) float64 {
+	differ := 0
+	for k := range a.Hash {
+		differ += bits.OnesCount64(a.Hash[k] ^ b.Hash[k])
+	}
+	rings := 0.0
+	for k := range a.Rings {
+		rings += math.Abs(a.Rings[k]-b.Rings[k]) / fingerprint_rings
+	}
+	return (float64(differ)/(fingerprint_cells*fingerprint_cells) + rings) / 2
+}
+
+// the fingerprints of the reference turned from 0 to 60 degrees
+func rotated_fingerprints(img image.Image) []Fingerprint {
+	all := []Fingerprint{fingerprint(img)}
+	for degrees := rotation_step; degrees < 60; degrees += rotation_step {
+		all = append(all, fingerprint(transform.Rotate(img, float64(degrees), &transform.RotationOptions{ResizeBounds: true})))
+	}
+	return all
+}
+
+// the distance to the closest rotation of the reference
+func closest_distance(references []Fingerprint, f Fingerprint) float64 {
+	closest := math.Inf(1)
+	for _, reference := range references {
+		closest = math.Min(closest, fingerprint_distance(reference, f))
+	}
+	return closest
+}
+
+func min_int(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// results and states, the files find-similar compares
+func is_flake_file(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png", ".jpg", ".jpeg", ".snow":
+		return true
+	}
+	return false
+}
+
+// snow find-similar [-n 10] ref.png dir/
+func find_similar(args []string) {
+	flags := flag.NewFlagSet("find-similar", flag.ExitOnError)
+	n := flags.Int("n", 10, "matches to print")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow find-similar [flags] reference.png|reference.snow directory\n\n")
+		fmt.Fprintf(flags.Output(), "prints the closest flakes in the directory and everything under it, closest first\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	reference, dir := flags.Arg(0), flags.Arg(1)
+
+	img, err := load_layer(reference)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	wanted := rotated_fingerprints(img)
+
+	var files []string
+	self, _ := filepath.Abs(reference)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if absolute, _ := filepath.Abs(path); !info.IsDir() && is_flake_file(path) && absolute != self {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// fingerprints of files that didn't change since the last search are taken from the cache
+	cache_file := filepath.Join(dir, fingerprint_cache)
+	var cache fingerprint_file
+	if data, err := os.ReadFile(cache_file); err == nil {
+		if err := json.Unmarshal(data, &cache); err != nil {
+			fmt.Fprintf(os.Stderr, "ignoring %s: %v\n", cache_file, err)
+		}
+	}
+	if cache.Version != fingerprint_version {
+		cache.Files = nil
+	}
+	updated := make(map[string]cached_fingerprint, len(files))
+	var todo []string
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		key, _ := filepath.Rel(dir, path)
+		if c, ok := cache.Files[key]; ok && c.Size == info.Size() && c.Modified.Equal(info.ModTime()) {
+			updated[key] = c
+		} else {
+			todo = append(todo, path)
+		}
+	}
+
+	next := make(chan string)
+	var wait sync.WaitGroup
+	var mutex sync.Mutex // for updated and the progress
+	done := 0
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for path := range next {
+				info, err := os.Stat(path)
+				var img image.Image
+				if err == nil {
+					img, err = load_layer(path)
+				}
+				var f Fingerprint
+				if err == nil {
+					f = fingerprint(img)
+				}
+				key, _ := filepath.Rel(dir, path)
+
+				mutex.Lock()
+				done++
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "\nskipping %s: %v\n", path, err)
+				} else {
+					updated[key] = cached_fingerprint{info.Size(), info.ModTime(), f}
+				}
+				fmt.Fprintf(os.Stderr, "\rfingerprints:\t %d / %d", done, len(todo))
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, path := range todo {
+		next <- path
+	}
+	close(next)
+	wait.Wait()
+	if len(todo) > 0 {
+		fmt.Fprintln(os.Stderr)
+		if data, err := json.Marshal(fingerprint_file{fingerprint_version, updated}); err == nil {
+			if err := os.WriteFile(cache_file, data, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to save fingerprints:", err)
+			}
+		}
+	}
+
+	type match struct {
+		path     string
+		distance float64
+	}
+	matches := make([]match, 0, len(updated))
+	for key, c := range updated {
+		matches = append(matches, match{filepath.Join(dir, key), closest_distance(wanted, c.Fingerprint)})
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].distance != matches[b].distance {
+			return matches[a].distance < matches[b].distance
+		}
+		return matches[a].path < matches[b].path
+	})
+	if len(matches) > *n {
+		matches = matches[:*n]
+	}
+	for _, m := range matches {
+		fmt.Printf("%.4f\t %s\n", m.distance, m.path)
+	}
+}
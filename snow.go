@@ -1,110 +1,2295 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"image"
-	"image/color"
+	"io"
 	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/anthonynsimon/bild/adjust"
-	"github.com/anthonynsimon/bild/imgio"
-	"github.com/anthonynsimon/bild/transform"
+	"github.com/aquilax/go-perlin"
+)
+
+// default matrix size, this decides the size of the image
+const size int = 800
+
+// note:
+// I use a 2 dimentional matrix and treats it as a hexagonial grid.
+//
+// X X X X X X X X X X X X
+// X X X X . . . . . . . X
+// X X X . . . . . . . . X
+// X X . . . N N . . . . X
+// X . . . N O N . . . . X
+// X . . . N N . . . . X X
+// X . . . . . . . . X X X
+// X . . . . . . . X X X X
+// X X X X X X X X X X X X
+//
+// where X is out of bound, O is is a frozen hexagon and N it's neighbours.
+// The matrix indexes are axial coordinates, render() turns them into cartesian ones with one pixel
+// between the hexagons, every row is half a hexagon further right than the one above and sqrt(3)/2
+// pixels lower. The pixels take the values between the hexagons bilinear.
+
+// coldness values are stored as float64, or float32 to save memory
+type Real interface {
+	~float32 | ~float64
+}
+
+// values a grid can hold, floats or fixed point numbers (see fixed.go)
+type Value interface {
+	Real | Fixed
+}
+
+type Grid[T Value] [][]T
+type Matrix = Grid[float64]
+type Matrix32 = Grid[float32]
+type Mask [][]uint8
+
+// states the mask can have
+const (
+	receptive uint8 = iota
+	non_receptive
+	out_of_bound
+	obstacle // never freezes, see obstacles.go
+)
+
+// parameters for one simulation, see README for what they do
+type Settings struct {
+	A, B, Y, PP, PM float64
+	L               int64
+	Size            int    `json:"size"`
+	Height          int    `json:"height,omitempty"`    // rows of a rectangular grid, Size is the width then, square when 0
+	Crop            string `json:"crop,omitempty"`      // "pad" renders all of a rectangular grid and lets it grow into the corners, see crop.go
+	Precision       int    `json:"precision,omitempty"` // 64 (the default) or 32 bits per value
+	Fixed           bool   `json:"fixed,omitempty"`     // Q32.32 fixed point values, see fixed.go
+
+	SeedPos   *[2]int `json:"seed_pos,omitempty"`   // cell of the first frozen hexagon, the middle by default
+	SeedValue float64 `json:"seed_value,omitempty"` // initial coldness of the seed, 1.0 when not set
+	SeedText  string  `json:"seed_text,omitempty"`  // letters that start frozen instead of the seed, see seedtext.go
+	Font      string  `json:"font,omitempty"`       // .ttf or .otf file of the seed text, the bold go font when not set
+
+	BEdge      *float64 `json:"B_edge,omitempty"`     // background level at the border, B goes over to it from the middle
+	Background string   `json:"background,omitempty"` // "radial:inner,outer" replaces B with a radial gradient
+
+	// fractal noise, octaves of Perlin noise are added with lacunarity times the frequency and gain
+	// times the amplitude of the previous one. One octave by default, the other two default to 2 and 0.5
+	Octaves    int     `json:"octaves,omitempty"`
+	Lacunarity float64 `json:"lacunarity,omitempty"`
+	Gain       float64 `json:"gain,omitempty"`
+
+	MaxSize int `json:"max_size,omitempty"` // the grid grows up to this size when the flake gets near the border, see grow.go
+
+	Tile  bool `json:"tile,omitempty"`  // the matrix wraps around and renders as a seamless tile, see tile.go
+	Seeds int  `json:"seeds,omitempty"` // flakes growing in a tile, 1 when not set
+
+	// "laplacian" diffuses the vapor with the diffusion coefficient in sub-steps, see diffusion.go,
+	// Reiter's averaging when not set
+	Solver    string  `json:"solver,omitempty"`
+	Diffusion float64 `json:"diffusion,omitempty"` // 1/12 when not set
+	Substeps  int     `json:"substeps,omitempty"`  // 1 when not set
+
+	// latent heat a freezing hexagon gives off, 1 stops the freezing around it, see heat.go
+	Heat    float64 `json:"heat,omitempty"`
+	Cooling float64 `json:"cooling,omitempty"` // part of the heat lost every iteration, 0.02 when not set
+
+	Kinetics *Kinetics `json:"kinetics,omitempty"` // Gravner-Griffeath attachment instead of Y, see kinetics.go
+
+	Smoothing float64 `json:"smoothing,omitempty"` // surface tension from 0 to 1, see surface.go
+	Curvature float64 `json:"curvature,omitempty"` // how much lower the freezing threshold is at tips, see curvature.go
+
+	Summation string `json:"summation,omitempty"` // "kahan" gathers the sums of step() compensated, see summation.go
+
+	Weights *[6]float64 `json:"weights,omitempty"` // the vapor every direction gets in A/12, all 1 when not set, see anisotropy.go
+
+	Sources string `json:"sources,omitempty"` // "x,y,rate;x,y,x2,y2,rate" vapor added after every step, see sources.go
+
+	Obstacles   string `json:"obstacles,omitempty"`   // image with the obstacles the flake grows around in white, see obstacles.go
+	Receptivity string `json:"receptivity,omitempty"` // image that scales Y with its brightness, see receptivity.go
+}
+
+// rows of the grid
+func (settings Settings) height() int {
+	if settings.Height == 0 {
+		return settings.Size
+	}
+	return settings.Height
+}
+
+func (settings Settings) seed_pos() (int, int) {
+	if settings.SeedPos == nil {
+		return settings.Size / 2, settings.height() / 2
+	}
+	return settings.SeedPos[0], settings.SeedPos[1]
+}
+
+func (settings Settings) kahan() bool {
+	return settings.Summation == "kahan"
+}
+
+func (settings Settings) anisotropic() bool {
+	return settings.Weights != nil && *settings.Weights != [6]float64{1, 1, 1, 1, 1, 1}
+}
+
+func (settings Settings) seed_value() float64 {
+	if settings.SeedValue == 0 {
+		return 1.0
+	}
+	return settings.SeedValue
+}
+
+// the settings from the README, a good place to start
+func default_settings() Settings {
+	return Settings{A: 1.0, B: 0.33, Y: 0.0002, PP: 0.05, PM: 0.2, L: 10000, Size: size}
+}
+
+func main() {
+	regen := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "regen":
+			// a normal run with the settings from the image
+			regen = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "batch":
+			batch(os.Args[2:])
+			return
+		case "worker":
+			worker(os.Args[2:])
+			return
+		case "render":
+			render_state(os.Args[2:])
+			return
+		case "diff":
+			diff_states(os.Args[2:])
+			return
+		case "verify":
+			verify(os.Args[2:])
+			return
+		case "rewind":
+			rewind(os.Args[2:])
+			return
+		case "traits":
+			traits_command(os.Args[2:])
+			return
+		case "stats":
+			stats_command(os.Args[2:])
+			return
+		case "drift":
+			drift_command(os.Args[2:])
+			return
+		case "gallery":
+			gallery(os.Args[2:])
+			return
+		case "catalog":
+			catalog_command(os.Args[2:])
+			return
+		case "find-similar":
+			find_similar(os.Args[2:])
+			return
+		case "init":
+			wizard(os.Args[2:])
+			return
+		case "explain":
+			explain(os.Args[2:])
+			return
+		case "atlas":
+			atlas(os.Args[2:])
+			return
+		case "layer":
+			layer_command(os.Args[2:])
+			return
+		case "melt":
+			melt_command(os.Args[2:])
+			return
+		case "watch":
+			watch_command(os.Args[2:])
+			return
+		case "perform":
+			perform(os.Args[2:])
+			return
+		case "gui":
+			gui(os.Args[2:])
+			return
+		case "bot":
+			bot_command(os.Args[2:])
+			return
+		case "bench":
+			bench(os.Args[2:])
+			return
+		}
+	}
+
+	out := flag.String("out", "", "output file, - streams the png to stdout, s3://bucket/key and gs://bucket/key upload it, a name ending in / is a directory or prefix (default is snowflakes/<parameters>.png)")
+	workers := flag.String("workers", "", "comma separated worker addresses, runs the simulation distributed over them")
+	threads := flag.Int("threads", runtime.NumCPU(), "threads the steps are done on, the flake is the same on any number")
+	checkpoint := flag.String("checkpoint", "", "state file to save the simulation to while it runs")
+	checkpoint_every := flag.Int64("checkpoint-every", 1000, "iterations between checkpoints")
+	live := flag.String("live", "", "render the growing flake to this file every -live-every iterations, to watch it in an image viewer")
+	live_every := flag.Int64("live-every", 100, "iterations between the renders of -live")
+	watch_file := flag.String("watch", "", "grow the settings in this .yaml or .json file to a preview and start over every time it's saved, see watch.go")
+	audio := flag.String("audio", "", "also save a midi soundtrack of the growth to this file")
+	audio_speed := flag.Float64("audio-speed", 500, "iterations per second in the soundtrack")
+	save_traits_file := flag.Bool("traits", false, "also save traits like the arm count and symmetry as json next to the result")
+	save_stats := flag.Bool("stats", false, "also save measurements of the arms, side branches and perimeter as json next to the result")
+	catalog := flag.String("catalog", "", "add the flake to this SQLite catalog, like "+default_catalog)
+	habit := flag.Bool("habit", false, "sort the flake into a habit like fern or sectored plate, saved in the metadata and the name of the result")
+	save_timeline := flag.Bool("timeline", false, "also save the iteration every hexagon froze in as json next to the result")
+	show_timings := flag.Bool("timings", false, "print how long the phases of the run took, see timing.go")
+	save_timings := flag.Bool("timings-json", false, "also save the timings of -timings as json next to the result")
+	notify_url := flag.String("notify-url", "", "post a json message to this url when the run is done or failed, see notify.go")
+	print_code := flag.Bool("code", false, "only print the shareable code of the settings, without running the simulation")
+	from_code := flag.String("from-code", "", "take the settings from a code made with -code, replaces the parameters")
+	from_image := flag.String("from-image", "", "take the settings and iterations from the metadata of a result, replaces the parameters")
+	image_size := flag.Int("size", 0, "pixels of the longer side of the result, the same flake rendered larger or smaller (default is a pixel per hexagon)")
+	history_length := flag.Int("history", 0, "snapshots to keep for rewinding, saved as a .history file next to the result")
+	history_every := flag.Int64("history-every", 500, "iterations between history snapshots")
+	resume := flag.String("resume", "", "state file to continue a simulation from, replaces the parameters")
+	mmap := flag.String("mmap", "", "directory for memory mapped matrices, for sizes that don't fit in memory")
+	precision := flag.Int("precision", 64, "bits per value, 32 halves the memory use")
+	fixed := flag.Bool("fixed", false, "use fixed point values, the result is bit identical on every platform")
+	seed_pos := flag.String("seed-pos", "", "x,y cell of the first frozen hexagon (default is the middle)")
+	seed_value := flag.Float64("seed-value", 1.0, "initial coldness of the seed, above 1.0 gives a stronger pulse")
+	octaves := flag.Int("octaves", 1, "octaves of perlin noise in the background")
+	lacunarity := flag.Float64("lacunarity", 2, "frequency multiplier between octaves")
+	gain := flag.Float64("gain", 0.5, "amplitude multiplier between octaves")
+	solver := flag.String("solver", "reiter", "how the vapor diffuses, reiter (averaging) or laplacian (diffusion equation)")
+	diffusion := flag.Float64("diffusion", 0, "diffusion coefficient of -solver laplacian (default 1/12, like reiter)")
+	substeps := flag.Int("substeps", 1, "steps of -solver laplacian for every iteration, diffusion / substeps can be at most 1/6")
+	heat := flag.Float64("heat", 0, "latent heat a freezing hexagon gives off, it slows the freezing around it, 1 stops it")
+	cooling := flag.Float64("cooling", 0, "part of the latent heat lost every iteration (default 0.02)")
+	smoothing := flag.Float64("smoothing", 0, "surface tension from 0 to 1, slows the growth in front of spikes and tips and speeds it up in notches")
+	curvature := flag.Float64("curvature", 0, "from 0 to 1, how much lower the freezing threshold is in front of sharp tips than 1, flat sides get half of it")
+	weights := flag.String("weights", "", "w1,...,w6 vapor going right, up right, up left, left, down left and down right, in A/12")
+	anisotropy := flag.Float64("anisotropy", 0, "from -2 to 1, the horizontal axis gets 1-a of the vapor and the rest 1+a/2, a needle above 0 and an X below")
+	seed_text := flag.String("seed-text", "", "text that starts frozen instead of the seed, the flake grows out of the letters, \\n starts a new line")
+	font_file := flag.String("font", "", ".ttf or .otf font of -seed-text (default is go bold)")
+	obstacles := flag.String("obstacles", "", "image stretched over the result, the flake grows around its white parts")
+	receptivity := flag.String("receptivity", "", "image stretched over the result, its brightness scales Y, the flake grows dense where it is white and hardly where it is black")
+	var sources []string
+	source_flag(flag.CommandLine, &sources)
+	summation := flag.String("summation", "naive", "how the vapor of every hexagon is added up, naive or kahan (compensated, for -precision 32)")
+	kinetics := flag.Bool("kinetics", false, "grow with the attachment kinetics of gravner and griffeath instead of Y, B is the vapor density")
+	initial := default_kinetics()
+	beta := flag.Float64("beta", initial.Beta, "boundary mass a tip needs to attach with -kinetics")
+	alpha := flag.Float64("alpha", initial.Alpha, "boundary mass enough to attach with one frozen neighbour less than -attach-neighbours when the vapor around is below -theta")
+	theta := flag.Float64("theta", initial.Theta, "vapor of a hexagon and its neighbours below which -alpha is enough")
+	kappa := flag.Float64("kappa", initial.Kappa, "part of the freezing vapor that becomes crystal mass right away with -kinetics")
+	mu := flag.Float64("mu", initial.Mu, "part of the boundary mass that melts back to vapor every iteration with -kinetics")
+	gamma := flag.Float64("gamma", initial.Gamma, "part of the crystal mass next to the ice that melts back to vapor every iteration with -kinetics")
+	attach_neighbours := flag.Int("attach-neighbours", 4, "frozen neighbours a hexagon always attaches with, with -kinetics")
+	background := flag.String("background", "uniform", "initial background, uniform (B) or radial:inner,outer")
+	grow := flag.Int("grow", 0, "start with a small grid and let it grow up to this size when the flake gets near the border")
+	fill := flag.Float64("fill", 0, "stop when this fraction of the hexagons inside the border is frozen, L becomes optional and only a limit")
+	duration := flag.Duration("duration", 0, "stop after this much time, L becomes optional and only a limit")
+	social := flag.String("social", "", "render for social media, instagram, twitter or og (link previews)")
+	caption := flag.String("caption", "", "caption below the flake with -social, {settings}, {code} and {date} are filled in")
+	social_background := flag.String("social-background", "", "background color with -social (default is the background of the theme)")
+	theme_name := flag.String("theme", "", "background and colors of the result: "+theme_names())
+	palette_name := flag.String("palette", "", "colors of the result (default gray or the colors of the theme): "+palette_names()+", or a .cube lut, gradient file or picture")
+	palette_from := flag.String("palette-from", "", "take the colors of the result from a png or jpeg, the same as -palette with the picture")
+	check_palette := flag.Bool("palette-check", false, "only show how the palette looks with color vision deficiencies, without running the simulation")
+	creator := flag.String("creator", "", "author saved in the metadata of the result")
+	copyright := flag.String("copyright", "", "copyright notice saved in the metadata of the result")
+	as_sprite := flag.Bool("sprite", false, "save only the flake, cropped with premultiplied alpha, and a json descriptor for game engines")
+	raw := flag.Bool("raw", false, "also save the matrix as it is with a pixel per hexagon next to the result, without moving the hexagons into place")
+	no_shear := flag.Bool("no-shear", false, "save the matrix as it is with a pixel per hexagon as the result, like -raw, instead of moving the hexagons into place")
+	pfm := flag.Bool("pfm", false, "also save the values, mask and temperature of the matrix as 32 bit floats in a pfm next to the result")
+	pbr := flag.Bool("pbr", false, "also save albedo, height, normal and opacity maps for materials next to the result")
+	scene := flag.String("scene", "", "also save the flake as a mesh in a scene with a camera, light and ice material next to the result, gltf (blender) or pov (pov-ray)")
+	pbr_directx := flag.Bool("pbr-directx", false, "directx style normal maps (green is down) like unreal wants")
+	animation_file := flag.String("animation", "", "also save the growth as an animated gif to this file")
+	animation_every := flag.Int64("animation-every", 100, "iterations between the frames of -animation")
+	animation_cells := flag.Int("animation-cells", 0, "take a frame of -animation every time this many more hexagons froze, instead of -animation-every")
+	loop := flag.Bool("loop", false, "play -animation forward and then backward, so it loops without a jump")
+	fps := flag.Float64("fps", 25, "frames a second of -animation, at most 50")
+	animation_duration := flag.Duration("animation-duration", 0, "how long -animation plays, instead of -fps")
+	hold_last := flag.Duration("hold-last", 0, "keep the finished flake of -animation up this much longer")
+	tween := flag.Int("tween", 0, "frames cross-faded in between every two frames of -animation, for smooth growth from few frames")
+	spritesheet := flag.String("spritesheet", "", "also save a sprite sheet of the growth with columns x rows stages, like 8x8")
+	sprite_pot := flag.Bool("sprite-pot", false, "pad sprites to power of two sizes")
+	text := flag.String("text", "", "text to stamp on the result, {settings}, {code} and {date} are filled in and \\n starts a new line")
+	text_position := flag.String("text-position", "bottom-right", "corner of the text, top-left, top-right, bottom-left, bottom-right, top or bottom")
+	text_scale := flag.Int("text-size", 0, "size of the text as a multiple of the 13 pixel font (default depends on the image size)")
+	text_opacity := flag.Float64("text-opacity", 0.8, "opacity of the text, from 0 to 1")
+	text_color := flag.String("text-color", "", "color of the text (default is the color of the flake)")
+	grid := flag.String("grid", "", "width x height of the grid like 1200x600, for banners (default is a square of 800)")
+	crop := flag.String("crop", "", "pad shows the whole slanted matrix of a rectangular -grid and lets the flake grow into its corners, see crop.go (default is the rectangle all the rows cover)")
+	roi_flag := flag.String("roi", "", "x,y,w,h of the flake in cells (pixels at 1:1) to render magnified to the full size instead of all of it")
+	var insets []image.Rectangle
+	inset_flag(flag.CommandLine, &insets)
+	kaleidoscope := flag.Int("kaleidoscope", 0, "make the result 3, 6 or 12-fold symmetric around the seed from one wedge of the flake")
+	mirror := flag.Bool("mirror", false, "mirror the left half of the flake onto the right")
+	rotate := flag.String("rotate", "", "degrees to turn the flake clockwise, or random")
+	render_mode := flag.String("render", "heatmap", "how the flake is colored: "+render_mode_names())
+	equalize_values := flag.Bool("equalize", false, "spread the values over the grays by their rank, so the inside of the ice and the vapor show their structure")
+	normalize_values := flag.Bool("normalize", false, "stretch the values from the darkest to the brightest percent of the hexagons over all grays, so every background looks alike")
+	mask_threshold := flag.Float64("mask-threshold", 1, "the value from which a hexagon is white with -render mask, 1 is everything frozen")
+	stroke_width := flag.Float64("stroke-width", 2, "pixels of the line with -render stroke")
+	stroke_cap := flag.String("stroke-cap", "round", "pen of -render stroke, round or square")
+	stroke_color := flag.String("stroke-color", "", "color of the line with -render stroke (default is the color of the flake)")
+	stroke_background := flag.String("stroke-background", "transparent", "background of -render stroke, transparent or a color")
+	light_flag := flag.String("light", "-1,-1,1.3", "x,y,z towards the light of -render ice and volume, y is down and z towards the camera")
+	absorption := flag.Float64("absorption", 1, "how much red -render volume swallows, more makes the ice bluer and darker")
+	resample := flag.String("resample", "", "how the pixels take their value from the hexagons around them, "+resample_names()+" (default bilinear)")
+	tile := flag.Bool("tile", false, "wrap around at the borders and render a seamless tile for textures")
+	seeds := flag.Int("seeds", 1, "flakes growing in a -tile, spread out evenly")
+	draft := flag.Bool("draft", false, "grow a quick copy of the flake on a small matrix and stretch it to the full size, to try settings")
+	preview_field := flag.Bool("preview-field", false, "only render the initial background, without running the simulation")
+	random := flag.Bool("random", false, "pick A, B, Y, PP and PM from ranges that grow nice flakes, only L is given (default 10000)")
+	random_seed := flag.Int64("random-seed", 0, "seed for -random and -rotate random, the same seed picks the same parameters (default is the time)")
+	ranges := map[string]random_range{}
+	for name, r := range random_ranges {
+		ranges[name] = r
+	}
+	for _, name := range []string{"A", "B", "Y", "PP", "PM"} {
+		name := name
+		r := ranges[name]
+		flag.Func("random-"+name, fmt.Sprintf("low:high range of %s for -random (default %g:%g)", name, r.low, r.high), func(s string) error {
+			r, err := parse_range(s)
+			ranges[name] = r
+			return err
+		})
+	}
+	var b_edge *float64
+	flag.Func("B-edge", "background level at the border, goes over to B in the middle (default is B)", func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		b_edge = &v
+		return err
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: snow [flags] A B Y PP PM L\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       snow [flags] -duration 5m|-fill 0.35 A B Y PP PM [L]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       snow [flags] -resume state.snow [L]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       snow [flags] -from-code XXXX-XXXX [L]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       snow regen flake.png [flags] [L]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       snow [flags] -random [L]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       snow serve|batch|worker|regen|render|diff|verify|rewind|traits|stats|drift|find-similar|catalog|gallery|atlas|layer|melt|watch|perform|gui|bot|bench|explain|init ...\n")
+		flag.PrintDefaults()
+	}
+	storage_flags(flag.CommandLine)
+	flag.Parse()
+	if regen {
+		// the image can come before the flags, snow regen flake.png -size 3200
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		*from_image = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if *palette_from != "" {
+		if *palette_name != "" {
+			fmt.Fprintln(os.Stderr, "-palette-from and -palette both pick the colors, use one of them")
+			os.Exit(2)
+		}
+		if !is_picture(*palette_from) {
+			fmt.Fprintln(os.Stderr, "-palette-from takes a png or jpeg")
+			os.Exit(2)
+		}
+		*palette_name = *palette_from
+	}
+
+	if *watch_file != "" {
+		// the settings come from the file, only the flags of the preview go with it
+		preview, every := watch_out, int64(watch_live_every)
+		var others []string
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "out":
+				preview = *out
+			case "live-every":
+				every = *live_every
+			case "watch", "palette", "palette-from":
+			default:
+				others = append(others, "-"+f.Name)
+			}
+		})
+		if len(others) > 0 || flag.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "-watch takes the settings from %s, only -out, -live-every and -palette go with it\n", *watch_file)
+			os.Exit(2)
+		}
+		watch(*watch_file, preview, every, *palette_name)
+		return
+	}
+
+	if *check_palette {
+		name := *palette_name
+		if name == "" {
+			name = "gray"
+		}
+		p, err := find_palette(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		// a file is named without its directory and extension
+		name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		filename := *out
+		if filename == "" {
+			filename = "snowflakes/palette-" + name + ".png"
+		}
+		sheet := palette_check(name, p, func(format string, a ...interface{}) {
+			fmt.Fprintf(os.Stderr, format, a...)
+		})
+		if err := save_image(filename, sheet, nil); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save palette check:", err)
+			os.Exit(1)
+		}
+		if filename != "-" {
+			fmt.Fprintln(os.Stderr, "saved palette check:\t", filename)
+		}
+		return
+	}
+
+	initialized := time.Now()
+	var state *State
+	draft_scale := 1.0
+	if *resume != "" && *draft {
+		fmt.Fprintln(os.Stderr, "a resumed state can't be drafted, it is as large as it is")
+		os.Exit(2)
+	}
+	if *resume != "" {
+		var err error
+		if state, err = load_state(*resume); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to resume:", err)
+			os.Exit(1)
+		}
+		// an optional L keeps the simulation going for longer than it was started with
+		if flag.NArg() > 0 {
+			state.Settings.L, _ = strconv.ParseInt(flag.Arg(0), 10, 64)
+		}
+	} else {
+		var settings Settings
+		if *from_code != "" {
+			var err error
+			if settings, err = decode_code(*from_code); err != nil {
+				fmt.Fprintln(os.Stderr, "bad -from-code:", err)
+				os.Exit(2)
+			}
+			// an optional L keeps the simulation going for longer than the code says
+			if flag.NArg() > 0 {
+				settings.L, _ = strconv.ParseInt(flag.Arg(0), 10, 64)
+			}
+		} else if *from_image != "" {
+			var err error
+			if settings, err = image_settings(*from_image); err != nil {
+				fmt.Fprintln(os.Stderr, "bad -from-image:", err)
+				os.Exit(2)
+			}
+			// an optional L keeps the simulation going for longer than the result did
+			if flag.NArg() > 0 {
+				settings.L, _ = strconv.ParseInt(flag.Arg(0), 10, 64)
+			}
+		} else {
+			// A, B, Y, PP, PM, L parameters
+			args := flag.Args()
+			grid_width, grid_height := size, 0
+			if *grid != "" {
+				width, height, err := parse_grid(*grid)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "bad -grid:", err)
+					os.Exit(2)
+				}
+				// the rows are slanted, the grid is wider than the image and has more rows
+				grid_width = width
+				if *crop == "pad" {
+					grid_width, grid_height = padded_grid_size(width, height)
+				} else if height != width {
+					grid_width, grid_height = grid_size(width, height)
+				}
+			}
+			if *random {
+				if len(args) > 1 {
+					flag.Usage()
+					os.Exit(2)
+				}
+				seed := *random_seed
+				if seed == 0 {
+					seed = time.Now().UnixNano()
+				}
+				r := random_settings(rand.New(rand.NewSource(seed)), ranges, grid_width, grid_height, 0)
+				picked := strings.Fields(fmt.Sprintf("%g %g %g %g %g", r.A, r.B, r.Y, r.PP, r.PM))
+				args = append(picked, args...)
+				if len(args) == 5 && *duration == 0 && *fill == 0 {
+					args = append(args, "10000")
+				}
+				fmt.Fprintf(os.Stderr, "random:	 %s (-random-seed %d)\n", strings.Join(args, " "), seed)
+			}
+			if len(args) < 6 && !((*duration > 0 || *fill > 0) && len(args) == 5) {
+				flag.Usage()
+				os.Exit(2)
+			}
+			A, _ := strconv.ParseFloat(args[0], 64)
+			B, _ := strconv.ParseFloat(args[1], 64)
+			Y, _ := strconv.ParseFloat(args[2], 64)
+			PP, _ := strconv.ParseFloat(args[3], 64)
+			PM, _ := strconv.ParseFloat(args[4], 64)
+			// without L the time budget or the fill is the only limit
+			L := int64(math.MaxInt64)
+			if len(args) > 5 {
+				L, _ = strconv.ParseInt(args[5], 10, 64)
+			}
+			settings = Settings{
+				A: A, B: B, Y: Y, PP: PP, PM: PM, L: L, Size: grid_width, Height: grid_height,
+				Precision: *precision, Fixed: *fixed,
+				SeedValue: *seed_value, BEdge: b_edge, Background: *background,
+				Octaves: *octaves, Lacunarity: *lacunarity, Gain: *gain,
+				Tile: *tile, Diffusion: *diffusion, Heat: *heat, Cooling: *cooling,
+				Smoothing: *smoothing, Curvature: *curvature,
+			}
+			if *solver != "reiter" {
+				settings.Solver = *solver
+			}
+			if *substeps != 1 {
+				settings.Substeps = *substeps
+			}
+			if *summation != "naive" {
+				settings.Summation = *summation
+			}
+			settings.Sources = strings.Join(sources, ";")
+			settings.Obstacles, settings.Receptivity = *obstacles, *receptivity
+			settings.SeedText, settings.Font = *seed_text, *font_file
+			if *anisotropy != 0 {
+				w := anisotropy_weights(*anisotropy)
+				settings.Weights = &w
+			}
+			if *weights != "" {
+				w, err := parse_weights(*weights)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "bad -weights:", err)
+					os.Exit(2)
+				}
+				settings.Weights = &w
+			}
+			if *kinetics {
+				settings.Kinetics = &Kinetics{Beta: *beta, Alpha: *alpha, Theta: *theta, Kappa: *kappa, Mu: *mu, Gamma: *gamma}
+				if *attach_neighbours != 4 {
+					settings.Kinetics.Neighbours = *attach_neighbours
+				}
+			}
+			if *seeds != 1 {
+				settings.Seeds = *seeds
+			}
+			settings.Crop = *crop
+			if *grow > 0 {
+				settings.Size, settings.MaxSize = grow_start, *grow
+				if settings.MaxSize < settings.Size {
+					settings.Size = settings.MaxSize
+				}
+			}
+			if *seed_pos != "" {
+				x, y, err := parse_pair(*seed_pos)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "bad -seed-pos:", err)
+					os.Exit(2)
+				}
+				settings.SeedPos = &[2]int{x, y}
+			}
+		}
+		if *draft {
+			var err error
+			if settings, draft_scale, err = draft_settings(settings); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+		if err := settings.check(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if *mmap != "" && settings.MaxSize != 0 {
+			fmt.Fprintln(os.Stderr, "memory mapped matrices can't grow")
+			os.Exit(2)
+		}
+		if *mmap != "" {
+			var err error
+			if state, err = new_mapped_state(settings, *mmap); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to map matrices:", err)
+				os.Exit(1)
+			}
+		} else {
+			state = new_state(settings)
+		}
+	}
+	// resumed states are loaded into memory first and then copied to the mapped files
+	if *resume != "" && *mmap != "" {
+		if err := map_state(state, *mmap); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to map matrices:", err)
+			os.Exit(1)
+		}
+	}
+	defer state.close()
+	settings := state.Settings
+
+	if *print_code {
+		code, err := encode_code(settings)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(code)
+		return
+	}
+
+	// keep stdout clean for the image when streaming
+	console := io.Writer(os.Stdout)
+	if *out == "-" {
+		console = os.Stderr
+	}
+
+	iterations := strconv.FormatInt(settings.L, 10)
+	if settings.L == math.MaxInt64 {
+		iterations = "unlimited"
+	}
+	fmt.Fprintf(console, "settings:\t A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%s size=%s\n",
+		settings.A, settings.B, settings.Y, settings.PP, settings.PM, iterations, settings.dimensions())
+	if *duration > 0 {
+		fmt.Fprintf(console, "time budget:\t %s\n", *duration)
+	}
+	if *fill < 0 || *fill >= 1 {
+		fmt.Fprintln(os.Stderr, "-fill must be between 0 and 1")
+		os.Exit(2)
+	}
+	colors, err := pick_theme(*theme_name, *palette_name, *social_background)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if _, ok := social_presets[*social]; *social != "" && !ok {
+		fmt.Fprintf(os.Stderr, "unknown -social preset %q, use instagram, twitter or og\n", *social)
+		os.Exit(2)
+	}
+	ink := colors.ink()
+	if *text_color != "" {
+		if ink, err = parse_color(*text_color); err != nil {
+			fmt.Fprintln(os.Stderr, "bad -text-color:", err)
+			os.Exit(2)
+		}
+	}
+	if !valid_text_position(*text_position) {
+		fmt.Fprintf(os.Stderr, "unknown -text-position %q, use %s\n", *text_position, strings.Join(text_positions, ", "))
+		os.Exit(2)
+	}
+	sheet_columns, sheet_rows := 0, 0
+	if *spritesheet != "" {
+		if sheet_columns, sheet_rows, err = parse_sheet_size(*spritesheet); err != nil {
+			fmt.Fprintln(os.Stderr, "bad -spritesheet:", err)
+			os.Exit(2)
+		}
+	}
+	if state.Settings.Tile && (*as_sprite || *spritesheet != "" || *pbr || *scene != "") {
+		fmt.Fprintln(os.Stderr, "tiles can't be sprites, pbr maps or scenes")
+		os.Exit(2)
+	}
+	if _, ok := scene_formats[*scene]; *scene != "" && !ok {
+		fmt.Fprintf(os.Stderr, "unknown -scene %q, use gltf or pov\n", *scene)
+		os.Exit(2)
+	}
+	var roi *image.Rectangle
+	if *roi_flag != "" {
+		r, err := parse_roi(*roi_flag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bad -roi:", err)
+			os.Exit(2)
+		}
+		if state.Settings.Tile || *as_sprite {
+			fmt.Fprintln(os.Stderr, "tiles and sprites can't have a -roi")
+			os.Exit(2)
+		}
+		roi = &r
+	}
+	var kernel *resample_kernel
+	if *resample != "" {
+		k, err := parse_resample(*resample)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if state.Settings.Tile {
+			fmt.Fprintln(os.Stderr, "tiles are always resampled bilinear")
+			os.Exit(2)
+		}
+		kernel = &k
+	}
+	if *kaleidoscope != 0 && !valid_fold(*kaleidoscope) {
+		fmt.Fprintln(os.Stderr, "-kaleidoscope is 3, 6 or 12")
+		os.Exit(2)
+	}
+	if *kaleidoscope != 0 && *mirror {
+		fmt.Fprintln(os.Stderr, "-mirror and -kaleidoscope can't be used together, -kaleidoscope 12 is mirrored already")
+		os.Exit(2)
+	}
+	if (*kaleidoscope != 0 || *mirror) && state.Settings.Tile {
+		fmt.Fprintln(os.Stderr, "tiles can't have a -kaleidoscope or -mirror")
+		os.Exit(2)
+	}
+	rotation := 0.0
+	if *rotate != "" {
+		seed := *random_seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		if rotation, err = parse_rotation(*rotate, rand.New(rand.NewSource(seed))); err != nil {
+			fmt.Fprintln(os.Stderr, "bad -rotate:", err)
+			os.Exit(2)
+		}
+		if state.Settings.Tile || *as_sprite || roi != nil || len(insets) > 0 {
+			fmt.Fprintln(os.Stderr, "tiles, sprites, -roi and -inset can't be rotated")
+			os.Exit(2)
+		}
+		if strings.EqualFold(*rotate, "random") {
+			fmt.Fprintf(console, "rotate:\t\t %g degrees\n", rotation)
+		}
+	}
+	if !valid_render_mode(*render_mode) {
+		fmt.Fprintf(os.Stderr, "unknown -render %q, use %s\n", *render_mode, render_mode_names())
+		os.Exit(2)
+	}
+	if *render_mode != "heatmap" && (state.Settings.Tile || *as_sprite || *social != "" || len(insets) > 0 || *palette_name != "") {
+		fmt.Fprintln(os.Stderr, "tiles, sprites, -social, -inset and -palette can only be rendered as a heatmap")
+		os.Exit(2)
+	}
+	light, err := parse_light(*light_flag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bad -light:", err)
+		os.Exit(2)
+	}
+	pen, err := parse_stroke(*stroke_width, *stroke_cap, *stroke_color, *stroke_background, colors.ink())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *mask_threshold <= 0 {
+		fmt.Fprintln(os.Stderr, "-mask-threshold must be more than 0")
+		os.Exit(2)
+	}
+	if *absorption < 0 {
+		fmt.Fprintln(os.Stderr, "-absorption can't be less than 0")
+		os.Exit(2)
+	}
+	if len(insets) > 0 && (state.Settings.Tile || *as_sprite || *social != "" || roi != nil) {
+		fmt.Fprintln(os.Stderr, "tiles, sprites, -social and -roi can't have insets")
+		os.Exit(2)
+	}
+	if *as_sprite && (*social != "" || *text != "") {
+		fmt.Fprintln(os.Stderr, "sprites can't have -social frames or -text")
+		os.Exit(2)
+	}
+	if *image_size < 0 || (*image_size > 0 && (state.Settings.Tile || *as_sprite || len(insets) > 0)) {
+		fmt.Fprintln(os.Stderr, "-size must be more than 0, tiles, sprites and -inset can't have one")
+		os.Exit(2)
+	}
+	if (*equalize_values || *normalize_values) && (*render_mode != "heatmap" || *as_sprite) {
+		fmt.Fprintln(os.Stderr, "-equalize and -normalize only work with heatmaps and can't make sprites")
+		os.Exit(2)
+	}
+	if *equalize_values && *normalize_values {
+		fmt.Fprintln(os.Stderr, "-equalize and -normalize both spread the values, use one of them")
+		os.Exit(2)
+	}
+	if *no_shear && (state.Settings.Tile || *as_sprite || *social != "" || roi != nil || len(insets) > 0 || kernel != nil || rotation != 0 || *image_size > 0 || *render_mode != "heatmap" || *raw) {
+		fmt.Fprintln(os.Stderr, "-no-shear saves the matrix as it is, tiles, sprites, -social, -roi, -inset, -resample, -rotate, -size, -render and -raw can't go with it")
+		os.Exit(2)
+	}
+	if state.Settings.padded() && (*as_sprite || *spritesheet != "" || *pbr || *scene != "" || roi != nil || len(insets) > 0 || kernel != nil || rotation != 0 || *image_size > 0 || *equalize_values || *normalize_values) {
+		fmt.Fprintln(os.Stderr, "a padded grid is rendered whole, sprites, sprite sheets, pbr maps, scenes, -roi, -inset, -resample, -rotate, -size, -equalize and -normalize can't go with it")
+		os.Exit(2)
+	}
+	// the hexagons -fill counts against, again whenever a growing grid grew
+	cells, cells_of := state.cells(), len(state.Mask)
+	if *resume != "" {
+		fmt.Fprintf(console, "resuming:\t %s at iteration %d\n", *resume, state.Iteration)
+	}
+
+	if *preview_field {
+		filename := *out
+		if filename == "" {
+			filename = strings.TrimSuffix(default_filename(settings), ".png") + "-field.png"
+		}
+		low, high, field := background_field(state)
+		if err := save(filename, &field, nil); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save background:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(console, "background:\t %.4f (black) to %.4f (white)\n", low, high)
+		if filename != "-" {
+			fmt.Fprintln(console, "saved background:\t", filename)
+		}
+		return
+	}
+
+	// a directory or prefix gets the default name
+	prefix := ""
+	if strings.HasSuffix(*out, "/") {
+		prefix, *out = *out, ""
+	}
+	filename := *out
+	if filename == "" {
+		filename = default_filename(settings)
+	}
+	if *as_sprite && filename != "-" && strings.ToLower(filepath.Ext(filename)) != ".png" {
+		fmt.Fprintln(os.Stderr, "sprites are always png")
+		os.Exit(2)
+	}
+	// uploads that can't work fail before the simulation
+	if is_object_url(*checkpoint) || is_object_url(*live) {
+		fmt.Fprintln(os.Stderr, "checkpoints and live images are rewritten while the flake grows, they can't be uploaded")
+		os.Exit(2)
+	}
+	for _, name := range []string{in_prefix(prefix, filename), *animation_file, *audio} {
+		if err := check_output(name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	var sound *soundtrack
+	if *audio != "" {
+		if ext := strings.ToLower(filepath.Ext(*audio)); ext != ".mid" && ext != ".midi" {
+			fmt.Fprintln(os.Stderr, "only midi (.mid) soundtracks are supported")
+			os.Exit(2)
+		}
+		sound = new_soundtrack(*audio_speed)
+	}
+	var frozen *timeline
+	if *save_timeline || *spritesheet != "" {
+		frozen = new_timeline(state)
+	}
+	if *live != "" && (*live == "-" || *live_every < 1) {
+		fmt.Fprintln(os.Stderr, "-live needs a file to overwrite and -live-every must be at least 1")
+		os.Exit(2)
+	}
+	var anim *animation
+	if *animation_file != "" {
+		if strings.ToLower(filepath.Ext(*animation_file)) != ".gif" {
+			fmt.Fprintln(os.Stderr, "animations are saved as gif (.gif)")
+			os.Exit(2)
+		}
+		if *animation_every < 1 || *animation_cells < 0 || *tween < 0 {
+			fmt.Fprintln(os.Stderr, "-animation-every must be at least 1 and -animation-cells and -tween can't be less than 0")
+			os.Exit(2)
+		}
+		anim = new_animation(*animation_every, *animation_cells, *tween, *loop)
+		defer anim.finish()
+		if err := anim.timing(*fps, *animation_duration, *hold_last); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	var snapshots *history
+	if *history_length > 0 && *history_every > 0 {
+		snapshots = new_history(*history_length, *history_every)
+	}
+
+	// run simulation loop
+	start := time.Now()
+	if *show_timings || *save_timings {
+		state.timings = &Timings{Init: start.Sub(initialized)}
+	}
+	paused, resumed := pause_signals()
+	deadline := time.Now().Add(*duration)
+	progress := func(iteration int64) {
+		switch {
+		case *duration > 0:
+			fmt.Fprintf(console, "\rsimulation:\t %d, %s left ", iteration, time.Until(deadline).Round(time.Second))
+		case settings.L == math.MaxInt64:
+			fmt.Fprintf(console, "\rsimulation:\t %d", iteration)
+		default:
+			fmt.Fprintf(console, "\rsimulation:\t %d / %d", iteration, settings.L)
+		}
+		// stopping is lowering L to where the simulation is
+		if *duration > 0 && time.Now().After(deadline) {
+			state.Settings.L = iteration
+		}
+		if *fill > 0 {
+			if len(state.Mask) != cells_of {
+				cells, cells_of = state.cells(), len(state.Mask)
+			}
+			frozen := float64(state.frozen()) / float64(cells)
+			fmt.Fprintf(console, " %.1f%% frozen ", frozen*100)
+			if frozen >= *fill {
+				state.Settings.L = iteration
+			}
+		}
+		select {
+		case <-paused:
+			// the time budget is for simulating, the pause doesn't use it up
+			pause_started := time.Now()
+			pause_simulation(state, filename, *checkpoint, console, resumed)
+			deadline = deadline.Add(time.Since(pause_started))
+		default:
+		}
+		if frozen != nil {
+			frozen.update(state)
+		}
+		if sound != nil {
+			sound.record(state)
+		}
+		if anim != nil && anim.due(state) {
+			anim.capture(state)
+		}
+		if snapshots != nil && iteration%snapshots.every == 0 {
+			if err := snapshots.add(state); err != nil {
+				fmt.Fprintln(os.Stderr, "\nfailed to keep history:", err)
+			}
+		}
+		if *live != "" && iteration%*live_every == 0 {
+			if err := save_live(*live, state, colors, *palette_name != "" || *theme_name != ""); err != nil {
+				fmt.Fprintln(os.Stderr, "\nfailed to save live image:", err)
+			}
+		}
+		if *checkpoint != "" && *checkpoint_every > 0 && iteration%*checkpoint_every == 0 {
+			if err := save_state(*checkpoint, state); err != nil {
+				fmt.Fprintln(os.Stderr, "\nfailed to save checkpoint:", err)
+			}
+		}
+	}
+	if *threads < 1 {
+		fmt.Fprintln(os.Stderr, "-threads must be at least 1")
+		os.Exit(2)
+	}
+	state.threads = *threads
+	// where the result went, once it's saved
+	saved := ""
+	// ends a run that failed, after telling -notify-url
+	fail := func(a ...interface{}) {
+		fmt.Fprintln(os.Stderr, a...)
+		if *notify_url != "" {
+			notify_run(*notify_url, state, anim.backpressure(), saved, initialized, strings.TrimSpace(fmt.Sprintln(a...)))
+		}
+		os.Exit(1)
+	}
+	if *workers != "" {
+		// the ones that need the matrix while it grows
+		if err := settings.check_distributed(
+			run_feature{"checkpoints", *checkpoint != ""},
+			run_feature{"live images", *live != ""},
+			run_feature{"-fill", *fill > 0},
+			run_feature{"timelines and sprite sheets", frozen != nil},
+			run_feature{"soundtracks", *audio != ""},
+			run_feature{"animations", anim != nil},
+			run_feature{"histories", snapshots != nil},
+		); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := simulate_distributed(state, strings.Split(*workers, ","), progress); err != nil {
+			fail("\ndistributed simulation failed:", err)
+		}
+	} else if err := run_context(context.Background(), state, progress); err != nil {
+		fail("\n" + err.Error())
+	}
+	if *live != "" {
+		if err := save_live(*live, state, colors, *palette_name != "" || *theme_name != ""); err != nil {
+			fmt.Fprintln(os.Stderr, "\nfailed to save live image:", err)
+		}
+	}
+	encoding := time.Now()
+	coldness_matrix := state.values()
+	if *kaleidoscope != 0 || *mirror {
+		x, y := state.Settings.seed_pos()
+		coldness_matrix = symmetric(coldness_matrix, x, y, *kaleidoscope)
+	}
+	if state.Truncated >= 0 {
+		fmt.Fprintf(os.Stderr, "\nwarning:\t flake truncated at iteration %d, it touched the border\n", state.Truncated)
+	}
+	if (*duration > 0 || *fill > 0 || state.Settings.MaxSize != 0) && *out == "" {
+		// named after the iterations that were done
+		filename = default_filename(state.Settings)
+	}
+	if *as_sprite && *out == "" {
+		filename = strings.TrimSuffix(filename, ".png") + "-sprite.png"
+	}
+	if (*kaleidoscope != 0 || *mirror) && *out == "" {
+		filename = symmetric_filename(filename, *kaleidoscope)
+	}
+	if roi != nil && *out == "" {
+		filename = roi_filename(filename, *roi)
+	}
+	if len(insets) > 0 && *out == "" {
+		filename = insets_filename(filename)
+	}
+	if *no_shear && *out == "" {
+		filename = strings.TrimSuffix(filename, ".png") + "-raw.png"
+	}
+	if rotation != 0 && *out == "" {
+		filename = rotated_filename(filename, rotation)
+	}
+	if *image_size > 0 && *out == "" {
+		filename = size_filename(filename, *image_size)
+	}
+	if *draft && *out == "" {
+		filename = strings.TrimSuffix(filename, ".png") + "-draft.png"
+	}
+	label := ""
+	if *habit {
+		label = measure_structure(state).Habit
+		if *out == "" {
+			filename = habit_filename(filename, label)
+		}
+	}
+	filename = in_prefix(prefix, filename)
+
+	// the last checkpoint is the final state
+	if *checkpoint != "" {
+		if err := save_state(*checkpoint, state); err != nil {
+			fail("\nfailed to save checkpoint:", err)
+		}
+	}
+
+	// save as png
+	draw := func(matrix *Matrix) image.Image {
+		if *no_shear {
+			return render_raw(matrix)
+		}
+		if roi != nil && *image_size > 0 {
+			return render_scaled(matrix, *roi, float64(*image_size)/math.Max(float64(roi.Dx()), float64(roi.Dy())))
+		}
+		if roi != nil {
+			return render_roi(matrix, *roi)
+		}
+		if kernel != nil || rotation != 0 || *image_size > 0 {
+			k := resample_kernels["bilinear"]
+			if kernel != nil {
+				k = *kernel
+			}
+			scale := 1.0
+			if *image_size > 0 {
+				scale = size_scale(len(*matrix), len((*matrix)[0]), *image_size)
+			}
+			return render_resized(matrix, k, rotation, scale)
+		}
+		return render_settings(state.Settings, matrix)
+	}
+	// the heatmap and the insets show the equalized values, everything else the real ones
+	shown := coldness_matrix
+	if *equalize_values {
+		shown = equalize(coldness_matrix, state.Settings.Tile)
+	}
+	if *normalize_values {
+		shown = normalize(coldness_matrix, state.Settings.Tile)
+	}
+	var img image.Image
+	switch *render_mode {
+	case "ice":
+		img = render_ice(coldness_matrix, draw, colors.background(), light)
+	case "volume":
+		img = render_volume(coldness_matrix, draw, colors.background(), light, *absorption)
+	case "mask":
+		img = render_mask(coldness_matrix, draw, *mask_threshold)
+	case "stroke":
+		img = render_stroke(coldness_matrix, draw, pen)
+	default:
+		img = draw(&shown)
+	}
+	if draft_scale > 1 && !state.Settings.Tile && len(insets) == 0 && *image_size == 0 {
+		img = draft_upscale(img, draft_scale)
+	}
+	var descriptor sprite_descriptor
+	if *as_sprite {
+		img, descriptor = make_sprite(frozen_layer(coldness_matrix, colors.ink()), rendered_seed(state.Settings), *sprite_pot)
+	} else if *social != "" {
+		if img, err = social_frame(img, *social, expand_text(*caption, state.Settings), colors); err != nil {
+			fail("\nfailed to frame result:", err)
+		}
+	} else if len(insets) > 0 {
+		img = render_insets(img, &shown, insets, colors)
+	} else if (*palette_name != "" || *theme_name != "") && *render_mode == "heatmap" {
+		img = colorize(img, colors)
+	}
+	if *text != "" {
+		img = stamp_text(img, expand_text(*text, state.Settings), *text_position, *text_scale, *text_opacity, ink)
+	}
+	if *draft {
+		corner := "top-left"
+		if *text != "" && *text_position == corner {
+			corner = "top-right"
+		}
+		img = stamp_text(img, "DRAFT", corner, *text_scale, *text_opacity, ink)
+	}
+	metadata := state.metadata()
+	if *draft {
+		metadata["Draft"] = fmt.Sprintf("grown on a matrix %g times smaller than the full flake", draft_scale)
+	}
+	if *creator != "" {
+		metadata["Author"] = *creator
+	}
+	if *copyright != "" {
+		metadata["Copyright"] = *copyright
+	}
+	if label != "" {
+		metadata["Habit"] = label
+	}
+	if err := save_image(filename, img, metadata); err != nil {
+		fail("\nfailed to save result:", err)
+	}
+	saved = filename
+	if state.timings != nil {
+		state.timings.add(&state.timings.Encoding, encoding)
+	}
+	if filename == "-" {
+		fmt.Fprintln(console, "\nwrote result to stdout")
+	} else {
+		fmt.Fprintln(console, "\nsaved result:\t", filename)
+	}
+	if label != "" {
+		fmt.Fprintln(console, "habit:\t\t", label)
+	}
+	if *catalog != "" {
+		if err := catalog_flake(*catalog, state, filename, time.Since(start).Seconds()); err != nil {
+			fail("failed to add to the catalog:", err)
+		}
+		fmt.Fprintln(console, "added to catalog:\t", *catalog)
+	}
+	if *as_sprite && filename != "-" {
+		descriptor.Image = filepath.Base(filename)
+		descriptor_file := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".json"
+		if err := save_json(descriptor_file, descriptor); err != nil {
+			fail("failed to save sprite descriptor:", err)
+		}
+		fmt.Fprintln(console, "saved sprite descriptor:\t", descriptor_file)
+	}
+
+	if anim != nil {
+		encoding := time.Now()
+		if anim.last != state.Iteration {
+			anim.capture(state)
+		}
+		if err := anim.save(*animation_file, colors); err != nil {
+			fail("failed to save animation:", err)
+		}
+		if state.timings != nil {
+			state.timings.add(&state.timings.Encoding, encoding)
+			state.timings.Backpressure = anim.backpressure()
+		}
+		fmt.Fprintln(console, "saved animation:\t", *animation_file)
+		fmt.Fprintln(console, "backpressure:\t", anim.backpressure())
+	}
+	if sound != nil {
+		if err := sound.save(*audio); err != nil {
+			fail("failed to save soundtrack:", err)
+		}
+		fmt.Fprintln(console, "saved soundtrack:\t", *audio)
+	}
+	if state.timings != nil {
+		fmt.Fprintln(console, "timings:\t", state.timings)
+	}
+	if *save_timings {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		timings_file := strings.TrimSuffix(name, ".png") + "-timings.json"
+		if err := save_json(timings_file, state.timings); err != nil {
+			fail("failed to save timings:", err)
+		}
+		fmt.Fprintln(console, "saved timings:\t", timings_file)
+	}
+	if *save_traits_file {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		traits_file := strings.TrimSuffix(name, ".png") + "-traits.json"
+		traits := analyze(state)
+		if err := save_traits(traits_file, traits); err != nil {
+			fail("failed to save traits:", err)
+		}
+		fmt.Fprintf(console, "traits:\t\t %d arms, %s, %s, %s\n", traits.Arms, traits.Form, traits.Density, traits.Symmetry)
+		fmt.Fprintln(console, "saved traits:\t", traits_file)
+	}
+	if *save_stats {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		stats_file := strings.TrimSuffix(name, ".png") + "-stats.json"
+		structure := measure_structure(state)
+		stats := struct {
+			Structure
+			Backpressure *Backpressure `json:"backpressure,omitempty"` // of -animation
+		}{structure, anim.backpressure()}
+		if err := save_json(stats_file, stats); err != nil {
+			fail("failed to save stats:", err)
+		}
+		fmt.Fprintln(console, "structure:\t", structure)
+		fmt.Fprintln(console, "saved stats:\t", stats_file)
+	}
+	if *save_timeline {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		timeline_file := strings.TrimSuffix(name, ".png") + "-timeline.json"
+		if err := frozen.save(timeline_file, state.Iteration); err != nil {
+			fail("failed to save timeline:", err)
+		}
+		fmt.Fprintln(console, "saved timeline:\t", timeline_file)
+	}
+	if *raw {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + "-raw.png"
+		if err := save_image(name, render_raw(&coldness_matrix), state.metadata()); err != nil {
+			fail("failed to save raw matrix:", err)
+		}
+		fmt.Fprintln(console, "saved raw matrix:	", name)
+	}
+	if *pfm {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ".pfm"
+		if err := save_pfm(name, state); err != nil {
+			fail("failed to save pfm:", err)
+		}
+		fmt.Fprintln(console, "saved pfm:	", name)
+	}
+	if *pbr {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		maps := pbr_maps(coldness_matrix, colors, *pbr_directx)
+		for _, kind := range []string{"albedo", "height", "normal", "opacity"} {
+			if err := save_image(name+"-"+kind+".png", maps[kind], nil); err != nil {
+				fail(fmt.Sprintf("failed to save %s map:", kind), err)
+			}
+		}
+		fmt.Fprintf(console, "saved pbr maps:\t %s-{albedo,height,normal,opacity}.png\n", name)
+	}
+	if *scene != "" {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + scene_formats[*scene]
+		if err := save_scene(name, *scene, coldness_matrix); err != nil {
+			fail("failed to save scene:", err)
+		}
+		fmt.Fprintln(console, "saved scene:\t", name)
+	}
+	if *spritesheet != "" {
+		name := filename
+		if name == "-" {
+			name = default_filename(state.Settings)
+		}
+		name = strings.TrimSuffix(strings.TrimSuffix(name, filepath.Ext(name)), "-sprite")
+		sheet, descriptor := make_spritesheet(frozen, state, sheet_columns, sheet_rows, colors.ink())
+		descriptor.Image = filepath.Base(name + "-sheet.png")
+		if err := save_image(name+"-sheet.png", sheet, state.metadata()); err != nil {
+			fail("failed to save sprite sheet:", err)
+		}
+		if err := save_json(name+"-sheet.json", descriptor); err != nil {
+			fail("failed to save sprite sheet frames:", err)
+		}
+		fmt.Fprintln(console, "saved sprite sheet:\t", name+"-sheet.png")
+	}
+
+	// the history ends with the final state
+	if snapshots != nil {
+		if snapshots.last != state.Iteration {
+			if err := snapshots.add(state); err != nil {
+				fail("failed to keep history:", err)
+			}
+		}
+		if filename == "-" {
+			filename = default_filename(state.Settings)
+		}
+		history_file := strings.TrimSuffix(filename, ".png") + ".history"
+		if err := snapshots.save(history_file); err != nil {
+			fail("failed to save history:", err)
+		}
+		fmt.Fprintln(console, "saved history:\t", history_file)
+	}
+	if *notify_url != "" {
+		notify_run(*notify_url, state, anim.backpressure(), saved, initialized, "")
+	}
+}
+
+// saves a snapshot of the simulation and waits for the resume signal
+func pause_simulation(state *State, filename, checkpoint string, console io.Writer, resume <-chan os.Signal) {
+	if filename == "-" {
+		filename = default_filename(state.Settings)
+	}
+	snapshot := fmt.Sprintf("%s-%d.png", strings.TrimSuffix(filename, ".png"), state.Iteration)
+	var err error
+	if state.collect != nil {
+		// in distributed mode the matrix is on the workers
+		err = state.collect()
+	}
+	if err == nil {
+		coldness_matrix := state.values()
+		err = save_image(snapshot, render_settings(state.Settings, &coldness_matrix), state.metadata())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nfailed to save snapshot:", err)
+	} else {
+		fmt.Fprintln(console, "\nsaved snapshot:\t", snapshot)
+	}
+	if checkpoint != "" && state.collect == nil {
+		if err := save_state(checkpoint, state); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save checkpoint:", err)
+		}
+	}
+
+	// ignore a resume signal that came in before the pause
+	select {
+	case <-resume:
+	default:
+	}
+	fmt.Fprintf(console, "paused:\t\t at iteration %d, send SIGUSR2 (kill -USR2 %d) to continue\n", state.Iteration, os.Getpid())
+	<-resume
+}
+
+// parses "x,y"
+func parse_pair(s string) (int, int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected x,y but got %q", s)
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	return x, y, nil
+}
+
+// parses width x height like 1200x600
+func parse_grid(s string) (int, int, error) {
+	width, height, err := parse_pair(strings.Replace(strings.ToLower(s), "x", ",", 1))
+	if err != nil {
+		return 0, 0, fmt.Errorf("expected width x height like 1200x600 but got %q", s)
+	}
+	return width, height, nil
+}
+
+// the initial background stretched so its lowest value is black and its highest white, the seed
+// and the border are left black
+func background_field(state *State) (float64, float64, Matrix) {
+	values := state.values()
+	size, height := len(values), len(values[0])
+	field := new_matrix_like(values)
+
+	low, high := math.Inf(1), math.Inf(-1)
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if state.Mask[i][j] != out_of_bound && values[i][j] < 1.0 {
+				low = math.Min(low, values[i][j])
+				high = math.Max(high, values[i][j])
+			}
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if state.Mask[i][j] != out_of_bound && values[i][j] < 1.0 && high > low {
+				field[i][j] = (values[i][j] - low) / (high - low)
+			}
+		}
+	}
+	return low, high, field
+}
+
+// snowflakes are named after the settings they were created with
+func default_filename(settings Settings) string {
+	if settings.Tile {
+		return fmt.Sprintf("snowflakes/%.4f-%.4f-%.4f-%.4f-%.4f-%d-%s-tile.png",
+			settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L, settings.image_dimensions())
+	}
+	if settings.padded() {
+		return fmt.Sprintf("snowflakes/%.4f-%.4f-%.4f-%.4f-%.4f-%d-%s-pad.png",
+			settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L, settings.image_dimensions())
+	}
+	return fmt.Sprintf("snowflakes/%.4f-%.4f-%.4f-%.4f-%.4f-%d-%s.png",
+		settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L, settings.image_dimensions())
+}
+
+// the size, or width x height for a rectangular grid
+func (settings Settings) dimensions() string {
+	if settings.Height == 0 {
+		return strconv.Itoa(settings.Size)
+	}
+	return fmt.Sprintf("%dx%d", settings.Size, settings.Height)
+}
+
+// like dimensions, but of the image a rectangular grid renders to, which is what -grid asked for
+func (settings Settings) image_dimensions() string {
+	if settings.Height == 0 {
+		return strconv.Itoa(settings.Size)
+	}
+	width, height := rendered_size(settings.Size, settings.Height)
+	if settings.padded() {
+		width, height = padded_size(settings.Size, settings.Height)
+	}
+	return fmt.Sprintf("%dx%d", width, height)
+}
 
-	"github.com/aquilax/go-perlin"
-)
+// amount of frozen hexagons in the matrix
+func frozen_cells[T Value](matrix *Grid[T]) int {
+	one := to_value[T](1.0)
+	frozen := 0
+	for i := range *matrix {
+		for _, v := range (*matrix)[i] {
+			if v >= one {
+				frozen++
+			}
+		}
+	}
+	return frozen
+}
 
-// matrix size, this decides the size of the image
-const size int = 800
+// frozen hexagons of the state, without converting the values first
+func (state *State) frozen() int {
+	switch {
+	case state.Settings.Fixed:
+		return frozen_cells(&state.ColdnessFixed)
+	case state.Settings.single_precision():
+		return frozen_cells(&state.Coldness32)
+	}
+	return frozen_cells(&state.Coldness)
+}
 
-// note:
-// I use a 2 dimentional matrix and treats it as a hexagonial grid.
-//
-// X X X X X X X X X X X X
-// X X X X . . . . . . . X
-// X X X . . . . . . . . X
-// X X . . . N N . . . . X
-// X . . . N O N . . . . X
-// X . . . N N . . . . X X
-// X . . . . . . . . X X X
-// X . . . . . . . X X X X
-// X X X X X X X X X X X X
-//
-// where X is out of bound, O is is a frozen hexagon and N it's neighbours.
-// When the matrix values have become pixel values the image gets sheared to make it look normal.
+// hexagons inside the border that can freeze
+func (state *State) cells() int {
+	cells := 0
+	for i := range state.Mask {
+		for _, m := range state.Mask[i] {
+			if m != out_of_bound && m != obstacle {
+				cells++
+			}
+		}
+	}
+	return cells
+}
 
-type Matrix [size][size]float64
-type Mask [size][size]uint8
+// true when a frozen hexagon is next to the border, anything that grows after that is cut off
+func (state *State) touches_border() bool {
+	if state.Settings.Tile {
+		// tiles have no border
+		return false
+	}
+	if state.border == nil {
+		width, height := len(state.Mask), len(state.Mask[0])
+		state.border = border_cells(state.Settings.in_arena, width, height, 0, width)
+	}
 
-// states the mask can have
-const (
-	receptive uint8 = iota
-	non_receptive
-	out_of_bound
-)
+	switch {
+	case state.Settings.Fixed:
+		return frozen_at(&state.ColdnessFixed, state.border)
+	case state.Settings.single_precision():
+		return frozen_at(&state.Coldness32, state.border)
+	}
+	return frozen_at(&state.Coldness, state.border)
+}
 
-func main() {
-	// A, B, Y, PP, PM, L parameters
-	args := os.Args[1:]
-	A, _ := strconv.ParseFloat(args[0], 64)
-	B, _ := strconv.ParseFloat(args[1], 64)
-	Y, _ := strconv.ParseFloat(args[2], 64)
-	PP, _ := strconv.ParseFloat(args[3], 64)
-	PM, _ := strconv.ParseFloat(args[4], 64)
-	L, _ := strconv.ParseInt(args[5], 10, 64)
-
-	fmt.Printf("settings:\t A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%d size=%d\n", A, B, Y, PP, PM, L, size)
-
-	// create matrices
-	var coldness_matrix Matrix
-	var mask_matrix Mask
-	init_matrices(B, PP, PM, &coldness_matrix, &mask_matrix)
+// the hexagons inside the border next to it, in the rows from to to
+func border_cells(inside func(i, j int) bool, width, height, from, to int) [][2]int {
+	var border [][2]int
+	for i := from; i < to; i++ {
+		for j := 0; j < height; j++ {
+			if inside(i, j) && (!inside(i-1, j) || !inside(i-1, j+1) || !inside(i, j-1) ||
+				!inside(i, j+1) || !inside(i+1, j-1) || !inside(i+1, j)) {
+				border = append(border, [2]int{i, j})
+			}
+		}
+	}
+	return border
+}
 
-	// run simulation loop
-	for iteration := int64(0); iteration <= L; iteration++ {
-		step(A, B, Y, &coldness_matrix, &mask_matrix)
-		fmt.Printf("\rsimulation:\t %d / %d", iteration, L)
+func frozen_at[T Value](matrix *Grid[T], cells [][2]int) bool {
+	one := to_value[T](1.0)
+	for _, cell := range cells {
+		if (*matrix)[cell[0]][cell[1]] >= one {
+			return true
+		}
 	}
+	return false
+}
 
-	// save as png
-	filename := fmt.Sprintf("snowflakes/%.4f-%.4f-%.4f-%.4f-%.4f-%d-%d.png", A, B, Y, PP, PM, L, size)
-	save(filename, &coldness_matrix)
-	fmt.Println("\nsaved result:\t", filename)
+// the iteration the flake was truncated at for json output, nil if it wasn't
+func (state *State) truncated() *int64 {
+	if state.Truncated < 0 {
+		return nil
+	}
+	truncated := state.Truncated
+	return &truncated
+}
+
+// png text chunks describing how the image was made
+func (state *State) metadata() map[string]string {
+	settings, _ := json.Marshal(state.Settings)
+	metadata := map[string]string{
+		"Software":  "procedural-snowflakes",
+		"Settings":  string(settings),
+		"Iteration": strconv.FormatInt(state.Iteration, 10),
+		"Description": fmt.Sprintf("snowflake A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%d size=%s",
+			state.Settings.A, state.Settings.B, state.Settings.Y, state.Settings.PP, state.Settings.PM,
+			state.Iteration, state.Settings.dimensions()),
+	}
+	if code, err := encode_code(state.Settings); err == nil {
+		metadata["Code"] = code
+	}
+	if state.Truncated >= 0 {
+		metadata["Truncated"] = fmt.Sprintf("flake truncated at iteration %d", state.Truncated)
+	}
+	return metadata
+}
+
+// create a size x size matrix backed by one continuous slice
+func new_grid[T Value](size int) Grid[T] {
+	return new_rect_grid[T](size, size)
+}
+
+// create a width x height matrix, the first index goes along the width
+func new_rect_grid[T Value](width, height int) Grid[T] {
+	backing := make([]T, width*height)
+	grid := make(Grid[T], width)
+	for i := range grid {
+		grid[i] = backing[i*height : (i+1)*height]
+	}
+	return grid
+}
+
+func new_matrix(size int) Matrix {
+	return new_grid[float64](size)
+}
+
+// a matrix with the same width and height as the grid
+func new_matrix_like[T Value](grid Grid[T]) Matrix {
+	return new_rect_grid[float64](len(grid), len(grid[0]))
+}
+
+func new_mask(size int) Mask {
+	return new_rect_mask(size, size)
+}
+
+func new_rect_mask(width, height int) Mask {
+	backing := make([]uint8, width*height)
+	mask := make(Mask, width)
+	for i := range mask {
+		mask[i] = backing[i*height : (i+1)*height]
+	}
+	return mask
+}
+
+// everything needed to continue a simulation
+type State struct {
+	Settings      Settings
+	Iteration     int64       // the last iteration that has been run, -1 before the first step
+	Truncated     int64       // the iteration the flake first touched the border, -1 if it hasn't
+	Coldness      Matrix      // used with 64 bit precision
+	Coldness32    Matrix32    // used with 32 bit precision
+	ColdnessFixed FixedMatrix // used with fixed point values
+	Mask          Mask
+	Temperature   Matrix // used with latent heat
+	Vapor         Matrix // the masses of attachment kinetics
+	Boundary      Matrix
+	Crystal       Matrix
+
+	temp      Matrix // next iteration of the coldness matrix, reused between steps
+	temp32    Matrix32
+	tempFixed FixedMatrix
+	tempHeat  Matrix
+	tempVapor Matrix
+	unmap     []func() error // set when the matrices are memory mapped
+	border    [][2]int       // hexagons inside the border that are next to it
+	edge      [][2]int       // hexagons that make a growing grid grow when they freeze
+	hooks     *hooks         // set with on_iteration, on_freeze and on_complete
+	threads   int            // threads of a step, one if it's 0 (see threads.go)
+	timings   *Timings       // the phases of the runs are timed into it when it's set (see timing.go)
+	collect   func() error   // brings the strips of the workers into the matrices in distributed mode
+}
+
+// returns what is wrong with the settings, if anything
+func (settings Settings) check() error {
+	switch {
+	case settings.Size < 8 || (settings.Height != 0 && settings.Height < 8):
+		// the border needs a few cells to work with
+		return fmt.Errorf("size must be at least 8")
+	case settings.L < 0:
+		return fmt.Errorf("L can't be negative")
+	case settings.Precision != 0 && settings.Precision != 32 && settings.Precision != 64:
+		return fmt.Errorf("precision must be 32 or 64")
+	case settings.Fixed && settings.single_precision():
+		return fmt.Errorf("fixed point values can't have 32 bit precision")
+	case settings.SeedValue < 0:
+		return fmt.Errorf("the seed value can't be negative")
+	case settings.Octaves < 0 || settings.Octaves > 16:
+		return fmt.Errorf("octaves must be between 1 and 16")
+	case settings.Lacunarity < 0 || settings.Gain < 0:
+		return fmt.Errorf("lacunarity and gain must be positive")
+	}
+	if settings.laplacian() {
+		switch {
+		case settings.Diffusion < 0 || settings.Substeps < 0:
+			return fmt.Errorf("diffusion and substeps must be positive")
+		case settings.diffusion()/float64(settings.substeps()) > 1.0/6:
+			// a hexagon would give away more vapor than it has
+			return fmt.Errorf("diffusion / substeps can be at most 1/6, use more substeps")
+		case settings.Fixed || settings.Tile:
+			return fmt.Errorf("the laplacian solver can't have fixed point values or tiles")
+		}
+	} else if settings.Solver != "" && settings.Solver != "reiter" {
+		return fmt.Errorf("unknown solver %q, use %s", settings.Solver, strings.Join(solvers, " or "))
+	} else if settings.Diffusion != 0 || settings.Substeps != 0 {
+		return fmt.Errorf("diffusion and substeps need the laplacian solver")
+	}
+	switch {
+	case settings.Heat < 0:
+		return fmt.Errorf("heat can't be negative")
+	case settings.Cooling < 0 || settings.Cooling >= 1:
+		return fmt.Errorf("cooling must be from 0 to 1")
+	case settings.Heat == 0 && settings.Cooling != 0:
+		return fmt.Errorf("cooling needs heat")
+	case settings.Heat != 0 && (settings.Fixed || settings.Tile):
+		return fmt.Errorf("latent heat can't have fixed point values or tiles")
+	}
+	switch {
+	case settings.Smoothing < 0 || settings.Smoothing > 1:
+		return fmt.Errorf("smoothing must be from 0 to 1")
+	case settings.Curvature < 0 || settings.Curvature >= 1:
+		return fmt.Errorf("curvature must be from 0 to 1")
+	case (settings.Smoothing != 0 || settings.Curvature != 0) && (settings.Fixed || settings.Tile || settings.Kinetics != nil):
+		return fmt.Errorf("smoothing and curvature can't have fixed point values, tiles or attachment kinetics")
+	}
+	switch {
+	case settings.Summation != "" && settings.Summation != "naive" && settings.Summation != "kahan":
+		return fmt.Errorf("unknown summation %q, use naive or kahan", settings.Summation)
+	case settings.kahan() && (settings.Fixed || settings.Tile || settings.laplacian() || settings.Kinetics != nil):
+		return fmt.Errorf("kahan summation needs the reiter solver without fixed point values, tiles or attachment kinetics")
+	}
+	if settings.Weights != nil {
+		for _, weight := range settings.Weights {
+			if weight < 0 {
+				return fmt.Errorf("the weights of the directions can't be below 0, -anisotropy goes from -2 to 1")
+			}
+		}
+		if settings.anisotropic() && (settings.Fixed || settings.Tile || settings.laplacian() || settings.Kinetics != nil || settings.kahan()) {
+			return fmt.Errorf("anisotropy needs the reiter solver without fixed point values, tiles, attachment kinetics or kahan summation")
+		}
+	}
+	if settings.Sources != "" {
+		all, err := settings.sources()
+		if err != nil {
+			return fmt.Errorf("bad sources: %v", err)
+		}
+		switch {
+		case settings.Fixed || settings.Kinetics != nil:
+			return fmt.Errorf("sources can't have fixed point values or attachment kinetics")
+		case settings.MaxSize != 0:
+			return fmt.Errorf("a growing grid moves the hexagons of the sources, they need a grid that doesn't grow")
+		}
+		for _, source := range all {
+			for _, cell := range [][2]int{{source.X, source.Y}, {source.X2, source.Y2}} {
+				if settings.Tile && (cell[0] < 0 || cell[0] >= settings.Size || cell[1] < 0 || cell[1] >= settings.Size) {
+					return fmt.Errorf("the source %v is outside of the matrix", source)
+				}
+				if !settings.Tile && !settings.in_arena(cell[0], cell[1]) {
+					return fmt.Errorf("the source %v is outside of the border", source)
+				}
+			}
+		}
+	}
+	if settings.SeedText != "" || settings.Font != "" {
+		switch {
+		case settings.SeedText == "":
+			return fmt.Errorf("-font is the font of -seed-text, there is no seed text")
+		case settings.SeedPos != nil:
+			return fmt.Errorf("the seed text replaces the seed, it can't have a seed position")
+		case settings.Tile || settings.MaxSize != 0:
+			return fmt.Errorf("the seed text needs a grid that doesn't grow and isn't a tile")
+		}
+		cells, err := seed_text_cells(settings, settings.Size, settings.height())
+		if err != nil {
+			return fmt.Errorf("bad seed text: %v", err)
+		}
+		if len(cells) == 0 {
+			return fmt.Errorf("the seed text %q doesn't cover any hexagons, it needs letters and a grid they fit on", settings.SeedText)
+		}
+	}
+	if settings.Receptivity != "" {
+		if settings.Fixed || settings.Tile || settings.Kinetics != nil || settings.MaxSize != 0 {
+			return fmt.Errorf("receptivity needs a grid that doesn't grow, without fixed point values, tiles or attachment kinetics")
+		}
+		if _, err := settings.receptivity(); err != nil {
+			return err
+		}
+	}
+	if settings.Obstacles != "" {
+		if settings.Fixed || settings.Tile || settings.laplacian() || settings.Kinetics != nil || settings.MaxSize != 0 {
+			return fmt.Errorf("obstacles need the reiter solver on a grid that doesn't grow, without fixed point values, tiles or attachment kinetics")
+		}
+		if err := settings.check_obstacles(); err != nil {
+			return err
+		}
+	}
+	if err := settings.check_crop(); err != nil {
+		return err
+	}
+	if settings.Kinetics != nil {
+		switch {
+		case settings.Fixed || settings.single_precision() || settings.Tile || settings.MaxSize != 0:
+			return fmt.Errorf("attachment kinetics need 64 bit values and a grid that isn't a tile and doesn't grow")
+		case settings.laplacian() || settings.Heat != 0:
+			return fmt.Errorf("attachment kinetics have their own diffusion and can't be combined with the laplacian solver or latent heat")
+		}
+		if err := settings.Kinetics.check(); err != nil {
+			return err
+		}
+	}
+	if settings.Background != "" && settings.Background != "uniform" {
+		if _, _, ok := settings.radial_background(); !ok {
+			return fmt.Errorf("background must be uniform or radial:inner,outer")
+		}
+		if settings.BEdge != nil {
+			return fmt.Errorf("B_edge can't be combined with a radial background")
+		}
+	}
+	if settings.MaxSize != 0 {
+		switch {
+		case settings.MaxSize < settings.Size:
+			return fmt.Errorf("max_size can't be smaller than size")
+		case settings.SeedPos != nil || settings.BEdge != nil || (settings.Background != "" && settings.Background != "uniform"):
+			// these depend on the size of the grid, which changes when it grows
+			return fmt.Errorf("a growing grid needs the seed in the middle and a uniform background")
+		}
+	}
+	if settings.Height != 0 {
+		switch {
+		case settings.MaxSize != 0:
+			return fmt.Errorf("rectangular grids can't grow")
+		case settings.Tile:
+			return fmt.Errorf("tiles must be square")
+		case settings.BEdge != nil || (settings.Background != "" && settings.Background != "uniform"):
+			// these are measured from the middle of a hexagonal border
+			return fmt.Errorf("rectangular grids need a uniform background")
+		}
+	}
+	if settings.Tile {
+		switch {
+		case settings.Fixed:
+			return fmt.Errorf("tiles can't have fixed point values")
+		case settings.MaxSize != 0:
+			return fmt.Errorf("tiles can't grow")
+		case settings.BEdge != nil || (settings.Background != "" && settings.Background != "uniform"):
+			// these have a middle and don't repeat
+			return fmt.Errorf("tiles need a uniform background")
+		case settings.Seeds < 0:
+			return fmt.Errorf("seeds can't be negative")
+		}
+		if x, y := settings.seed_pos(); x < 0 || x >= settings.Size || y < 0 || y >= settings.Size {
+			return fmt.Errorf("the seed %d,%d is outside of the matrix", x, y)
+		}
+		return nil
+	}
+	if settings.Seeds > 1 {
+		return fmt.Errorf("more than one seed needs a tile")
+	}
+	if x, y := settings.seed_pos(); !settings.in_arena(x, y) {
+		return fmt.Errorf("the seed %d,%d is outside of the border", x, y)
+	}
+	return nil
+}
+
+func (settings Settings) single_precision() bool {
+	return settings.Precision == 32
+}
+
+// creates the matrices for a new simulation
+func new_state(settings Settings) *State {
+	state := &State{
+		Settings:  settings,
+		Iteration: -1,
+		Truncated: -1,
+		Mask:      new_rect_mask(settings.Size, settings.height()),
+	}
+	switch {
+	case settings.Fixed:
+		state.ColdnessFixed = new_rect_grid[Fixed](settings.Size, settings.height())
+	case settings.single_precision():
+		state.Coldness32 = new_rect_grid[float32](settings.Size, settings.height())
+	default:
+		state.Coldness = new_rect_grid[float64](settings.Size, settings.height())
+	}
+	state.init()
+	return state
+}
+
+func (state *State) init() {
+	settings := state.Settings
+	switch {
+	case settings.Fixed:
+		init_matrices(settings, &state.ColdnessFixed, &state.Mask)
+	case settings.single_precision():
+		init_matrices(settings, &state.Coldness32, &state.Mask)
+	default:
+		init_matrices(settings, &state.Coldness, &state.Mask)
+	}
+}
+
+// the coldness matrix as float64, with 32 bit precision or fixed point values this is a converted copy
+func (state *State) values() Matrix {
+	switch {
+	case state.Settings.Fixed:
+		matrix := new_matrix_like(state.ColdnessFixed)
+		for i := range matrix {
+			for j, v := range state.ColdnessFixed[i] {
+				matrix[i][j] = v.float()
+			}
+		}
+		return matrix
+	case state.Settings.single_precision():
+		matrix := new_matrix_like(state.Coldness32)
+		for i := range matrix {
+			for j, v := range state.Coldness32[i] {
+				matrix[i][j] = float64(v)
+			}
+		}
+		return matrix
+	}
+	return state.Coldness
+}
+
+// creates a new simulation with its matrices memory mapped to files in dir
+func new_mapped_state(settings Settings, dir string) (*State, error) {
+	state := &State{Settings: settings, Iteration: -1, Truncated: -1}
+	if err := map_state(state, dir); err != nil {
+		state.close()
+		return nil, err
+	}
+	state.init()
+	return state, nil
+}
+
+// runs the whole simulation and returns the final coldness matrix,
+// progress (if not nil) is called after every iteration
+func simulate(settings Settings, progress func(iteration int64)) Matrix {
+	values, _ := simulate_context(context.Background(), settings, progress)
+	return values
+}
+
+// like simulate, but stops with the error of the context when it's cancelled or its deadline passes, or
+// with the error of a simulation that blew up
+func simulate_context(ctx context.Context, settings Settings, progress func(iteration int64)) (Matrix, error) {
+	state := new_state(settings)
+	if err := run_context(ctx, state, progress); err != nil {
+		return nil, err
+	}
+	return state.values(), nil
+}
+
+// continues the simulation from where the state is until all L loops are done,
+// progress may change A, Y and L in state.Settings, the next iteration uses the new values
+func run(state *State, progress func(iteration int64)) {
+	run_context(context.Background(), state, progress)
+}
+
+// like run, but stops with the error of the context when it's cancelled or its deadline passes, the
+// state stays at the last iteration that was done
+func run_context(ctx context.Context, state *State, progress func(iteration int64)) error {
+	timings := state.timings
+	var mark time.Time
+	if timings != nil {
+		mark = time.Now()
+	}
+	settings := state.Settings
+	switch {
+	case settings.Fixed && state.tempFixed == nil:
+		state.tempFixed = new_rect_grid[Fixed](settings.Size, settings.height())
+	case settings.single_precision() && state.temp32 == nil:
+		state.temp32 = new_rect_grid[float32](settings.Size, settings.height())
+	case !settings.Fixed && !settings.single_precision() && state.temp == nil:
+		state.temp = new_rect_grid[float64](settings.Size, settings.height())
+	}
+	state.init_fields()
+	if settings.Heat != 0 && state.tempHeat == nil {
+		state.tempHeat = new_rect_grid[float64](settings.Size, settings.height())
+	}
+	if settings.Kinetics != nil && state.tempVapor == nil {
+		state.tempVapor = new_rect_grid[float64](settings.Size, settings.height())
+	}
+
+	sources, err := settings.sources()
+	if err != nil {
+		return err
+	}
+	obstacles := obstacle_cells(&state.Mask)
+	receptivity, err := settings.receptivity()
+	if err != nil {
+		return err
+	}
+	weights := [6]float64{1, 1, 1, 1, 1, 1}
+	if settings.Weights != nil {
+		weights = *settings.Weights
+	}
+
+	// the plain step reads the mask packed, made when it's first needed (see bitmask.go)
+	var packed bit_mask
+
+	// a background that is frozen at the edge breaks the first step already
+	if err := state.sanity(); err != nil {
+		return err
+	}
+	if timings != nil {
+		mark = timings.add(&timings.Init, mark)
+	}
+	for state.Iteration < state.Settings.L {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		settings := state.Settings
+		timed := false // the plain step times its passes itself
+		switch {
+		case settings.Kinetics != nil:
+			step_kinetics(*settings.Kinetics, &state.Coldness, &state.Mask, &state.Vapor, &state.Boundary, &state.Crystal, &state.tempVapor)
+		case settings.Fixed:
+			step_fixed(settings.A, settings.B, settings.Y, &state.ColdnessFixed, &state.tempFixed, &state.Mask)
+		case settings.Tile && settings.single_precision():
+			step_wrapped(settings.A, settings.B, settings.Y, &state.Coldness32, &state.temp32, &state.Mask)
+		case settings.Tile:
+			step_wrapped(settings.A, settings.B, settings.Y, &state.Coldness, &state.temp, &state.Mask)
+		case settings.laplacian() && settings.single_precision():
+			step_laplacian(settings.A, settings.Y, settings.diffusion(), settings.substeps(), &state.Coldness32, &state.temp32, &state.Mask)
+		case settings.laplacian():
+			step_laplacian(settings.A, settings.Y, settings.diffusion(), settings.substeps(), &state.Coldness, &state.temp, &state.Mask)
+		case settings.kahan() && settings.single_precision():
+			step_kahan(settings.A, settings.Y, &state.Coldness32, &state.temp32, &state.Mask)
+		case settings.kahan():
+			step_kahan(settings.A, settings.Y, &state.Coldness, &state.temp, &state.Mask)
+		case settings.anisotropic() && settings.single_precision():
+			step_anisotropic(settings.A, settings.Y, *settings.Weights, &state.Coldness32, &state.temp32, &state.Mask)
+		case settings.anisotropic():
+			step_anisotropic(settings.A, settings.Y, *settings.Weights, &state.Coldness, &state.temp, &state.Mask)
+		case settings.single_precision():
+			if packed == nil {
+				packed = pack_mask(state.Mask)
+			}
+			step_threads(settings.A, settings.B, settings.Y, state.threads, &state.Coldness32, &state.temp32, packed, &state.Mask, timings)
+			timed = true
+		default:
+			if packed == nil {
+				packed = pack_mask(state.Mask)
+			}
+			step_threads(settings.A, settings.B, settings.Y, state.threads, &state.Coldness, &state.temp, packed, &state.Mask, timings)
+			timed = true
+		}
+		switch {
+		case timings != nil && timed:
+			mark = time.Now()
+		case timings != nil:
+			mark = timings.add(&timings.Diffusion, mark)
+		}
+		switch {
+		case receptivity != nil && settings.single_precision():
+			paint_receptivity(settings.Y, receptivity, &state.Coldness32, &state.Mask)
+		case receptivity != nil:
+			paint_receptivity(settings.Y, receptivity, &state.Coldness, &state.Mask)
+		}
+		switch {
+		case obstacles != nil && settings.single_precision():
+			bounce_obstacles(settings.A, weights, obstacles, &state.Coldness32, &state.temp32, &state.Mask)
+		case obstacles != nil:
+			bounce_obstacles(settings.A, weights, obstacles, &state.Coldness, &state.temp, &state.Mask)
+		}
+		if obstacles != nil && packed != nil {
+			// the step marked the obstacles next to the ice receptive in the packed mask as well
+			for _, cell := range obstacles {
+				packed.set(cell[0], cell[1], obstacle)
+			}
+		}
+		switch {
+		case settings.Smoothing != 0 && settings.single_precision():
+			smooth_growth(settings.Smoothing, &state.Coldness32, &state.temp32, &state.Mask)
+		case settings.Smoothing != 0:
+			smooth_growth(settings.Smoothing, &state.Coldness, &state.temp, &state.Mask)
+		}
+		switch {
+		case settings.Curvature != 0 && settings.single_precision():
+			curvature_freeze(settings.Curvature, &state.Coldness32, &state.temp32, &state.Mask)
+		case settings.Curvature != 0:
+			curvature_freeze(settings.Curvature, &state.Coldness, &state.temp, &state.Mask)
+		}
+		switch {
+		case sources != nil && settings.single_precision():
+			apply_sources(sources, &state.Coldness32, &state.Mask)
+		case sources != nil:
+			apply_sources(sources, &state.Coldness, &state.Mask)
+		}
+		switch {
+		case settings.Heat != 0 && settings.single_precision():
+			release_heat(settings.Heat, settings.cooling(), &state.Coldness32, &state.temp32, &state.Mask, &state.Temperature, &state.tempHeat)
+		case settings.Heat != 0:
+			release_heat(settings.Heat, settings.cooling(), &state.Coldness, &state.temp, &state.Mask, &state.Temperature, &state.tempHeat)
+		}
+		state.Iteration++
+		if settings.MaxSize > settings.Size && state.near_edge() {
+			state.grow()
+			packed = nil
+		}
+		if state.Truncated < 0 && state.touches_border() {
+			state.Truncated = state.Iteration
+		}
+		if err := state.sanity(); err != nil {
+			return err
+		}
+		if timings != nil {
+			mark = timings.add(&timings.Extras, mark)
+		}
+		if state.hooks != nil {
+			state.hooks.iterated(state)
+		}
+		if progress != nil {
+			progress(state.Iteration)
+		}
+		if timings != nil {
+			mark = timings.add(&timings.Snapshots, mark)
+		}
+	}
+	if state.hooks != nil {
+		state.hooks.completed(state)
+	}
+	return nil
 }
 
-func init_matrices(B, PP, PM float64, coldness_matrix *Matrix, mask_matrix *Mask) {
+func init_matrices[T Value](settings Settings, coldness_matrix *Grid[T], mask_matrix *Mask) {
+	if settings.Tile {
+		init_tile(settings, coldness_matrix, mask_matrix)
+		return
+	}
+
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+	PP, PM := settings.PP, settings.PM
+	background := settings.background_level()
+
 	// perlin noise generator
-	perlin := perlin.NewPerlin(2, 2, 1, 1)
+	noise := settings.noise()
+	origin := settings.origin()
 
 	for i := 0; i < size; i++ {
-		for j := 0; j < size; j++ {
+		for j := 0; j < height; j++ {
 			// set coldness initial background level, B, PP, PM parameters are used here
-			perlin_value := perlin.Noise2D(float64(i)*PP, float64(j)*PP) * PM
-			coldness_matrix[i][j] = perlin_value + B
+			perlin_value := noise.Noise2D(float64(i-origin)*PP, float64(j-origin)*PP) * PM
+			(*coldness_matrix)[i][j] = to_value[T](perlin_value + background(i, j, size))
 
 			// set a border for the matrix where no calculation is done
-			x := i - size/2
-			z := j - size/2
-			y := -x - z
-
-			if math.Max(math.Max(math.Abs(float64(x)), math.Abs(float64(y))), math.Abs(float64(z))) > float64(size/2-2) {
-				mask_matrix[i][j] = out_of_bound
+			if !settings.in_arena(i, j) {
+				(*mask_matrix)[i][j] = out_of_bound
 			} else {
 				// all hexagons are set to non receptive at the beginning because there are no frozen hexagons
-				mask_matrix[i][j] = non_receptive
+				(*mask_matrix)[i][j] = non_receptive
 			}
 		}
 	}
 
-	// freeze the middle hexagon
-	coldness_matrix[size/2][size/2] = 1.0
+	if settings.Obstacles != "" {
+		place_obstacles(settings.Obstacles, coldness_matrix, mask_matrix)
+	}
+
+	if settings.SeedText != "" {
+		freeze_seed_text(settings, coldness_matrix)
+		return
+	}
+
+	// freeze the seed hexagon, the middle one by default
+	x, y := settings.seed_pos()
+	(*coldness_matrix)[x][y] = to_value[T](settings.seed_value())
+}
+
+// perlin noise generator for the background, the library adds the octaves
+// with alpha being the inverse of the gain and beta being the lacunarity
+func (settings Settings) noise() *perlin.Perlin {
+	octaves, lacunarity, gain := settings.Octaves, settings.Lacunarity, settings.Gain
+	if octaves == 0 {
+		octaves = 1
+	}
+	if lacunarity == 0 {
+		lacunarity = 2
+	}
+	if gain == 0 {
+		gain = 0.5
+	}
+	return perlin.NewPerlin(1/gain, lacunarity, int32(octaves), 1)
+}
+
+// returns the background level of a hexagon before the noise is added, B everywhere by default
+func (settings Settings) background_level() func(i, j, size int) float64 {
+	B := settings.B
+
+	// radial gradient from inner in the middle to outer at the border
+	if inner, outer, ok := settings.radial_background(); ok {
+		return func(i, j, size int) float64 {
+			r := math.Min(radius(i, j, size)/float64(size/2-2), 1.0)
+			return inner + (outer-inner)*r
+		}
+	}
+
+	// with B_edge the background level goes from B in the middle to B_edge at the border
+	if settings.BEdge != nil {
+		edge := *settings.BEdge
+		return func(i, j, size int) float64 {
+			r := math.Min(hex_distance(i, j, size)/float64(size/2-2), 1.0)
+			return B + (edge-B)*r
+		}
+	}
+
+	return func(i, j, size int) float64 {
+		return B
+	}
+}
+
+// parses "radial:inner,outer", ok is false for the uniform background
+func (settings Settings) radial_background() (inner, outer float64, ok bool) {
+	values := strings.TrimPrefix(settings.Background, "radial:")
+	if values == settings.Background {
+		return 0, 0, false
+	}
+	parts := strings.Split(values, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	inner, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	outer, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return inner, outer, true
+}
+
+// distance between the hexagon and the middle of the matrix as it looks in the image
+func radius(i, j, size int) float64 {
+	x := float64(i-size/2) + float64(j-size/2)/2.0
+	y := float64(j-size/2) * math.Sqrt(3) / 2.0
+	return math.Sqrt(x*x + y*y)
+}
+
+// tells if the hexagon is inside the hexagonal border
+func in_bounds(i, j, size int) bool {
+	return hex_distance(i, j, size) <= float64(size/2-2)
+}
+
+// tells if the hexagon is inside the border, the hexagonal one of a square grid or the rectangle the
+// image shows of a rectangular grid
+func in_arena(i, j, width, height int) bool {
+	if width == height {
+		return in_bounds(i, j, width)
+	}
+	x, y := cell_position(i, j, width, height)
+	image_width, image_height := rendered_size(width, height)
+	return x >= 2 && x < float64(image_width-2) && y >= 2 && y < float64(image_height-2)
+}
+
+// the hexagon in the middle of the image, the one the border of a square grid is around, or the middle
+// of a rectangular grid so the rows reach as far out on both sides
+func grid_middle(width, height int) (float64, float64) {
+	if width == height {
+		return float64(width / 2), float64(height / 2)
+	}
+	return float64(width-1) / 2, float64(height-1) / 2
+}
+
+// the size of the image render() makes of a width x height grid, a square grid is as large as the
+// image and shows the whole border, the rows of a rectangular grid are slanted and only the rectangle
+// they all cover is shown
+func rendered_size(width, height int) (int, int) {
+	if width == height {
+		return width, height
+	}
+	image_height := rendered_height(height)
+	return int(float64(width) - float64(image_height-1)/math.Sqrt(3)), image_height
+}
+
+// the rows of a rectangular grid are sqrt(3)/2 pixels apart
+func rendered_height(height int) int {
+	return int(float64(height-1)*math.Sqrt(3)/2 + 1)
+}
+
+// the rectangular grid that renders to a width x height image
+func grid_size(image_width, image_height int) (int, int) {
+	height := 2
+	for rendered_height(height) < image_height {
+		height++
+	}
+	width := image_width
+	for int(float64(width)-float64(rendered_height(height)-1)/math.Sqrt(3)) < image_width {
+		width++
+	}
+	// a square grid has the hexagonal border, the image gets a pixel wider instead
+	if width == height {
+		width++
+	}
+	return width, height
+}
+
+// where the middle of the hexagon ends up in the image, pixel x, y covers x to x+1 and y to y+1
+func cell_position(i, j, width, height int) (float64, float64) {
+	image_width, image_height := rendered_size(width, height)
+	middle_i, middle_j := grid_middle(width, height)
+	di, dj := float64(i)-middle_i, float64(j)-middle_j
+	return float64(image_width)/2 + di + dj/2, float64(image_height)/2 + dj*math.Sqrt(3)/2
+}
+
+// the other way around, the axial coordinates of a point of the image, between the hexagons
+func image_to_cell(x, y float64, width, height int) (float64, float64) {
+	image_width, image_height := rendered_size(width, height)
+	middle_i, middle_j := grid_middle(width, height)
+	dj := (y - float64(image_height)/2) * 2 / math.Sqrt(3)
+	di := x - float64(image_width)/2 - dj/2
+	return middle_i + di, middle_j + dj
+}
+
+// amount of hexagons between the hexagon and the middle of the matrix
+func hex_distance(i, j, size int) float64 {
+	x := i - size/2
+	z := j - size/2
+	y := -x - z
+
+	return math.Max(math.Max(math.Abs(float64(x)), math.Abs(float64(y))), math.Abs(float64(z)))
 }
 
-func step(A, B, Y float64, coldness_matrix *Matrix, mask_matrix *Mask) {
+// converts an initial value to the type of the grid
+func to_value[T Value](v float64) T {
+	var zero T
+	if _, ok := any(zero).(Fixed); ok {
+		return T(background_fixed(v))
+	}
+	return T(v)
+}
+
+func step[T Real](A, B, Y float64, coldness_matrix, temp_coldness_matrix *Grid[T], mask_matrix *Mask) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+
 	// look for frozen hexagons and set receptive values on the mask
 	for i := 0; i < size; i++ {
-		for j := 0; j < size; j++ {
+		for j := 0; j < height; j++ {
 			if (*coldness_matrix)[i][j] >= 1.0 {
 				(*mask_matrix)[i-1][j] = receptive
 				(*mask_matrix)[i-1][j+1] = receptive
@@ -117,64 +2302,114 @@ func step(A, B, Y float64, coldness_matrix *Matrix, mask_matrix *Mask) {
 		}
 	}
 
-	// create next itteration of the coldness matrix
-	var temp_coldness_matrix Matrix
+	// create next itteration of the coldness matrix, the temp matrix is reused between steps
+	for i := range *temp_coldness_matrix {
+		for j := range (*temp_coldness_matrix)[i] {
+			(*temp_coldness_matrix)[i][j] = 0
+		}
+	}
 
 	for i := 0; i < size; i++ {
-		for j := 0; j < size; j++ {
+		for j := 0; j < height; j++ {
 			switch {
 			case (*mask_matrix)[i][j] == non_receptive:
 				// simulate water floating out to it's neighbour hexagons
 				v0 := (*coldness_matrix)[i][j]
-				v1 := A * v0 / 12.0
+				v1 := T(A) * v0 / 12.0
 
-				temp_coldness_matrix[i-1][j] += v1
-				temp_coldness_matrix[i-1][j+1] += v1
-				temp_coldness_matrix[i][j-1] += v1
-				temp_coldness_matrix[i][j] += v0 / 2.0
-				temp_coldness_matrix[i][j+1] += v1
-				temp_coldness_matrix[i+1][j-1] += v1
-				temp_coldness_matrix[i+1][j] += v1
+				(*temp_coldness_matrix)[i-1][j] += v1
+				(*temp_coldness_matrix)[i-1][j+1] += v1
+				(*temp_coldness_matrix)[i][j-1] += v1
+				(*temp_coldness_matrix)[i][j] += v0 / 2.0
+				(*temp_coldness_matrix)[i][j+1] += v1
+				(*temp_coldness_matrix)[i+1][j-1] += v1
+				(*temp_coldness_matrix)[i+1][j] += v1
 
 			case (*mask_matrix)[i][j] == receptive:
 				// add constant to hexagons next to already frozen hexagon
-				temp_coldness_matrix[i][j] += (*coldness_matrix)[i][j] + Y
+				(*temp_coldness_matrix)[i][j] += (*coldness_matrix)[i][j] + T(Y)
 
 			default:
-				// ignore out of bound
+				// ignore out of bound and obstacles
 			}
 		}
 	}
 
-	// overwrite the old coldness matrix with the new coldness matrix
-	*coldness_matrix = temp_coldness_matrix
+	// swap so the new coldness matrix becomes the current one
+	*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
 }
 
-func save(filename string, matrix *Matrix) {
-	// create empty canvas
-	img := image.NewRGBA(image.Rect(0, 0, size, size))
+// turns the coldness matrix into the final image
+func render[T Real](matrix *Grid[T]) image.Image {
+	return render_resampled(matrix, resample_kernels["bilinear"], 0)
+}
 
-	// draw coldness matrixs values to pixels
-	for x := 0; x < size; x++ {
-		for y := 0; y < size; y++ {
-			c := math.Min((matrix[x][y] * 255), 255)
-			img.Set(x, y, color.Gray{uint8(c)})
-		}
-	}
+// saves the rendered matrix with the metadata (can be nil), as png unless the filename ends in .jpg or
+// .tif, the filename - writes a png to stdout
+func save[T Real](filename string, matrix *Grid[T], metadata map[string]string) error {
+	return save_image(filename, render(matrix), metadata)
+}
 
-	// shear the image horizontally
-	img = transform.ShearH(img, -30)
+// the format is decided by the extension, jpeg and tiff files get the metadata as exif and xmp
+func save_image(filename string, img image.Image, metadata map[string]string) error {
+	return save_image_context(context.Background(), filename, img, metadata)
+}
 
-	// crop it in the middle (magic to find the middle after the shear)
-	c := float64(size) / math.Cos(math.Pi/6.0)
-	a := math.Sqrt(math.Pow(c, 2) - math.Pow(float64(size), 2))
-	img = transform.Crop(img, image.Rect(int(a/2), 0, int(a/2)+size, size))
+// like save_image, but stops with the error of the context when it's cancelled, a file that was
+// started is removed and an object in a bucket isn't uploaded
+func save_image_context(ctx context.Context, filename string, img image.Image, metadata map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if filename == "-" {
+		w := bufio.NewWriter(os.Stdout)
+		if err := write_png_context(ctx, w, img, metadata); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
 
-	// fill out the emptyness from the shear
-	img = adjust.Apply(img, func(r color.RGBA) color.RGBA {
-		r.A = 255
-		return r
-	})
+	write := func(w io.Writer, img image.Image, metadata map[string]string) error {
+		return write_png_context(ctx, w, img, metadata)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg":
+		write = write_jpeg
+	case ".tif", ".tiff":
+		write = write_tiff
+	}
+	file, err := create_output(filename, metadata)
+	if err != nil {
+		return err
+	}
+	err = write(context_writer{ctx, file}, img, metadata)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
 
-	imgio.Save(filename, img, imgio.PNGEncoder())
+// the metadata save_image wrote to a png or jpeg, empty for other images
+func read_metadata(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var metadata map[string]string
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg":
+		metadata, err = read_jpeg_metadata(r)
+	default:
+		metadata, err = read_png_metadata(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return metadata, nil
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"image"
+	"math"
+	"testing"
+)
+
+// square grids of odd and even sizes and rectangular ones, wider and taller
+var test_grids = []struct{ width, height int }{
+	{8, 8}, {9, 9}, {64, 64}, {65, 65}, {800, 800}, {801, 801},
+	{120, 100}, {121, 101}, {1489, 578}, {1490, 579}, {60, 200}, {61, 201},
+}
+
+func TestCellPositionRoundTrip(t *testing.T) {
+	for _, grid := range test_grids {
+		for _, cell := range [][2]int{{0, 0}, {grid.width - 1, grid.height - 1}, {grid.width / 3, grid.height / 2}, {grid.width / 2, grid.height - 1}, {3, 5}} {
+			x, y := cell_position(cell[0], cell[1], grid.width, grid.height)
+			i, j := image_to_cell(x, y, grid.width, grid.height)
+			if math.Abs(i-float64(cell[0])) > 1e-9 || math.Abs(j-float64(cell[1])) > 1e-9 {
+				t.Errorf("%dx%d: hexagon %d,%d is at %g,%g, which goes back to %g,%g", grid.width, grid.height, cell[0], cell[1], x, y, i, j)
+			}
+		}
+	}
+}
+
+func TestMiddleIsInTheCentre(t *testing.T) {
+	for _, grid := range test_grids {
+		image_width, image_height := rendered_size(grid.width, grid.height)
+		middle_i, middle_j := grid_middle(grid.width, grid.height)
+		x, y := cell_position(int(middle_i), int(middle_j), grid.width, grid.height)
+		if middle_i != math.Trunc(middle_i) || middle_j != math.Trunc(middle_j) {
+			// an even side of a rectangular grid has its middle between two hexagons
+			i, j := image_to_cell(float64(image_width)/2, float64(image_height)/2, grid.width, grid.height)
+			if i != middle_i || j != middle_j {
+				t.Errorf("%dx%d: the centre of the image is at %g,%g, not the middle %g,%g", grid.width, grid.height, i, j, middle_i, middle_j)
+			}
+			continue
+		}
+		if x != float64(image_width)/2 || y != float64(image_height)/2 {
+			t.Errorf("%dx%d: the middle hexagon %g,%g is at %g,%g, not in the centre of the %dx%d image", grid.width, grid.height, middle_i, middle_j, x, y, image_width, image_height)
+		}
+	}
+}
+
+func TestSquareGridsHaveTheirMiddleHexagon(t *testing.T) {
+	// the seed of a square grid is a hexagon, in the middle of a pixel for odd sizes and on the corner
+	// of four for even ones
+	for size := 8; size <= 64; size++ {
+		x, y := cell_position(size/2, size/2, size, size)
+		if x != float64(size)/2 || y != float64(size)/2 {
+			t.Errorf("size %d: the seed is at %g,%g", size, x, y)
+		}
+	}
+}
+
+func TestGridSizeInvertsRenderedSize(t *testing.T) {
+	for image_width := 8; image_width < 300; image_width++ {
+		for image_height := 8; image_height < 300; image_height += 5 {
+			width, height := grid_size(image_width, image_height)
+			w, h := rendered_size(width, height)
+			// a square grid would get the hexagonal border, so it is a hexagon wider
+			wider := width == height+1 && w == image_width+1
+			if h != image_height || (w != image_width && !wider) {
+				t.Fatalf("the grid for a %dx%d image is %dx%d, which renders %dx%d", image_width, image_height, width, height, w, h)
+			}
+			if shorter, _ := rendered_size(width, height-1); height > 8 && rendered_height(height-1) >= image_height && shorter >= image_width {
+				t.Fatalf("the grid for a %dx%d image is %dx%d, a row less is enough", image_width, image_height, width, height)
+			}
+		}
+	}
+}
+
+func TestPaddedGridSizeInvertsPaddedSize(t *testing.T) {
+	for image_width := 40; image_width < 300; image_width++ {
+		for image_height := 8; image_height < 60; image_height += 3 {
+			width, height := padded_grid_size(image_width, image_height)
+			w, h := padded_size(width, height)
+			// a square grid would get the hexagonal border, so it is a hexagon wider
+			wider := width == height+1 && w == image_width+1
+			if h != image_height || (w != image_width && !wider) {
+				t.Fatalf("the padded grid for a %dx%d image is %dx%d, which renders %dx%d", image_width, image_height, width, height, w, h)
+			}
+		}
+	}
+}
+
+func TestPaddedImageHasTheWholeMatrix(t *testing.T) {
+	for _, grid := range test_grids {
+		if grid.width == grid.height {
+			continue
+		}
+		matrix := make(Matrix, grid.width)
+		for i := range matrix {
+			matrix[i] = make([]float64, grid.height)
+			for j := range matrix[i] {
+				matrix[i][j] = 1
+			}
+		}
+		img := render_padded(&matrix).(*image.Gray)
+		width, _ := padded_size(grid.width, grid.height)
+		// the first and the last hexagon of the first row are at the left and right edge
+		if img.GrayAt(0, 0).Y != 255 || img.GrayAt(grid.width-1, 0).Y != 255 || img.GrayAt(width-1, 0).Y != 0 {
+			t.Errorf("%dx%d: the first row of the padded image isn't the first row of the matrix", grid.width, grid.height)
+		}
+	}
+}
+
+// the result of grow_frames tells a finished run from a cancelled one
+func TestGrowFramesResult(t *testing.T) {
+	settings := default_settings()
+	settings.Size, settings.L = 40, 200
+	frames, result := grow_frames(context.Background(), settings, 50)
+	count := 0
+	for range frames {
+		count++
+	}
+	// iterations 0, 50, 100, 150 and 200
+	if err := result(); err != nil || count != 5 {
+		t.Errorf("finished run: %d frames, %v", count, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	frames, result = grow_frames(ctx, settings, 50)
+	<-frames
+	cancel()
+	for range frames {
+	}
+	if err := result(); err != context.Canceled {
+		t.Errorf("cancelled run: %v", err)
+	}
+
+	if _, result := grow_frames(context.Background(), settings, 0); result() == nil {
+		t.Errorf("a frame every 0 iterations has no error")
+	}
+}
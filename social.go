@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// note:
+// The social presets put the rendered flake in the middle of an image with the size the sites want,
+// with some padding around it and an optional caption below it. The whole image gets the background of
+// the theme, the caption gets the color of the frozen hexagons.
+
+type social_preset struct {
+	width, height int
+}
+
+var social_presets = map[string]social_preset{
+	"instagram": {1080, 1080},
+	"twitter":   {1600, 900},
+	"og":        {1200, 630}, // open graph, the preview image of a link
+}
+
+func social_frame(img image.Image, name, caption string, t theme) (image.Image, error) {
+	preset, ok := social_presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown social preset %q, use instagram, twitter or og", name)
+	}
+
+	short := math.Min(float64(preset.width), float64(preset.height))
+	padding := int(short * 0.05)
+	scale := int(math.Max(1, math.Round(short/400)))
+	// long captions get a smaller font so they fit
+	caption_width, caption_height := text_size(caption, scale)
+	for scale > 1 && caption_width > preset.width-2*padding {
+		scale--
+		caption_width, caption_height = text_size(caption, scale)
+	}
+	if caption == "" {
+		caption_height = 0
+	}
+
+	// the flake is square and gets all the room that's left
+	side := preset.height - 2*padding
+	if caption_height > 0 {
+		side -= caption_height + padding/2
+	}
+	side = int(math.Min(float64(side), float64(preset.width-2*padding)))
+	flake := transform.Resize(img, side, side, transform.Linear)
+
+	ink := t.ink()
+	out := image.NewRGBA(image.Rect(0, 0, preset.width, preset.height))
+	left, top := (preset.width-side)/2, padding
+	for y := 0; y < preset.height; y++ {
+		for x := 0; x < preset.width; x++ {
+			g := 0.0
+			if x >= left && x < left+side && y >= top && y < top+side {
+				g = float64(color.GrayModel.Convert(flake.At(x-left, y-top)).(color.Gray).Y) / 255
+			}
+			out.SetRGBA(x, y, t.at(x, y, g))
+		}
+	}
+
+	if caption_height > 0 {
+		draw_text(out, (preset.width-caption_width)/2, top+side+padding/2, caption, scale, ink)
+	}
+	return out, nil
+}
+
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	blend := func(x, y uint8) uint8 { return uint8(math.Round(float64(x)*(1-t) + float64(y)*t)) }
+	return color.RGBA{blend(a.R, b.R), blend(a.G, b.G), blend(a.B, b.B), 255}
+}
+
+// the gray palette on a colored background
+func social_palette(background color.RGBA) palette {
+	if luminance(background) > 0.5 {
+		return palette{[]color.RGBA{background, {0, 0, 0, 255}}, false}
+	}
+	return palette{[]color.RGBA{background, {255, 255, 255, 255}}, false}
+}
+
+func luminance(c color.RGBA) float64 {
+	return (0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)) / 255
+}
+
+// parses #rgb or #rrggbb, the # is optional
+func parse_color(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, errors.New("colors are #rgb or #rrggbb")
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, errors.New("colors are #rgb or #rrggbb")
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// note:
+// The background gives the flake the same vapor everywhere it grows, -source puts more of it in some
+// places to steer the growth. A source adds its rate to the vapor of its hexagons after every step, a
+// negative rate makes it a sink that takes vapor away, down to 0. The arms in front of a source get more
+// vapor and outgrow the rest, a sink starves the arms reaching for it. -source can be given more than
+// once:
+//
+//   x,y,rate             a point, the hexagon x,y like -seed-pos
+//   x,y,x2,y2,rate       a line from x,y to x2,y2, every hexagon on the way
+//
+// Only vapor is changed, the ice and the hexagons next to it are left alone. A source that gets the vapor
+// of a hexagon to 1 freezes it and a new flake grows from there, with 1 0.4 0.001 a line of 0.01 does
+// that within 2500 iterations and one of 0.004 doesn't. A point spreads out over a few hexagons, it only
+// steers the arm that grows right into it, lines reach a whole side. The hexagons are x,y of the matrix,
+// a growing grid would move them, so the grid can't grow with sources.
+
+type Source struct {
+	X, Y   int
+	X2, Y2 int // the end of a line, the same as X,Y for a point
+	Rate   float64
+}
+
+func (source Source) String() string {
+	if source.X == source.X2 && source.Y == source.Y2 {
+		return fmt.Sprintf("%d,%d,%g", source.X, source.Y, source.Rate)
+	}
+	return fmt.Sprintf("%d,%d,%d,%d,%g", source.X, source.Y, source.X2, source.Y2, source.Rate)
+}
+
+// -source can be given more than once
+func source_flag(flags *flag.FlagSet, sources *[]string) {
+	flags.Func("source", "x,y,rate or x,y,x2,y2,rate adds rate to the vapor of the hexagon or the line every iteration, below 0 takes it away, can be given more than once", func(s string) error {
+		_, err := parse_source(s)
+		if err == nil {
+			*sources = append(*sources, s)
+		}
+		return err
+	})
+}
+
+func parse_source(s string) (Source, error) {
+	var source Source
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 && len(parts) != 5 {
+		return source, fmt.Errorf("expected x,y,rate or x,y,x2,y2,rate but got %q", s)
+	}
+	cells := make([]int, len(parts)-1)
+	for k := range cells {
+		var err error
+		if cells[k], err = strconv.Atoi(strings.TrimSpace(parts[k])); err != nil {
+			return source, err
+		}
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(parts[len(parts)-1]), 64)
+	if err != nil {
+		return source, err
+	}
+	source = Source{cells[0], cells[1], cells[0], cells[1], rate}
+	if len(cells) == 4 {
+		source.X2, source.Y2 = cells[2], cells[3]
+	}
+	return source, nil
+}
+
+// the hexagons of the source, one for every step along the line
+func (source Source) cells() [][2]int {
+	steps := int(hex_distance(source.X2-source.X, source.Y2-source.Y, 0))
+	cells := make([][2]int, 0, steps+1)
+	for k := 0; k <= steps; k++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(k) / float64(steps)
+		}
+		x := float64(source.X) + t*float64(source.X2-source.X)
+		y := float64(source.Y) + t*float64(source.Y2-source.Y)
+		// rounded in cube coordinates, z is the third axis of the hex grid
+		z := -x - y
+		rx, ry, rz := math.Round(x), math.Round(y), math.Round(z)
+		// the coordinate rounded the most is the one that doesn't add up
+		dx, dy, dz := math.Abs(rx-x), math.Abs(ry-y), math.Abs(rz-z)
+		switch {
+		case dx > dy && dx > dz:
+			rx = -ry - rz
+		case dy > dz:
+			ry = -rx - rz
+		}
+		cells = append(cells, [2]int{int(rx), int(ry)})
+	}
+	return cells
+}
+
+// the sources of the settings, they're kept as the text of the flags so the settings stay comparable
+func (settings Settings) sources() ([]Source, error) {
+	if settings.Sources == "" {
+		return nil, nil
+	}
+	var sources []Source
+	for _, s := range strings.Split(settings.Sources, ";") {
+		source, err := parse_source(s)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// adds the vapor of the sources, called after a step
+func apply_sources[T Real](sources []Source, coldness_matrix *Grid[T], mask_matrix *Mask) {
+	for _, source := range sources {
+		rate := T(source.Rate)
+		for _, cell := range source.cells() {
+			i, j := cell[0], cell[1]
+			if (*mask_matrix)[i][j] != non_receptive || (*coldness_matrix)[i][j] >= 1.0 {
+				continue
+			}
+			(*coldness_matrix)[i][j] = T(math.Max(0, float64((*coldness_matrix)[i][j]+rate)))
+		}
+	}
+}
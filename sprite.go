@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+)
+
+// note:
+// Sprites are for game engines and particle systems: only the frozen hexagons, cropped tight, on a
+// transparent background. The colors are premultiplied with the alpha (what most engines blend with),
+// the png is written with those values as they are. The descriptor next to it has the trim rectangle
+// (where the flake is in the sprite) and the pivot at the seed, from 0 to 1 from the top left corner.
+
+type sprite_rect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type sprite_pivot struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type sprite_descriptor struct {
+	Image         string       `json:"image"`
+	Width         int          `json:"width"`
+	Height        int          `json:"height"`
+	Trim          sprite_rect  `json:"trim"`
+	Pivot         sprite_pivot `json:"pivot"`
+	Premultiplied bool         `json:"premultiplied"`
+}
+
+// renders the frozen hexagons in the color with the rendered brightness as alpha
+func frozen_layer(values Matrix, ink color.RGBA) *image.NRGBA {
+	mask := new_matrix_like(values)
+	for i := range values {
+		for j, v := range values[i] {
+			if v >= 1.0 {
+				mask[i][j] = 1
+			}
+		}
+	}
+	return mask_layer(mask, ink)
+}
+
+// renders a matrix of zeros and ones in the color with the rendered brightness as alpha
+func mask_layer(mask Matrix, ink color.RGBA) *image.NRGBA {
+	rendered := render(&mask)
+	bounds := rendered.Bounds()
+	layer := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			a := color.GrayModel.Convert(rendered.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			layer.SetNRGBA(x, y, color.NRGBA{ink.R, ink.G, ink.B, a})
+		}
+	}
+	return layer
+}
+
+// where the seed ends up in the rendered image
+func rendered_seed(settings Settings) image.Point {
+	i, j := settings.seed_pos()
+	x, y := cell_position(i, j, settings.Size, settings.height())
+	return image.Pt(int(x), int(y))
+}
+
+// crops the layer to what isn't transparent, optionally padded to a power of two, with premultiplied colors
+func make_sprite(layer *image.NRGBA, seed image.Point, power_of_two bool) (*image.NRGBA, sprite_descriptor) {
+	trim := opaque_bounds(layer)
+	if trim.Empty() {
+		trim = image.Rect(seed.X, seed.Y, seed.X+1, seed.Y+1)
+	}
+
+	width, height := trim.Dx(), trim.Dy()
+	if power_of_two {
+		width, height = next_power_of_two(width), next_power_of_two(height)
+	}
+	offset := image.Pt((width-trim.Dx())/2, (height-trim.Dy())/2)
+
+	sprite := image.NewNRGBA(image.Rect(0, 0, width, height))
+	premultiplied_copy(sprite, offset, layer, trim)
+
+	pivot := seed.Sub(trim.Min).Add(offset)
+	return sprite, sprite_descriptor{
+		Width:         width,
+		Height:        height,
+		Trim:          sprite_rect{offset.X, offset.Y, trim.Dx(), trim.Dy()},
+		Pivot:         sprite_pivot{float64(pivot.X) / float64(width), float64(pivot.Y) / float64(height)},
+		Premultiplied: true,
+	}
+}
+
+// the smallest rectangle around everything that isn't transparent
+func opaque_bounds(layer *image.NRGBA) image.Rectangle {
+	trim := image.Rectangle{}
+	bounds := layer.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if layer.NRGBAAt(x, y).A > 0 {
+				trim = trim.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return trim
+}
+
+// copies the part of the layer to at in dst, with the colors multiplied by the alpha
+func premultiplied_copy(dst *image.NRGBA, at image.Point, layer *image.NRGBA, part image.Rectangle) {
+	for y := part.Min.Y; y < part.Max.Y; y++ {
+		for x := part.Min.X; x < part.Max.X; x++ {
+			c := layer.NRGBAAt(x, y)
+			premultiply := func(v uint8) uint8 { return uint8((int(v)*int(c.A) + 127) / 255) }
+			dst.SetNRGBA(x-part.Min.X+at.X, y-part.Min.Y+at.Y, color.NRGBA{premultiply(c.R), premultiply(c.G), premultiply(c.B), c.A})
+		}
+	}
+}
+
+func next_power_of_two(n int) int {
+	return int(math.Pow(2, math.Ceil(math.Log2(float64(n)))))
+}
+
+func save_json(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+)
+
+// note:
+// A sprite sheet is a flipbook of the growth: columns x rows stages at evenly spaced iterations, from
+// the first stage to the end of the simulation, left to right and top to bottom. The stages are taken
+// from the timeline so the simulation doesn't have to stop for them. Every frame has the size of the
+// finished flake, it only grows, and is drawn like a sprite.
+
+type sheet_frame struct {
+	X         int   `json:"x"`
+	Y         int   `json:"y"`
+	W         int   `json:"w"`
+	H         int   `json:"h"`
+	Iteration int64 `json:"iteration"`
+}
+
+type sheet_descriptor struct {
+	Image         string        `json:"image"`
+	Columns       int           `json:"columns"`
+	Rows          int           `json:"rows"`
+	FrameWidth    int           `json:"frame_width"`
+	FrameHeight   int           `json:"frame_height"`
+	Pivot         sprite_pivot  `json:"pivot"`
+	Premultiplied bool          `json:"premultiplied"`
+	Frames        []sheet_frame `json:"frames"`
+}
+
+// parses 8x8 into columns and rows
+func parse_sheet_size(s string) (int, int, error) {
+	var columns, rows int
+	if _, err := fmt.Sscanf(s, "%dx%d", &columns, &rows); err != nil || columns < 1 || rows < 1 {
+		return 0, 0, fmt.Errorf("sprite sheets are columns x rows, like 8x8")
+	}
+	return columns, rows, nil
+}
+
+func make_spritesheet(t *timeline, state *State, columns, rows int, ink color.RGBA) (*image.NRGBA, sheet_descriptor) {
+	stages := columns * rows
+	last := state.Iteration
+
+	// the last stage is the biggest and decides the size of the frames
+	stage := func(k int) (*image.NRGBA, int64) {
+		iteration := last * int64(k+1) / int64(stages)
+		mask := new_rect_grid[float64](len(t.frozen_at), len(t.frozen_at[0]))
+		for i, row := range t.frozen_at {
+			for j, v := range row {
+				if v != never_frozen && int64(v) <= iteration {
+					mask[i][j] = 1
+				}
+			}
+		}
+		return mask_layer(mask, ink), iteration
+	}
+	final, _ := stage(stages - 1)
+	trim := opaque_bounds(final)
+	if trim.Empty() {
+		trim = image.Rect(0, 0, 1, 1)
+	}
+	seed := rendered_seed(state.Settings).Sub(trim.Min)
+
+	sheet := image.NewNRGBA(image.Rect(0, 0, columns*trim.Dx(), rows*trim.Dy()))
+	descriptor := sheet_descriptor{
+		Columns:       columns,
+		Rows:          rows,
+		FrameWidth:    trim.Dx(),
+		FrameHeight:   trim.Dy(),
+		Pivot:         sprite_pivot{float64(seed.X) / float64(trim.Dx()), float64(seed.Y) / float64(trim.Dy())},
+		Premultiplied: true,
+	}
+	// rendering is the slow part, the stages are rendered in parallel into their own part of the sheet
+	descriptor.Frames = make([]sheet_frame, stages)
+	next := make(chan int)
+	var wait sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for k := range next {
+				layer, iteration := stage(k)
+				at := image.Pt(k%columns*trim.Dx(), k/columns*trim.Dy())
+				premultiplied_copy(sheet, at, layer, trim)
+				descriptor.Frames[k] = sheet_frame{at.X, at.Y, trim.Dx(), trim.Dy(), iteration}
+			}
+		}()
+	}
+	for k := 0; k < stages; k++ {
+		next <- k
+	}
+	close(next)
+	wait.Wait()
+	return sheet, descriptor
+}
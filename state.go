@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// note:
+// State files store a simulation so it can be resumed, compared or rendered again later.
+// All numbers are little endian.
+//
+//   magic      6 bytes   "SNOWST"
+//   version    uint16    state_version
+//   header     uint32    length, followed by that many bytes of json (state_header)
+//   payload    uint64    length, followed by that many bytes of zstd compressed data
+//   checksum   32 bytes  sha256 of the header json and the uncompressed payload
+//
+// The uncompressed payload is the coldness matrix followed by the mask matrix, both row by row, and the
+// float64 matrices of the fields after them, shuffled the same way: the temperature with latent heat,
+// the vapor, boundary and crystal mass with attachment kinetics.
+// The float64 (float32 with 32 bit precision, int64 with fixed point values) values are stored byte
+// shuffled: first byte 0 of every value, then byte 1 and so on.
+// Neighbouring values share most of their sign, exponent and high mantissa bytes, so this makes
+// long runs of equal bytes that compress a lot better than the plain values.
+//
+// Version 2 added the float32 and fixed point encodings, the height and the fields. A version 1 file
+// is a version 2 file without them, so both are read, while readers of version 1 turn down the new
+// files instead of misreading them. The version goes up whenever the layout changes.
+//
+// The lengths are checked before anything is allocated, the header against state_max_header and the
+// payload against what the grid of the header can compress to, and the compressed bytes are read as
+// they come, so a corrupt or truncated file is an error and not a huge allocation.
+
+const state_magic = "SNOWST"
+const state_version uint16 = 2
+
+// a header is a few hundred bytes of json
+const state_max_header = 1 << 20
+
+// the longest side of a grid in a state file
+const state_max_side = 1 << 16
+
+type state_header struct {
+	Settings  Settings `json:"settings"`
+	Iteration int64    `json:"iteration"`
+	Size      int      `json:"size"`
+	Height    int      `json:"height,omitempty"` // rows of a rectangular grid, square when 0
+	Encoding  string   `json:"encoding"`
+	Truncated *int64   `json:"truncated,omitempty"`
+}
+
+func save_state(filename string, state *State) error {
+	// write to a temporary file first so a crash never leaves a broken checkpoint behind
+	file, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filename + ".tmp")
+
+	if err := write_state(file, state); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func load_state(filename string) (*State, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	state, err := read_state(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return state, nil
+}
+
+// bytes per value for the encodings
+var state_encodings = map[string]int{"float64-shuffled": 8, "float32-shuffled": 4, "q32.32-shuffled": 8}
+
+func state_encoding(settings Settings) string {
+	switch {
+	case settings.Fixed:
+		return "q32.32-shuffled"
+	case settings.single_precision():
+		return "float32-shuffled"
+	}
+	return "float64-shuffled"
+}
+
+func write_state(w io.Writer, state *State) error {
+	size, height := len(state.Mask), len(state.Mask[0])
+	encoding := state_encoding(state.Settings)
+	width := state_encodings[encoding]
+	header := state_header{
+		Settings:  state.Settings,
+		Iteration: state.Iteration,
+		Size:      size,
+		Encoding:  encoding,
+		Truncated: state.truncated(),
+	}
+	if height != size {
+		header.Height = height
+	}
+	header_bytes, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	// build the uncompressed payload
+	cells := size * height
+	payload := make([]byte, cells*(width+1), state_payload(state.Settings, cells, width))
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			cell := i*height + j
+			var bits uint64
+			switch {
+			case state.Settings.Fixed:
+				bits = uint64(state.ColdnessFixed[i][j])
+			case state.Settings.single_precision():
+				bits = uint64(math.Float32bits(state.Coldness32[i][j]))
+			default:
+				bits = math.Float64bits(state.Coldness[i][j])
+			}
+			for b := 0; b < width; b++ {
+				payload[b*cells+cell] = byte(bits >> (8 * b))
+			}
+			payload[width*cells+cell] = state.Mask[i][j]
+		}
+	}
+	state.init_fields()
+	for _, field := range state.fields() {
+		payload = append(payload, shuffle(*field)...)
+	}
+
+	checksum := sha256.New()
+	checksum.Write(header_bytes)
+	checksum.Write(payload)
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return err
+	}
+	compressed := encoder.EncodeAll(payload, nil)
+	encoder.Close()
+
+	out := bufio.NewWriter(w)
+	out.WriteString(state_magic)
+	binary.Write(out, binary.LittleEndian, state_version)
+	binary.Write(out, binary.LittleEndian, uint32(len(header_bytes)))
+	out.Write(header_bytes)
+	binary.Write(out, binary.LittleEndian, uint64(len(compressed)))
+	out.Write(compressed)
+	out.Write(checksum.Sum(nil))
+	return out.Flush()
+}
+
+func read_state(r io.Reader) (*State, error) {
+	magic := make([]byte, len(state_magic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != state_magic {
+		return nil, errors.New("not a state file")
+	}
+
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version < 1 || version > state_version {
+		return nil, fmt.Errorf("unsupported state file version %d", version)
+	}
+
+	var header_length uint32
+	if err := binary.Read(r, binary.LittleEndian, &header_length); err != nil {
+		return nil, err
+	}
+	if header_length > state_max_header {
+		return nil, fmt.Errorf("header of %d bytes, the file is corrupt", header_length)
+	}
+	header_bytes := make([]byte, header_length)
+	if _, err := io.ReadFull(r, header_bytes); err != nil {
+		return nil, err
+	}
+	var header state_header
+	if err := json.Unmarshal(header_bytes, &header); err != nil {
+		return nil, fmt.Errorf("bad header: %v", err)
+	}
+	width, ok := state_encodings[header.Encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding %q", header.Encoding)
+	}
+
+	size, height := header.Size, header.Height
+	if height == 0 {
+		height = size
+	}
+	if size < 1 || height < 1 || size > state_max_side || height > state_max_side {
+		return nil, fmt.Errorf("grid of %dx%d in the header, the file is corrupt", size, height)
+	}
+	cells := size * height
+	expected := state_payload(header.Settings, cells, width)
+
+	var compressed_length uint64
+	if err := binary.Read(r, binary.LittleEndian, &compressed_length); err != nil {
+		return nil, err
+	}
+	// zstd never makes data more than a little larger
+	if compressed_length > uint64(expected+expected/128+1<<16) {
+		return nil, fmt.Errorf("payload of %d bytes is more than a %dx%d grid can take, the file is corrupt", compressed_length, size, height)
+	}
+	var compressed bytes.Buffer
+	if _, err := io.CopyN(&compressed, r, int64(compressed_length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	stored_checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, stored_checksum); err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(expected)))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	payload, err := decoder.DecodeAll(compressed.Bytes(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt payload: %v", err)
+	}
+
+	checksum := sha256.New()
+	checksum.Write(header_bytes)
+	checksum.Write(payload)
+	if !bytes.Equal(checksum.Sum(nil), stored_checksum) {
+		return nil, errors.New("checksum mismatch, the file is corrupt")
+	}
+
+	if len(payload) != expected || state_encoding(header.Settings) != header.Encoding {
+		return nil, errors.New("payload doesn't match the header")
+	}
+
+	state := &State{
+		Settings:  header.Settings,
+		Iteration: header.Iteration,
+		Truncated: -1,
+		Mask:      new_rect_mask(size, height),
+	}
+	if header.Truncated != nil {
+		state.Truncated = *header.Truncated
+	}
+	switch {
+	case header.Settings.Fixed:
+		state.ColdnessFixed = new_rect_grid[Fixed](size, height)
+	case header.Settings.single_precision():
+		state.Coldness32 = new_rect_grid[float32](size, height)
+	default:
+		state.Coldness = new_rect_grid[float64](size, height)
+	}
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			cell := i*height + j
+			var bits uint64
+			for b := 0; b < width; b++ {
+				bits |= uint64(payload[b*cells+cell]) << (8 * b)
+			}
+			switch {
+			case header.Settings.Fixed:
+				state.ColdnessFixed[i][j] = Fixed(bits)
+			case header.Settings.single_precision():
+				state.Coldness32[i][j] = math.Float32frombits(uint32(bits))
+			default:
+				state.Coldness[i][j] = math.Float64frombits(bits)
+			}
+			state.Mask[i][j] = payload[width*cells+cell]
+		}
+	}
+	for k, field := range state.fields() {
+		*field = unshuffle(payload[cells*(width+1+8*k):], size, height)
+	}
+	return state, nil
+}
+
+// bytes of the uncompressed payload
+func state_payload(settings Settings, cells, width int) int {
+	return cells*(width+1) + len((&State{Settings: settings}).fields())*cells*8
+}
+
+// the float64 matrices the settings need next to the coldness
+func (state *State) fields() []*Matrix {
+	var fields []*Matrix
+	if state.Settings.Heat != 0 {
+		fields = append(fields, &state.Temperature)
+	}
+	if state.Settings.Kinetics != nil {
+		fields = append(fields, &state.Vapor, &state.Boundary, &state.Crystal)
+	}
+	return fields
+}
+
+// creates the fields that don't exist yet, before the first step
+func (state *State) init_fields() {
+	if state.Settings.Heat != 0 && state.Temperature == nil {
+		// nothing has frozen yet
+		state.Temperature = new_rect_grid[float64](len(state.Mask), len(state.Mask[0]))
+	}
+	if state.Settings.Kinetics != nil && state.Vapor == nil {
+		state.Vapor, state.Boundary, state.Crystal = init_kinetics(state.Coldness)
+	}
+}
+
+// the float64 values of the matrix row by row, byte shuffled
+func shuffle(matrix Matrix) []byte {
+	size, height := len(matrix), len(matrix[0])
+	cells := size * height
+	out := make([]byte, cells*8)
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			bits := math.Float64bits(matrix[i][j])
+			for b := 0; b < 8; b++ {
+				out[b*cells+i*height+j] = byte(bits >> (8 * b))
+			}
+		}
+	}
+	return out
+}
+
+func unshuffle(data []byte, size, height int) Matrix {
+	matrix := new_rect_grid[float64](size, height)
+	cells := size * height
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			var bits uint64
+			for b := 0; b < 8; b++ {
+				bits |= uint64(data[b*cells+i*height+j]) << (8 * b)
+			}
+			matrix[i][j] = math.Float64frombits(bits)
+		}
+	}
+	return matrix
+}
+
+// snow render [-out file] state.snow
+func render_state(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	out := flags.String("out", "", "output file, - streams the png to stdout (default is the state file name with .png)")
+	roi_flag := flags.String("roi", "", "x,y,w,h of the flake in cells (pixels at 1:1) to render magnified to the full size instead of all of it")
+	var insets []image.Rectangle
+	inset_flag(flags, &insets)
+	kaleidoscope := flags.Int("kaleidoscope", 0, "make the result 3, 6 or 12-fold symmetric around the seed from one wedge of the flake")
+	mirror := flags.Bool("mirror", false, "mirror the left half of the flake onto the right")
+	rotate := flags.String("rotate", "", "degrees to turn the flake clockwise, or random")
+	resample := flags.String("resample", "", "how the pixels take their value from the hexagons around them, "+resample_names()+" (default bilinear)")
+	scene := flags.String("scene", "", "also save the flake as a mesh in a scene with a camera, light and ice material next to the result, gltf (blender) or pov (pov-ray)")
+	render_mode := flags.String("render", "heatmap", "how the flake is colored: "+render_mode_names())
+	light_flag := flags.String("light", "-1,-1,1.3", "x,y,z towards the light of -render ice and volume, y is down and z towards the camera")
+	absorption := flags.Float64("absorption", 1, "how much red -render volume swallows, more makes the ice bluer and darker")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow render [flags] state.snow\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	state, err := load_state(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	filename := *out
+	if filename == "" {
+		filename = strings.TrimSuffix(flags.Arg(0), filepath.Ext(flags.Arg(0))) + ".png"
+	}
+	coldness_matrix := state.values()
+	if *kaleidoscope != 0 || *mirror {
+		if (*kaleidoscope != 0 && !valid_fold(*kaleidoscope)) || (*kaleidoscope != 0 && *mirror) || state.Settings.Tile {
+			fmt.Fprintln(os.Stderr, "-kaleidoscope is 3, 6 or 12, it can't be used with -mirror and tiles can't have either")
+			os.Exit(2)
+		}
+		x, y := state.Settings.seed_pos()
+		coldness_matrix = symmetric(coldness_matrix, x, y, *kaleidoscope)
+		if *out == "" {
+			filename = symmetric_filename(filename, *kaleidoscope)
+		}
+	}
+	var roi *image.Rectangle
+	if *roi_flag != "" {
+		r, err := parse_roi(*roi_flag)
+		if err == nil && state.Settings.Tile {
+			err = errors.New("tiles can't have a -roi")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bad -roi:", err)
+			os.Exit(2)
+		}
+		roi = &r
+		if *out == "" {
+			filename = roi_filename(filename, r)
+		}
+	}
+	kernel, rotation := resample_kernels["bilinear"], 0.0
+	if roi == nil && (*resample != "" || *rotate != "") {
+		var err error
+		if *resample != "" {
+			kernel, err = parse_resample(*resample)
+		}
+		if err == nil && *rotate != "" {
+			if rotation, err = parse_rotation(*rotate, rand.New(rand.NewSource(time.Now().UnixNano()))); err != nil {
+				err = fmt.Errorf("bad -rotate: %v", err)
+			}
+		}
+		if err == nil && state.Settings.Tile {
+			err = errors.New("tiles are always resampled bilinear and can't be rotated")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if rotation != 0 && *out == "" {
+			filename = rotated_filename(filename, rotation)
+		}
+	}
+	draw := func(matrix *Matrix) image.Image {
+		if roi != nil {
+			return render_roi(matrix, *roi)
+		}
+		if *resample != "" || *rotate != "" {
+			return render_resampled(matrix, kernel, rotation)
+		}
+		return render_settings(state.Settings, matrix)
+	}
+	var img image.Image
+	switch {
+	case !valid_render_mode(*render_mode):
+		fmt.Fprintf(os.Stderr, "unknown -render %q, use %s\n", *render_mode, render_mode_names())
+		os.Exit(2)
+	case *render_mode != "heatmap":
+		light, err := parse_light(*light_flag)
+		if err != nil {
+			err = fmt.Errorf("bad -light: %v", err)
+		}
+		if err == nil && *absorption < 0 {
+			err = errors.New("-absorption can't be less than 0")
+		}
+		if err == nil && (state.Settings.Tile || len(insets) > 0) {
+			err = errors.New("tiles and -inset can only be rendered as a heatmap")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		background := plain_theme(palettes["gray"]).background()
+		if *render_mode == "ice" {
+			img = render_ice(coldness_matrix, draw, background, light)
+		} else {
+			img = render_volume(coldness_matrix, draw, background, light, *absorption)
+		}
+	default:
+		img = draw(&coldness_matrix)
+	}
+	if len(insets) > 0 {
+		if *roi_flag != "" || *rotate != "" || state.Settings.Tile {
+			fmt.Fprintln(os.Stderr, "tiles, -roi and -rotate can't have insets")
+			os.Exit(2)
+		}
+		img = render_insets(img, &coldness_matrix, insets, plain_theme(palettes["gray"]))
+		if *out == "" {
+			filename = insets_filename(filename)
+		}
+	}
+	if err := save_image(filename, img, state.metadata()); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save result:", err)
+		os.Exit(1)
+	}
+	if filename != "-" {
+		fmt.Printf("rendered iteration %d:\t %s\n", state.Iteration, filename)
+	}
+	if *scene != "" {
+		name := filename
+		if name == "-" {
+			name = strings.TrimSuffix(flags.Arg(0), filepath.Ext(flags.Arg(0))) + ".png"
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + scene_formats[*scene]
+		if err := save_scene(name, *scene, coldness_matrix); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save scene:", err)
+			os.Exit(1)
+		}
+		fmt.Println("saved scene:\t", name)
+	}
+}
+
+// snow diff [-out diff.png] a.snow b.snow
+func diff_states(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	out := flags.String("out", "", "also save an image of where the states differ")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow diff [flags] a.snow b.snow\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a, err := load_state(flags.Arg(0))
+	if err == nil {
+		var b *State
+		if b, err = load_state(flags.Arg(1)); err == nil {
+			err = print_diff(a, b, *out)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func print_diff(a, b *State, out string) error {
+	if len(a.Mask) != len(b.Mask) || len(a.Mask[0]) != len(b.Mask[0]) {
+		return fmt.Errorf("can't compare size %dx%d with size %dx%d", len(a.Mask), len(a.Mask[0]), len(b.Mask), len(b.Mask[0]))
+	}
+	a_values, b_values := a.values(), b.values()
+
+	fmt.Printf("a:\t A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%d\n", a.Settings.A, a.Settings.B, a.Settings.Y, a.Settings.PP, a.Settings.PM, a.Iteration)
+	fmt.Printf("b:\t A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%d\n", b.Settings.A, b.Settings.B, b.Settings.Y, b.Settings.PP, b.Settings.PM, b.Iteration)
+
+	size, height := len(a.Mask), len(a.Mask[0])
+	difference := new_matrix_like(a_values)
+	changed, only_a, only_b := 0, 0, 0
+	max_difference, total := 0.0, 0.0
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			va, vb := a_values[i][j], b_values[i][j]
+			d := math.Abs(va - vb)
+			difference[i][j] = d
+			total += d
+			max_difference = math.Max(max_difference, d)
+			if va != vb {
+				changed++
+			}
+			if va >= 1.0 && vb < 1.0 {
+				only_a++
+			}
+			if vb >= 1.0 && va < 1.0 {
+				only_b++
+			}
+		}
+	}
+
+	fmt.Printf("changed cells:\t %d of %d\n", changed, size*height)
+	fmt.Printf("max difference:\t %g\n", max_difference)
+	fmt.Printf("mean difference:\t %g\n", total/float64(size*height))
+	fmt.Printf("frozen only in a:\t %d\n", only_a)
+	fmt.Printf("frozen only in b:\t %d\n", only_b)
+
+	if out == "" {
+		return nil
+	}
+
+	// scale so the largest difference is white
+	if max_difference > 0 {
+		for i := range difference {
+			for j := range difference[i] {
+				difference[i][j] /= max_difference
+			}
+		}
+	}
+	if err := save(out, &difference, nil); err != nil {
+		return err
+	}
+	fmt.Println("saved difference:\t", out)
+	return nil
+}
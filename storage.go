@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// note:
+// Outputs can go straight to object storage, an output named s3://bucket/key or gs://bucket/key is
+// uploaded instead of written to a file. That works for the result, the files saved next to it (stats,
+// timeline, animation...), the results of snow batch and, with -store, the flakes snow serve makes. An
+// -out that ends with / is a prefix, the file gets the name it would have had in snowflakes/. Checkpoints
+// and live images are rewritten and renamed while the flake grows, so they stay local files.
+//
+//   snow -out s3://flakes/2026/ 1 0.33 0.0002 0.05 0.2 10000
+//   snow batch -out gs://flakes/batch/ < jobs.ndjson
+//   snow serve -store s3://flakes/served/
+//
+// The upload is one PUT of the whole file when it's closed, signed with AWS signature version 4. The
+// credentials come from the environment like the cloud tools take them:
+//
+//   s3   AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN if the keys are temporary,
+//        AWS_REGION (or AWS_DEFAULT_REGION, us-east-1 without), and AWS_ENDPOINT_URL for other stores
+//        that speak s3 like minio or r2, those get the bucket in the path
+//   gs   GOOGLE_OAUTH_ACCESS_TOKEN (what gcloud auth print-access-token prints), or the HMAC keys of a
+//        service account in GOOGLE_HMAC_ACCESS_ID and GOOGLE_HMAC_SECRET, and STORAGE_EMULATOR_HOST
+//        for an emulator
+//
+// The content type comes from the extension unless -content-type says otherwise. The metadata of an
+// image (the settings, the code...) goes along as object metadata, and -object-metadata key=value adds
+// more to every object, like an owner or a project.
+
+// the options of the uploads, set by the flags of storage_flags
+var object_options = struct {
+	content_type string
+	metadata     map[string]string
+}{metadata: map[string]string{}}
+
+// adds -content-type and -object-metadata to the flags of a command
+func storage_flags(flags *flag.FlagSet) {
+	flags.StringVar(&object_options.content_type, "content-type", "", "content type of the objects uploaded to s3:// and gs://, by their extension if empty")
+	flags.Func("object-metadata", "key=value added to the metadata of every uploaded object, can be given more than once", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("%q is not key=value", s)
+		}
+		object_options.metadata[key] = value
+		return nil
+	})
+}
+
+// true for the names that are uploaded
+func is_object_url(name string) bool {
+	return strings.HasPrefix(name, "s3://") || strings.HasPrefix(name, "gs://")
+}
+
+// the name of a file in a directory, or in a bucket and prefix, the file itself without one
+func in_prefix(prefix, filename string) string {
+	if prefix == "" {
+		return filename
+	}
+	if is_object_url(prefix) {
+		return strings.TrimSuffix(prefix, "/") + "/" + path.Base(filepath.ToSlash(filename))
+	}
+	return filepath.Join(prefix, filepath.Base(filename))
+}
+
+// an output that is kept when it's closed, abort drops it instead after a write or an encoder failed
+type output interface {
+	io.WriteCloser
+	abort()
+}
+
+// a file to write an output to, an upload for s3:// and gs:// that happens when it's closed. metadata
+// is sent along with the upload.
+func create_output(filename string, metadata map[string]string) (output, error) {
+	if !is_object_url(filename) {
+		file, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		return file_output{file}, nil
+	}
+	if err := check_output(filename); err != nil {
+		return nil, err
+	}
+	return &object_writer{url: filename, metadata: metadata}, nil
+}
+
+// a local file, an aborted one is removed so nothing half written is left behind
+type file_output struct {
+	*os.File
+}
+
+func (f file_output) abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// the whole object is kept until it is closed
+type object_writer struct {
+	bytes.Buffer
+	url      string
+	metadata map[string]string
+}
+
+func (w *object_writer) Close() error {
+	return put_object(w.url, w.Bytes(), w.metadata)
+}
+
+// drops the object without uploading it, a truncated one would replace a good object under the same key
+func (w *object_writer) abort() {
+	w.Reset()
+}
+
+// the bucket and key of s3://bucket/key
+func split_object_url(name string) (string, string, error) {
+	rest := name[strings.Index(name, "://")+3:]
+	bucket, key, _ := strings.Cut(rest, "/")
+	if bucket == "" || key == "" || strings.HasSuffix(key, "/") {
+		return "", "", fmt.Errorf("%s needs a bucket and a name, like s3://bucket/flake.png", name)
+	}
+	return bucket, key, nil
+}
+
+// the content type of an object by its extension
+func content_type(name string) string {
+	if object_options.content_type != "" {
+		return object_options.content_type
+	}
+	switch ext := strings.ToLower(path.Ext(name)); ext {
+	case ".json":
+		return "application/json"
+	case ".history", ".snow", ".pfm":
+		return "application/octet-stream"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+	}
+	return "application/octet-stream"
+}
+
+// where an object goes and what it is signed with
+type object_target struct {
+	url, key, region      string
+	access, secret, token string
+	bearer                string // an oauth token of gcs instead of the keys
+}
+
+// the endpoint and credentials of an object, an error without them
+func find_object_target(name string) (object_target, error) {
+	bucket, key, err := split_object_url(name)
+	if err != nil {
+		return object_target{}, err
+	}
+	env := func(names ...string) string {
+		for _, name := range names {
+			if v := os.Getenv(name); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	target := object_target{key: key}
+	if strings.HasPrefix(name, "gs://") {
+		target.url, target.region = "https://storage.googleapis.com/"+bucket, "auto"
+		if emulator := env("STORAGE_EMULATOR_HOST"); emulator != "" {
+			if !strings.Contains(emulator, "://") {
+				emulator = "http://" + emulator
+			}
+			target.url = strings.TrimSuffix(emulator, "/") + "/" + bucket
+		}
+		target.access, target.secret = env("GOOGLE_HMAC_ACCESS_ID"), env("GOOGLE_HMAC_SECRET")
+		target.bearer = env("GOOGLE_OAUTH_ACCESS_TOKEN")
+		if target.bearer == "" && (target.access == "" || target.secret == "") {
+			return target, fmt.Errorf("%s: set GOOGLE_OAUTH_ACCESS_TOKEN or GOOGLE_HMAC_ACCESS_ID and GOOGLE_HMAC_SECRET", name)
+		}
+	} else {
+		target.region = env("AWS_REGION", "AWS_DEFAULT_REGION")
+		if target.region == "" {
+			target.region = "us-east-1"
+		}
+		if custom := env("AWS_ENDPOINT_URL"); custom != "" {
+			target.url = strings.TrimSuffix(custom, "/") + "/" + bucket
+		} else {
+			target.url = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, target.region)
+		}
+		target.access, target.secret, target.token = env("AWS_ACCESS_KEY_ID"), env("AWS_SECRET_ACCESS_KEY"), env("AWS_SESSION_TOKEN")
+		if target.access == "" || target.secret == "" {
+			return target, fmt.Errorf("%s: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", name)
+		}
+	}
+	target.url += "/" + escape_key(key)
+	return target, nil
+}
+
+// an error for an output that can't be uploaded, to find out before the simulation and not after.
+// local files are left to fail when they are saved.
+func check_output(name string) error {
+	if !is_object_url(name) {
+		return nil
+	}
+	_, err := find_object_target(name)
+	return err
+}
+
+// uploads the object with a PUT
+func put_object(name string, body []byte, metadata map[string]string) error {
+	target, err := find_object_target(name)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPut, target.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", content_type(target.key))
+	// the xml api of gcs takes the amz headers with hmac keys, its own with an oauth token
+	meta := "X-Amz-Meta-"
+	if target.bearer != "" {
+		meta = "X-Goog-Meta-"
+	}
+	for k, v := range object_metadata(metadata) {
+		request.Header.Set(meta+k, v)
+	}
+	if target.bearer != "" {
+		request.Header.Set("Authorization", "Bearer "+target.bearer)
+	} else {
+		sum := sha256.Sum256(body)
+		sign_v4(request, hex.EncodeToString(sum[:]), target.access, target.secret, target.token, target.region, "s3", time.Now())
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("uploading %s: %s %s", name, response.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// the metadata of the image and of -object-metadata, with the keys lowercase and the values that
+// can't be headers left out
+func object_metadata(metadata map[string]string) map[string]string {
+	all := map[string]string{}
+	for _, m := range []map[string]string{metadata, object_options.metadata} {
+		for k, v := range m {
+			printable := true
+			for _, c := range k + v {
+				if c < 0x20 || c > 0x7e {
+					printable = false
+				}
+			}
+			if printable {
+				all[strings.ToLower(k)] = v
+			}
+		}
+	}
+	return all
+}
+
+// the key with everything but the unreserved characters and / escaped, as signature version 4 wants
+func escape_key(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signs the request with AWS signature version 4, all of its headers are signed
+func sign_v4(request *http.Request, payload_hash, access, secret, token, region, service string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	request.Header.Set("X-Amz-Date", stamp)
+	if service == "s3" {
+		request.Header.Set("X-Amz-Content-Sha256", payload_hash)
+	}
+	if token != "" {
+		request.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for k, v := range request.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical_headers strings.Builder
+	for _, k := range names {
+		// trimmed, with the runs of spaces inside made one
+		fmt.Fprintf(&canonical_headers, "%s:%s\n", k, strings.Join(strings.Fields(headers[k]), " "))
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		canonical_query(request.URL.Query()),
+		canonical_headers.String(),
+		signed,
+		payload_hash,
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	to_sign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+secret), day)
+	key = mac(key, region)
+	key = mac(key, service)
+	key = mac(key, "aws4_request")
+	signature := hex.EncodeToString(mac(key, to_sign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", access, scope, signed, signature))
+}
+
+// the query sorted by name and value, escaped like the key
+func canonical_query(query url.Values) string {
+	var pairs []string
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, strings.ReplaceAll(escape_key(k), "/", "%2F")+"="+strings.ReplaceAll(escape_key(v), "/", "%2F"))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// note:
+// -render stroke draws only the edge of the flake, as a line -stroke-width pixels wide, for icons and
+// logos where the filled flake is too heavy. The edge is found like the one of -render mask, where the
+// rendered frozen hexagons cross one half, and the pen is put down on every pixel of the ice next to a
+// pixel of the vapor:
+//
+//   round    a round pen, the corners and the tips of the arms are round (the default)
+//   square   a square pen, they come out square like a square cap and a miter join on a plotter
+//
+// The edges of the flake are closed lines, so there are no ends and the cap only shows at corners and
+// thin tips. The line is -stroke-color (the ice color of the theme by default) and antialiased, on a
+// transparent background unless -stroke-background gives it a color. The gaps inside the ice are edges
+// too, so they get lines of their own.
+
+type stroke struct {
+	width       float64
+	square      bool
+	color       color.RGBA
+	background  color.RGBA
+	transparent bool
+}
+
+func parse_stroke(width float64, cap, line, background string, ink color.RGBA) (stroke, error) {
+	s := stroke{width: width, color: ink, transparent: true}
+	if width <= 0 {
+		return s, fmt.Errorf("-stroke-width must be more than 0")
+	}
+	switch cap {
+	case "round":
+	case "square":
+		s.square = true
+	default:
+		return s, fmt.Errorf("unknown -stroke-cap %q, use round or square", cap)
+	}
+	var err error
+	if line != "" {
+		if s.color, err = parse_color(line); err != nil {
+			return s, fmt.Errorf("bad -stroke-color: %v", err)
+		}
+	}
+	if background != "" && background != "transparent" {
+		if s.background, err = parse_color(background); err != nil {
+			return s, fmt.Errorf("bad -stroke-background: %v", err)
+		}
+		s.transparent = false
+	}
+	return s, nil
+}
+
+// the edge of the rendered frozen hexagons drawn with the pen
+func render_stroke(values Matrix, draw func(*Matrix) image.Image, pen stroke) image.Image {
+	frozen := new_matrix_like(values)
+	for i := range values {
+		for j, v := range values[i] {
+			if v >= 1.0 {
+				frozen[i][j] = 1
+			}
+		}
+	}
+	rendered := draw(&frozen)
+	bounds := rendered.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	ice := func(x, y int) bool {
+		if x < 0 || x >= width || y < 0 || y >= height {
+			return false
+		}
+		return color.GrayModel.Convert(rendered.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y >= 128
+	}
+
+	// how much of every pixel the pen covers, the pen is antialiased over a pixel at its rim
+	coverage := make([]float64, width*height)
+	radius := pen.width / 2
+	reach := int(math.Ceil(radius + 0.5))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !ice(x, y) || (ice(x-1, y) && ice(x+1, y) && ice(x, y-1) && ice(x, y+1)) {
+				continue
+			}
+			for dy := -reach; dy <= reach; dy++ {
+				for dx := -reach; dx <= reach; dx++ {
+					px, py := x+dx, y+dy
+					if px < 0 || px >= width || py < 0 || py >= height {
+						continue
+					}
+					d := math.Hypot(float64(dx), float64(dy))
+					if pen.square {
+						d = math.Max(math.Abs(float64(dx)), math.Abs(float64(dy)))
+					}
+					c := math.Max(0, math.Min(1, radius+0.5-d))
+					if c > coverage[py*width+px] {
+						coverage[py*width+px] = c
+					}
+				}
+			}
+		}
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := coverage[y*width+x]
+			if pen.transparent {
+				out.SetNRGBA(x, y, color.NRGBA{pen.color.R, pen.color.G, pen.color.B, uint8(math.Round(c * 255))})
+			} else {
+				m := mix(pen.background, pen.color, c)
+				out.SetNRGBA(x, y, color.NRGBA{m.R, m.G, m.B, 255})
+			}
+		}
+	}
+	return out
+}
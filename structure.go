@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// note:
+// Structure measures the shape of a flake in numbers, where traits put it in words. Reiter's flakes grow
+// along the six axes of the hexagons, so the arms are measured along them from the seed:
+//
+//   arms        axes the flake reaches at least half as far out along as along the longest one
+//   length      frozen hexagons along the axis from the seed before the first gap
+//   branches    going out along an arm, how far the ice reaches sideways at every step, in the two
+//               directions of the hexagons 60 degrees off the arm where the side branches grow. A side
+//               branch is a run of steps that reach branch_reach hexagons further than the arm is wide
+//               (the median of the reaches), the plate around the seed isn't counted
+//   spacing     hexagons between the side branches on the same side of an arm
+//   perimeter   sides of frozen hexagons that touch one that isn't, compared to the frozen hexagons it's
+//               low for plates and high for lacy flakes
+//
+// The structure of a run is saved with -stats, batches add it to the results with -stats, and
+// snow stats aggregates it over states and batch results.
+
+// hexagons a side branch has to stick out of its arm
+const branch_reach = 3
+
+// the six axes in axial coordinates, clockwise from the right in the image
+var hex_axes = [6][2]int{{1, 0}, {0, 1}, {-1, 1}, {-1, 0}, {0, -1}, {1, -1}}
+
+type Arm struct {
+	Angle    int     `json:"angle"` // degrees clockwise from the right
+	Length   int     `json:"length"`
+	Branches int     `json:"branches"`
+	Spacing  float64 `json:"spacing,omitempty"`
+}
+
+type Structure struct {
+	Arms           int     `json:"arms"`
+	ArmLength      float64 `json:"arm_length"` // means of the main arms
+	Branches       float64 `json:"branches"`
+	Spacing        float64 `json:"spacing"`
+	Area           int     `json:"area"`      // frozen hexagons
+	Perimeter      int     `json:"perimeter"` // sides between frozen hexagons and the rest
+	PerimeterRatio float64 `json:"perimeter_ratio"`
+	PerArm         []Arm   `json:"per_arm"`
+	Habit          string  `json:"habit"`
+}
+
+func measure_structure(state *State) Structure {
+	values := state.values()
+	size, height := len(values), len(values[0])
+	x, y := state.Settings.seed_pos()
+	frozen := func(q, r int) bool {
+		i, j := x+q, y+r
+		return i >= 0 && i < size && j >= 0 && j < height && values[i][j] >= 1.0
+	}
+
+	var s Structure
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if values[i][j] < 1.0 {
+				continue
+			}
+			s.Area++
+			for _, n := range hex_neighbours {
+				if !frozen(i-x+n[0], j-y+n[1]) {
+					s.Perimeter++
+				}
+			}
+		}
+	}
+	if s.Area == 0 {
+		return s
+	}
+	s.PerimeterRatio = float64(s.Perimeter) / float64(s.Area)
+
+	// the plate around the seed, every hexagon up to core from it is frozen
+	core := 0
+	for ring := 1; ring < size; ring++ {
+		// around the ring, from ring steps up along the fifth axis
+		full := true
+		q, r := hex_axes[4][0]*ring, hex_axes[4][1]*ring
+		for _, a := range hex_axes {
+			for step := 0; step < ring; step++ {
+				full = full && frozen(q, r)
+				q, r = q+a[0], r+a[1]
+			}
+		}
+		if !full {
+			break
+		}
+		core = ring
+	}
+
+	lengths := make([]int, len(hex_axes))
+	longest := 0
+	for k, a := range hex_axes {
+		for frozen(a[0]*(lengths[k]+1), a[1]*(lengths[k]+1)) {
+			lengths[k]++
+		}
+		if lengths[k] > longest {
+			longest = lengths[k]
+		}
+	}
+
+	for k, a := range hex_axes {
+		if longest == 0 || 2*lengths[k] < longest {
+			continue
+		}
+		arm := Arm{Angle: 60 * k, Length: lengths[k]}
+		var gaps []int
+		for _, side := range [2][2]int{hex_axes[(k+1)%6], hex_axes[(k+5)%6]} {
+			peaks := side_branches(lengths[k], core, func(t, s int) bool {
+				return frozen(a[0]*t+side[0]*s, a[1]*t+side[1]*s)
+			})
+			arm.Branches += len(peaks)
+			for p := 1; p < len(peaks); p++ {
+				gaps = append(gaps, peaks[p]-peaks[p-1])
+			}
+		}
+		if len(gaps) > 0 {
+			sum := 0
+			for _, g := range gaps {
+				sum += g
+			}
+			arm.Spacing = float64(sum) / float64(len(gaps))
+		}
+		s.PerArm = append(s.PerArm, arm)
+	}
+
+	s.Arms = len(s.PerArm)
+	spaced := 0
+	for _, arm := range s.PerArm {
+		s.ArmLength += float64(arm.Length) / float64(s.Arms)
+		s.Branches += float64(arm.Branches) / float64(s.Arms)
+		if arm.Spacing > 0 {
+			s.Spacing += arm.Spacing
+			spaced++
+		}
+	}
+	if spaced > 0 {
+		s.Spacing /= float64(spaced)
+	}
+	s.Habit = classify_habit(s)
+	return s
+}
+
+// the steps along an arm where side branches are, frozen(t, s) is the hexagon t steps out along the arm
+// and s steps to the side
+func side_branches(length, core int, frozen func(t, s int) bool) []int {
+	reach := make([]int, length+1)
+	for t := range reach {
+		for frozen(t, reach[t]+1) {
+			reach[t]++
+		}
+	}
+	if length <= core+1 {
+		return nil
+	}
+	sorted := append([]int(nil), reach[core+1:]...)
+	sort.Ints(sorted)
+	width := sorted[len(sorted)/2]
+
+	var peaks []int
+	peak, best := -1, -1
+	for t := core + 1; t <= length; t++ {
+		if reach[t] >= width+branch_reach {
+			if reach[t] > best {
+				peak, best = t, reach[t]
+			}
+			continue
+		}
+		if peak >= 0 {
+			peaks = append(peaks, peak)
+			peak, best = -1, -1
+		}
+	}
+	if peak >= 0 {
+		peaks = append(peaks, peak)
+	}
+	return peaks
+}
+
+// min, mean and max of a measurement over many flakes
+type Spread struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	Max  float64 `json:"max"`
+}
+
+type StructureSummary struct {
+	Flakes         int            `json:"flakes"`
+	Arms           Spread         `json:"arms"`
+	ArmLength      Spread         `json:"arm_length"`
+	Branches       Spread         `json:"branches"`
+	Spacing        Spread         `json:"spacing"`
+	PerimeterRatio Spread         `json:"perimeter_ratio"`
+	Habits         map[string]int `json:"habits"` // flakes of every habit
+}
+
+func summarize_structures(all []Structure) StructureSummary {
+	summary := StructureSummary{Flakes: len(all), Habits: map[string]int{}}
+	for _, s := range all {
+		summary.Habits[s.Habit]++
+	}
+	spread := func(value func(s Structure) float64) Spread {
+		if len(all) == 0 {
+			return Spread{}
+		}
+		sp := Spread{Min: math.Inf(1), Max: math.Inf(-1)}
+		for _, s := range all {
+			v := value(s)
+			sp.Min, sp.Max = math.Min(sp.Min, v), math.Max(sp.Max, v)
+			sp.Mean += v / float64(len(all))
+		}
+		return sp
+	}
+	summary.Arms = spread(func(s Structure) float64 { return float64(s.Arms) })
+	summary.ArmLength = spread(func(s Structure) float64 { return s.ArmLength })
+	summary.Branches = spread(func(s Structure) float64 { return s.Branches })
+	summary.Spacing = spread(func(s Structure) float64 { return s.Spacing })
+	summary.PerimeterRatio = spread(func(s Structure) float64 { return s.PerimeterRatio })
+	return summary
+}
+
+// one line for the console
+func (s Structure) String() string {
+	return fmt.Sprintf("%s, %d arms %.0f long, %.1f side branches %.1f apart, perimeter %.2f of the area", s.Habit, s.Arms, s.ArmLength, s.Branches, s.Spacing, s.PerimeterRatio)
+}
+
+func (s StructureSummary) String() string {
+	names := make([]string, 0, len(s.Habits))
+	for habit := range s.Habits {
+		names = append(names, habit)
+	}
+	sort.Strings(names)
+	for k, habit := range names {
+		names[k] = fmt.Sprintf("%d %s", s.Habits[habit], habit)
+	}
+	return fmt.Sprintf("%d flakes (%s), %.1f arms %.0f long, %.1f side branches %.1f apart, perimeter %.2f of the area",
+		s.Flakes, strings.Join(names, ", "), s.Arms.Mean, s.ArmLength.Mean, s.Branches.Mean, s.Spacing.Mean, s.PerimeterRatio.Mean)
+}
+
+// the structures of the batch results with -stats in an ndjson file
+func read_result_structures(filename string) ([]Structure, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var all []Structure
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result Result
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		if result.Structure != nil {
+			all = append(all, *result.Structure)
+		}
+	}
+	return all, scanner.Err()
+}
+
+// snow stats [-aggregate] a.snow b.snow results.ndjson ...
+func stats_command(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	aggregate := flags.Bool("aggregate", false, "only print the min, mean and max over all flakes")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow stats [flags] state.snow|results.ndjson ...\n\n")
+		fmt.Fprintf(flags.Output(), "states are measured, batch results take the structure they got with snow batch -stats\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var all []Structure
+	lines := json.NewEncoder(os.Stdout)
+	for _, filename := range flags.Args() {
+		var structures []Structure
+		if strings.EqualFold(filepath.Ext(filename), ".snow") {
+			state, err := load_state(filename)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			structures = []Structure{measure_structure(state)}
+		} else {
+			var err error
+			if structures, err = read_result_structures(filename); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		all = append(all, structures...)
+		if !*aggregate {
+			for _, s := range structures {
+				lines.Encode(s)
+			}
+		}
+	}
+	if *aggregate {
+		lines.Encode(summarize_structures(all))
+	}
+}
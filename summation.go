@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+)
+
+// note:
+// step() scatters: every hexagon adds its share of vapor to its neighbours one after the other, so the
+// new value of a hexagon is a sum of up to 7 parts added in the order the loop reaches them, and every
+// addition rounds. -summation kahan gathers instead, every hexagon adds up the parts it gets from its
+// neighbours itself with Kahan's compensated summation, which carries the rounding error of every
+// addition over to the next one. The parts are the same, only how they're added up changes:
+//
+//   naive   the scatter of step(), the default and what all older results were made with
+//   kahan   gathered and compensated, about 2x slower
+//
+// snow drift measures what it buys: it runs the same flake at 32 bits both ways and compares them with
+// the same flake at 64 bits with kahan as the reference. It's less than hoped, both end up about as far
+// off. A sum of 7 parts has little rounding to compensate, most of the drift comes from rounding the
+// result to float32 when it's stored, which no summation changes. TestSummationDrift keeps the bounds.
+// Only the reiter solver without tiles has the choice, the other steps have their own sums.
+
+// a step of the reiter model like step() with the sum of every hexagon gathered and compensated
+func step_kahan[T Real](A, Y float64, coldness_matrix, temp_coldness_matrix *Grid[T], mask_matrix *Mask) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+
+	// look for frozen hexagons and set receptive values on the mask
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if (*coldness_matrix)[i][j] >= 1.0 {
+				(*mask_matrix)[i-1][j] = receptive
+				(*mask_matrix)[i-1][j+1] = receptive
+				(*mask_matrix)[i][j-1] = receptive
+				(*mask_matrix)[i][j] = receptive
+				(*mask_matrix)[i][j+1] = receptive
+				(*mask_matrix)[i+1][j-1] = receptive
+				(*mask_matrix)[i+1][j] = receptive
+			}
+		}
+	}
+
+	coldness, mask := *coldness_matrix, *mask_matrix
+	y := T(Y)
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			// the parts in the order step() adds them, from the neighbours that give away vapor and
+			// the hexagon itself in the middle
+			var sum, compensation T
+			if i > 0 {
+				if mask[i-1][j] == non_receptive {
+					kahan_add(&sum, &compensation, T(A)*coldness[i-1][j]/12.0)
+				}
+				if j+1 < height && mask[i-1][j+1] == non_receptive {
+					kahan_add(&sum, &compensation, T(A)*coldness[i-1][j+1]/12.0)
+				}
+			}
+			if j > 0 && mask[i][j-1] == non_receptive {
+				kahan_add(&sum, &compensation, T(A)*coldness[i][j-1]/12.0)
+			}
+			switch mask[i][j] {
+			case non_receptive:
+				kahan_add(&sum, &compensation, coldness[i][j]/2.0)
+			case receptive:
+				kahan_add(&sum, &compensation, coldness[i][j]+y)
+			}
+			if j+1 < height && mask[i][j+1] == non_receptive {
+				kahan_add(&sum, &compensation, T(A)*coldness[i][j+1]/12.0)
+			}
+			if i+1 < size {
+				if j > 0 && mask[i+1][j-1] == non_receptive {
+					kahan_add(&sum, &compensation, T(A)*coldness[i+1][j-1]/12.0)
+				}
+				if mask[i+1][j] == non_receptive {
+					kahan_add(&sum, &compensation, T(A)*coldness[i+1][j]/12.0)
+				}
+			}
+			(*temp_coldness_matrix)[i][j] = sum
+		}
+	}
+
+	// swap so the new coldness matrix becomes the current one
+	*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
+}
+
+// adds v to sum and carries the rounding error over to the next addition in compensation
+func kahan_add[T Real](sum, compensation *T, v T) {
+	y := v - *compensation
+	t := *sum + y
+	*compensation = (t - *sum) - y
+	*sum = t
+}
+
+// how far two matrices are apart, over the hexagons and in the ice
+type drift struct {
+	max, mean float64
+	frozen    int // hexagons frozen in one and not the other
+}
+
+func measure_drift(a, b Matrix) drift {
+	var d drift
+	count := 0
+	for i := range a {
+		for j := range a[i] {
+			diff := math.Abs(a[i][j] - b[i][j])
+			d.max = math.Max(d.max, diff)
+			d.mean += diff
+			count++
+			if (a[i][j] >= 1.0) != (b[i][j] >= 1.0) {
+				d.frozen++
+			}
+		}
+	}
+	d.mean /= float64(count)
+	return d
+}
+
+// snow drift [-L 5000] [-size 200] [A B Y PP PM]
+func drift_command(args []string) {
+	flags := flag.NewFlagSet("drift", flag.ExitOnError)
+	L := flags.Int64("L", 5000, "iterations to run")
+	grid := flags.Int("size", 200, "size of the matrix")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow drift [flags] [A B Y PP PM]\n\n")
+		fmt.Fprintf(flags.Output(), "runs the flake with both summations at 32 and 64 bits and prints how far they drift from 64 bit kahan\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	settings := default_settings()
+	settings.L, settings.Size = *L, *grid
+	if flags.NArg() != 0 {
+		if flags.NArg() != 5 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		for k, value := range []*float64{&settings.A, &settings.B, &settings.Y, &settings.PP, &settings.PM} {
+			if _, err := fmt.Sscan(flags.Arg(k), value); err != nil {
+				fmt.Fprintf(os.Stderr, "bad parameter %q\n", flags.Arg(k))
+				os.Exit(2)
+			}
+		}
+	}
+	if err := settings.check(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	modes := []struct {
+		name      string
+		precision int
+		summation string
+	}{
+		{"64 bit kahan", 64, "kahan"},
+		{"64 bit naive", 64, ""},
+		{"32 bit kahan", 32, "kahan"},
+		{"32 bit naive", 32, ""},
+	}
+	var reference Matrix
+	fmt.Printf("settings:\t A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%d size=%d\n", settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L, settings.Size)
+	for k, mode := range modes {
+		s := settings
+		s.Precision, s.Summation = mode.precision, mode.summation
+		state := new_state(s)
+		if err := run_context(context.Background(), state, func(iteration int64) {
+			fmt.Fprintf(os.Stderr, "\r%s:\t %d / %d", mode.name, iteration, s.L)
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, "\n"+err.Error())
+			os.Exit(1)
+		}
+		fmt.Fprint(os.Stderr, "\r")
+		values := state.values()
+		if k == 0 {
+			reference = values
+			fmt.Printf("%s:\t reference, %d frozen\n", mode.name, frozen_cells(&values))
+			continue
+		}
+		d := measure_drift(values, reference)
+		fmt.Printf("%s:\t max %.3g, mean %.3g, %d hexagons frozen differently\n", mode.name, d.max, d.mean, d.frozen)
+	}
+}
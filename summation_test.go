@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// the values of a small run of the settings with the given precision and summation
+func drift_run(t *testing.T, settings Settings, precision int, summation string) Matrix {
+	settings.Precision, settings.Summation = precision, summation
+	state := new_state(settings)
+	if err := run_context(context.Background(), state, nil); err != nil {
+		t.Fatalf("%d bit %q: %v", precision, summation, err)
+	}
+	return state.values()
+}
+
+// how far naive and kahan summation drift from 64 bit kahan, the bounds have a margin of about 10x
+func TestSummationDrift(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs 8 simulations")
+	}
+	dense := default_settings()
+	dense.B, dense.Y = 0.4, 0.001
+	for _, test := range []struct {
+		name     string
+		settings Settings
+	}{
+		{"defaults", default_settings()},
+		{"1 0.4 0.001", dense},
+	} {
+		settings := test.settings
+		settings.Size, settings.L = 100, 3000
+		reference := drift_run(t, settings, 64, "kahan")
+		if frozen_cells(&reference) == 0 {
+			t.Fatalf("%s: nothing froze in the reference", test.name)
+		}
+
+		naive_64 := measure_drift(drift_run(t, settings, 64, ""), reference)
+		if naive_64.max > 1e-13 || naive_64.mean > 1e-15 || naive_64.frozen != 0 {
+			t.Errorf("%s: 64 bit naive drifts by max %g, mean %g, %d frozen differently", test.name, naive_64.max, naive_64.mean, naive_64.frozen)
+		}
+
+		kahan := measure_drift(drift_run(t, settings, 32, "kahan"), reference)
+		naive := measure_drift(drift_run(t, settings, 32, ""), reference)
+		for _, d := range []struct {
+			name string
+			drift
+		}{{"kahan", kahan}, {"naive", naive}} {
+			if d.max > 0.05 || d.mean > 2e-4 || d.frozen > 2 {
+				t.Errorf("%s: 32 bit %s drifts by max %g, mean %g, %d frozen differently", test.name, d.name, d.max, d.mean, d.frozen)
+			}
+		}
+		// most of the 32 bit drift is the rounding when storing, compensating the sums must not make it worse
+		if kahan.mean > naive.mean*1.1 {
+			t.Errorf("%s: 32 bit kahan drifts more than naive, mean %g against %g", test.name, kahan.mean, naive.mean)
+		}
+	}
+}
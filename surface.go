@@ -0,0 +1,43 @@
+package main
+
+import "math"
+
+// note:
+// -smoothing mimics surface tension, which makes curved ice harder to grow than flat ice. After every
+// step the growth of a receptive hexagon is weighted by how many of its 6 neighbours were frozen before
+// the step: one that sits in front of a spike or a sharp tip with one frozen neighbour grows less, one
+// in a notch between frozen hexagons grows more, and one on a flat side grows the same. A hexagon next to
+// a straight side touches 2 hexagons of it:
+//
+//   growth * (1 + smoothing * (frozen - 2) / 2)
+//
+// At 1 a hexagon in front of a spike grows at half the speed and one surrounded by ice at three times. A
+// little rounds off the single hexagon spikes some values of A grow, around 0.5 the arms become smooth
+// petals, and close to 1 the tips are held back so much that the flake stays a small round plate.
+
+// weighs the growth of the receptive hexagons, called after a step with the coldness before it in previous
+func smooth_growth[T Real](smoothing float64, coldness_matrix, previous_matrix *Grid[T], mask_matrix *Mask) {
+	size, height := len(*coldness_matrix), len((*coldness_matrix)[0])
+
+	// the border turns receptive when the flake reaches it, the edge of the matrix has no neighbours
+	for i := 1; i < size-1; i++ {
+		for j := 1; j < height-1; j++ {
+			before := (*previous_matrix)[i][j]
+			if (*mask_matrix)[i][j] != receptive || before >= 1.0 {
+				continue
+			}
+			weight := T(math.Max(0, 1+smoothing*float64(frozen_neighbours(previous_matrix, i, j)-2)/2))
+			(*coldness_matrix)[i][j] = before + ((*coldness_matrix)[i][j]-before)*weight
+		}
+	}
+}
+
+func frozen_neighbours[T Real](matrix *Grid[T], i, j int) int {
+	frozen := 0
+	for _, n := range hex_neighbours {
+		if (*matrix)[i+n[0]][j+n[1]] >= 1.0 {
+			frozen++
+		}
+	}
+	return frozen
+}
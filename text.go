@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// note:
+// Text is drawn with the 7x13 bitmap font that comes with x/image, scaled up by whole pixels so it stays
+// sharp. It only has ascii, other characters are drawn as boxes.
+
+var text_face = basicfont.Face7x13
+
+// width and height of the text in pixels
+func text_size(text string, scale int) (int, int) {
+	width := font.MeasureString(text_face, text).Round()
+	return width * scale, text_face.Height * scale
+}
+
+// draws the text with its top left corner at x, y
+func draw_text(dst draw.Image, x, y int, text string, scale int, c color.Color) {
+	width, height := text_size(text, 1)
+	if width == 0 {
+		return
+	}
+
+	// draw it small as a mask and scale that up
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	drawer := font.Drawer{
+		Dst:  mask,
+		Src:  image.Opaque,
+		Face: text_face,
+		Dot:  fixed.P(0, text_face.Ascent),
+	}
+	drawer.DrawString(text)
+
+	scaled := image.NewAlpha(image.Rect(0, 0, width*scale, height*scale))
+	for py := 0; py < height*scale; py++ {
+		for px := 0; px < width*scale; px++ {
+			scaled.SetAlpha(px, py, mask.AlphaAt(px/scale, py/scale))
+		}
+	}
+	draw.DrawMask(dst, scaled.Bounds().Add(image.Pt(x, y)), image.NewUniform(c), image.Point{}, scaled, image.Point{}, draw.Over)
+}
+
+// fills in {settings}, {code} and {date} of a caption or stamped text
+func expand_text(text string, settings Settings) string {
+	line := fmt.Sprintf("A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%d",
+		settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L)
+	code, err := encode_code(settings)
+	if err != nil {
+		code = ""
+	}
+	return strings.NewReplacer(
+		"{settings}", line,
+		"{code}", code,
+		"{date}", time.Now().Format("2006-01-02"),
+	).Replace(text)
+}
+
+var text_positions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "top", "bottom"}
+
+func valid_text_position(position string) bool {
+	for _, p := range text_positions {
+		if p == position {
+			return true
+		}
+	}
+	return false
+}
+
+// stamps the text in a corner (or the middle of an edge) of the image, \n in the text starts a new line
+func stamp_text(img image.Image, text, position string, scale int, opacity float64, c color.RGBA) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Src)
+
+	if scale <= 0 {
+		scale = int(math.Max(1, math.Round(float64(bounds.Dx())/400)))
+	}
+	margin := 6 * scale
+	lines := strings.Split(text, `\n`)
+	_, line_height := text_size("", scale)
+
+	// color.NRGBA keeps the color and only makes it see through
+	ink := color.NRGBA{c.R, c.G, c.B, uint8(math.Round(math.Max(0, math.Min(1, opacity)) * 255))}
+	top := margin
+	if strings.HasPrefix(position, "bottom") {
+		top = out.Bounds().Dy() - margin - len(lines)*line_height
+	}
+	for k, line := range lines {
+		width, _ := text_size(line, scale)
+		left := margin
+		switch {
+		case strings.HasSuffix(position, "right"):
+			left = out.Bounds().Dx() - margin - width
+		case position == "top" || position == "bottom":
+			left = (out.Bounds().Dx() - width) / 2
+		}
+		draw_text(out, left, top+k*line_height, line, scale, ink)
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// note:
+// A theme is a background with a texture and the palette that goes with it, so the same flake can be
+// rendered for a dark website or a white page. The texture only shows where the palette is at its
+// first color, the flake covers it. With -palette the theme keeps its background and the palette
+// colors the flake.
+
+type theme struct {
+	palette palette
+	texture func(x, y int) float64 // brightness added to the background, from -1 to 1
+}
+
+func plain_theme(p palette) theme {
+	return theme{p, nil}
+}
+
+var themes = map[string]theme{
+	"dark":      {palette{hex_stops("#0d1117", "#ffffff"), true}, nil},
+	"light":     {palette{hex_stops("#ffffff", "#1b263b"), false}, nil},
+	"blueprint": {palette{hex_stops("#1f4e8c", "#eef4ff"), false}, blueprint_grid},
+	"paper":     {palette{hex_stops("#f3ead6", "#3a2e25"), false}, paper_grain},
+}
+
+func theme_names() string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// thin lines every 32 pixels and stronger ones every 160
+func blueprint_grid(x, y int) float64 {
+	switch {
+	case x%160 == 0 || y%160 == 0:
+		return 0.18
+	case x%32 == 0 || y%32 == 0:
+		return 0.08
+	}
+	return 0
+}
+
+// fine noise with some fibres along x, the same for every image
+func paper_grain(x, y int) float64 {
+	hash := func(x, y int) float64 {
+		h := uint32(x)*374761393 + uint32(y)*668265263
+		h = (h ^ h>>13) * 1274126177
+		return float64(h^h>>16)/float64(math.MaxUint32)*2 - 1
+	}
+	return 0.025*hash(x, y) + 0.02*hash(x/7, y)
+}
+
+// the theme with the palette on top, both can be empty, the background color replaces the one of the
+// theme
+func pick_theme(theme_name, palette_name, background string) (theme, error) {
+	t := plain_theme(palettes["gray"])
+	if theme_name != "" {
+		var ok bool
+		if t, ok = themes[theme_name]; !ok {
+			return t, fmt.Errorf("unknown -theme %q, use %s", theme_name, theme_names())
+		}
+	}
+	if palette_name != "" {
+		p, err := find_palette(palette_name)
+		if err != nil {
+			return t, err
+		}
+		if theme_name != "" {
+			p = p.with_background(t.background())
+		}
+		t.palette = p
+	}
+	if background != "" {
+		c, err := parse_color(background)
+		if err != nil {
+			return t, fmt.Errorf("bad -social-background: %v", err)
+		}
+		if palette_name == "" && theme_name == "" {
+			// white on dark and black on light backgrounds
+			t.palette = social_palette(c)
+		} else {
+			t.palette = t.palette.with_background(c)
+		}
+	}
+	return t, nil
+}
+
+func (t theme) background() color.RGBA {
+	return t.palette.stops[0]
+}
+
+func (t theme) ink() color.RGBA {
+	return t.palette.stops[len(t.palette.stops)-1]
+}
+
+// the color of a pixel with the brightness g from 0 to 1
+func (t theme) at(x, y int, g float64) color.RGBA {
+	c := t.palette.at(g)
+	if t.texture == nil {
+		return c
+	}
+	// only as much texture as the first stop has weight in the color
+	weight := math.Max(0, 1-g*float64(len(t.palette.stops)-1))
+	shift := t.texture(x, y) * weight * 255
+	add := func(v uint8) uint8 { return uint8(math.Max(0, math.Min(255, math.Round(float64(v)+shift)))) }
+	return color.RGBA{add(c.R), add(c.G), add(c.B), 255}
+}
+
+func colorize(img image.Image, t theme) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			g := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			out.SetRGBA(x, y, t.at(x, y, float64(g)/255))
+		}
+	}
+	return out
+}
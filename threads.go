@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// note:
+// -threads N does the steps of a simulation on N threads, all cpus by default. Every thread takes a band
+// of rows and scatters the vapor of its cells like step() does, into the rows of its band only, so no
+// two threads write the same row. The rows next to another band get vapor from it as well, and the sums
+// have to be added in the order of step() to round the same way:
+//
+//   first row   the vapor from the row above comes first, the row is gathered from its neighbours
+//               like the workers of distributed mode do, over what the scatter left there
+//   last row    the vapor from the row below comes last, it is added after the scatter
+//
+// so the flake is exactly the same on any number of threads. The receptive cells are marked before, with
+// the threads waiting for each other in between since a cell reads the mask of the rows around its own.
+// The mask is read packed like on one thread (see bitmask.go), a row starts a word so the bands never
+// share one.
+//
+// Only the plain step runs on threads, in 64 and 32 bit precision. Tiles, the other diffusion solvers,
+// kinetics and fixed point values, and what comes after the step (sources, obstacles, heat...) stay
+// on one thread. Small matrices are quicker on one thread as well, a band has at least
+// min_thread_rows rows.
+
+const min_thread_rows = 32
+
+// sets the cells with a frozen cell next to them receptive in both masks, for the rows from, to
+func receptive_rows[T Real](from, to int, coldness_matrix *Grid[T], packed bit_mask, mask_matrix *Mask) {
+	rows := len(*coldness_matrix)
+	mark := func(i, j int) {
+		if i >= from && i < to && j >= 0 && j < len((*mask_matrix)[i]) {
+			packed.mark(i, j, mask_matrix)
+		}
+	}
+	// the frozen cells of the rows and the rows next to them, most cells aren't
+	for i := from - 1; i <= to; i++ {
+		if i < 0 || i >= rows {
+			continue
+		}
+		for j, v := range (*coldness_matrix)[i] {
+			if v >= 1.0 {
+				mark(i-1, j)
+				mark(i-1, j+1)
+				mark(i, j-1)
+				mark(i, j)
+				mark(i, j+1)
+				mark(i+1, j-1)
+				mark(i+1, j)
+			}
+		}
+	}
+}
+
+// the next values of the rows from, to, gathered from their neighbours
+func gather_rows[T Real](A, Y float64, from, to int, coldness_matrix, temp_coldness_matrix *Grid[T], packed bit_mask) {
+	rows := len(*coldness_matrix)
+	a := T(A)
+
+	// water floating in from a non receptive neighbour, j can be outside the row
+	flow := func(values []T, mask []uint64, j int) T {
+		if values == nil || j < 0 || j >= len(values) || uint8(mask[j/cells_per_word]>>(2*(j%cells_per_word)))&3 != non_receptive {
+			return 0
+		}
+		return a * values[j] / 12.0
+	}
+
+	for i := from; i < to; i++ {
+		var above, below []T
+		var above_mask, below_mask []uint64
+		if i > 0 {
+			above, above_mask = (*coldness_matrix)[i-1], packed[i-1]
+		}
+		if i < rows-1 {
+			below, below_mask = (*coldness_matrix)[i+1], packed[i+1]
+		}
+		values, mask, next := (*coldness_matrix)[i], packed[i], (*temp_coldness_matrix)[i]
+		for j := range values {
+			// same order as the scatter in step() so the sums round the same way
+			v := flow(above, above_mask, j)
+			v += flow(above, above_mask, j+1)
+			v += flow(values, mask, j-1)
+			switch uint8(mask[j/cells_per_word]>>(2*(j%cells_per_word))) & 3 {
+			case non_receptive:
+				v += values[j] / 2.0
+			case receptive:
+				v += values[j] + T(Y)
+			}
+			v += flow(values, mask, j+1)
+			v += flow(below, below_mask, j-1)
+			v += flow(below, below_mask, j)
+			next[j] = v
+		}
+	}
+}
+
+// like step, with the rows cut into bands for the threads
+func step_threads[T Real](A, B, Y float64, threads int, coldness_matrix, temp_coldness_matrix *Grid[T], packed bit_mask, mask_matrix *Mask, timings *Timings) {
+	rows := len(*coldness_matrix)
+	if threads > rows/min_thread_rows {
+		threads = rows / min_thread_rows
+	}
+	if threads < 2 {
+		step_packed(A, B, Y, coldness_matrix, temp_coldness_matrix, packed, mask_matrix, timings)
+		return
+	}
+
+	bands := func(f func(from, to int)) {
+		var wg sync.WaitGroup
+		for t := 0; t < threads; t++ {
+			wg.Add(1)
+			go func(from, to int) {
+				defer wg.Done()
+				f(from, to)
+			}(rows*t/threads, rows*(t+1)/threads)
+		}
+		wg.Wait()
+	}
+	var start time.Time
+	if timings != nil {
+		start = time.Now()
+	}
+	bands(func(from, to int) { receptive_rows(from, to, coldness_matrix, packed, mask_matrix) })
+	if timings != nil {
+		start = timings.add(&timings.Receptive, start)
+	}
+	bands(func(from, to int) {
+		scatter_rows(A, Y, from, to, coldness_matrix, temp_coldness_matrix, packed)
+		if from > 0 {
+			gather_rows(A, Y, from, from+1, coldness_matrix, temp_coldness_matrix, packed)
+		}
+		if to < rows {
+			flow_from_below(A, to-1, coldness_matrix, temp_coldness_matrix, packed)
+		}
+	})
+	if timings != nil {
+		timings.add(&timings.Diffusion, start)
+	}
+
+	// swap so the new coldness matrix becomes the current one
+	*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
+}
+
+// adds the vapor the non receptive cells of the row below give to row i, in the order of step()
+func flow_from_below[T Real](A float64, i int, coldness_matrix, temp_coldness_matrix *Grid[T], packed bit_mask) {
+	values, row := (*coldness_matrix)[i+1], (*temp_coldness_matrix)[i]
+	for j := range values {
+		if packed.at(i+1, j) == non_receptive {
+			v1 := T(A) * values[j] / 12.0
+			row[j] += v1
+			row[j+1] += v1
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image"
+	"io"
+	"sort"
+)
+
+// note:
+// Tiff files are written by hand because the tiff package can't add tags. The same tags also make up
+// the exif block of jpegs, exif is a tiff header with only the metadata in it.
+//
+//   header      II*\0 and the offset of the first (and only) directory
+//   directory   entry count, 12 byte entries sorted by tag, 0 for no next directory
+//   values      everything that doesn't fit in the 4 bytes of an entry
+//   pixels      one strip of 8 bit rgb, only in tiff files
+//
+// The metadata is also written as xmp, which is what most asset managers read.
+
+const (
+	tiff_byte      = 1
+	tiff_ascii     = 2
+	tiff_short     = 3
+	tiff_long      = 4
+	tiff_rational  = 5
+	tiff_undefined = 7
+)
+
+type tiff_entry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	data  []byte
+}
+
+func ascii_entry(tag uint16, s string) tiff_entry {
+	return tiff_entry{tag, tiff_ascii, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func short_entry(tag uint16, values ...uint16) tiff_entry {
+	data := make([]byte, 2*len(values))
+	for k, v := range values {
+		binary.LittleEndian.PutUint16(data[2*k:], v)
+	}
+	return tiff_entry{tag, tiff_short, uint32(len(values)), data}
+}
+
+func long_entry(tag uint16, v uint32) tiff_entry {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, v)
+	return tiff_entry{tag, tiff_long, 1, data}
+}
+
+func rational_entry(tag uint16, numerator, denominator uint32) tiff_entry {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, numerator)
+	binary.LittleEndian.PutUint32(data[4:], denominator)
+	return tiff_entry{tag, tiff_rational, 1, data}
+}
+
+// the standard tags for the metadata that has them: description, software, artist and copyright
+func metadata_entries(metadata map[string]string) []tiff_entry {
+	var entries []tiff_entry
+	tags := []struct {
+		tag uint16
+		key string
+	}{{270, "Description"}, {305, "Software"}, {315, "Author"}, {33432, "Copyright"}}
+	for _, t := range tags {
+		if v, ok := metadata[t.key]; ok {
+			entries = append(entries, ascii_entry(t.tag, v))
+		}
+	}
+	return entries
+}
+
+// encodes a tiff header with one directory, the values go right after it
+func encode_ifd(entries []tiff_entry) []byte {
+	sort.Slice(entries, func(a, b int) bool { return entries[a].tag < entries[b].tag })
+
+	var out bytes.Buffer
+	out.WriteString("II*\x00")
+	binary.Write(&out, binary.LittleEndian, uint32(8))
+	binary.Write(&out, binary.LittleEndian, uint16(len(entries)))
+
+	// values start after the directory and are kept at even offsets
+	offset := 8 + 2 + 12*len(entries) + 4
+	var values bytes.Buffer
+	for _, entry := range entries {
+		binary.Write(&out, binary.LittleEndian, entry.tag)
+		binary.Write(&out, binary.LittleEndian, entry.kind)
+		binary.Write(&out, binary.LittleEndian, entry.count)
+		if len(entry.data) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, entry.data)
+			out.Write(inline)
+			continue
+		}
+		binary.Write(&out, binary.LittleEndian, uint32(offset+values.Len()))
+		values.Write(entry.data)
+		if values.Len()%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(&out, binary.LittleEndian, uint32(0))
+	out.Write(values.Bytes())
+	return out.Bytes()
+}
+
+// writes the image as an uncompressed rgb tiff with the metadata as tags and xmp
+func write_tiff(w io.Writer, img image.Image, metadata map[string]string) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pixels := make([]byte, 0, 3*width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			pixels = append(pixels, byte(r>>8), byte(g>>8), byte(b>>8))
+		}
+	}
+
+	entries := append(metadata_entries(metadata),
+		long_entry(256, uint32(width)),
+		long_entry(257, uint32(height)),
+		short_entry(258, 8, 8, 8), // bits per sample
+		short_entry(259, 1),       // no compression
+		short_entry(262, 2),       // rgb
+		long_entry(273, 0),        // strip offset, filled in below
+		short_entry(277, 3),       // samples per pixel
+		long_entry(278, uint32(height)),
+		long_entry(279, uint32(len(pixels))),
+		rational_entry(282, 72, 1),
+		rational_entry(283, 72, 1),
+		short_entry(296, 2), // inch
+	)
+	xmp := xmp_packet(metadata)
+	entries = append(entries, tiff_entry{700, tiff_byte, uint32(len(xmp)), xmp})
+
+	// the strip offset is a long in the entry so it doesn't change the length of the header
+	header := encode_ifd(entries)
+	for k := range entries {
+		if entries[k].tag == 273 {
+			entries[k] = long_entry(273, uint32(len(header)))
+		}
+	}
+	header = encode_ifd(entries)
+
+	buffered := bufio.NewWriter(w)
+	buffered.Write(header)
+	buffered.Write(pixels)
+	return buffered.Flush()
+}
+
+// an xmp packet with the dublin core and xmp fields, the rest of the metadata goes in the snow namespace
+func xmp_packet(metadata map[string]string) []byte {
+	escape := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` + "\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"` +
+		` xmlns:snow="https://github.com/antonpalsson/procedural-snowflakes/">` + "\n")
+	if v, ok := metadata["Software"]; ok {
+		b.WriteString("<xmp:CreatorTool>" + escape(v) + "</xmp:CreatorTool>\n")
+	}
+	if v, ok := metadata["Author"]; ok {
+		b.WriteString("<dc:creator><rdf:Seq><rdf:li>" + escape(v) + "</rdf:li></rdf:Seq></dc:creator>\n")
+	}
+	if v, ok := metadata["Description"]; ok {
+		b.WriteString(`<dc:description><rdf:Alt><rdf:li xml:lang="x-default">` + escape(v) + "</rdf:li></rdf:Alt></dc:description>\n")
+	}
+	if v, ok := metadata["Copyright"]; ok {
+		b.WriteString(`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">` + escape(v) + "</rdf:li></rdf:Alt></dc:rights>\n")
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		switch key {
+		case "Software", "Author", "Description", "Copyright":
+		default:
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b.WriteString("<snow:" + key + ">" + escape(metadata[key]) + "</snow:" + key + ">\n")
+	}
+	b.WriteString("</rdf:Description>\n</rdf:RDF></x:xmpmeta>\n")
+	b.WriteString(`<?xpacket end="w"?>`)
+	return b.Bytes()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+// note:
+// Tiles are textures that repeat without seams. Three things make that work:
+//
+//   wrapping    the matrix is a torus, the hexagons on the right are the neighbours of the ones on the
+//               left and the same for the top and the bottom, so there is no border
+//   noise       the background noise is blended with itself moved by a whole matrix in each direction,
+//               so it repeats with the matrix too
+//   seeds       several flakes grow at once, placed with the R2 low discrepancy sequence so they are
+//               spread out evenly but don't look like a grid
+//
+// The matrix is a rhombus of hexagons, not a rectangle, so it is rendered by sampling the torus for every
+// pixel of a rectangle that repeats: size wide and size times the square root of 3 high.
+
+// same as step() but the matrix wraps around at the edges
+func step_wrapped[T Real](A, B, Y float64, coldness_matrix, temp_coldness_matrix *Grid[T], mask_matrix *Mask) {
+	size := len(*coldness_matrix)
+	prev, next := make([]int, size), make([]int, size)
+	for i := 0; i < size; i++ {
+		prev[i], next[i] = (i+size-1)%size, (i+1)%size
+	}
+
+	// look for frozen hexagons and set receptive values on the mask
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			if (*coldness_matrix)[i][j] >= 1.0 {
+				(*mask_matrix)[prev[i]][j] = receptive
+				(*mask_matrix)[prev[i]][next[j]] = receptive
+				(*mask_matrix)[i][prev[j]] = receptive
+				(*mask_matrix)[i][j] = receptive
+				(*mask_matrix)[i][next[j]] = receptive
+				(*mask_matrix)[next[i]][prev[j]] = receptive
+				(*mask_matrix)[next[i]][j] = receptive
+			}
+		}
+	}
+
+	for i := range *temp_coldness_matrix {
+		for j := range (*temp_coldness_matrix)[i] {
+			(*temp_coldness_matrix)[i][j] = 0
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			switch (*mask_matrix)[i][j] {
+			case non_receptive:
+				v0 := (*coldness_matrix)[i][j]
+				v1 := T(A) * v0 / 12.0
+
+				(*temp_coldness_matrix)[prev[i]][j] += v1
+				(*temp_coldness_matrix)[prev[i]][next[j]] += v1
+				(*temp_coldness_matrix)[i][prev[j]] += v1
+				(*temp_coldness_matrix)[i][j] += v0 / 2.0
+				(*temp_coldness_matrix)[i][next[j]] += v1
+				(*temp_coldness_matrix)[next[i]][prev[j]] += v1
+				(*temp_coldness_matrix)[next[i]][j] += v1
+
+			case receptive:
+				(*temp_coldness_matrix)[i][j] += (*coldness_matrix)[i][j] + T(Y)
+			}
+		}
+	}
+
+	*coldness_matrix, *temp_coldness_matrix = *temp_coldness_matrix, *coldness_matrix
+}
+
+func init_tile[T Value](settings Settings, coldness_matrix *Grid[T], mask_matrix *Mask) {
+	size := len(*coldness_matrix)
+	noise := settings.noise()
+	period := float64(size) * settings.PP
+
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			// blend the noise with its copies one period away, the weights make every edge match the
+			// opposite one
+			x, y := float64(i)*settings.PP, float64(j)*settings.PP
+			u, v := float64(i)/float64(size), float64(j)/float64(size)
+			n := (1-u)*(1-v)*noise.Noise2D(x, y) + u*(1-v)*noise.Noise2D(x-period, y) +
+				(1-u)*v*noise.Noise2D(x, y-period) + u*v*noise.Noise2D(x-period, y-period)
+			(*coldness_matrix)[i][j] = to_value[T](n*settings.PM + settings.B)
+			(*mask_matrix)[i][j] = non_receptive
+		}
+	}
+
+	for _, seed := range settings.tile_seeds() {
+		(*coldness_matrix)[seed[0]][seed[1]] = to_value[T](settings.seed_value())
+	}
+}
+
+// the first seed is where -seed-pos says, the others follow the R2 sequence from there
+func (settings Settings) tile_seeds() [][2]int {
+	seeds := settings.Seeds
+	if seeds == 0 {
+		seeds = 1
+	}
+	// 1/g and 1/g² of the plastic number
+	const a1, a2 = 0.7548776662466927, 0.5698402909980532
+	x, y := settings.seed_pos()
+	size := float64(settings.Size)
+	positions := make([][2]int, seeds)
+	for k := range positions {
+		u := math.Mod(float64(x)/size+float64(k)*a1, 1)
+		v := math.Mod(float64(y)/size+float64(k)*a2, 1)
+		positions[k] = [2]int{int(u * size), int(v * size)}
+	}
+	return positions
+}
+
+// width and height of a rendered tile
+func tile_size(size int) (int, int) {
+	return size, int(math.Round(float64(size) * math.Sqrt(3)))
+}
+
+// renders the torus as a rectangle that repeats, with bilinear samples of the hexagons
+func render_tile[T Real](matrix *Grid[T]) image.Image {
+	img, _ := render_tile_context(context.Background(), matrix)
+	return img
+}
+
+// like render_tile, but stops between the rows with the error of the context when it's cancelled
+func render_tile_context[T Real](ctx context.Context, matrix *Grid[T]) (image.Image, error) {
+	size := len(*matrix)
+	width, height := tile_size(size)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	value := func(i, j int) float64 {
+		return float64((*matrix)[(i%size+size)%size][(j%size+size)%size])
+	}
+	for py := 0; py < height; py++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for px := 0; px < width; px++ {
+			// moving down by the height is -1, 2 whole matrices in hexagons, right by the width is 1, 0
+			u, v := (float64(px)+0.5)/float64(width), (float64(py)+0.5)/float64(height)
+			fi, fj := float64(size)*(u-v), 2*float64(size)*v
+			i, j := int(math.Floor(fi)), int(math.Floor(fj))
+			di, dj := fi-float64(i), fj-float64(j)
+			c := (1-di)*(1-dj)*value(i, j) + di*(1-dj)*value(i+1, j) + (1-di)*dj*value(i, j+1) + di*dj*value(i+1, j+1)
+			img.Set(px, py, color.Gray{uint8(math.Min(c*255, 255))})
+		}
+	}
+	return img, nil
+}
+
+// renders tiles with render_tile, padded grids with render_padded and everything else with render
+func render_settings[T Real](settings Settings, matrix *Grid[T]) image.Image {
+	img, _ := render_settings_context(context.Background(), settings, matrix)
+	return img
+}
+
+// like render_settings, but stops with the error of the context when it's cancelled
+func render_settings_context[T Real](ctx context.Context, settings Settings, matrix *Grid[T]) (image.Image, error) {
+	if settings.Tile {
+		return render_tile_context(ctx, matrix)
+	}
+	if settings.padded() {
+		return render_padded_context(ctx, matrix)
+	}
+	return render_resized_context(ctx, matrix, resample_kernels["bilinear"], 0, 1)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+)
+
+// note:
+// The timeline records the iteration every hexagon froze in. It is saved as json next to the image:
+//
+//   {"size":800,"iteration":10000,"frozen_at":[[null,null,...],[null,-1,12,...],...]}
+//
+// frozen_at is indexed like the coldness matrix, frozen_at[i][j] is hexagon i of row j in axial
+// coordinates. The seed is frozen at -1 (before the first iteration) and hexagons that never froze are
+// null. When a simulation is resumed the hexagons that were already frozen get the iteration it was
+// resumed at.
+
+// placeholder for hexagons that haven't frozen yet
+const never_frozen = -1 << 31
+
+type timeline struct {
+	frozen_at [][]int32
+}
+
+func new_timeline(state *State) *timeline {
+	size, height := len(state.Mask), len(state.Mask[0])
+	t := &timeline{frozen_at: make([][]int32, size)}
+	for i := range t.frozen_at {
+		t.frozen_at[i] = make([]int32, height)
+		for j := range t.frozen_at[i] {
+			t.frozen_at[i][j] = never_frozen
+		}
+	}
+	t.update(state)
+	return t
+}
+
+// records the hexagons that froze since the last update
+func (t *timeline) update(state *State) {
+	t.record(state, nil)
+}
+
+// like update, froze is called with every hexagon that froze when it isn't nil
+func (t *timeline) record(state *State, froze func(i, j int)) {
+	// a growing grid has become larger, the old timeline goes in the middle like the old grid
+	if size := len(state.Mask); size != len(t.frozen_at) {
+		old := t.frozen_at
+		offset := size/2 - len(old)/2
+		t.frozen_at = make([][]int32, size)
+		for i := range t.frozen_at {
+			t.frozen_at[i] = make([]int32, size)
+			for j := range t.frozen_at[i] {
+				t.frozen_at[i][j] = never_frozen
+			}
+		}
+		for i := range old {
+			copy(t.frozen_at[i+offset][offset:], old[i])
+		}
+	}
+
+	iteration := int32(state.Iteration)
+	switch {
+	case state.Settings.Fixed:
+		record_frozen(t.frozen_at, &state.ColdnessFixed, iteration, froze)
+	case state.Settings.single_precision():
+		record_frozen(t.frozen_at, &state.Coldness32, iteration, froze)
+	default:
+		record_frozen(t.frozen_at, &state.Coldness, iteration, froze)
+	}
+}
+
+func record_frozen[T Value](frozen_at [][]int32, matrix *Grid[T], iteration int32, froze func(i, j int)) {
+	one := to_value[T](1.0)
+	for i := range *matrix {
+		for j, v := range (*matrix)[i] {
+			if v >= one && frozen_at[i][j] == never_frozen {
+				frozen_at[i][j] = iteration
+				if froze != nil {
+					froze(i, j)
+				}
+			}
+		}
+	}
+}
+
+func (t *timeline) save(filename string, iteration int64) error {
+	file, err := create_output(filename, nil)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, `{"size":%d,"iteration":%d,"frozen_at":[`, len(t.frozen_at), iteration)
+	for i, row := range t.frozen_at {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteByte('[')
+		for j, v := range row {
+			if j > 0 {
+				w.WriteByte(',')
+			}
+			if v == never_frozen {
+				w.WriteString("null")
+			} else {
+				w.WriteString(strconv.Itoa(int(v)))
+			}
+		}
+		w.WriteByte(']')
+	}
+	w.WriteString("]}\n")
+
+	if err := w.Flush(); err != nil {
+		file.abort()
+		return err
+	}
+	return file.Close()
+}
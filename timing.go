@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// note:
+// -timings breaks the time of a run down into its phases, to see where it goes and why some parameters
+// run slower than others:
+//
+//   init        making the matrices, the background, the mask and the temp matrices
+//   receptive   marking the cells next to the ice, of the plain step
+//   diffusion   the vapor going around, and all of the step for the other solvers (tiles, kinetics...)
+//               since they don't mark the receptive cells in a pass of their own
+//   extras      what runs after the step, sources, obstacles, heat, smoothing, curvature, receptivity,
+//               growing grids and the check for the border
+//   snapshots   what is done between the iterations, animation frames, live images, checkpoints,
+//               history, soundtracks and printing the progress
+//   encoding    rendering and saving the result and the animation at the end
+//
+// The phases are timed with the clock around every pass, which costs a fraction of a microsecond an
+// iteration, so the numbers are only kept when a caller asks for them by setting timings on the state.
+// With threads a pass is timed from the start of its first thread to the end of its last one. In
+// distributed mode the steps are done on the workers, only init and encoding are timed.
+// -timings-json saves them as <result>-timings.json in seconds, batch -timings adds them to the stats.
+// With -animation they also keep how often and how long the simulation waited for its renderer.
+
+// time spent in the phases of a run
+type Timings struct {
+	Init, Receptive, Diffusion, Extras, Snapshots, Encoding time.Duration
+
+	Backpressure *Backpressure // of the animation, nil without one
+}
+
+func (t *Timings) total() time.Duration {
+	return t.Init + t.Receptive + t.Diffusion + t.Extras + t.Snapshots + t.Encoding
+}
+
+// adds the time since start to the phase and returns now, for the next phase to start from
+func (t *Timings) add(phase *time.Duration, start time.Time) time.Time {
+	now := time.Now()
+	*phase += now.Sub(start)
+	return now
+}
+
+type phase_time struct {
+	name     string
+	duration time.Duration
+}
+
+func (t *Timings) phases() []phase_time {
+	return []phase_time{
+		{"init", t.Init}, {"receptive", t.Receptive}, {"diffusion", t.Diffusion},
+		{"extras", t.Extras}, {"snapshots", t.Snapshots}, {"encoding", t.Encoding},
+	}
+}
+
+// like "init 0.12s (3%), receptive 0.50s (12%) ..."
+func (t *Timings) String() string {
+	total := t.total()
+	var parts []string
+	for _, p := range t.phases() {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(p.duration) / float64(total)
+		}
+		parts = append(parts, fmt.Sprintf("%s %.2fs (%.0f%%)", p.name, p.duration.Seconds(), share))
+	}
+	if t.Backpressure != nil {
+		parts = append(parts, fmt.Sprintf("waited %d times for the animation (%s)", t.Backpressure.Stalls, t.Backpressure.Waited.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// the phases in seconds
+func (t *Timings) MarshalJSON() ([]byte, error) {
+	seconds := map[string]interface{}{"total": t.total().Seconds()}
+	for _, p := range t.phases() {
+		seconds[p.name] = p.duration.Seconds()
+	}
+	if t.Backpressure != nil {
+		seconds["backpressure"] = t.Backpressure
+	}
+	return json.Marshal(seconds)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// note:
+// Traits describe a finished flake in words, for sorting and searching big collections. Everything is
+// measured around the seed in hex coordinates, the matrix indexes i, j are the axial coordinates q, r.
+//
+//   arms       directions the flake reaches further out in than in the others
+//   form       how much of the hexagon around the flake is frozen, plates fill it and dendrites don't
+//   density    frozen hexagons on the edge of the flake compared to all of them, side branches make it lacy
+//   symmetry   how well the flake matches itself rotated by 60, 120 and 180 degrees, and mirrored
+//
+// With more than one flake every trait also gets its rarity, the share of the flakes that have it.
+
+type Traits struct {
+	Arms     int    `json:"arms"`
+	Form     string `json:"form"`
+	Density  string `json:"density"`
+	Symmetry string `json:"symmetry"`
+	Mirror   bool   `json:"mirror"`
+
+	Frozen        int     `json:"frozen"`
+	Radius        int     `json:"radius"`
+	Fill          float64 `json:"fill"`
+	Edge          float64 `json:"edge"`
+	SymmetryScore float64 `json:"symmetry_score"`
+	MirrorScore   float64 `json:"mirror_score"`
+
+	Rarity map[string]float64 `json:"rarity,omitempty"`
+}
+
+// a match above this counts as symmetric
+const symmetry_threshold = 0.85
+
+func analyze(state *State) Traits {
+	values := state.values()
+	size, height := len(values), len(values[0])
+	x, y := state.Settings.seed_pos()
+
+	frozen := func(q, r int) bool {
+		i, j := x+q, y+r
+		return i >= 0 && i < size && j >= 0 && j < height && values[i][j] >= 1.0
+	}
+
+	// collect the frozen hexagons relative to the seed
+	var traits Traits
+	var cells [][2]int
+	edge := 0
+	for i := 0; i < size; i++ {
+		for j := 0; j < height; j++ {
+			if values[i][j] < 1.0 {
+				continue
+			}
+			q, r := i-x, j-y
+			cells = append(cells, [2]int{q, r})
+			traits.Radius = int(math.Max(float64(traits.Radius), hex_length(q, r)))
+			if !frozen(q-1, r) || !frozen(q-1, r+1) || !frozen(q, r-1) ||
+				!frozen(q, r+1) || !frozen(q+1, r-1) || !frozen(q+1, r) {
+				edge++
+			}
+		}
+	}
+	traits.Frozen = len(cells)
+	if traits.Frozen == 0 {
+		traits.Form, traits.Density, traits.Symmetry = "none", "none", "none"
+		return traits
+	}
+
+	// hexagons in a hexagon with the radius of the flake
+	R := float64(traits.Radius)
+	traits.Fill = float64(traits.Frozen) / (3*R*R + 3*R + 1)
+	traits.Edge = float64(edge) / float64(traits.Frozen)
+
+	switch {
+	case traits.Fill >= 0.6:
+		traits.Form = "plate"
+	case traits.Fill >= 0.35:
+		traits.Form = "sectored plate"
+	case traits.Fill >= 0.12:
+		traits.Form = "stellar dendrite"
+	default:
+		traits.Form = "fernlike dendrite"
+	}
+
+	switch {
+	case traits.Edge >= 0.6:
+		traits.Density = "lacy"
+	case traits.Edge >= 0.3:
+		traits.Density = "medium"
+	default:
+		traits.Density = "solid"
+	}
+
+	traits.Arms = count_arms(cells)
+
+	// rotating by 60 degrees around the seed in axial coordinates is q, r -> -r, q+r
+	rotate := func(c [2]int) [2]int { return [2]int{-c[1], c[0] + c[1]} }
+	// thin branches rarely line up exactly, a frozen neighbour counts as a match too
+	match := func(transform func([2]int) [2]int) float64 {
+		hits := 0
+		for _, c := range cells {
+			q, r := transform(c)[0], transform(c)[1]
+			if frozen(q, r) || frozen(q-1, r) || frozen(q-1, r+1) || frozen(q, r-1) ||
+				frozen(q, r+1) || frozen(q+1, r-1) || frozen(q+1, r) {
+				hits++
+			}
+		}
+		return float64(hits) / float64(len(cells))
+	}
+	rotations := []struct {
+		times int
+		name  string
+	}{{1, "six-fold"}, {2, "three-fold"}, {3, "two-fold"}}
+	traits.Symmetry = "none"
+	for _, rotation := range rotations {
+		score := match(func(c [2]int) [2]int {
+			for k := 0; k < rotation.times; k++ {
+				c = rotate(c)
+			}
+			return c
+		})
+		if rotation.times == 1 {
+			traits.SymmetryScore = score
+		}
+		if score >= symmetry_threshold {
+			traits.Symmetry = rotation.name
+			break
+		}
+	}
+	// mirrored along the axis q = r
+	traits.MirrorScore = match(func(c [2]int) [2]int { return [2]int{c[1], c[0]} })
+	traits.Mirror = traits.MirrorScore >= symmetry_threshold
+
+	return traits
+}
+
+// hex distance of q, r from the middle
+func hex_length(q, r int) float64 {
+	return math.Max(math.Max(math.Abs(float64(q)), math.Abs(float64(r))), math.Abs(float64(q+r)))
+}
+
+// counts the directions the flake reaches far out in, with 5 degree steps
+func count_arms(cells [][2]int) int {
+	const directions = 72
+	reach := make([]float64, directions)
+	for _, c := range cells {
+		// axial to cartesian
+		px := float64(c[0]) + float64(c[1])/2
+		py := float64(c[1]) * math.Sqrt(3) / 2
+		d := int(math.Floor((math.Atan2(py, px) + math.Pi) / (2 * math.Pi) * directions))
+		reach[d%directions] = math.Max(reach[d%directions], math.Hypot(px, py))
+	}
+
+	low, high := reach[0], reach[0]
+	for _, r := range reach {
+		low, high = math.Min(low, r), math.Max(high, r)
+	}
+	if high-low < 0.2*high {
+		// the flake reaches about as far out everywhere, a plate with six corners
+		return 6
+	}
+
+	// an arm is a run of directions that reach further out than half way between the lowest and highest
+	far := (low + high) / 2
+	arms, start := 0, -1
+	for d := range reach {
+		if reach[d] < far {
+			start = d
+			break
+		}
+	}
+	inside := false
+	for k := 1; k <= directions; k++ {
+		d := (start + k) % directions
+		if reach[d] >= far && !inside {
+			arms++
+		}
+		inside = reach[d] >= far
+	}
+	return arms
+}
+
+// adds the share of the flakes that has the same trait to every flake
+func add_rarity(traits []Traits) {
+	names := []string{"arms", "form", "density", "symmetry", "mirror"}
+	value := func(t Traits, name string) string {
+		switch name {
+		case "arms":
+			return fmt.Sprint(t.Arms)
+		case "form":
+			return t.Form
+		case "density":
+			return t.Density
+		case "symmetry":
+			return t.Symmetry
+		}
+		return fmt.Sprint(t.Mirror)
+	}
+
+	for _, name := range names {
+		counts := map[string]int{}
+		for _, t := range traits {
+			counts[value(t, name)]++
+		}
+		for k := range traits {
+			if traits[k].Rarity == nil {
+				traits[k].Rarity = map[string]float64{}
+			}
+			traits[k].Rarity[name] = float64(counts[value(traits[k], name)]) / float64(len(traits))
+		}
+	}
+}
+
+func save_traits(filename string, traits Traits) error {
+	return save_json(filename, traits)
+}
+
+// snow traits [-out dir] a.snow b.snow ...
+func traits_command(args []string) {
+	flags := flag.NewFlagSet("traits", flag.ExitOnError)
+	out := flags.String("out", "", "directory for the traits files (default is next to the state files)")
+	print_only := flags.Bool("print", false, "only print the traits as json lines instead of saving files")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow traits [flags] state.snow ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	all := make([]Traits, flags.NArg())
+	for k, filename := range flags.Args() {
+		state, err := load_state(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		all[k] = analyze(state)
+	}
+	if len(all) > 1 {
+		add_rarity(all)
+	}
+
+	lines := json.NewEncoder(os.Stdout)
+	for k, filename := range flags.Args() {
+		if *print_only {
+			lines.Encode(all[k])
+			continue
+		}
+		name := strings.TrimSuffix(filename, filepath.Ext(filename)) + "-traits.json"
+		if *out != "" {
+			name = filepath.Join(*out, filepath.Base(name))
+		}
+		if err := save_traits(name, all[k]); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save traits:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s:\t %d arms, %s, %s, %s\n", name, all[k].Arms, all[k].Form, all[k].Density, all[k].Symmetry)
+	}
+}
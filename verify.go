@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+)
+
+// small simulations with known results, the hashes were made on linux/amd64
+var references = []struct {
+	name     string
+	settings Settings
+	hash     string
+}{
+	{
+		name:     "float64",
+		settings: Settings{A: 1.0, B: 0.4, Y: 0.001, PP: 0.05, PM: 0.2, L: 400, Size: 96},
+		hash:     "e11c704b8bf8adda8cc268a731afe18ac393ca869b9a54caffcd939be9199b4e",
+	},
+	{
+		name:     "float32",
+		settings: Settings{A: 1.0, B: 0.4, Y: 0.001, PP: 0.05, PM: 0.2, L: 400, Size: 96, Precision: 32},
+		hash:     "15eb92067499c07e76fb36fb2f28d76180aadc167090c70f8ca84713fa4654c0",
+	},
+	{
+		name:     "fixed",
+		settings: Settings{A: 1.0, B: 0.4, Y: 0.001, PP: 0.05, PM: 0.2, L: 400, Size: 96, Fixed: true},
+		hash:     "558b4af678c4fa2672d1d11eba15de7bd86f49f2a75e1d5c9dd81fa7a4044034",
+	},
+}
+
+// snow verify, runs the reference simulations and checks that this build reproduces them exactly
+func verify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow verify\n\n")
+		fmt.Fprintf(flags.Output(), "runs small reference simulations and compares them with the canonical results\n")
+	}
+	flags.Parse(args)
+
+	fmt.Printf("platform:\t %s/%s %s\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+
+	failed := false
+	for _, reference := range references {
+		state := new_state(reference.settings)
+		run(state, nil)
+		hash := state_hash(state)
+
+		if hash == reference.hash {
+			fmt.Printf("%s:\t ok\n", reference.name)
+		} else {
+			failed = true
+			fmt.Printf("%s:\t MISMATCH got %s want %s\n", reference.name, hash, reference.hash)
+		}
+	}
+
+	if failed {
+		fmt.Println("this build does not reproduce the canonical results, snowflakes made with the failing modes can differ from other machines")
+		os.Exit(1)
+	}
+	fmt.Println("this build reproduces the canonical results exactly")
+}
+
+// sha256 of the exact bits of the coldness values and the mask
+func state_hash(state *State) string {
+	hash := sha256.New()
+	buffer := make([]byte, 8)
+	for i := range state.Mask {
+		for j := range state.Mask[i] {
+			switch {
+			case state.Settings.Fixed:
+				binary.LittleEndian.PutUint64(buffer, uint64(state.ColdnessFixed[i][j]))
+			case state.Settings.single_precision():
+				binary.LittleEndian.PutUint64(buffer, uint64(math.Float32bits(state.Coldness32[i][j])))
+			default:
+				binary.LittleEndian.PutUint64(buffer, math.Float64bits(state.Coldness[i][j]))
+			}
+			hash.Write(buffer)
+		}
+		hash.Write(state.Mask[i])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// note:
+// snow perform can stream the flake as video for OBS, a virtual camera or a video call, next to the
+// image it renders:
+//
+//   -video -       raw rgb24 frames on stdout (or a file or fifo), for ffmpeg to turn into anything
+//   -mjpeg :8080   motion jpeg over http, every client gets its own stream, for the media or browser
+//                  source of OBS and anything else that shows an <img>
+//
+// A v4l2 loopback camera needs its format set before frames are written to it, which ffmpeg does:
+//
+//   snow perform -video - | ffmpeg -f rawvideo -pix_fmt rgb24 -s 400x400 -r 25 -i - -f v4l2 /dev/video2
+//
+// The simulation renders a frame every -every iterations into the feed, and the streams send whatever is
+// newest at -fps, so the video runs at the same rate however fast the flake grows, showing a frame
+// again when the next isn't done yet. A client that falls behind only misses frames. The size of
+// the frames is the size of the rendered matrix (printed when it starts), ffmpeg scales it if needed.
+//
+// With -fade the streams cross-fade from the last frame of a flake to the frames of the next one, the
+// blend is made when a frame is sent so it is as smooth as -fps. -screensaver puts that together with
+// random flakes and /screen, a page that shows the motion jpeg on a black screen without a cursor and
+// goes fullscreen after a click, for a browser in kiosk mode. -window shows the feed in a fullscreen
+// window of its own instead (see window.go).
+
+// the newest frame of the performance
+type frame_feed struct {
+	mu       sync.Mutex
+	frame    *image.RGBA
+	previous *image.RGBA // the last frame of the flake before, while it fades out
+	faded    time.Time   // when the fade started
+	fade     time.Duration
+}
+
+// replaces the newest frame
+func (f *frame_feed) set(img image.Image) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		bounds := img.Bounds()
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	}
+	f.mu.Lock()
+	f.frame = rgba
+	f.mu.Unlock()
+}
+
+// fades from the newest frame to the frames that come after it for the duration
+func (f *frame_feed) fade_out(duration time.Duration) {
+	f.mu.Lock()
+	f.previous, f.faded, f.fade = f.frame, time.Now(), duration
+	f.mu.Unlock()
+}
+
+// the newest frame, nil before the first, it isn't changed after this
+func (f *frame_feed) get() *image.RGBA {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.previous == nil || f.frame == nil || f.previous.Rect != f.frame.Rect {
+		return f.frame
+	}
+	t := float64(time.Since(f.faded)) / float64(f.fade)
+	if t >= 1 {
+		f.previous = nil
+		return f.frame
+	}
+	out := image.NewRGBA(f.frame.Rect)
+	for k := range out.Pix {
+		out.Pix[k] = uint8(float64(f.previous.Pix[k])*(1-t) + float64(f.frame.Pix[k])*t + 0.5)
+	}
+	return out
+}
+
+// writes the newest frame as rgb24 fps times a second until writing fails
+func stream_raw(w io.Writer, feed *frame_feed, fps float64) error {
+	out := bufio.NewWriter(w)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+	defer ticker.Stop()
+	var rgb []byte
+	for range ticker.C {
+		frame := feed.get()
+		if frame == nil {
+			continue
+		}
+		rgb = rgb[:0]
+		for k := 0; k < len(frame.Pix); k += 4 {
+			rgb = append(rgb, frame.Pix[k], frame.Pix[k+1], frame.Pix[k+2])
+		}
+		if _, err := out.Write(rgb); err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a page that shows the stream on all of the screen, fullscreen after a click
+const screen_page = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>snow</title>
+<style>html, body { margin: 0; height: 100%; background: #000; cursor: none; overflow: hidden; }
+img { width: 100%; height: 100%; object-fit: contain; }</style></head>
+<body><img src="/" alt=""><script>
+document.body.addEventListener("click", () => document.documentElement.requestFullscreen());
+</script></body></html>
+`
+
+// serves the feed as motion jpeg until the listener fails, and the fullscreen page on /screen
+func serve_mjpeg(listener net.Listener, feed *frame_feed, fps float64) error {
+	const boundary = "snowflake"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/screen" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, screen_page)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+		w.Header().Set("Cache-Control", "no-cache")
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+			frame := feed.get()
+			if frame == nil {
+				continue
+			}
+			fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\n\r\n", boundary)
+			if err := jpeg.Encode(w, frame, &jpeg.Options{Quality: 90}); err != nil {
+				return
+			}
+			if _, err := io.WriteString(w, "\r\n"); err != nil {
+				return
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+	})
+	return http.Serve(listener, handler)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// note:
+// -render volume is an experimental renderer that ray-marches through the flake as a translucent volume.
+// The thickness of -render ice becomes a plate around z = 0, volume_depth pixels thick where it is
+// thickest, and every pixel sends a ray straight into it. At every step along the ray a second ray is
+// marched to the -light to see how much of it is left after going through the ice, so light that only
+// crosses a thin part or the border arrives strong and the inside glows softly. The ice scatters all
+// colors the same and swallows red more than blue, so the deep parts glow blue, -absorption scales how
+// much it swallows.
+//
+// It is slow, about view_steps * light_steps samples for every pixel of the flake, the rows are marched
+// in parallel.
+
+const (
+	volume_depth   = 12.0 // thickness in pixels of the thickest ice
+	volume_step    = 1.0  // pixels between the samples along the rays
+	volume_reach   = 40.0 // pixels the light rays go before the light counts as not blocked
+	volume_scatter = 0.12 // part of the light scattered for every pixel, the same for all colors
+	volume_forward = 0.8  // part of the scattered light that keeps going the same way, ice scatters forward
+	volume_power   = 1.6  // brightness of the light
+	volume_ambient = 0.12 // light that comes from everywhere, so shadowed ice isn't black
+)
+
+// part of every channel the ice swallows for every pixel, before -absorption, red the most
+var volume_absorption = [3]float64{0.05, 0.02, 0.006}
+
+// x,y,z towards the light, in image coordinates with y down and z towards the camera
+func parse_light(s string) ([3]float64, error) {
+	var v [3]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("expected x,y,z but got %q", s)
+	}
+	for k, part := range parts {
+		var err error
+		if v[k], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+			return v, err
+		}
+	}
+	if v == [3]float64{} {
+		return v, fmt.Errorf("the direction of %q is 0", s)
+	}
+	return normalized(v), nil
+}
+
+// a sampled image with its values from 0 to 1, bilinear between the pixels and 0 outside
+type field struct {
+	width, height int
+	values        []float64
+}
+
+func new_field(img image.Image) field {
+	bounds := img.Bounds()
+	f := field{bounds.Dx(), bounds.Dy(), make([]float64, bounds.Dx()*bounds.Dy())}
+	for y := 0; y < f.height; y++ {
+		for x := 0; x < f.width; x++ {
+			f.values[y*f.width+x] = float64(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y) / 255
+		}
+	}
+	return f
+}
+
+// the value at x, y where pixel centers are at .5
+func (f field) at(x, y float64) float64 {
+	x, y = x-0.5, y-0.5
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	get := func(x, y int) float64 {
+		if x < 0 || x >= f.width || y < 0 || y >= f.height {
+			return 0
+		}
+		return f.values[y*f.width+x]
+	}
+	top := get(x0, y0)*(1-fx) + get(x0+1, y0)*fx
+	bottom := get(x0, y0+1)*(1-fx) + get(x0+1, y0+1)*fx
+	return top*(1-fy) + bottom*fy
+}
+
+// the flake ray-marched as a volume on the background, draw renders a matrix like the result is rendered
+func render_volume(values Matrix, draw func(*Matrix) image.Image, background color.RGBA, light [3]float64, absorption float64) image.Image {
+	img, _ := render_volume_context(context.Background(), values, draw, background, light, absorption)
+	return img
+}
+
+// like render_volume, but no more rows are marched once the context is cancelled and it returns its error
+func render_volume_context(ctx context.Context, values Matrix, draw func(*Matrix) image.Image, background color.RGBA, light [3]float64, absorption float64) (image.Image, error) {
+	thick := thickness(values)
+	heights := new_field(draw(&thick))
+	back := [3]float64{float64(background.R) / 255, float64(background.G) / 255, float64(background.B) / 255}
+	// the light along the view rays loses everything that is scattered, the light on its way to a point
+	// keeps what is scattered forward
+	var extinction, shadow [3]float64
+	for k := range extinction {
+		extinction[k] = volume_scatter + volume_absorption[k]*absorption
+		shadow[k] = (1-volume_forward)*volume_scatter + volume_absorption[k]*absorption
+	}
+	// half of the plate at x, y
+	half := func(x, y float64) float64 { return heights.at(x, y) * volume_depth / 2 }
+
+	out := image.NewRGBA(image.Rect(0, 0, heights.width, heights.height))
+	rows := make(chan int)
+	var wait sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for py := range rows {
+				for px := 0; px < heights.width; px++ {
+					x, y := float64(px)+0.5, float64(py)+0.5
+					top := half(x, y)
+					c := back
+					if top > 0 {
+						c = march(x, y, top, half, light, extinction, shadow, back)
+					}
+					channel := func(v float64) uint8 { return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255)) }
+					out.SetRGBA(px, py, color.RGBA{channel(c[0]), channel(c[1]), channel(c[2]), 255})
+				}
+			}
+		}()
+	}
+	for py := 0; py < heights.height && ctx.Err() == nil; py++ {
+		select {
+		case rows <- py:
+		case <-ctx.Done():
+		}
+	}
+	close(rows)
+	wait.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// the light that leaves the plate towards the camera at x, y, from the top of the plate to the bottom,
+// with the background behind it
+func march(x, y, top float64, half func(x, y float64) float64, light, extinction, shadow, back [3]float64) [3]float64 {
+	var sum [3]float64
+	through := [3]float64{1, 1, 1}
+	for z := top - volume_step/2; z > -top; z -= volume_step {
+		// how much ice there is between here and the light
+		depth := 0.0
+		for d := volume_step; d < volume_reach; d += volume_step {
+			lx, ly, lz := x+light[0]*d, y+light[1]*d, z+light[2]*d
+			if math.Abs(lz) >= volume_depth/2 && lz*light[2] > 0 {
+				// above or below the thickest ice and going away, nothing to block the light
+				break
+			}
+			if math.Abs(lz) < half(lx, ly) {
+				depth += volume_step
+			}
+		}
+		for k := range sum {
+			arrived := volume_power*math.Exp(-shadow[k]*depth) + volume_ambient
+			taken := 1 - math.Exp(-extinction[k]*volume_step)
+			// the part of what is taken that is scattered and not swallowed
+			sum[k] += through[k] * taken * volume_scatter / extinction[k] * arrived
+			through[k] *= 1 - taken
+		}
+	}
+	for k := range sum {
+		sum[k] += through[k] * back[k]
+	}
+	return sum
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// note:
+// snow watch flake.yaml grows the flake of the settings in the file and starts over every time the file
+// changes, for an edit, save and look loop. The file is checked every watch_poll, a change of its time
+// or size stops the simulation that runs and starts the new one right away. The flake is rendered to the
+// preview (snowflakes/watch.png, -out) every -live-every iterations and when it's done, like -live, so
+// an image viewer that shows the preview follows along.
+//
+// The file has the settings like a job of snow batch. A .json file is one json object, and a .yaml or
+// .yml file has one key: value a line, with # comments, quoted or bare strings, and kinetics or seed_pos
+// as json on their line:
+//
+//   A: 1
+//   B: 0.4                # background
+//   size: 400
+//   kinetics: {"beta": 1.6, "kappa": 0.005}
+//
+// That is all of yaml the settings need, there are no nested blocks or lists. Missing settings get the
+// README defaults. A file that doesn't parse or has bad settings prints why and waits for the next save.
+//
+// snow -watch flake.yaml is the same loop from the normal command line. The settings all come from the
+// file, so next to it only -out, -live-every and -palette can be given, the other flags would be ignored
+// and are turned down.
+
+// how often the watched file is checked for changes
+const watch_poll = 250 * time.Millisecond
+
+// the preview and how often it's rendered when they aren't given
+const (
+	watch_out        = "snowflakes/watch.png"
+	watch_live_every = 500
+)
+
+// the settings in the file, json or flat yaml
+func read_watched(filename string) (Settings, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Settings{}, err
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		fields, err := parse_flat_yaml(string(data))
+		if err != nil {
+			return Settings{}, err
+		}
+		if data, err = json.Marshal(fields); err != nil {
+			return Settings{}, err
+		}
+	}
+	settings := default_settings()
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return Settings{}, err
+	}
+	return settings, settings.check()
+}
+
+// the key: value lines of the yaml
+func parse_flat_yaml(text string) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	for n, line := range strings.Split(text, "\n") {
+		if k := strings.Index(line, "#"); k == 0 || (k > 0 && (line[k-1] == ' ' || line[k-1] == '\t')) {
+			line = line[:k]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line == "---" {
+			continue
+		}
+		k := strings.Index(line, ":")
+		if k < 0 {
+			return nil, fmt.Errorf("line %d: %q isn't key: value", n+1, line)
+		}
+		key, value := strings.TrimSpace(line[:k]), strings.TrimSpace(line[k+1:])
+		if value == "" {
+			return nil, fmt.Errorf("line %d: %s has no value, nested yaml isn't supported, write it as json", n+1, key)
+		}
+		var v interface{}
+		switch {
+		case value[0] == '{' || value[0] == '[':
+			if err := json.Unmarshal([]byte(value), &v); err != nil {
+				return nil, fmt.Errorf("line %d: %s: %v", n+1, key, err)
+			}
+		case value[0] == '"':
+			s, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %v", n+1, key, err)
+			}
+			v = s
+		case value[0] == '\'' && len(value) > 1 && value[len(value)-1] == '\'':
+			v = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		case value == "true" || value == "false":
+			v = value == "true"
+		default:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				v = f
+			} else {
+				v = value
+			}
+		}
+		fields[key] = v
+	}
+	return fields, nil
+}
+
+// what tells that the file changed
+type watch_stamp struct {
+	modified time.Time
+	size     int64
+}
+
+func watched_stamp(filename string) watch_stamp {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return watch_stamp{}
+	}
+	return watch_stamp{info.ModTime(), info.Size()}
+}
+
+// snow watch [-out snowflakes/watch.png] flake.yaml
+func watch_command(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	out := flags.String("out", watch_out, "preview the flake is rendered to")
+	live_every := flags.Int64("live-every", watch_live_every, "iterations between the renders of the preview while the flake grows, 0 only renders the finished flake")
+	palette_name := flags.String("palette", "", "colors of the preview: "+palette_names()+", or a file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow watch [flags] flake.yaml|flake.json\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	watch(flags.Arg(0), *out, *live_every, *palette_name)
+}
+
+// grows the settings in the file to the preview out and starts over whenever the file changes, until
+// the program is stopped
+func watch(filename, out string, live_every int64, palette_name string) {
+	if out == "-" || live_every < 0 {
+		fmt.Fprintln(os.Stderr, "the preview must be a file and -live-every can't be less than 0")
+		os.Exit(2)
+	}
+	colors, err := pick_theme("", palette_name, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if dir := filepath.Dir(out); dir != "." {
+		os.MkdirAll(dir, 0755)
+	}
+	fmt.Fprintf(os.Stderr, "watching:\t %s, the preview is %s\n", filename, out)
+
+	ticker := time.NewTicker(watch_poll)
+	defer ticker.Stop()
+	for {
+		stamp := watched_stamp(filename)
+		settings, err := read_watched(filename)
+		var done chan error
+		cancel := func() {}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bad settings:\t %v, waiting for a change\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "settings:\t A=%.4f B=%.4f Y=%.4f PP=%.4f PM=%.4f I=%d size=%s\n",
+				settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L, settings.dimensions())
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			done = make(chan error, 1)
+			state := new_state(settings)
+			go func() {
+				err := run_context(ctx, state, func(iteration int64) {
+					fmt.Fprintf(os.Stderr, "\rsimulation:\t %d / %d", iteration, settings.L)
+					if live_every > 0 && iteration%live_every == 0 {
+						if err := save_live(out, state, colors, palette_name != ""); err != nil {
+							fmt.Fprintln(os.Stderr, "\nfailed to save preview:", err)
+						}
+					}
+				})
+				if err == nil {
+					err = save_live(out, state, colors, palette_name != "")
+				}
+				done <- err
+			}()
+		}
+
+		// until the file changes, the simulation finishes on the way
+	wait:
+		for range ticker.C {
+			select {
+			case err := <-done:
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "\nfailed:\t\t", err)
+				} else {
+					fmt.Fprintln(os.Stderr, "\nsaved preview:\t", out)
+				}
+				done = nil
+			default:
+			}
+			if watched_stamp(filename) != stamp {
+				break wait
+			}
+		}
+		cancel()
+		if done != nil {
+			<-done
+			fmt.Fprintln(os.Stderr, "\nchanged:\t starting over")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+//go:build gui
+// +build gui
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"runtime"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/widget"
+)
+
+// note:
+// The windows of snow are fyne windows, built with -tags gui since fyne needs cgo, OpenGL and the
+// development files of X11 (or Wayland with -tags gui,wayland) to compile, which a build of the
+// command line shouldn't. Without the tag window_other.go says how to get them instead.
+//
+//   perform -window   the screensaver, the frames of the feed fullscreen
+//   gui               sliders for the parameters next to the flake growing, see desktop_gui.go
+//
+// perform -window shows the feed on the whole screen, black around the flake and without a cursor,
+// the newest frame -fps times a second so it fades between flakes like the video streams do (see
+// video.go). Escape or q closes it and ends the performance. Fyne runs its windows on the main
+// goroutine, the simulation goes on in another one and only hands over frames.
+
+// nil when this build can open windows, fyne panics when there is no display
+func windows_available() error {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" && os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return errors.New("there is no display to open a window on, DISPLAY and WAYLAND_DISPLAY are empty")
+	}
+	return nil
+}
+
+// an image on black that hides the cursor over it
+type screen_view struct {
+	widget.BaseWidget
+	image *canvas.Image
+}
+
+func new_screen_view() *screen_view {
+	view := &screen_view{image: canvas.NewImageFromImage(image.NewRGBA(image.Rect(0, 0, 1, 1)))}
+	view.image.FillMode = canvas.ImageFillContain
+	view.ExtendBaseWidget(view)
+	return view
+}
+
+func (view *screen_view) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewMax(canvas.NewRectangle(color.Black), view.image))
+}
+
+func (view *screen_view) Cursor() desktop.Cursor {
+	return desktop.HiddenCursor
+}
+
+// shows the feed fullscreen until the window is closed
+func show_window(feed *frame_feed, fps float64) error {
+	a := app.New()
+	w := a.NewWindow("snow")
+	view := new_screen_view()
+	w.SetContent(view)
+	w.SetPadded(false)
+	w.SetFullScreen(true)
+	w.Canvas().SetOnTypedKey(func(key *fyne.KeyEvent) {
+		if key.Name == fyne.KeyEscape || key.Name == fyne.KeyQ {
+			w.Close()
+		}
+	})
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+		defer ticker.Stop()
+		var shown *image.RGBA
+		for range ticker.C {
+			// the feed hands out the same frame until there is a new one or a fade
+			if frame := feed.get(); frame != nil && frame != shown {
+				shown = frame
+				view.image.Image = frame
+				view.image.Refresh()
+			}
+		}
+	}()
+	w.ShowAndRun()
+	return nil
+}
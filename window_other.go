@@ -0,0 +1,23 @@
+//go:build !gui
+// +build !gui
+
+package main
+
+import "errors"
+
+// windows need fyne, and fyne needs cgo and the development files of the desktop, so they are only
+// built with -tags gui (see window.go)
+const no_windows = "this snow is built without windows, go build -tags gui adds them, it needs cgo, OpenGL and the development files of X11 (or -tags gui,wayland)"
+
+// nil when this build can open windows
+func windows_available() error {
+	return errors.New(no_windows)
+}
+
+func show_window(feed *frame_feed, fps float64) error {
+	return errors.New(no_windows)
+}
+
+func desktop_gui(max_size int) error {
+	return errors.New(no_windows)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// note:
+// The wizard asks a few questions in plain words and picks the parameters from the answers:
+//
+//   shape       branchy, in between or plate, B and Y from the thumbnails of snow explain
+//   irregular   symmetric, natural or wild, PM
+//   size        small, medium or large, the size of the matrix
+//
+// L is found with a preview on a matrix a quarter of the size, it runs until the flake reaches a bit more
+// than half way to the border and L is scaled up from there. Flakes grow about as many hexagons per
+// iteration on every matrix size, until they get close to the border where the water runs out and they
+// almost stop, so the preview doesn't go further than that. The result is a batch job, so the full run
+// is snow batch < the file.
+
+type wizard_choice struct {
+	answer   string
+	describe string
+	set      func(s *Settings)
+}
+
+type wizard_question struct {
+	question string
+	choices  []wizard_choice // the first is the default
+}
+
+var wizard_questions = []wizard_question{
+	{"More branchy or more plate-like?", []wizard_choice{
+		{"in between", "arms with side branches", func(s *Settings) { s.B, s.Y = 0.4, 0.001 }},
+		{"branchy", "thin arms full of branches", func(s *Settings) { s.B, s.Y = 0.35, 0.001 }},
+		{"plate", "solid and wide", func(s *Settings) { s.B, s.Y = 0.45, 0.003 }},
+	}},
+	{"How regular?", []wizard_choice{
+		{"natural", "all arms a little different", func(s *Settings) { s.PP, s.PM = 0.05, 0.2 }},
+		{"symmetric", "six identical arms", func(s *Settings) { s.PP, s.PM = 0.05, 0 }},
+		{"wild", "lopsided", func(s *Settings) { s.PP, s.PM = 0.05, 0.4 }},
+	}},
+	{"How big?", []wizard_choice{
+		{"medium", "800 pixels", func(s *Settings) { s.Size = 800 }},
+		{"small", "400 pixels, quick", func(s *Settings) { s.Size = 400 }},
+		{"large", "1600 pixels, slow", func(s *Settings) { s.Size = 1600 }},
+	}},
+}
+
+// the settings don't change with this one, only the command line
+var animated_question = wizard_question{"Animated?", []wizard_choice{
+	{"no", "only the final flake", nil},
+	{"yes", "also a sprite sheet of the growth", nil},
+}}
+
+// how far from the seed to the border the preview grows, as a share of half the matrix
+const wizard_reach = 0.6
+
+// asks the question until the answer is one of the choices, an empty answer is the default
+func ask(r *bufio.Reader, w io.Writer, q wizard_question) wizard_choice {
+	for {
+		fmt.Fprintln(w, q.question)
+		for k, choice := range q.choices {
+			fmt.Fprintf(w, "  %d. %s, %s\n", k+1, choice.answer, choice.describe)
+		}
+		fmt.Fprintf(w, "[%s] ", q.choices[0].answer)
+		line, err := r.ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		fmt.Fprintln(w)
+		if answer == "" {
+			return q.choices[0]
+		}
+		for k, choice := range q.choices {
+			if answer == fmt.Sprint(k+1) || strings.HasPrefix(choice.answer, answer) {
+				return choice
+			}
+		}
+		if err != nil {
+			// nothing more to read, take the rest as defaults
+			return q.choices[0]
+		}
+		fmt.Fprintf(w, "%q isn't one of the answers\n\n", answer)
+	}
+}
+
+// grows the flake until it reaches wizard_reach, returns the iterations it took
+func wizard_preview(settings Settings, limit int64) (*State, int64) {
+	settings.L = limit
+	state := new_state(settings)
+	reach := wizard_reach * float64(settings.Size) / 2
+	run(state, func(iteration int64) {
+		if iteration%100 != 0 {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\rpreview:\t %d", iteration)
+		if _, radius := frozen_extent(state.Settings, &state.Coldness); radius >= reach {
+			state.Settings.L = iteration
+		}
+	})
+	fmt.Fprintln(os.Stderr)
+	return state, state.Iteration
+}
+
+// snow init [-out flake.ndjson]
+func wizard(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	out := flags.String("out", "flake.ndjson", "batch job to write, run it with snow batch < flake.ndjson")
+	preview_file := flags.String("preview", "snowflakes/preview.png", "where to save the preview")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: snow init [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	r := bufio.NewReader(os.Stdin)
+	w := os.Stdout
+	for {
+		settings := default_settings()
+		for _, q := range wizard_questions {
+			ask(r, w, q).set(&settings)
+		}
+		animated := ask(r, w, animated_question).answer == "yes"
+
+		full := settings.Size
+		settings.Size = full / 4
+		state, iterations := wizard_preview(settings, 100000)
+		if state.Settings.L >= 100000 {
+			fmt.Fprintln(w, "the flake hardly grows with these answers, try others")
+			continue
+		}
+		coldness_matrix := state.values()
+		if err := save(*preview_file, &coldness_matrix, nil); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save preview:", err)
+			os.Exit(1)
+		}
+		settings.Size = full
+		settings.L = iterations * 4
+		fmt.Fprintf(w, "preview:\t %s\nsettings:\t %g %g %g %g %g %d, size %d\n\n",
+			*preview_file, settings.A, settings.B, settings.Y, settings.PP, settings.PM, settings.L, settings.Size)
+
+		keep := ask(r, w, wizard_question{"Happy with the preview?", []wizard_choice{
+			{"yes", "write the job for the full run", nil},
+			{"no", "start over", nil},
+		}})
+		if keep.answer == "no" {
+			continue
+		}
+
+		job, _ := json.Marshal(Job{Settings: settings, Out: default_filename(settings)})
+		if err := os.WriteFile(*out, append(job, '\n'), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save job:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(w, "saved job:\t %s\nrun it with:\t snow batch < %s\n", *out, *out)
+		if animated {
+			code, err := encode_code(settings)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			// batch jobs are only the settings, the sprite sheet needs the command line
+			fmt.Fprintf(w, "animated:\t snow -spritesheet 8x8 -from-code %s\n", code)
+		}
+		return
+	}
+}